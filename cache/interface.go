@@ -19,6 +19,7 @@
 package cache
 
 import (
+	"github.com/Loopring/relay/cache/memory"
 	myredis "github.com/Loopring/relay/cache/redis"
 )
 
@@ -35,6 +36,17 @@ type Cache interface {
 
 	Exists(key string) (bool, error)
 
+	IncrBy(key string, increment int64) (int64, error)
+
+	// SetNX key不存在时写入,返回是否写入成功
+	SetNX(key string, value []byte, ttl int64) (bool, error)
+
+	// CompareAndExpire key的值等于value时重新设置ttl
+	CompareAndExpire(key string, value []byte, ttl int64) (bool, error)
+
+	// CompareAndDel key的值等于value时删除
+	CompareAndDel(key string, value []byte) (bool, error)
+
 	Keys(keyFormat string) ([][]byte, error)
 
 	HMSet(key string, ttl int64, args ...[]byte) error
@@ -61,6 +73,7 @@ type Cache interface {
 
 	ZRange(key string, start, stop int64, withScores bool) ([][]byte, error)
 	ZRemRangeByScore(key string, start, stop int64) (int64, error)
+	ZRem(key string, members ...[]byte) (int64, error)
 }
 
 func NewCache(cfg interface{}) {
@@ -69,6 +82,13 @@ func NewCache(cfg interface{}) {
 	cache = redisCache
 }
 
+// NewMemoryCache 使用进程内缓存代替redis,用于回测
+func NewMemoryCache() {
+	memoryCache := &memory.MemoryCacheImpl{}
+	memoryCache.Initialize(nil)
+	cache = memoryCache
+}
+
 func Set(key string, value []byte, ttl int64) error { return cache.Set(key, value, ttl) }
 func Get(key string) ([]byte, error)                { return cache.Get(key) }
 func Del(key string) error                          { return cache.Del(key) }
@@ -76,6 +96,22 @@ func Dels(keys []string) error                      { return cache.Dels(keys) }
 func Exists(key string) (bool, error)               { return cache.Exists(key) }
 func Keys(keyFormat string) ([][]byte, error)       { return cache.Keys(keyFormat) }
 
+func IncrBy(key string, increment int64) (int64, error) {
+	return cache.IncrBy(key, increment)
+}
+
+func SetNX(key string, value []byte, ttl int64) (bool, error) {
+	return cache.SetNX(key, value, ttl)
+}
+
+func CompareAndExpire(key string, value []byte, ttl int64) (bool, error) {
+	return cache.CompareAndExpire(key, value, ttl)
+}
+
+func CompareAndDel(key string, value []byte) (bool, error) {
+	return cache.CompareAndDel(key, value)
+}
+
 func HMSet(key string, ttl int64, args ...[]byte) error {
 	return cache.HMSet(key, ttl, args...)
 }
@@ -128,3 +164,6 @@ func ZRange(key string, start, stop int64, withScores bool) ([][]byte, error) {
 func ZRemRangeByScore(key string, start, stop int64) (int64, error) {
 	return cache.ZRemRangeByScore(key, start, stop)
 }
+func ZRem(key string, members ...[]byte) (int64, error) {
+	return cache.ZRem(key, members...)
+}
@@ -0,0 +1,430 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package memory
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// MemoryCacheImpl 进程内缓存,不支持ttl,只用于回测等不能使用redis的场景
+type MemoryCacheImpl struct {
+	mtx     sync.RWMutex
+	strings map[string][]byte
+	hashes  map[string]map[string][]byte
+	sets    map[string]map[string][]byte
+	zsets   map[string]map[string]float64
+}
+
+func (impl *MemoryCacheImpl) Initialize(cfg interface{}) {
+	impl.strings = make(map[string][]byte)
+	impl.hashes = make(map[string]map[string][]byte)
+	impl.sets = make(map[string]map[string][]byte)
+	impl.zsets = make(map[string]map[string]float64)
+}
+
+func (impl *MemoryCacheImpl) Get(key string) ([]byte, error) {
+	impl.mtx.RLock()
+	defer impl.mtx.RUnlock()
+
+	if v, exists := impl.strings[key]; exists {
+		return v, nil
+	}
+	return []byte{}, fmt.Errorf("no this key:%s", key)
+}
+
+func (impl *MemoryCacheImpl) Exists(key string) (bool, error) {
+	impl.mtx.RLock()
+	defer impl.mtx.RUnlock()
+
+	return impl.exists(key), nil
+}
+
+func (impl *MemoryCacheImpl) Set(key string, value []byte, ttl int64) error {
+	impl.mtx.Lock()
+	defer impl.mtx.Unlock()
+
+	impl.strings[key] = value
+	return nil
+}
+
+func (impl *MemoryCacheImpl) Del(key string) error {
+	impl.mtx.Lock()
+	defer impl.mtx.Unlock()
+
+	impl.del(key)
+	return nil
+}
+
+func (impl *MemoryCacheImpl) Dels(keys []string) error {
+	impl.mtx.Lock()
+	defer impl.mtx.Unlock()
+
+	for _, key := range keys {
+		impl.del(key)
+	}
+	return nil
+}
+
+func (impl *MemoryCacheImpl) IncrBy(key string, increment int64) (int64, error) {
+	impl.mtx.Lock()
+	defer impl.mtx.Unlock()
+
+	var v int64
+	if data, exists := impl.strings[key]; exists {
+		var err error
+		if v, err = strconv.ParseInt(string(data), 10, 64); nil != err {
+			return 0, err
+		}
+	}
+	v += increment
+	impl.strings[key] = []byte(strconv.FormatInt(v, 10))
+	return v, nil
+}
+
+func (impl *MemoryCacheImpl) SetNX(key string, value []byte, ttl int64) (bool, error) {
+	impl.mtx.Lock()
+	defer impl.mtx.Unlock()
+
+	if impl.exists(key) {
+		return false, nil
+	}
+	impl.strings[key] = value
+	return true, nil
+}
+
+func (impl *MemoryCacheImpl) CompareAndExpire(key string, value []byte, ttl int64) (bool, error) {
+	impl.mtx.RLock()
+	defer impl.mtx.RUnlock()
+
+	v, exists := impl.strings[key]
+	return exists && bytes.Equal(v, value), nil
+}
+
+func (impl *MemoryCacheImpl) CompareAndDel(key string, value []byte) (bool, error) {
+	impl.mtx.Lock()
+	defer impl.mtx.Unlock()
+
+	if v, exists := impl.strings[key]; !exists || !bytes.Equal(v, value) {
+		return false, nil
+	}
+	impl.del(key)
+	return true, nil
+}
+
+func (impl *MemoryCacheImpl) Keys(keyFormat string) ([][]byte, error) {
+	impl.mtx.RLock()
+	defer impl.mtx.RUnlock()
+
+	res := [][]byte{}
+	add := func(key string) {
+		if matched, _ := path.Match(keyFormat, key); matched {
+			res = append(res, []byte(key))
+		}
+	}
+	for key := range impl.strings {
+		add(key)
+	}
+	for key := range impl.hashes {
+		add(key)
+	}
+	for key := range impl.sets {
+		add(key)
+	}
+	for key := range impl.zsets {
+		add(key)
+	}
+	return res, nil
+}
+
+func (impl *MemoryCacheImpl) HMSet(key string, ttl int64, args ...[]byte) error {
+	if len(args)%2 != 0 {
+		return errors.New("the length of `args` must be even")
+	}
+
+	impl.mtx.Lock()
+	defer impl.mtx.Unlock()
+
+	h, exists := impl.hashes[key]
+	if !exists {
+		h = make(map[string][]byte)
+		impl.hashes[key] = h
+	}
+	for i := 0; i < len(args); i += 2 {
+		h[string(args[i])] = args[i+1]
+	}
+	return nil
+}
+
+func (impl *MemoryCacheImpl) HMGet(key string, fields ...[]byte) ([][]byte, error) {
+	impl.mtx.RLock()
+	defer impl.mtx.RUnlock()
+
+	res := [][]byte{}
+	h := impl.hashes[key]
+	for _, field := range fields {
+		if v, exists := h[string(field)]; exists {
+			res = append(res, v)
+		} else {
+			res = append(res, []byte{})
+		}
+	}
+	return res, nil
+}
+
+func (impl *MemoryCacheImpl) HDel(key string, fields ...[]byte) (int64, error) {
+	impl.mtx.Lock()
+	defer impl.mtx.Unlock()
+
+	var count int64
+	if h, exists := impl.hashes[key]; exists {
+		for _, field := range fields {
+			if _, exists := h[string(field)]; exists {
+				delete(h, string(field))
+				count += 1
+			}
+		}
+		if len(h) == 0 {
+			delete(impl.hashes, key)
+		}
+	}
+	return count, nil
+}
+
+func (impl *MemoryCacheImpl) HGetAll(key string) ([][]byte, error) {
+	impl.mtx.RLock()
+	defer impl.mtx.RUnlock()
+
+	res := [][]byte{}
+	for field, v := range impl.hashes[key] {
+		res = append(res, []byte(field), v)
+	}
+	return res, nil
+}
+
+func (impl *MemoryCacheImpl) HVals(key string) ([][]byte, error) {
+	impl.mtx.RLock()
+	defer impl.mtx.RUnlock()
+
+	res := [][]byte{}
+	for _, v := range impl.hashes[key] {
+		res = append(res, v)
+	}
+	return res, nil
+}
+
+func (impl *MemoryCacheImpl) HExists(key string, field []byte) (bool, error) {
+	impl.mtx.RLock()
+	defer impl.mtx.RUnlock()
+
+	_, exists := impl.hashes[key][string(field)]
+	return exists, nil
+}
+
+func (impl *MemoryCacheImpl) SAdd(key string, ttl int64, members ...[]byte) error {
+	impl.mtx.Lock()
+	defer impl.mtx.Unlock()
+
+	s, exists := impl.sets[key]
+	if !exists {
+		s = make(map[string][]byte)
+		impl.sets[key] = s
+	}
+	for _, member := range members {
+		s[string(member)] = member
+	}
+	return nil
+}
+
+func (impl *MemoryCacheImpl) SCard(key string) (int64, error) {
+	impl.mtx.RLock()
+	defer impl.mtx.RUnlock()
+
+	return int64(len(impl.sets[key])), nil
+}
+
+func (impl *MemoryCacheImpl) SRem(key string, members ...[]byte) (int64, error) {
+	impl.mtx.Lock()
+	defer impl.mtx.Unlock()
+
+	var count int64
+	if s, exists := impl.sets[key]; exists {
+		for _, member := range members {
+			if _, exists := s[string(member)]; exists {
+				delete(s, string(member))
+				count += 1
+			}
+		}
+		if len(s) == 0 {
+			delete(impl.sets, key)
+		}
+	}
+	return count, nil
+}
+
+func (impl *MemoryCacheImpl) SMembers(key string) ([][]byte, error) {
+	impl.mtx.RLock()
+	defer impl.mtx.RUnlock()
+
+	res := [][]byte{}
+	for _, member := range impl.sets[key] {
+		res = append(res, member)
+	}
+	return res, nil
+}
+
+func (impl *MemoryCacheImpl) SIsMember(key string, member []byte) (bool, error) {
+	impl.mtx.RLock()
+	defer impl.mtx.RUnlock()
+
+	_, exists := impl.sets[key][string(member)]
+	return exists, nil
+}
+
+// ZAdd 与redis一致,args为score、member交替
+func (impl *MemoryCacheImpl) ZAdd(key string, ttl int64, args ...[]byte) error {
+	if len(args)%2 != 0 {
+		return errors.New("the length of `args` must be even")
+	}
+
+	impl.mtx.Lock()
+	defer impl.mtx.Unlock()
+
+	z, exists := impl.zsets[key]
+	if !exists {
+		z = make(map[string]float64)
+		impl.zsets[key] = z
+	}
+	for i := 0; i < len(args); i += 2 {
+		score, err := strconv.ParseFloat(string(args[i]), 64)
+		if nil != err {
+			return err
+		}
+		z[string(args[i+1])] = score
+	}
+	return nil
+}
+
+func (impl *MemoryCacheImpl) ZRange(key string, start, stop int64, withScores bool) ([][]byte, error) {
+	impl.mtx.RLock()
+	defer impl.mtx.RUnlock()
+
+	members := impl.sortedMembers(key)
+	length := int64(len(members))
+	if start < 0 {
+		start += length
+	}
+	if stop < 0 {
+		stop += length
+	}
+	if start < 0 {
+		start = 0
+	}
+	if stop >= length {
+		stop = length - 1
+	}
+
+	res := [][]byte{}
+	for i := start; i <= stop; i++ {
+		res = append(res, []byte(members[i]))
+		if withScores {
+			res = append(res, []byte(strconv.FormatFloat(impl.zsets[key][members[i]], 'f', -1, 64)))
+		}
+	}
+	return res, nil
+}
+
+func (impl *MemoryCacheImpl) ZRemRangeByScore(key string, start, stop int64) (int64, error) {
+	impl.mtx.Lock()
+	defer impl.mtx.Unlock()
+
+	var count int64
+	if z, exists := impl.zsets[key]; exists {
+		for member, score := range z {
+			if score >= float64(start) && score <= float64(stop) {
+				delete(z, member)
+				count += 1
+			}
+		}
+		if len(z) == 0 {
+			delete(impl.zsets, key)
+		}
+	}
+	return count, nil
+}
+
+func (impl *MemoryCacheImpl) ZRem(key string, members ...[]byte) (int64, error) {
+	impl.mtx.Lock()
+	defer impl.mtx.Unlock()
+
+	var count int64
+	if z, exists := impl.zsets[key]; exists {
+		for _, member := range members {
+			if _, exists := z[string(member)]; exists {
+				delete(z, string(member))
+				count += 1
+			}
+		}
+		if len(z) == 0 {
+			delete(impl.zsets, key)
+		}
+	}
+	return count, nil
+}
+
+func (impl *MemoryCacheImpl) exists(key string) bool {
+	if _, exists := impl.strings[key]; exists {
+		return true
+	}
+	if _, exists := impl.hashes[key]; exists {
+		return true
+	}
+	if _, exists := impl.sets[key]; exists {
+		return true
+	}
+	_, exists := impl.zsets[key]
+	return exists
+}
+
+func (impl *MemoryCacheImpl) del(key string) {
+	delete(impl.strings, key)
+	delete(impl.hashes, key)
+	delete(impl.sets, key)
+	delete(impl.zsets, key)
+}
+
+func (impl *MemoryCacheImpl) sortedMembers(key string) []string {
+	z := impl.zsets[key]
+	members := []string{}
+	for member := range z {
+		members = append(members, member)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if z[members[i]] != z[members[j]] {
+			return z[members[i]] < z[members[j]]
+		}
+		return members[i] < members[j]
+	})
+	return members
+}
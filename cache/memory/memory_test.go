@@ -0,0 +1,108 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package memory_test
+
+import (
+	"github.com/Loopring/relay/cache/memory"
+	"testing"
+)
+
+func newCache() *memory.MemoryCacheImpl {
+	c := &memory.MemoryCacheImpl{}
+	c.Initialize(nil)
+	return c
+}
+
+func TestMemoryCacheImpl_Sets(t *testing.T) {
+	c := newCache()
+	c.SAdd("set", 0, []byte("a"), []byte("b"), []byte("a"))
+	if count, _ := c.SCard("set"); count != 2 {
+		t.Fatalf("expect 2 members, got %d", count)
+	}
+	c.SRem("set", []byte("a"), []byte("b"))
+	if exists, _ := c.Exists("set"); exists {
+		t.Fatalf("empty set should be removed")
+	}
+}
+
+func TestMemoryCacheImpl_Hashes(t *testing.T) {
+	c := newCache()
+	if err := c.HMSet("hash", 0, []byte("f1"), []byte("v1"), []byte("f2"), []byte("v2")); nil != err {
+		t.Fatal(err.Error())
+	}
+	if vals, _ := c.HMGet("hash", []byte("f2"), []byte("f3")); string(vals[0]) != "v2" || len(vals[1]) != 0 {
+		t.Fatalf("unexpected values:%v", vals)
+	}
+	if err := c.HMSet("hash", 0, []byte("f1")); nil == err {
+		t.Fatalf("odd args should be rejected")
+	}
+	c.Del("hash")
+	if vals, _ := c.HVals("hash"); len(vals) != 0 {
+		t.Fatalf("hash should be deleted")
+	}
+}
+
+func TestMemoryCacheImpl_ZSets(t *testing.T) {
+	c := newCache()
+	c.ZAdd("zset", 0, []byte("3"), []byte("c"), []byte("1"), []byte("a"), []byte("2"), []byte("b"))
+	if members, _ := c.ZRange("zset", 0, 0, false); string(members[0]) != "a" {
+		t.Fatalf("lowest member should be a, got %s", string(members[0]))
+	}
+	if removed, _ := c.ZRemRangeByScore("zset", 0, 2); removed != 2 {
+		t.Fatalf("expect 2 removed, got %d", removed)
+	}
+	if members, _ := c.ZRange("zset", 0, -1, true); len(members) != 2 || string(members[0]) != "c" || string(members[1]) != "3" {
+		t.Fatalf("unexpected members:%v", members)
+	}
+}
+
+func TestMemoryCacheImpl_KeysAndIncr(t *testing.T) {
+	c := newCache()
+	c.Set("prefix_a", []byte("1"), 0)
+	c.SAdd("prefix_b", 0, []byte("x"))
+	c.Set("other", []byte("1"), 0)
+	if keys, _ := c.Keys("prefix_*"); len(keys) != 2 {
+		t.Fatalf("expect 2 keys, got %d", len(keys))
+	}
+	if v, _ := c.IncrBy("prefix_a", 5); v != 6 {
+		t.Fatalf("expect 6, got %d", v)
+	}
+}
+
+func TestMemoryCacheImpl_Lease(t *testing.T) {
+	c := newCache()
+	if ok, _ := c.SetNX("lease", []byte("a"), 10); !ok {
+		t.Fatalf("lease should be acquired")
+	}
+	if ok, _ := c.SetNX("lease", []byte("b"), 10); ok {
+		t.Fatalf("lease held by a should not be acquired by b")
+	}
+	if ok, _ := c.CompareAndExpire("lease", []byte("b"), 10); ok {
+		t.Fatalf("lease should only be renewed by its holder")
+	}
+	if ok, _ := c.CompareAndDel("lease", []byte("b")); ok {
+		t.Fatalf("lease should only be released by its holder")
+	}
+	if ok, _ := c.CompareAndDel("lease", []byte("a")); !ok {
+		t.Fatalf("lease should be released by its holder")
+	}
+	if ok, _ := c.SetNX("lease", []byte("b"), 10); !ok {
+		t.Fatalf("released lease should be acquired")
+	}
+}
@@ -389,6 +389,97 @@ func (impl *RedisCacheImpl) SRem(key string, members ...[]byte) (int64, error) {
 	}
 }
 
+func (impl *RedisCacheImpl) ZRem(key string, members ...[]byte) (int64, error) {
+
+	//log.Info("[REDIS-ZRem] key : " + key)
+
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	vs := []interface{}{}
+	vs = append(vs, key)
+	for _, v := range members {
+		vs = append(vs, v)
+	}
+	reply, err := conn.Do("zrem", vs...)
+
+	if err != nil {
+		log.Errorf(" key:%s, err:%s", key, err.Error())
+		return 0, err
+	} else {
+		res := reply.(int64)
+		return res, err
+	}
+}
+
+func (impl *RedisCacheImpl) IncrBy(key string, increment int64) (int64, error) {
+
+	//log.Info("[REDIS-IncrBy] key : " + key)
+
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	reply, err := conn.Do("incrby", key, increment)
+
+	if err != nil {
+		log.Errorf(" key:%s, err:%s", key, err.Error())
+		return 0, err
+	} else {
+		res := reply.(int64)
+		return res, err
+	}
+}
+
+func (impl *RedisCacheImpl) SetNX(key string, value []byte, ttl int64) (bool, error) {
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	var (
+		reply interface{}
+		err   error
+	)
+	if ttl > 0 {
+		reply, err = conn.Do("set", key, value, "ex", ttl, "nx")
+	} else {
+		reply, err = conn.Do("set", key, value, "nx")
+	}
+	if err != nil {
+		log.Errorf("key:%s, err:%s", key, err.Error())
+		return false, err
+	}
+	return nil != reply, nil
+}
+
+// 比较和修改需要原子执行,使用lua脚本
+var (
+	compareAndExpireScript = redis.NewScript(1, `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("expire", KEYS[1], ARGV[2]) else return 0 end`)
+	compareAndDelScript    = redis.NewScript(1, `if redis.call("get", KEYS[1]) == ARGV[1] then return redis.call("del", KEYS[1]) else return 0 end`)
+)
+
+func (impl *RedisCacheImpl) CompareAndExpire(key string, value []byte, ttl int64) (bool, error) {
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Int64(compareAndExpireScript.Do(conn, key, value, ttl))
+	if err != nil {
+		log.Errorf("key:%s, err:%s", key, err.Error())
+		return false, err
+	}
+	return reply > 0, nil
+}
+
+func (impl *RedisCacheImpl) CompareAndDel(key string, value []byte) (bool, error) {
+	conn := impl.pool.Get()
+	defer conn.Close()
+
+	reply, err := redis.Int64(compareAndDelScript.Do(conn, key, value))
+	if err != nil {
+		log.Errorf("key:%s, err:%s", key, err.Error())
+		return false, err
+	}
+	return reply > 0, nil
+}
+
 func (impl *RedisCacheImpl) SIsMember(key string, member []byte) (bool, error) {
 	conn := impl.pool.Get()
 	defer conn.Close()
@@ -0,0 +1,145 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/Loopring/relay/cache"
+	"github.com/Loopring/relay/cmd/utils"
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/extractor"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/market"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/marketcap"
+	"github.com/Loopring/relay/ordermanager"
+	"github.com/Loopring/relay/txmanager"
+	"github.com/Loopring/relay/usermanager"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const (
+	backfillHandlerOrder = "order"
+	backfillHandlerTx    = "tx"
+)
+
+func extractorCommands() cli.Command {
+	extractorCommand := cli.Command{
+		Name:     "extractor",
+		Usage:    "extractor ",
+		Category: "extractor commands",
+		Action:   nil,
+		Subcommands: []cli.Command{
+			cli.Command{
+				Name:   "backfill",
+				Usage:  "re-extract blocks in range and run selected handlers against db, blocks extracted by the running relay are not changed",
+				Action: backfill,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "config,c",
+						Usage: "config file",
+					},
+					cli.StringFlag{
+						Name:  "from",
+						Usage: "first block number of range",
+					},
+					cli.StringFlag{
+						Name:  "to",
+						Usage: "last block number of range, included",
+					},
+					cli.StringFlag{
+						Name:  "handlers",
+						Usage: "comma separated handlers, order:rings, fills, cancels and cutoffs of orders, tx:transaction history, default all",
+					},
+				},
+			},
+		},
+	}
+	return extractorCommand
+}
+
+func parseBlockNumber(name, value string) (*big.Int, error) {
+	number, ok := new(big.Int).SetString(value, 0)
+	if !ok || number.Sign() < 0 {
+		return nil, errors.New("invalid " + name + " block number:" + value)
+	}
+	return number, nil
+}
+
+func backfill(ctx *cli.Context) {
+	globalConfig := config.LoadConfig(ctx.String("config"))
+	logger := log.Initialize(globalConfig.Log)
+	defer func() {
+		if nil != logger {
+			logger.Sync()
+		}
+	}()
+
+	from, err := parseBlockNumber("from", ctx.String("from"))
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+	to, err := parseBlockNumber("to", ctx.String("to"))
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+	var names []string
+	if "" != ctx.String("handlers") {
+		for _, name := range strings.Split(ctx.String("handlers"), ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+	}
+
+	rdsService := dao.NewRdsService(globalConfig.Mysql)
+	cache.NewCache(globalConfig.Redis)
+	util.Initialize(globalConfig.Market)
+	if err := ethaccessor.Initialize(globalConfig.Accessor, globalConfig.Common, util.WethTokenAddress()); nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+
+	// 只创建handler需要的模块,不启动,避免影响正在运行的relay
+	accountManager := market.NewAccountManager(globalConfig.AccountManager)
+	marketCapProvider := marketcap.NewMarketCapProvider(globalConfig.MarketCap)
+	userManager := usermanager.NewUserManager(&globalConfig.UserManager, rdsService)
+	orderManager := ordermanager.NewOrderManager(&globalConfig.OrderManager, rdsService, userManager, marketCapProvider, &accountManager)
+	txManager := txmanager.NewTxManager(rdsService, &accountManager)
+
+	backfiller := extractor.NewBackfiller(globalConfig.Extractor, rdsService, from, to)
+	for topic, handle := range orderManager.ChainEventHandlers() {
+		backfiller.Register(backfillHandlerOrder, topic, handle)
+	}
+	for topic, handle := range txManager.ChainEventHandlers() {
+		backfiller.Register(backfillHandlerTx, topic, handle)
+	}
+
+	fmt.Fprintf(ctx.App.Writer, "backfill from block %s to %s\n", from.String(), to.String())
+	err = backfiller.Run(names, func(progress extractor.BackfillProgress) {
+		fmt.Fprintf(ctx.App.Writer, "block:%s/%s, blocks:%d, transactions:%d, handled:%v, failed:%v\n", progress.Current.String(), progress.To.String(), progress.Blocks, progress.Transactions, progress.Handled, progress.Failed)
+	})
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+	fmt.Fprintln(ctx.App.Writer, "backfill complete")
+}
@@ -41,6 +41,8 @@ func main() {
 
 	app.Commands = []cli.Command{
 		accountCommands(),
+		minerCommands(),
+		extractorCommands(),
 	}
 
 	sort.Sort(cli.CommandsByName(app.Commands))
@@ -18,7 +18,24 @@
 
 package main
 
-import "gopkg.in/urfave/cli.v1"
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/Loopring/relay/cmd/utils"
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/miner"
+	"github.com/Loopring/relay/miner/timing_matcher"
+	"gopkg.in/urfave/cli.v1"
+)
+
+const reportDateLayout = "2006-01-02"
 
 func minerCommands() cli.Command {
 	minerCommand := cli.Command{
@@ -26,6 +43,165 @@ func minerCommands() cli.Command {
 		Usage:    "miner ",
 		Category: "miner commands",
 		Action:   nil,
+		Subcommands: []cli.Command{
+			cli.Command{
+				Name:   "report",
+				Usage:  "report estimated and realized profit of mined rings by market, sender and day",
+				Action: profitReport,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "config,c",
+						Usage: "config file",
+					},
+					cli.StringFlag{
+						Name:  "start",
+						Usage: "start date(UTC) of report, format:2006-01-02, default 7 days before end",
+					},
+					cli.StringFlag{
+						Name:  "end",
+						Usage: "end date(UTC) of report and not included, format:2006-01-02, default tomorrow",
+					},
+				},
+			},
+			cli.Command{
+				Name:   "backtest",
+				Usage:  "replay historical orders and fills through the matcher and report rings, estimated profit and fill rates, no transaction will be sent",
+				Action: backtest,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "config,c",
+						Usage: "config file",
+					},
+					cli.StringFlag{
+						Name:  "start",
+						Usage: "start time(UTC) of backtest, format:2006-01-02 or 2006-01-02T15:04:05",
+					},
+					cli.StringFlag{
+						Name:  "end",
+						Usage: "end time(UTC) of backtest and not included, format:2006-01-02 or 2006-01-02T15:04:05",
+					},
+					cli.Float64Flag{
+						Name:  "eth-price",
+						Usage: "legal currency price of eth during backtest",
+					},
+					cli.StringFlag{
+						Name:  "gas-price",
+						Usage: "gas price(wei) used when there is no historical ring transaction",
+						Value: "10000000000",
+					},
+					cli.Int64Flag{
+						Name:  "block-interval",
+						Usage: "seconds of simulated time between two match rounds",
+						Value: 15,
+					},
+				},
+			},
+		},
 	}
 	return minerCommand
 }
+
+func profitReport(ctx *cli.Context) {
+	globalConfig := config.LoadConfig(ctx.String("config"))
+	logger := log.Initialize(globalConfig.Log)
+	defer func() {
+		if nil != logger {
+			logger.Sync()
+		}
+	}()
+
+	end := time.Now().UTC().Truncate(24 * time.Hour).Add(24 * time.Hour)
+	if "" != ctx.String("end") {
+		t, err := time.Parse(reportDateLayout, ctx.String("end"))
+		if nil != err {
+			utils.ExitWithErr(ctx.App.Writer, err)
+		}
+		end = t
+	}
+	start := end.Add(-7 * 24 * time.Hour)
+	if "" != ctx.String("start") {
+		t, err := time.Parse(reportDateLayout, ctx.String("start"))
+		if nil != err {
+			utils.ExitWithErr(ctx.App.Writer, err)
+		}
+		start = t
+	}
+	if !start.Before(end) {
+		utils.ExitWithErr(ctx.App.Writer, errors.New("start must be before end"))
+	}
+
+	rdsService := dao.NewRdsService(globalConfig.Mysql)
+	report, err := miner.GenerateProfitReport(rdsService, start.Unix(), end.Unix())
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+
+	fmt.Fprintf(ctx.App.Writer, "profit report from %s to %s, currency:%s\n", start.Format(reportDateLayout), end.Format(reportDateLayout), globalConfig.MarketCap.Currency)
+	printProfitSummaries(ctx, "market", report.ByMarket)
+	printProfitSummaries(ctx, "sender", report.BySender)
+	printProfitSummaries(ctx, "day", report.ByDay)
+	printProfitSummaries(ctx, "total", []*miner.ProfitSummary{report.Total})
+}
+
+func printProfitSummaries(ctx *cli.Context, title string, summaries []*miner.ProfitSummary) {
+	fmt.Fprintf(ctx.App.Writer, "\n%-44s %6s %6s %6s %14s %14s %14s %14s %14s %14s\n", title, "rings", "mined", "failed", "estFee", "estCost", "estProfit", "fee", "cost", "profit")
+	for _, s := range summaries {
+		fmt.Fprintf(ctx.App.Writer, "%-44s %6d %6d %6d %14.4f %14.4f %14.4f %14.4f %14.4f %14.4f\n", s.Key, s.RingCount, s.MinedCount, s.FailedCount, s.EstimatedFee, s.EstimatedCost, s.EstimatedProfit, s.RealizedFee, s.RealizedCost, s.RealizedProfit)
+	}
+}
+
+func parseBacktestTime(value string) (time.Time, error) {
+	if t, err := time.Parse("2006-01-02T15:04:05", value); nil == err {
+		return t, nil
+	}
+	return time.Parse(reportDateLayout, value)
+}
+
+func backtest(ctx *cli.Context) {
+	globalConfig := config.LoadConfig(ctx.String("config"))
+	logger := log.Initialize(globalConfig.Log)
+	defer func() {
+		if nil != logger {
+			logger.Sync()
+		}
+	}()
+
+	start, err := parseBacktestTime(ctx.String("start"))
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+	end, err := parseBacktestTime(ctx.String("end"))
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+	gasPrice, ok := new(big.Int).SetString(ctx.String("gas-price"), 0)
+	if !ok {
+		utils.ExitWithErr(ctx.App.Writer, errors.New("invalid gas price:"+ctx.String("gas-price")))
+	}
+
+	rdsService := dao.NewRdsService(globalConfig.Mysql)
+	util.Initialize(globalConfig.Market)
+	if err := ethaccessor.Initialize(globalConfig.Accessor, globalConfig.Common, util.WethTokenAddress()); nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+
+	options := timing_matcher.BacktestOptions{
+		Start:         start.Unix(),
+		End:           end.Unix(),
+		BlockInterval: ctx.Int64("block-interval"),
+		Currency:      globalConfig.MarketCap.Currency,
+		EthPrice:      ctx.Float64("eth-price"),
+		GasPrice:      gasPrice,
+	}
+	report, err := timing_matcher.NewBacktester(globalConfig.Miner, rdsService, options).Run()
+	if nil != err {
+		utils.ExitWithErr(ctx.App.Writer, err)
+	}
+
+	fmt.Fprintf(ctx.App.Writer, "backtest from %s to %s, rounds:%d, currency:%s\n", start.Format(time.RFC3339), end.Format(time.RFC3339), report.Rounds, globalConfig.MarketCap.Currency)
+	fmt.Fprintf(ctx.App.Writer, "\n%-20s %6s %8s %8s %8s %14s %14s %14s\n", "market", "rings", "orders", "matched", "fillRate", "estFee", "estCost", "estProfit")
+	for _, m := range report.Markets {
+		fmt.Fprintf(ctx.App.Writer, "%-20s %6d %8d %8d %8.4f %14.4f %14.4f %14.4f\n", m.Market, m.Rings, m.Orders, m.MatchedOrders, m.FillRate, m.EstimatedFee, m.EstimatedCost, m.EstimatedReceived)
+	}
+	fmt.Fprintf(ctx.App.Writer, "%-20s %6d %8d %8d %8.4f %14.4f %14.4f %14.4f\n", "total", report.Rings, report.Orders, report.MatchedOrders, report.FillRate, report.EstimatedFee, report.EstimatedCost, report.EstimatedReceived)
+}
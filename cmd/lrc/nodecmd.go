@@ -101,6 +101,9 @@ func unlockAccount(ctx *cli.Context, globalConfig *config.GlobalConfig) {
 			utils.ExitWithErr(ctx.App.Writer, fmt.Errorf("require a address as miner to sign and submit ring when running as miner"))
 		}
 		for _, addr := range minerAccs {
+			if crypto.IsRemoteSigned(common.HexToAddress(addr)) {
+				continue
+			}
 			unlocked := false
 			for _, unlockAcc := range unlockAccs {
 				if strings.ToLower(unlockAcc.Address.Hex()) == strings.ToLower(addr) {
@@ -107,9 +107,12 @@ func (c *GlobalConfig) defaultConfig() {
 }
 
 type OrderManagerOptions struct {
-	CutoffCacheExpireTime int64
-	CutoffCacheCleanTime  int64
-	DustOrderValue        int64
+	CutoffCacheExpireTime   int64
+	CutoffCacheCleanTime    int64
+	DustOrderValue          int64
+	LocalOrderBook          bool  // 订单簿只由本进程处理的事件更新,只适用于单个full模式的relay,否则miner以及深度查询直接读数据库
+	OrderBookReloadInterval int64 // seconds,0 means default 300
+	FrozenReconcileInterval int64 // seconds,0 means never reconcile frozen ledger with db
 }
 
 type IpfsOptions struct {
@@ -129,6 +132,7 @@ func (opts IpfsOptions) Url() string {
 
 type AccessorOptions struct {
 	RawUrls           []string `required:"true"`
+	WsUrls            []string // websocket地址,用于订阅newHeads,为空时轮询最新块
 	FetchTxRetryCount int
 }
 
@@ -139,19 +143,48 @@ type ExtractorOptions struct {
 	ForkWaitingTime    int64
 	Debug              bool
 	Open               bool
+	FetchConcurrency   int    // 同时获取交易及receipt的块数,大于1时开启预取,默认1
+	PrefetchBlocks     int    // 已获取但还未处理的最多块数,默认FetchConcurrency*2
+	Mode               string // full:获取全部交易(默认),log:用eth_getLogs只获取相关合约的交易
+	LogBatchBlocks     int64  // 日志模式下每次eth_getLogs的块数,默认100
+	LogEthTransfer     bool   // 日志模式下是否获取块内交易以处理eth转账
+	UnconfirmedEvents  bool   // 在最新块发出未确认事件,ConfirmBlockNumber个确认后发出确认事件,分叉时发出移除事件
+	Contracts          []ContractOptions
+}
+
+// ContractOptions 额外解析的合约,事件按abi通用解码后存入contract_event表
+type ContractOptions struct {
+	Name       string
+	Address    string
+	Abi        string   // 合约abi json
+	Events     []string // 需要解析的事件名,为空时解析全部事件
+	OwnerParam string   // 作为事件owner的address参数名,事件没有该参数时owner为交易发送者
 }
 
 type KeyStoreOptions struct {
-	Keydir  string
-	ScryptN int
-	ScryptP int
+	Keydir        string
+	ScryptN       int
+	ScryptP       int
+	RemoteSigners []RemoteSignerOptions // 配置在remote signer中的地址使用远程签名,其余地址使用keystore
+}
+
+type RemoteSignerOptions struct {
+	Url       string   // clef兼容的json-rpc地址
+	Addresses []string // 使用该signer签名的地址
+	Token     string   // 不为空时以 Authorization: Bearer <token> 发送
+	CaFile    string   // 校验signer证书的ca
+	CertFile  string   // mTLS的客户端证书
+	KeyFile   string
+	Timeout   int64 // seconds,默认10
 }
 
 type ProtocolOptions struct {
-	Address          map[string]string
-	ImplAbi          string
-	DelegateAbi      string
-	TokenRegistryAbi string
+	Address             map[string]string
+	ImplAbi             string
+	DelegateAbi         string
+	TokenRegistryAbi    string
+	RinghashRegistryAbi string            // 不为空时加载ringhash registry,用于commit-reveal提交环路
+	RinghashRegistry    map[string]string // 协议版本 -> ringhash registry地址
 }
 
 type CommonOptions struct {
@@ -173,6 +206,31 @@ type TimingMatcher struct {
 	DelayedNumber                int64
 	MaxCacheRoundsLength         int
 	LagForCleanSubmitCacheBlocks int64
+	DecisionLogSize              int     // miner_getDecisions可以查询的最近的撮合决定数,默认1000
+	OrderPriority                string  // 订单选取以及收益相同环路的排序策略:price_time(默认),fee_weighted,age_boost
+	AgeBoostPercentage           float64 // age_boost时订单每等待AgeBoostInterval秒价格提高的百分比,默认1
+	AgeBoostInterval             int64   // seconds,默认60
+	MaxAgeBoostPercentage        float64 // age_boost最多提高的百分比,默认20
+	WaitMetricsSize              int     // 统计订单首次撮合等待时间的最近样本数,默认1000
+}
+
+type EventMatcher struct {
+	Debounce int64 // milliseconds,撮合前等待的时间,合并短时间内的多个订单
+}
+
+type CommitReveal struct {
+	Open               bool
+	BatchSize          int   // 一次batchSubmitRinghash最多登记的环路数,默认10
+	BatchInterval      int64 // milliseconds,等待凑批的最长时间,默认2000
+	ConfirmBlocks      int64 // RinghashSubmitted事件之后等待的块数,默认1
+	RegisterTtlBlocks  int64 // 登记交易发送之后超过该块数仍未确认则环路失败,默认20
+	ReservationTtlTime int64 // seconds,撮合时避开其他miner已登记环路的时间窗口,默认600
+}
+
+type MarketShard struct {
+	Open       bool
+	InstanceId string // 默认为hostname-pid,多个实例之间不能重复,已停止实例的环路缓存由其他实例启动时清除
+	LeaseTtl   int64  // seconds,实例停止续约之后其market被其他实例接管的时间,默认15
 }
 
 type PercentMinerAddress struct {
@@ -189,17 +247,26 @@ type NormalMinerAddress struct {
 }
 
 type MinerOptions struct {
-	RingMaxLength         int `` //recommended value:4
-	Name                  string
-	Subsidy               float64
-	WalletSplit           float64
-	NormalMiners          []NormalMinerAddress  //
-	PercentMiners         []PercentMinerAddress //
-	TimingMatcher         *TimingMatcher
-	RateRatioCVSThreshold int64
-	MinGasLimit           int64
-	MaxGasLimit           int64
-	FeeReceipt            string
+	RingMaxLength          int `` //recommended value:4
+	Name                   string
+	Subsidy                float64
+	WalletSplit            float64
+	NormalMiners           []NormalMinerAddress  //
+	PercentMiners          []PercentMinerAddress //
+	TimingMatcher          *TimingMatcher
+	EventMatcher           *EventMatcher
+	MatchStrategies        map[string]string // market -> strategy(timing/event),未配置的market使用timing
+	CommitReveal           *CommitReveal     // 先登记ringhash再提交环路,避免环路在交易池中被抄袭
+	MarketShard            *MarketShard      // 多个miner实例通过redis lease分配market
+	RateRatioCVSThreshold  int64
+	MinGasLimit            int64
+	MaxGasLimit            int64
+	GasMarginPercentage    int64   // 提交环路时在eth_estimateGas结果上增加的百分比,默认20
+	GasPriceBumpPercentage int64   // 交易pending超过MaxPendingTtl个块之后gasPrice提高的百分比,默认10
+	NonceGapTimeout        int64   // seconds,分配之后超过该时间没有使用的nonce会被填补,默认60
+	MinSenderEthBalance    float64 // eth余额低于该值的地址不再用于提交环路,默认0表示不排除
+	SenderCheckInterval    int64   // seconds,检查miner地址余额的间隔,默认60
+	FeeReceipt             string
 }
 
 type MarketOptions struct {
@@ -229,6 +296,10 @@ type GatewayFiltersOptions struct {
 	PowFilter struct {
 		Difficulty string
 	}
+	OrderLimitFilter struct {
+		MaxOpenOrders    int
+		MaxOpenUsdAmount float64
+	}
 }
 
 type GateWayOptions struct {
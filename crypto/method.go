@@ -58,7 +58,7 @@ func SigToVRS(sig []byte) (v byte, r []byte, s []byte) {
 }
 
 func UnlockKSAccount(acc accounts.Account, passphrase string) error {
-	if c, ok := crypto.(EthKSCrypto); ok {
+	if c, ok := ksCrypto(); ok {
 		return c.UnlockAccount(acc, passphrase)
 	} else {
 		return errors.New("can't unlock ")
@@ -66,7 +66,10 @@ func UnlockKSAccount(acc accounts.Account, passphrase string) error {
 }
 
 func IsKSAccountUnlocked(addr common.Address) bool {
-	if c, ok := crypto.(EthKSCrypto); ok {
+	if IsRemoteSigned(addr) {
+		return true
+	}
+	if c, ok := ksCrypto(); ok {
 		return c.IsUnlocked(addr)
 	} else {
 		log.Errorf("unable to get address :%s lock status", addr.Hex())
@@ -74,6 +77,25 @@ func IsKSAccountUnlocked(addr common.Address) bool {
 	}
 }
 
+// IsRemoteSigned 地址使用remote signer签名时不需要unlock
+func IsRemoteSigned(addr common.Address) bool {
+	if c, ok := crypto.(*RoutedCrypto); ok {
+		return c.IsRouted(addr)
+	}
+	return false
+}
+
+func ksCrypto() (EthKSCrypto, bool) {
+	switch c := crypto.(type) {
+	case EthKSCrypto:
+		return c, true
+	case *RoutedCrypto:
+		ks, ok := c.local.(EthKSCrypto)
+		return ks, ok
+	}
+	return EthKSCrypto{}, false
+}
+
 func SignTx(a common.Address, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
 	return crypto.SignTx(a, tx, chainID)
 }
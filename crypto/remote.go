@@ -0,0 +1,270 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package crypto
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/Loopring/relay/config"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const defaultRemoteSignerTimeout = 10
+
+// RemoteSigner 通过json-rpc调用外部signer(clef)签名,私钥不需要放在relay所在的机器上
+type RemoteSigner struct {
+	EthCrypto
+	url       string
+	token     string
+	client    *http.Client
+	addresses []common.Address
+	id        uint64
+}
+
+type remoteSignerRequest struct {
+	JsonRpc string        `json:"jsonrpc"`
+	Id      uint64        `json:"id"`
+	Method  string        `json:"method"`
+	Params  []interface{} `json:"params"`
+}
+
+type remoteSignerResponse struct {
+	Id     uint64          `json:"id"`
+	Result json.RawMessage `json:"result"`
+	Error  *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// remoteSendTxArgs 对应clef account_signTransaction的参数
+type remoteSendTxArgs struct {
+	From     common.Address  `json:"from"`
+	To       *common.Address `json:"to"`
+	Gas      hexutil.Big     `json:"gas"`
+	GasPrice hexutil.Big     `json:"gasPrice"`
+	Value    hexutil.Big     `json:"value"`
+	Nonce    hexutil.Uint64  `json:"nonce"`
+	Data     hexutil.Bytes   `json:"data"`
+	ChainId  *hexutil.Big    `json:"chainId,omitempty"`
+}
+
+type remoteSignTxResult struct {
+	Raw hexutil.Bytes `json:"raw"`
+}
+
+func NewRemoteSigner(homestead bool, options config.RemoteSignerOptions) (*RemoteSigner, error) {
+	if "" == options.Url {
+		return nil, errors.New("remote signer url is empty")
+	}
+	timeout := options.Timeout
+	if timeout <= 0 {
+		timeout = defaultRemoteSignerTimeout
+	}
+
+	tlsConfig := &tls.Config{}
+	if "" != options.CaFile {
+		ca, err := ioutil.ReadFile(options.CaFile)
+		if nil != err {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("invalid remote signer ca file:" + options.CaFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if "" != options.CertFile || "" != options.KeyFile {
+		cert, err := tls.LoadX509KeyPair(options.CertFile, options.KeyFile)
+		if nil != err {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	signer := &RemoteSigner{EthCrypto: EthCrypto{homestead: homestead}}
+	signer.url = options.Url
+	signer.token = options.Token
+	signer.client = &http.Client{
+		Timeout:   time.Duration(timeout) * time.Second,
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+	}
+	for _, addr := range options.Addresses {
+		if !common.IsHexAddress(addr) {
+			return nil, errors.New("remote signer address:" + addr + " is not a HexAddress")
+		}
+		signer.addresses = append(signer.addresses, common.HexToAddress(addr))
+	}
+	return signer, nil
+}
+
+func (c *RemoteSigner) Addresses() []common.Address {
+	return c.addresses
+}
+
+// Sign 与EthKSCrypto一致,签名内容为"\x19Ethereum Signed Message:\n32"+hashPre,对应clef中text/plain的数据签名
+func (c *RemoteSigner) Sign(hashPre []byte, signerAddr common.Address) ([]byte, error) {
+	var sig hexutil.Bytes
+	if err := c.call(&sig, "account_signData", "text/plain", signerAddr, hexutil.Bytes(hashPre)); nil != err {
+		return nil, err
+	}
+	if len(sig) != 65 {
+		return nil, fmt.Errorf("remote signer returns invalid signature length:%d", len(sig))
+	}
+	// clef返回的v为27/28
+	if sig[64] >= 27 {
+		sig[64] -= 27
+	}
+
+	if addr, err := c.SigToAddress(hashPre, sig); nil != err {
+		return nil, err
+	} else if common.BytesToAddress(addr) != signerAddr {
+		return nil, fmt.Errorf("remote signer signed with address:%s, expect:%s", common.BytesToAddress(addr).Hex(), signerAddr.Hex())
+	}
+	return sig, nil
+}
+
+func (c *RemoteSigner) SignTx(addr common.Address, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	args := remoteSendTxArgs{
+		From:     addr,
+		To:       tx.To(),
+		Gas:      hexutil.Big(*tx.Gas()),
+		GasPrice: hexutil.Big(*tx.GasPrice()),
+		Value:    hexutil.Big(*tx.Value()),
+		Nonce:    hexutil.Uint64(tx.Nonce()),
+		Data:     tx.Data(),
+	}
+	if nil != chainID {
+		args.ChainId = (*hexutil.Big)(chainID)
+	}
+
+	var res remoteSignTxResult
+	if err := c.call(&res, "account_signTransaction", args); nil != err {
+		return nil, err
+	}
+	signedTx := new(types.Transaction)
+	if err := rlp.DecodeBytes(res.Raw, signedTx); nil != err {
+		return nil, err
+	}
+
+	// signer可能修改交易内容,只接受与请求一致的交易
+	var signer types.Signer = types.HomesteadSigner{}
+	if signedTx.Protected() {
+		signer = types.NewEIP155Signer(signedTx.ChainId())
+	}
+	if sender, err := types.Sender(signer, signedTx); nil != err {
+		return nil, err
+	} else if sender != addr {
+		return nil, fmt.Errorf("remote signer signed tx with address:%s, expect:%s", sender.Hex(), addr.Hex())
+	}
+	if signedTx.Nonce() != tx.Nonce() || signedTx.Gas().Cmp(tx.Gas()) != 0 || signedTx.GasPrice().Cmp(tx.GasPrice()) != 0 ||
+		signedTx.Value().Cmp(tx.Value()) != 0 || !bytes.Equal(signedTx.Data(), tx.Data()) || !sameAddress(signedTx.To(), tx.To()) {
+		return nil, errors.New("remote signer returns a transaction different from the request")
+	}
+	return signedTx, nil
+}
+
+func (c *RemoteSigner) call(result interface{}, method string, params ...interface{}) error {
+	req := remoteSignerRequest{JsonRpc: "2.0", Id: atomic.AddUint64(&c.id, 1), Method: method, Params: params}
+	body, err := json.Marshal(req)
+	if nil != err {
+		return err
+	}
+	httpReq, err := http.NewRequest("POST", c.url, bytes.NewReader(body))
+	if nil != err {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	if "" != c.token {
+		httpReq.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	httpRes, err := c.client.Do(httpReq)
+	if nil != err {
+		return err
+	}
+	defer httpRes.Body.Close()
+	if httpRes.StatusCode != http.StatusOK {
+		return fmt.Errorf("remote signer %s returns http status:%s", method, httpRes.Status)
+	}
+
+	var res remoteSignerResponse
+	if err := json.NewDecoder(httpRes.Body).Decode(&res); nil != err {
+		return err
+	}
+	if nil != res.Error {
+		return fmt.Errorf("remote signer %s err, code:%d, message:%s", method, res.Error.Code, res.Error.Message)
+	}
+	return json.Unmarshal(res.Result, result)
+}
+
+func sameAddress(a, b *common.Address) bool {
+	if nil == a || nil == b {
+		return a == b
+	}
+	return *a == *b
+}
+
+// RoutedCrypto 按照地址选择签名方式,没有配置的地址使用local(keystore)
+type RoutedCrypto struct {
+	EthCrypto
+	local   Crypto
+	signers map[common.Address]Crypto
+}
+
+func NewRoutedCrypto(homestead bool, local Crypto) *RoutedCrypto {
+	return &RoutedCrypto{EthCrypto: EthCrypto{homestead: homestead}, local: local, signers: make(map[common.Address]Crypto)}
+}
+
+func (c *RoutedCrypto) Route(addr common.Address, signer Crypto) {
+	c.signers[addr] = signer
+}
+
+func (c *RoutedCrypto) IsRouted(addr common.Address) bool {
+	_, exists := c.signers[addr]
+	return exists
+}
+
+func (c *RoutedCrypto) signerOf(addr common.Address) Crypto {
+	if signer, exists := c.signers[addr]; exists {
+		return signer
+	}
+	return c.local
+}
+
+func (c *RoutedCrypto) Sign(hashPre []byte, signerAddr common.Address) ([]byte, error) {
+	return c.signerOf(signerAddr).Sign(hashPre, signerAddr)
+}
+
+func (c *RoutedCrypto) SignTx(addr common.Address, tx *types.Transaction, chainID *big.Int) (*types.Transaction, error) {
+	return c.signerOf(addr).SignTx(addr, tx, chainID)
+}
@@ -0,0 +1,136 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package crypto
+
+import (
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/Loopring/relay/config"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rlp"
+)
+
+const testRemotePrivateKey = "d1d194d90e52aeae4cd3a727b1dbb6ea5f1de8d5379827acc5f358bf1b0acba9"
+
+// newTestClef 模拟clef的account_signData与account_signTransaction
+func newTestClef(t *testing.T, token string, key EthPrivateKeyCrypto) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+token {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Id     uint64            `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); nil != err {
+			t.Fatal(err)
+		}
+
+		var result interface{}
+		switch req.Method {
+		case "account_signData":
+			var data hexutil.Bytes
+			json.Unmarshal(req.Params[2], &data)
+			sig, err := key.Sign(data, key.Address())
+			if nil != err {
+				t.Fatal(err)
+			}
+			sig[64] += 27
+			result = hexutil.Bytes(sig)
+		case "account_signTransaction":
+			var args remoteSendTxArgs
+			json.Unmarshal(req.Params[0], &args)
+			tx := types.NewTransaction(uint64(args.Nonce), *args.To, args.Value.ToInt(), args.Gas.ToInt(), args.GasPrice.ToInt(), args.Data)
+			signed, err := key.SignTx(args.From, tx, (*big.Int)(args.ChainId))
+			if nil != err {
+				t.Fatal(err)
+			}
+			raw, _ := rlp.EncodeToBytes(signed)
+			result = remoteSignTxResult{Raw: raw}
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"jsonrpc": "2.0", "id": req.Id, "result": result})
+	}))
+}
+
+func TestRemoteSigner(t *testing.T) {
+	key, err := NewPrivateKeyCrypto(true, testRemotePrivateKey)
+	if nil != err {
+		t.Fatal(err)
+	}
+	server := newTestClef(t, "secret", key)
+	defer server.Close()
+
+	signer, err := NewRemoteSigner(true, config.RemoteSignerOptions{Url: server.URL, Token: "secret", Addresses: []string{key.Address().Hex()}})
+	if nil != err {
+		t.Fatal(err)
+	}
+
+	hash := common.HexToHash("0x81181790552cbbff19077f2289e29992bdb5d0eee12ca1a7ce35ac2508406c3c").Bytes()
+	sig, err := signer.Sign(hash, key.Address())
+	if nil != err {
+		t.Fatal(err)
+	}
+	if expected, _ := key.Sign(hash, key.Address()); common.ToHex(sig) != common.ToHex(expected) {
+		t.Fatalf("signature:%s, expect:%s", common.ToHex(sig), common.ToHex(expected))
+	}
+	if _, err := signer.Sign(hash, common.HexToAddress("0x1")); nil == err {
+		t.Fatal("signature of other address should be rejected")
+	}
+
+	to := common.HexToAddress("0x750aD4351bB728ceC7d639A9511F9D6488f1E259")
+	tx := types.NewTransaction(3, to, big.NewInt(0), big.NewInt(300000), big.NewInt(1e9), []byte{1, 2, 3})
+	signedTx, err := signer.SignTx(key.Address(), tx, big.NewInt(1))
+	if nil != err {
+		t.Fatal(err)
+	}
+	if sender, _ := types.Sender(types.NewEIP155Signer(big.NewInt(1)), signedTx); sender != key.Address() {
+		t.Fatalf("tx sender:%s, expect:%s", sender.Hex(), key.Address().Hex())
+	}
+
+	unauthorized, _ := NewRemoteSigner(true, config.RemoteSignerOptions{Url: server.URL, Token: "wrong"})
+	if _, err := unauthorized.Sign(hash, key.Address()); nil == err {
+		t.Fatal("request with wrong token should be rejected")
+	}
+}
+
+func TestRoutedCrypto(t *testing.T) {
+	key, _ := NewPrivateKeyCrypto(true, testRemotePrivateKey)
+	server := newTestClef(t, "secret", key)
+	defer server.Close()
+
+	remote, _ := NewRemoteSigner(true, config.RemoteSignerOptions{Url: server.URL, Token: "secret"})
+	routed := NewRoutedCrypto(true, NewKSCrypto(true, nil))
+	routed.Route(key.Address(), remote)
+
+	if !routed.IsRouted(key.Address()) || routed.IsRouted(common.HexToAddress("0x1")) {
+		t.Fatal("wrong route")
+	}
+	hash := common.HexToHash("0x01").Bytes()
+	if _, err := routed.Sign(hash, key.Address()); nil != err {
+		t.Fatal(err)
+	}
+}
@@ -29,6 +29,28 @@ func (s *RdsServiceImpl) Add(item interface{}) error {
 	return s.db.Create(item).Error
 }
 
+// run process in one db transaction, process must use the rds passed in
+func (s *RdsServiceImpl) Transaction(process func(rds RdsService) error) (err error) {
+	tx := s.db.Begin()
+	if err = tx.Error; err != nil {
+		return err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	if err = process(&RdsServiceImpl{options: s.options, db: tx}); err != nil {
+		return err
+	}
+	return tx.Commit().Error
+}
+
 // del single item
 func (s *RdsServiceImpl) Del(item interface{}) error {
 	return s.db.Delete(item).Error
@@ -87,3 +87,17 @@ func (s *RdsServiceImpl) GetCancelForkEvents(from, to int64) ([]CancelEvent, err
 func (s *RdsServiceImpl) RollBackCancel(from, to int64) error {
 	return s.db.Model(&CancelEvent{}).Where("block_number > ? and block_number <= ?", from, to).Update("fork", true).Error
 }
+
+func (s *RdsServiceImpl) GetCancelsForReplay(start, end int64) ([]CancelEvent, error) {
+	var (
+		list []CancelEvent
+		err  error
+	)
+
+	err = s.db.Where("create_time >= ? and create_time < ?", start, end).
+		Where("fork = ?", false).
+		Order("block_number asc, log_index asc").
+		Find(&list).Error
+
+	return list, err
+}
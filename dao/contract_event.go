@@ -0,0 +1,126 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"encoding/json"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+)
+
+// ContractEvent extractor.contracts中登记合约的事件,参数以json保存
+type ContractEvent struct {
+	ID          int    `gorm:"column:id;primary_key" json:"id"`
+	Contract    string `gorm:"column:contract;type:varchar(64)" json:"contract"`
+	Address     string `gorm:"column:contract_address;type:varchar(42);index" json:"address"`
+	EventName   string `gorm:"column:event_name;type:varchar(64)" json:"eventName"`
+	EventId     string `gorm:"column:event_id;type:varchar(82)" json:"eventId"`
+	Params      string `gorm:"column:params;type:text" json:"params"`
+	Owner       string `gorm:"column:owner;type:varchar(42);index" json:"owner"`
+	TxHash      string `gorm:"column:tx_hash;type:varchar(82)" json:"txHash"`
+	LogIndex    int64  `gorm:"column:log_index;type:bigint" json:"logIndex"`
+	BlockNumber int64  `gorm:"column:block_number;type:bigint" json:"blockNumber"`
+	Time        int64  `gorm:"column:time;type:bigint" json:"timestamp"`
+	Fork        bool   `gorm:"column:fork"`
+}
+
+func (e *ContractEvent) ConvertDown(event *types.ContractEvent) error {
+	params, err := json.Marshal(event.Params)
+	if err != nil {
+		return err
+	}
+
+	e.Contract = event.ContractName
+	e.Address = event.Protocol.Hex()
+	e.EventName = event.EventName
+	e.EventId = event.EventId.Hex()
+	e.Params = string(params)
+	e.Owner = event.Owner.Hex()
+	e.TxHash = event.TxHash.Hex()
+	e.LogIndex = event.TxLogIndex
+	e.BlockNumber = event.BlockNumber.Int64()
+	e.Time = event.BlockTime
+	e.Fork = false
+
+	return nil
+}
+
+func (e *ContractEvent) ConvertUp(event *types.ContractEvent) error {
+	if err := json.Unmarshal([]byte(e.Params), &event.Params); err != nil {
+		return err
+	}
+
+	event.ContractName = e.Contract
+	event.Protocol = common.HexToAddress(e.Address)
+	event.EventName = e.EventName
+	event.EventId = common.HexToHash(e.EventId)
+	event.Owner = common.HexToAddress(e.Owner)
+	event.TxHash = common.HexToHash(e.TxHash)
+	event.TxLogIndex = e.LogIndex
+	event.BlockNumber = big.NewInt(e.BlockNumber)
+	event.BlockTime = e.Time
+	event.Status = types.TX_STATUS_SUCCESS
+
+	return nil
+}
+
+func (s *RdsServiceImpl) FindContractEvent(txhash string, logIndex int64) (*ContractEvent, error) {
+	var (
+		model ContractEvent
+		err   error
+	)
+
+	err = s.db.Where("tx_hash = ? and log_index = ?", txhash, logIndex).Where("fork = ?", false).First(&model).Error
+
+	return &model, err
+}
+
+func (s *RdsServiceImpl) GetContractEventCount(owner, contract string) (int, error) {
+	var number int
+
+	query := assembleContractEventQuery(owner, contract)
+	err := s.db.Model(&ContractEvent{}).Where(query).Count(&number).Error
+
+	return number, err
+}
+
+func (s *RdsServiceImpl) GetContractEvents(owner, contract string, limit, offset int) ([]ContractEvent, error) {
+	var list []ContractEvent
+
+	query := assembleContractEventQuery(owner, contract)
+	err := s.db.Where(query).Order("block_number DESC, log_index DESC").Limit(limit).Offset(offset).Find(&list).Error
+
+	return list, err
+}
+
+func (s *RdsServiceImpl) RollBackContractEvent(from, to int64) error {
+	return s.db.Model(&ContractEvent{}).Where("block_number > ? and block_number <= ?", from, to).Update("fork", true).Error
+}
+
+func assembleContractEventQuery(owner, contract string) map[string]interface{} {
+	query := make(map[string]interface{})
+	query["owner"] = owner
+	query["fork"] = false
+	if contract != "" {
+		query["contract"] = contract
+	}
+
+	return query
+}
@@ -70,18 +70,24 @@ func (s *RdsServiceImpl) Prepare() {
 	tables = append(tables, &Order{})
 	tables = append(tables, &Block{})
 	tables = append(tables, &RingMinedEvent{})
+	tables = append(tables, &RinghashSubmittedEvent{})
 	tables = append(tables, &FillEvent{})
 	tables = append(tables, &CancelEvent{})
 	tables = append(tables, &CutOffEvent{})
 	tables = append(tables, &CutOffPairEvent{})
 	tables = append(tables, &Trend{})
 	tables = append(tables, &WhiteList{})
+	tables = append(tables, &OrderLimit{})
 	tables = append(tables, &RingSubmitInfo{})
 	tables = append(tables, &FilledOrder{})
+	tables = append(tables, &RingProfit{})
 	tables = append(tables, &Transaction{})
 	tables = append(tables, &TransactionEntity{})
 	tables = append(tables, &TransactionView{})
 	tables = append(tables, &CheckPoint{})
+	tables = append(tables, &OrderTrigger{})
+	tables = append(tables, &ContractEvent{})
+	tables = append(tables, &ProcessedEvent{})
 	//tables = append(tables, &RingMinedMethod{})
 
 	for _, t := range tables {
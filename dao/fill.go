@@ -178,6 +178,14 @@ func (s *RdsServiceImpl) QueryRecentFills(market, owner string, start int64, end
 	return
 }
 
+func (s *RdsServiceImpl) GetFillsForReplay(start, end int64) (fills []FillEvent, err error) {
+	err = s.db.Where("create_time >= ? and create_time < ?", start, end).
+		Where("fork = ?", false).
+		Order("block_number asc, log_index asc").
+		Find(&fills).Error
+	return
+}
+
 func buildTimeQueryString(start, end int64) string {
 	rst := ""
 	if start != 0 && end == 0 {
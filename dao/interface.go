@@ -36,16 +36,35 @@ type RdsService interface {
 	Last(item interface{}) error
 	Save(item interface{}) error
 	FindAll(item interface{}) error
+	Transaction(process func(rds RdsService) error) error
 
 	// ring mined table
 	FindRingMined(txhash string) (*RingMinedEvent, error)
 	RollBackRingMined(from, to int64) error
+	GetRingMinedForReplay(start, end int64) ([]RingMinedEvent, error)
+
+	// ringhash submitted table
+	FindRinghashSubmitted(txhash string, logIndex int64) (*RinghashSubmittedEvent, error)
+	GetRinghashSubmittedByHashes(ringhashes []string) ([]RinghashSubmittedEvent, error)
+	GetRinghashSubmittedSince(time int64) ([]RinghashSubmittedEvent, error)
+	RollBackRinghashSubmitted(from, to int64) error
+
+	// processed event table
+	ProcessEventOnce(txhash string, logIndex int64, handler string, blockNumber int64, process func(rds RdsService) error) (bool, error)
+	RollBackProcessedEvent(prefix string, from, to int64) error
+
+	// contract event table
+	FindContractEvent(txhash string, logIndex int64) (*ContractEvent, error)
+	GetContractEventCount(owner, contract string) (int, error)
+	GetContractEvents(owner, contract string, limit, offset int) ([]ContractEvent, error)
+	RollBackContractEvent(from, to int64) error
 
 	// order table
 	GetOrderByHash(orderhash common.Hash) (*Order, error)
 	GetOrdersByHash(orderhashs []string) (map[string]Order, error)
 	MarkMinerOrders(filterOrderhashs []string, blockNumber int64) error
 	GetOrdersForMiner(protocol, tokenS, tokenB string, length int, filterStatus []types.OrderStatus, reservedTime, startBlockNumber, endBlockNumber int64) ([]*Order, error)
+	GetOrdersForBook(statusSet []types.OrderStatus) ([]Order, error)
 	GetCutoffOrders(owner common.Address, cutoffTime *big.Int) ([]Order, error)
 	GetCutoffPairOrders(owner, token1, token2 common.Address, cutoffTime *big.Int) ([]Order, error)
 	SetCutOffOrders(orderHashList []common.Hash, blockNumber *big.Int) error
@@ -55,8 +74,19 @@ type RdsService interface {
 	UpdateOrderWhileRollbackCutoff(orderhash common.Hash, status types.OrderStatus, blockNumber *big.Int) error
 	UpdateOrderWhileFill(hash common.Hash, status types.OrderStatus, dealtAmountS, dealtAmountB, splitAmountS, splitAmountB, blockNumber *big.Int) error
 	UpdateOrderWhileCancel(hash common.Hash, status types.OrderStatus, cancelledAmountS, cancelledAmountB, blockNumber *big.Int) error
+	UpdateOrderStatus(orderhash common.Hash, status types.OrderStatus) error
+	GetFundableOrders(owner, tokenS common.Address, statusSet []types.OrderStatus) ([]Order, error)
+	UpdateOrderFundableAmount(orderhash common.Hash, fundableAmountS *big.Int) error
 	GetFrozenAmount(owner common.Address, token common.Address, statusSet []types.OrderStatus, delegateAddress common.Address) ([]Order, error)
 	GetFrozenLrcFee(owner common.Address, statusSet []types.OrderStatus) ([]Order, error)
+	GetFrozenOrders(statusSet []types.OrderStatus) ([]Order, error)
+	GetOpenOrdersByOwner(owner common.Address, statusSet []types.OrderStatus) ([]Order, error)
+	GetOrdersForReplay(start, end int64) ([]Order, error)
+
+	// order trigger table
+	GetOrderTrigger(orderhash common.Hash) (*OrderTrigger, error)
+	GetPendingOrderTriggers() ([]OrderTrigger, error)
+	UpdateOrderTriggerStatus(orderhash common.Hash, status types.TriggerStatus, triggerTime int64) error
 
 	// block table
 	FindBlockByHash(blockhash common.Hash) (*Block, error)
@@ -72,11 +102,13 @@ type RdsService interface {
 	FillsPageQuery(query map[string]interface{}, pageIndex, pageSize int) (res PageResult, err error)
 	GetLatestFills(query map[string]interface{}, limit int) (res []FillEvent, err error)
 	FindFillsByRingHash(ringHash common.Hash) ([]FillEvent, error)
+	GetFillsForReplay(start, end int64) ([]FillEvent, error)
 
 	// cancel event table
 	GetCancelEvent(txhash common.Hash) (CancelEvent, error)
 	RollBackCancel(from, to int64) error
 	GetCancelForkEvents(from, to int64) ([]CancelEvent, error)
+	GetCancelsForReplay(start, end int64) ([]CancelEvent, error)
 
 	// cutoff event table
 	GetCutoffEvent(txhash common.Hash) (CutOffEvent, error)
@@ -98,6 +130,10 @@ type RdsService interface {
 	GetWhiteList() ([]WhiteList, error)
 	FindWhiteListUserByAddress(address common.Address) (*WhiteList, error)
 
+	// order limit
+	GetOrderLimits() ([]OrderLimit, error)
+	FindOrderLimitByAddress(address common.Address) (*OrderLimit, error)
+
 	//ringSubmitInfo
 	//UpdateRingSubmitInfoProtocolTxHash(ringhash common.Hash, txHash string) error
 	//UpdateRingSubmitInfoSubmitUsedGas(txHash string, usedGas *big.Int) error
@@ -106,10 +142,16 @@ type RdsService interface {
 	UpdateRingSubmitInfoResult(submitResult *types.RingSubmitResultEvent) error
 	GetRingForSubmitByHash(ringhash common.Hash) (RingSubmitInfo, error)
 	GetRingHashesByTxHash(txHash common.Hash) ([]*RingSubmitInfo, error)
+	GetRingSubmitInfosBySubmitStatus(statusSet []types.RingSubmitStatus) ([]RingSubmitInfo, error)
 	RingMinedPageQuery(query map[string]interface{}, pageIndex, pageSize int) (res PageResult, err error)
 	GetRingminedMethods(lastId int, limit int) ([]RingMinedEvent, error)
 	GetFilledOrderByRinghash(ringhash common.Hash) ([]*FilledOrder, error)
 
+	// ring profit
+	FindRingProfitByRinghash(ringhash common.Hash) (*RingProfit, error)
+	GetUnreconciledRingProfits(sinceMinedTime int64, afterId int, limit int) ([]RingProfit, error)
+	GetRingProfits(start, end int64) ([]RingProfit, error)
+
 	// transactions
 	GetTransactionById(id int) (Transaction, error)
 
@@ -127,6 +169,7 @@ type RdsService interface {
 	SetPendingTxViewFailed(hashlist []string) error
 	GetTxViewByOwnerAndHashs(owner string, hashs []string) ([]TransactionView, error)
 	GetPendingTxViewByOwner(owner string) ([]TransactionView, error)
+	GetPendingTxViewByHash(hash string) ([]TransactionView, error)
 	GetTxViewCountByOwner(owner string, symbol string, status types.TxStatus, typ txtyp.TxType) (int, error)
 	GetTxViewByOwner(owner string, symbol string, status types.TxStatus, typ txtyp.TxType, limit, offset int) ([]TransactionView, error)
 	RollBackTxView(from, to int64) error
@@ -71,6 +71,7 @@ type Order struct {
 	Market                string  `gorm:"column:market;type:varchar(40)"`
 	Side                  string  `gorm:"column:side;type:varchar(40)`
 	OrderType             string  `gorm:"column:order_type;type:varchar(40)`
+	FundableAmountS       string  `gorm:"column:fundable_amount_s;type:varchar(40)"`
 }
 
 // convert types/orderState to dao/order
@@ -117,6 +118,9 @@ func (o *Order) ConvertDown(state *types.OrderState) error {
 	o.BroadcastTime = state.BroadcastTime
 	o.Side = state.RawOrder.Side
 	o.OrderType = state.RawOrder.OrderType
+	if state.FundableAmountS != nil {
+		o.FundableAmountS = state.FundableAmountS.String()
+	}
 
 	return nil
 }
@@ -179,6 +183,9 @@ func (o *Order) ConvertUp(state *types.OrderState) error {
 		state.RawOrder.Side = o.Side
 	}
 	state.RawOrder.OrderType = o.OrderType
+	if len(o.FundableAmountS) > 0 {
+		state.FundableAmountS, _ = new(big.Int).SetString(o.FundableAmountS, 0)
+	}
 	return nil
 }
 
@@ -227,6 +234,63 @@ func (s *RdsServiceImpl) GetOrdersForMiner(protocol, tokenS, tokenB string, leng
 	return list, err
 }
 
+// 订单簿启动时从数据库加载所有未过期的市场订单
+func (s *RdsServiceImpl) GetOrdersForBook(statusSet []types.OrderStatus) ([]Order, error) {
+	var (
+		list []Order
+		err  error
+	)
+
+	err = s.db.Where("status in (?)", statusSet).
+		Where("order_type = ? ", types.ORDER_TYPE_MARKET).
+		Where("valid_until >= ? ", time.Now().Unix()).
+		Find(&list).Error
+
+	return list, err
+}
+
+// GetOrdersForReplay 回测时加载[start, end)之间有效的市场订单,按照提交时间排序
+func (s *RdsServiceImpl) GetOrdersForReplay(start, end int64) ([]Order, error) {
+	var (
+		list []Order
+		err  error
+	)
+
+	err = s.db.Where("order_type = ? ", types.ORDER_TYPE_MARKET).
+		Where("create_time < ? and valid_until >= ?", end, start).
+		Order("create_time asc").
+		Find(&list).Error
+
+	return list, err
+}
+
+func (s *RdsServiceImpl) GetOpenOrdersByOwner(owner common.Address, statusSet []types.OrderStatus) ([]Order, error) {
+	var (
+		list []Order
+		err  error
+	)
+
+	err = s.db.Where("owner = ? and status in (?)", owner.Hex(), statusSet).
+		Where("valid_until >= ? ", time.Now().Unix()).
+		Find(&list).Error
+
+	return list, err
+}
+
+// GetFrozenOrders 所有未过期的冻结状态订单,用于重建冻结金额账本
+func (s *RdsServiceImpl) GetFrozenOrders(statusSet []types.OrderStatus) ([]Order, error) {
+	var (
+		list []Order
+		err  error
+	)
+
+	err = s.db.Where("status in (?)", statusSet).
+		Where("valid_until >= ? ", time.Now().Unix()).
+		Find(&list).Error
+
+	return list, err
+}
+
 func (s *RdsServiceImpl) GetOrdersByHash(orderhashs []string) (map[string]Order, error) {
 	var (
 		list []Order
@@ -474,6 +538,28 @@ func (s *RdsServiceImpl) UpdateOrderWhileRollbackCutoff(orderhash common.Hash, s
 	return s.db.Model(&Order{}).Where("order_hash = ?", orderhash.Hex()).Update(items).Error
 }
 
+func (s *RdsServiceImpl) UpdateOrderStatus(orderhash common.Hash, status types.OrderStatus) error {
+	return s.db.Model(&Order{}).Where("order_hash = ?", orderhash.Hex()).Update("status", uint8(status)).Error
+}
+
+// GetFundableOrders 按下单先后返回owner卖出tokenS的有效订单,用于分配可成交数量
+func (s *RdsServiceImpl) GetFundableOrders(owner, tokenS common.Address, statusSet []types.OrderStatus) ([]Order, error) {
+	var (
+		list []Order
+		err  error
+	)
+
+	err = s.db.Where("owner = ? and token_s = ? and status in (?) and valid_until >= ?", owner.Hex(), tokenS.Hex(), statusSet, time.Now().Unix()).
+		Order("create_time asc, id asc").
+		Find(&list).Error
+
+	return list, err
+}
+
+func (s *RdsServiceImpl) UpdateOrderFundableAmount(orderhash common.Hash, fundableAmountS *big.Int) error {
+	return s.db.Model(&Order{}).Where("order_hash = ?", orderhash.Hex()).Update("fundable_amount_s", fundableAmountS.String()).Error
+}
+
 func (s *RdsServiceImpl) GetFrozenAmount(owner common.Address, token common.Address, statusSet []types.OrderStatus, delegateAddress common.Address) ([]Order, error) {
 	var (
 		list []Order
@@ -0,0 +1,79 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"errors"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type OrderLimit struct {
+	ID               int     `gorm:"column:id;primary_key;"`
+	Owner            string  `gorm:"column:owner;type:varchar(42);unique_index"`
+	MaxOpenOrders    int     `gorm:"column:max_open_orders"`
+	MaxOpenUsdAmount float64 `gorm:"column:max_open_usd_amount;type:decimal(28,8);"`
+	CreateTime       int64   `gorm:"column:create_time"`
+	IsDeleted        bool    `gorm:"column:is_deleted"`
+}
+
+func (s *RdsServiceImpl) GetOrderLimits() ([]OrderLimit, error) {
+	var (
+		list []OrderLimit
+		err  error
+	)
+
+	err = s.db.Where("is_deleted = false").Find(&list).Error
+
+	return list, err
+}
+
+func (s *RdsServiceImpl) FindOrderLimitByAddress(address common.Address) (*OrderLimit, error) {
+	var (
+		limit OrderLimit
+		err   error
+	)
+
+	err = s.db.Where("owner = ?", address.Hex()).First(&limit).Error
+
+	return &limit, err
+}
+
+func (l *OrderLimit) ConvertDown(src *types.OrderLimit) error {
+	l.Owner = src.Owner.Hex()
+	l.MaxOpenOrders = src.MaxOpenOrders
+	l.MaxOpenUsdAmount = src.MaxOpenUsdAmount
+	l.CreateTime = src.CreateTime
+	l.IsDeleted = false
+
+	return nil
+}
+
+func (l *OrderLimit) ConvertUp(dst *types.OrderLimit) error {
+	if l.IsDeleted == true {
+		return errors.New("order limit of " + l.Owner + " has deleted")
+	}
+
+	dst.Owner = common.HexToAddress(l.Owner)
+	dst.MaxOpenOrders = l.MaxOpenOrders
+	dst.MaxOpenUsdAmount = l.MaxOpenUsdAmount
+	dst.CreateTime = l.CreateTime
+
+	return nil
+}
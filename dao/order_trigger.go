@@ -0,0 +1,91 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type OrderTrigger struct {
+	ID           int     `gorm:"column:id;primary_key;"`
+	OrderHash    string  `gorm:"column:order_hash;type:varchar(82);unique_index"`
+	Owner        string  `gorm:"column:owner;type:varchar(42)"`
+	Market       string  `gorm:"column:market;type:varchar(40)"`
+	PriceSource  string  `gorm:"column:price_source;type:varchar(20)"`
+	Condition    string  `gorm:"column:trigger_condition;type:varchar(10)"`
+	TriggerPrice float64 `gorm:"column:trigger_price;type:decimal(28,16);"`
+	Status       uint8   `gorm:"column:status;type:tinyint(4)"`
+	CreateTime   int64   `gorm:"column:create_time;type:bigint"`
+	TriggerTime  int64   `gorm:"column:trigger_time;type:bigint"`
+}
+
+func (t *OrderTrigger) ConvertDown(src *types.OrderTrigger) error {
+	t.OrderHash = src.OrderHash.Hex()
+	t.Owner = src.Owner.Hex()
+	t.Market = src.Market
+	t.PriceSource = src.PriceSource
+	t.Condition = src.Condition
+	t.TriggerPrice = src.TriggerPrice
+	t.Status = uint8(src.Status)
+	t.CreateTime = src.CreateTime
+	t.TriggerTime = src.TriggerTime
+
+	return nil
+}
+
+func (t *OrderTrigger) ConvertUp(dst *types.OrderTrigger) error {
+	dst.OrderHash = common.HexToHash(t.OrderHash)
+	dst.Owner = common.HexToAddress(t.Owner)
+	dst.Market = t.Market
+	dst.PriceSource = t.PriceSource
+	dst.Condition = t.Condition
+	dst.TriggerPrice = t.TriggerPrice
+	dst.Status = types.TriggerStatus(t.Status)
+	dst.CreateTime = t.CreateTime
+	dst.TriggerTime = t.TriggerTime
+
+	return nil
+}
+
+func (s *RdsServiceImpl) GetOrderTrigger(orderhash common.Hash) (*OrderTrigger, error) {
+	trigger := &OrderTrigger{}
+	err := s.db.Where("order_hash = ?", orderhash.Hex()).First(trigger).Error
+	return trigger, err
+}
+
+func (s *RdsServiceImpl) GetPendingOrderTriggers() ([]OrderTrigger, error) {
+	var (
+		list []OrderTrigger
+		err  error
+	)
+
+	err = s.db.Where("status = ?", uint8(types.TRIGGER_STATUS_PENDING)).Order("create_time asc").Find(&list).Error
+	return list, err
+}
+
+func (s *RdsServiceImpl) UpdateOrderTriggerStatus(orderhash common.Hash, status types.TriggerStatus, triggerTime int64) error {
+	items := map[string]interface{}{
+		"status":       uint8(status),
+		"trigger_time": triggerTime,
+	}
+	return s.db.Model(&OrderTrigger{}).
+		Where("order_hash = ? and status = ?", orderhash.Hex(), uint8(types.TRIGGER_STATUS_PENDING)).
+		Update(items).Error
+}
@@ -0,0 +1,77 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"fmt"
+	"time"
+)
+
+// ProcessedEvent 已处理过的链上事件,与handler的处理结果在同一个数据库事务中写入,
+// 重启、重试以及回填时同一事件对同一handler只会生效一次
+type ProcessedEvent struct {
+	ID          int    `gorm:"column:id;primary_key"`
+	TxHash      string `gorm:"column:tx_hash;type:varchar(82);unique_index:tx_log_handler"`
+	LogIndex    int64  `gorm:"column:log_index;type:bigint;unique_index:tx_log_handler"`
+	Handler     string `gorm:"column:handler;type:varchar(64);unique_index:tx_log_handler"`
+	BlockNumber int64  `gorm:"column:block_number;type:bigint;index"`
+	CreateTime  int64  `gorm:"column:create_time;type:bigint"`
+}
+
+// ProcessEventOnce 事件未处理过时,在同一个事务中登记事件并执行process,process中的数据库操作必须使用传入的rds.
+// 返回false表示事件已经处理过,process未执行;并发处理同一事件时唯一索引冲突的一方返回错误
+func (s *RdsServiceImpl) ProcessEventOnce(txhash string, logIndex int64, handler string, blockNumber int64, process func(rds RdsService) error) (processed bool, err error) {
+	tx := s.db.Begin()
+	if err = tx.Error; err != nil {
+		return false, err
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			tx.Rollback()
+			panic(r)
+		}
+		if err != nil || !processed {
+			tx.Rollback()
+		}
+	}()
+
+	var model ProcessedEvent
+	if tx.Where("tx_hash = ? and log_index = ? and handler = ?", txhash, logIndex, handler).First(&model).Error == nil {
+		return false, nil
+	}
+
+	model = ProcessedEvent{TxHash: txhash, LogIndex: logIndex, Handler: handler, BlockNumber: blockNumber, CreateTime: time.Now().Unix()}
+	if err = tx.Create(&model).Error; err != nil {
+		return false, fmt.Errorf("add processed event tx:%s logIndex:%d handler:%s error:%s", txhash, logIndex, handler, err.Error())
+	}
+
+	if err = process(&RdsServiceImpl{options: s.options, db: tx}); err != nil {
+		return false, err
+	}
+	if err = tx.Commit().Error; err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RollBackProcessedEvent 删除分叉块中handler以prefix开头的登记,新链上的同一事件需要重新处理
+func (s *RdsServiceImpl) RollBackProcessedEvent(prefix string, from, to int64) error {
+	return s.db.Where("handler like ?", prefix+"%").Where("block_number > ? and block_number <= ?", from, to).Delete(&ProcessedEvent{}).Error
+}
@@ -0,0 +1,114 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao_test
+
+import (
+	"errors"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/test"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testEventHash() common.Hash {
+	return common.BigToHash(big.NewInt(time.Now().UnixNano()))
+}
+
+func TestRdsServiceImpl_ProcessEventOnceDuplicate(t *testing.T) {
+	s := test.GenerateDaoService()
+	s.Prepare()
+
+	txhash := testEventHash().Hex()
+	count := 0
+	process := func(rds dao.RdsService) error {
+		count++
+		return nil
+	}
+
+	processed, err := s.ProcessEventOnce(txhash, 1, "test.duplicate", 100, process)
+	if err != nil || !processed {
+		t.Fatalf("first process, processed:%t err:%v", processed, err)
+	}
+	processed, err = s.ProcessEventOnce(txhash, 1, "test.duplicate", 100, process)
+	if err != nil || processed {
+		t.Fatalf("duplicate process, processed:%t err:%v", processed, err)
+	}
+	if count != 1 {
+		t.Fatalf("process executed %d times", count)
+	}
+
+	// 同一事件的其他logIndex及其他handler分别登记
+	if processed, _ := s.ProcessEventOnce(txhash, 2, "test.duplicate", 100, process); !processed {
+		t.Fatalf("other logIndex should be processed")
+	}
+	if processed, _ := s.ProcessEventOnce(txhash, 1, "test.other", 100, process); !processed {
+		t.Fatalf("other handler should be processed")
+	}
+}
+
+func TestRdsServiceImpl_ProcessEventOnceRollback(t *testing.T) {
+	s := test.GenerateDaoService()
+	s.Prepare()
+
+	txhash := testEventHash().Hex()
+	ringhash := testEventHash()
+	processErr := errors.New("process failed")
+
+	processed, err := s.ProcessEventOnce(txhash, 1, "test.rollback", 100, func(rds dao.RdsService) error {
+		if err := rds.Add(&dao.RingProfit{RingHash: ringhash.Hex()}); err != nil {
+			return err
+		}
+		return processErr
+	})
+	if err != processErr || processed {
+		t.Fatalf("failed process, processed:%t err:%v", processed, err)
+	}
+	if _, err := s.FindRingProfitByRinghash(ringhash); err == nil {
+		t.Fatalf("record added in failed process should be rolled back")
+	}
+
+	// 登记随process一起回滚,重试时重新处理
+	processed, err = s.ProcessEventOnce(txhash, 1, "test.rollback", 100, func(rds dao.RdsService) error {
+		return rds.Add(&dao.RingProfit{RingHash: ringhash.Hex()})
+	})
+	if err != nil || !processed {
+		t.Fatalf("retry process, processed:%t err:%v", processed, err)
+	}
+	if _, err := s.FindRingProfitByRinghash(ringhash); err != nil {
+		t.Fatalf("record added in retry should be committed, err:%s", err.Error())
+	}
+}
+
+func TestRdsServiceImpl_RollBackProcessedEvent(t *testing.T) {
+	s := test.GenerateDaoService()
+	s.Prepare()
+
+	txhash := testEventHash().Hex()
+	process := func(rds dao.RdsService) error { return nil }
+
+	s.ProcessEventOnce(txhash, 1, "test.fork.handler", 100, process)
+	if err := s.RollBackProcessedEvent("test.fork.", 99, 100); err != nil {
+		t.Fatal(err.Error())
+	}
+	if processed, err := s.ProcessEventOnce(txhash, 1, "test.fork.handler", 100, process); err != nil || !processed {
+		t.Fatalf("event in forked block should be processed again, processed:%t err:%v", processed, err)
+	}
+}
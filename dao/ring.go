@@ -130,12 +130,13 @@ type RingSubmitInfo struct {
 	ProtocolUsedGas  string `gorm:"column:protocol_used_gas;type:varchar(50)"`
 	ProtocolTxHash   string `gorm:"column:protocol_tx_hash;type:varchar(82)"`
 
-	Status      int       `gorm:"column:status;type:int"`
-	RingIndex   string    `gorm:"column:ring_index;type:varchar(50)"`
-	BlockNumber string    `gorm:"column:block_number;type:varchar(50)"`
-	Miner       string    `gorm:"column:miner;type:varchar(42)"`
-	Err         string    `gorm:"column:err;type:text"`
-	CreateTime  time.Time `gorm:"column:create_time;type:TIMESTAMP;default:CURRENT_TIMESTAMP"`
+	Status       int       `gorm:"column:status;type:int"`
+	SubmitStatus int       `gorm:"column:submit_status;type:int"`
+	RingIndex    string    `gorm:"column:ring_index;type:varchar(50)"`
+	BlockNumber  string    `gorm:"column:block_number;type:varchar(50)"`
+	Miner        string    `gorm:"column:miner;type:varchar(42)"`
+	Err          string    `gorm:"column:err;type:text"`
+	CreateTime   time.Time `gorm:"column:create_time;type:TIMESTAMP;default:CURRENT_TIMESTAMP"`
 }
 
 func getBigIntString(v *big.Int) string {
@@ -148,7 +149,10 @@ func getBigIntString(v *big.Int) string {
 
 func (info *RingSubmitInfo) ConvertDown(typesInfo *types.RingSubmitInfo, err error) error {
 	info.RingHash = typesInfo.Ringhash.Hex()
-	info.UniqueId = typesInfo.RawRing.GenerateUniqueId().Hex()
+	// 重启后从outbox恢复的环路没有RawRing,保留原有的UniqueId
+	if nil != typesInfo.RawRing {
+		info.UniqueId = typesInfo.RawRing.GenerateUniqueId().Hex()
+	}
 	info.ProtocolAddress = typesInfo.ProtocolAddress.Hex()
 	info.OrdersCount = typesInfo.OrdersCount.Int64()
 	info.ProtocolData = common.ToHex(typesInfo.ProtocolData)
@@ -207,6 +211,12 @@ func (s *RdsServiceImpl) UpdateRingSubmitInfoResult(submitResult *types.RingSubm
 	if nil != submitResult.Err {
 		items["err"] = submitResult.Err.Error()
 	}
+	switch submitResult.Status {
+	case types.TX_STATUS_SUCCESS:
+		items["submit_status"] = uint8(types.RING_SUBMIT_MINED)
+	case types.TX_STATUS_FAILED:
+		items["submit_status"] = uint8(types.RING_SUBMIT_FAILED)
+	}
 	dbForUpdate := s.db.Model(&RingSubmitInfo{}).Where("ringhash = ? and protocol_tx_hash = ? ", submitResult.RingHash.Hex(), submitResult.TxHash.Hex())
 	return dbForUpdate.Update(items).Error
 }
@@ -221,6 +231,21 @@ func (s *RdsServiceImpl) GetRingForSubmitByHash(ringhash common.Hash) (ringForSu
 	return
 }
 
+// GetRingSubmitInfosBySubmitStatus 重启时用于恢复outbox中没有发送或者没有结果的环路
+func (s *RdsServiceImpl) GetRingSubmitInfosBySubmitStatus(statusSet []types.RingSubmitStatus) ([]RingSubmitInfo, error) {
+	var (
+		err   error
+		infos []RingSubmitInfo
+	)
+
+	err = s.db.Where("submit_status in (?)", statusSet).
+		Order("id asc").
+		Find(&infos).
+		Error
+
+	return infos, err
+}
+
 func (s *RdsServiceImpl) GetRingHashesByTxHash(txHash common.Hash) ([]*RingSubmitInfo, error) {
 	var (
 		err   error
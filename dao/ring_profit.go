@@ -0,0 +1,88 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// RingProfit 环路预估收益与链上实际收益,金额均为法币
+type RingProfit struct {
+	ID                int     `gorm:"column:id;primary_key;"`
+	RingHash          string  `gorm:"column:ringhash;type:varchar(82);unique_index"`
+	Market            string  `gorm:"column:market;type:varchar(42)"`
+	Miner             string  `gorm:"column:miner;type:varchar(42)"`
+	TxHash            string  `gorm:"column:tx_hash;type:varchar(82)"`
+	EstimatedFee      float64 `gorm:"column:estimated_fee;type:decimal(28,8)"`
+	EstimatedCost     float64 `gorm:"column:estimated_cost;type:decimal(28,8)"`
+	EstimatedReceived float64 `gorm:"column:estimated_received;type:decimal(28,8)"`
+	RealizedFee       float64 `gorm:"column:realized_fee;type:decimal(28,8)"`
+	RealizedCost      float64 `gorm:"column:realized_cost;type:decimal(28,8)"`
+	GasUsed           string  `gorm:"column:gas_used;type:varchar(50)"`
+	GasPrice          string  `gorm:"column:gas_price;type:varchar(50)"`
+	MinedPrices       string  `gorm:"column:mined_prices;type:text"` // 处理ringmined事件时各token的法币价格,json
+	Status            uint8   `gorm:"column:status;type:tinyint(4)"`
+	Reconciled        bool    `gorm:"column:reconciled"`
+	BlockNumber       int64   `gorm:"column:block_number;type:bigint"`
+	MinedTime         int64   `gorm:"column:mined_time;type:bigint"`
+	CreateTime        int64   `gorm:"column:create_time;type:bigint"`
+}
+
+func (s *RdsServiceImpl) FindRingProfitByRinghash(ringhash common.Hash) (*RingProfit, error) {
+	var (
+		profit RingProfit
+		err    error
+	)
+
+	err = s.db.Where("ringhash = ?", ringhash.Hex()).First(&profit).Error
+
+	return &profit, err
+}
+
+// GetUnreconciledRingProfits 打包时间不早于sinceMinedTime,已经打包成功但是还没有取到fill事件的环路,按id分页
+func (s *RdsServiceImpl) GetUnreconciledRingProfits(sinceMinedTime int64, afterId int, limit int) ([]RingProfit, error) {
+	var (
+		list []RingProfit
+		err  error
+	)
+
+	err = s.db.Where("status = ? and reconciled = ?", uint8(types.TX_STATUS_SUCCESS), false).
+		Where("mined_time >= ? and id > ?", sinceMinedTime, afterId).
+		Order("id asc").
+		Limit(limit).
+		Find(&list).
+		Error
+
+	return list, err
+}
+
+func (s *RdsServiceImpl) GetRingProfits(start, end int64) ([]RingProfit, error) {
+	var (
+		list []RingProfit
+		err  error
+	)
+
+	err = s.db.Where("create_time >= ? and create_time < ?", start, end).
+		Order("id asc").
+		Find(&list).
+		Error
+
+	return list, err
+}
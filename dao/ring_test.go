@@ -59,10 +59,6 @@ func TestNewRing(t *testing.T) {
 	info.RingHash = common.HexToHash("0x2c88ebf05254fb82e7ecd10c237036eb4cd0846e1ad8059ca72af40344a9d7d2").Hex()
 	info.ProtocolAddress = common.HexToAddress("0xB5FAB0B11776AAD5cE60588C16bd59DCfd61a1c2").Hex()
 	info.ProtocolData = "0x9812ad890"
-
-	if err := s.UpdateRingSubmitInfoRegistryTxHash([]common.Hash{common.HexToHash(info.RingHash)}, "0x3c88ebf05254fb82e7ecd10c237036eb4cd0846e1ad8059ca72af40344a9d7d2"); nil != err {
-		t.Error(err)
-	}
 }
 
 func TestGetRing(t *testing.T) {
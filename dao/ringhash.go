@@ -0,0 +1,100 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package dao
+
+import (
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+)
+
+// RinghashSubmittedEvent ringhash registry中登记的环路,commit-reveal时用于确认登记以及避开其他miner预留的环路
+type RinghashSubmittedEvent struct {
+	ID          int    `gorm:"column:id;primary_key" json:"id"`
+	Registry    string `gorm:"column:registry_address;type:varchar(42)" json:"registry"`
+	RingHash    string `gorm:"column:ring_hash;type:varchar(82);index" json:"ringHash"`
+	RingMiner   string `gorm:"column:ring_miner;type:varchar(42)" json:"ringMiner"`
+	TxHash      string `gorm:"column:tx_hash;type:varchar(82)" json:"txHash"`
+	LogIndex    int64  `gorm:"column:log_index;type:bigint" json:"logIndex"`
+	BlockNumber int64  `gorm:"column:block_number;type:bigint" json:"blockNumber"`
+	Time        int64  `gorm:"column:time;type:bigint" json:"timestamp"`
+	Fork        bool   `gorm:"column:fork"`
+}
+
+func (r *RinghashSubmittedEvent) ConvertDown(event *types.RinghashSubmittedEvent) error {
+	r.Registry = event.Protocol.Hex()
+	r.RingHash = event.Ringhash.Hex()
+	r.RingMiner = event.RingMiner.Hex()
+	r.TxHash = event.TxHash.Hex()
+	r.LogIndex = event.TxLogIndex
+	r.BlockNumber = event.BlockNumber.Int64()
+	r.Time = event.BlockTime
+	r.Fork = false
+
+	return nil
+}
+
+func (r *RinghashSubmittedEvent) ConvertUp(event *types.RinghashSubmittedEvent) error {
+	event.Protocol = common.HexToAddress(r.Registry)
+	event.Ringhash = common.HexToHash(r.RingHash)
+	event.RingMiner = common.HexToAddress(r.RingMiner)
+	event.TxHash = common.HexToHash(r.TxHash)
+	event.TxLogIndex = r.LogIndex
+	event.BlockNumber = big.NewInt(r.BlockNumber)
+	event.BlockTime = r.Time
+
+	return nil
+}
+
+func (s *RdsServiceImpl) FindRinghashSubmitted(txhash string, logIndex int64) (*RinghashSubmittedEvent, error) {
+	var (
+		model RinghashSubmittedEvent
+		err   error
+	)
+
+	err = s.db.Where("tx_hash = ? and log_index = ?", txhash, logIndex).Where("fork = ?", false).First(&model).Error
+
+	return &model, err
+}
+
+func (s *RdsServiceImpl) GetRinghashSubmittedByHashes(ringhashes []string) ([]RinghashSubmittedEvent, error) {
+	var (
+		list []RinghashSubmittedEvent
+		err  error
+	)
+
+	err = s.db.Where("ring_hash in (?)", ringhashes).Where("fork = ?", false).Find(&list).Error
+
+	return list, err
+}
+
+func (s *RdsServiceImpl) GetRinghashSubmittedSince(time int64) ([]RinghashSubmittedEvent, error) {
+	var (
+		list []RinghashSubmittedEvent
+		err  error
+	)
+
+	err = s.db.Where("time >= ?", time).Where("fork = ?", false).Find(&list).Error
+
+	return list, err
+}
+
+func (s *RdsServiceImpl) RollBackRinghashSubmitted(from, to int64) error {
+	return s.db.Model(&RinghashSubmittedEvent{}).Where("block_number > ? and block_number <= ?", from, to).Update("fork", true).Error
+}
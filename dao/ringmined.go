@@ -141,6 +141,20 @@ func (s *RdsServiceImpl) RingMinedPageQuery(query map[string]interface{}, pageIn
 	return
 }
 
+func (s *RdsServiceImpl) GetRingMinedForReplay(start, end int64) ([]RingMinedEvent, error) {
+	var (
+		list []RingMinedEvent
+		err  error
+	)
+
+	err = s.db.Where("time >= ? and time < ?", start, end).
+		Where("fork = ?", false).
+		Order("block_number asc").
+		Find(&list).Error
+
+	return list, err
+}
+
 func (s *RdsServiceImpl) GetRingminedMethods(lastId int, limit int) ([]RingMinedEvent, error) {
 	var (
 		list []RingMinedEvent
@@ -123,6 +123,17 @@ func (s *RdsServiceImpl) GetPendingTxViewByOwner(owner string) ([]TransactionVie
 	return txs, err
 }
 
+func (s *RdsServiceImpl) GetPendingTxViewByHash(hash string) ([]TransactionView, error) {
+	var txs []TransactionView
+
+	err := s.db.Where("tx_hash=?", hash).
+		Where("status=?", types.TX_STATUS_PENDING).
+		Where("fork=?", false).
+		Find(&txs).Error
+
+	return txs, err
+}
+
 func (s *RdsServiceImpl) GetTxViewCountByOwner(owner string, symbol string, status types.TxStatus, typ txtyp.TxType) (int, error) {
 	var number int
 
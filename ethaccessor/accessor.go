@@ -71,6 +71,10 @@ func GetBlockByHash(result types.CheckNull, blockHash string, withObject bool) e
 	//return accessor.RetryCall("latest", 2, result, "eth_getBlockByHash", blockHash, withObject)
 }
 
+func GetLogs(result interface{}, query *FilterQuery) error {
+	return accessor.RetryCall(query.ToBlock, 2, result, "eth_getLogs", query)
+}
+
 func GetTransactionReceipt(result interface{}, txHash string, blockParameter string) error {
 	return accessor.RetryCall(blockParameter, 2, result, "eth_getTransactionReceipt", txHash)
 }
@@ -113,10 +117,25 @@ func EstimateGas(callData []byte, to common.Address, blockNumber string) (gas, g
 	return accessor.EstimateGas(blockNumber, callData, to)
 }
 
+// SimulateTransaction 以sender身份通过eth_call预执行交易,合约revert时返回revert原因
+func SimulateTransaction(sender, to common.Address, value *big.Int, callData []byte, blockNumber string) error {
+	return accessor.SimulateTransaction(blockNumber, sender, to, value, callData)
+}
+
+// EstimateTransactionGas 与EstimateGas不同,会带上sender,合约中依赖msg.sender的检查才能正确执行
+func EstimateTransactionGas(sender, to common.Address, value *big.Int, callData []byte, blockNumber string) (*big.Int, error) {
+	return accessor.EstimateTransactionGas(blockNumber, sender, to, value, callData)
+}
+
 func SignAndSendTransaction(sender common.Address, to common.Address, gas, gasPrice, value *big.Int, callData []byte, needPreExe bool) (string, error) {
 	return accessor.ContractSendTransactionByData("latest", sender, to, gas, gasPrice, value, callData, needPreExe)
 }
 
+// SendTransactionWithNonce 使用调用方分配的nonce签名发送,不使用也不影响accessor中缓存的nonce
+func SendTransactionWithNonce(sender common.Address, to common.Address, nonce, gas, gasPrice, value *big.Int, callData []byte) (string, error) {
+	return accessor.SendTransactionWithNonce(sender, to, nonce, gas, gasPrice, value, callData)
+}
+
 func ContractSendTransactionMethod(routeParam string, a *abi.ABI, contractAddress common.Address) func(sender common.Address, methodName string, gas, gasPrice, value *big.Int, args ...interface{}) (string, error) {
 	return accessor.ContractSendTransactionMethod(routeParam, a, contractAddress)
 }
@@ -252,6 +271,11 @@ func DelegateAbi() *abi.ABI {
 	return accessor.DelegateAbi
 }
 
+// RinghashRegistryAbi 未配置ringhash registry时返回nil
+func RinghashRegistryAbi() *abi.ABI {
+	return accessor.RinghashRegistryAbi
+}
+
 //
 //func NameRegistryAbi() *abi.ABI {
 //	return accessor.NameRegistryAbi
@@ -302,6 +326,14 @@ func Initialize(accessorOptions config.AccessorOptions, commonOptions config.Com
 		accessor.TokenRegistryAbi = tokenRegistryAbi
 	}
 
+	if "" != commonOptions.ProtocolImpl.RinghashRegistryAbi {
+		if ringhashRegistryAbi, err := NewAbi(commonOptions.ProtocolImpl.RinghashRegistryAbi); nil != err {
+			return err
+		} else {
+			accessor.RinghashRegistryAbi = ringhashRegistryAbi
+		}
+	}
+
 	//if nameRegistryAbi, err := NewAbi(commonOptions.ProtocolImpl.NameRegistryAbi); nil != err {
 	//	return err
 	//} else {
@@ -330,6 +362,10 @@ func Initialize(accessorOptions config.AccessorOptions, commonOptions config.Com
 			log.Debugf("version:%s, contract:%s, delegateAddress:%s", version, address, addr)
 			impl.DelegateAddress = common.HexToAddress(addr)
 		}
+		if registry, ok := commonOptions.ProtocolImpl.RinghashRegistry[version]; ok {
+			log.Debugf("version:%s, contract:%s, ringhashRegistryAddress:%s", version, address, registry)
+			impl.RinghashRegistryAddress = common.HexToAddress(registry)
+		}
 		//if err := callMethod(&addr, "nameRegistryAddress", "latest"); nil != err {
 		//	return err
 		//} else {
@@ -341,6 +377,8 @@ func Initialize(accessorOptions config.AccessorOptions, commonOptions config.Com
 	}
 
 	accessor.MutilClient.startSyncBlockNumber()
+	accessor.heads = newHeadStream(accessorOptions.WsUrls)
+	accessor.heads.start()
 	return nil
 }
 
@@ -259,13 +259,15 @@ type ethNodeAccessor struct {
 	DelegateAbi      *abi.ABI
 	TokenRegistryAbi *abi.ABI
 	//NameRegistryAbi   *abi.ABI
-	WethAbi           *abi.ABI
-	WethAddress       common.Address
-	ProtocolAddresses map[common.Address]*ProtocolAddress
-	DelegateAddresses map[common.Address]bool
+	RinghashRegistryAbi *abi.ABI
+	WethAbi             *abi.ABI
+	WethAddress         common.Address
+	ProtocolAddresses   map[common.Address]*ProtocolAddress
+	DelegateAddresses   map[common.Address]bool
 
 	*MutilClient
 	gasPriceEvaluator *GasPriceEvaluator
+	heads             *headStream
 	mtx               sync.RWMutex
 	AddressNonce      map[common.Address]*big.Int
 	fetchTxRetryCount int
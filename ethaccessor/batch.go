@@ -19,9 +19,11 @@
 package ethaccessor
 
 import (
+	"fmt"
 	"github.com/Loopring/relay/types"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
 )
 
 type BatchErc20Req struct {
@@ -131,3 +133,31 @@ type BatchTransactionRecipientReq struct {
 	TxContent TransactionReceipt
 	Err       error
 }
+
+// BatchBlockReq Block为*BlockWithTxHash或者*BlockWithTxObject,与WithObject对应
+type BatchBlockReq struct {
+	BlockNumber *big.Int
+	WithObject  bool
+	Block       interface{}
+	BlockErr    error
+}
+
+type BatchBlockReqs []*BatchBlockReq
+
+func (reqs BatchBlockReqs) ToBatchElem() []rpc.BatchElem {
+	reqElems := make([]rpc.BatchElem, len(reqs))
+	for idx, req := range reqs {
+		reqElems[idx] = rpc.BatchElem{
+			Method: "eth_getBlockByNumber",
+			Args:   []interface{}{fmt.Sprintf("%#x", req.BlockNumber), req.WithObject},
+			Result: req.Block,
+		}
+	}
+	return reqElems
+}
+
+func (reqs BatchBlockReqs) FromBatchElem(elems []rpc.BatchElem) {
+	for idx, req := range elems {
+		reqs[idx].BlockErr = req.Error
+	}
+}
@@ -47,6 +47,7 @@ const (
 	EVENT_APPROVAL             = "Approval"
 	EVENT_WETH_DEPOSIT         = "Deposit"
 	EVENT_WETH_WITHDRAWAL      = "Withdrawal"
+	EVENT_RINGHASH_SUBMITTED   = "RinghashSubmitted"
 )
 
 const (
@@ -59,6 +60,9 @@ const (
 	METHOD_WETH_WITHDRAWAL = "withdraw"
 	METHOD_APPROVE         = "approve"
 	METHOD_TRANSFER        = "transfer"
+
+	METHOD_SUBMIT_RINGHASH       = "submitRinghash"
+	METHOD_BATCH_SUBMIT_RINGHASH = "batchSubmitRinghash"
 )
 
 func TxIsSubmitRing(methodName string) bool {
@@ -364,6 +368,35 @@ func (e *WethWithdrawalEvent) ConvertDown() *types.WethWithdrawalEvent {
 	return evt
 }
 
+// event RinghashSubmitted(address indexed _ringminer, bytes32 indexed _ringhash);
+type RinghashSubmittedEvent struct {
+	RingMiner common.Address `fieldName:"_ringminer" fieldId:"0"`
+	RingHash  common.Hash    `fieldName:"_ringhash" fieldId:"1"`
+}
+
+func (e *RinghashSubmittedEvent) ConvertDown() *types.RinghashSubmittedEvent {
+	evt := &types.RinghashSubmittedEvent{}
+	evt.RingMiner = e.RingMiner
+	evt.Ringhash = e.RingHash
+
+	return evt
+}
+
+// GenerateSubmitRinghashInputsData 只有一个环路时使用submitRinghash,否则使用batchSubmitRinghash
+func GenerateSubmitRinghashInputsData(ringminer common.Address, ringhashes []common.Hash, registryAbi *abi.ABI) ([]byte, error) {
+	if len(ringhashes) == 1 {
+		return registryAbi.Pack(METHOD_SUBMIT_RINGHASH, ringminer, [32]byte(ringhashes[0]))
+	}
+
+	minerList := []common.Address{}
+	hashList := [][32]byte{}
+	for _, ringhash := range ringhashes {
+		minerList = append(minerList, ringminer)
+		hashList = append(hashList, [32]byte(ringhash))
+	}
+	return registryAbi.Pack(METHOD_BATCH_SUBMIT_RINGHASH, minerList, hashList)
+}
+
 type SubmitRingMethodInputs struct {
 	AddressList        [][4]common.Address `fieldName:"addressList" fieldId:"0"`   // owner,tokenS, wallet, authAddress
 	UintArgsList       [][6]*big.Int       `fieldName:"uintArgsList" fieldId:"1"`  // amountS, amountB, validSince (second),validUntil (second), lrcFee, rateAmountS.
@@ -619,4 +652,6 @@ type ProtocolAddress struct {
 	//NameRegistryAddress common.Address
 
 	DelegateAddress common.Address
+
+	RinghashRegistryAddress common.Address
 }
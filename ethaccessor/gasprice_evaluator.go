@@ -20,7 +20,6 @@ package ethaccessor
 
 import (
 	"github.com/Loopring/relay/log"
-	"github.com/Loopring/relay/types"
 	"math/big"
 	"sort"
 )
@@ -49,38 +48,48 @@ func (e *GasPriceEvaluator) GasPrice(minGasPrice, maxGasPrice *big.Int) *big.Int
 }
 
 func (e *GasPriceEvaluator) start() {
-	var blockNumber types.Big
-	if err := BlockNumber(&blockNumber); nil == err {
-		go func() {
-			number := new(big.Int).Set(blockNumber.BigInt())
-			number.Sub(number, big.NewInt(30))
-			iterator := NewBlockIterator(number, nil, true, uint64(0))
-			for {
-				select {
-				case <-e.stopChan:
-					return
-				default:
-					blockInterface, err := iterator.Next()
-					if nil == err {
-						blockWithTxAndReceipt := blockInterface.(*BlockWithTxAndReceipt)
-						log.Debugf("gasPriceEvaluator, blockNumber:%s, gasPrice:%s", blockWithTxAndReceipt.Number.BigInt().String(), e.gasPrice.String())
-						e.Blocks = append(e.Blocks, blockWithTxAndReceipt)
-						if len(e.Blocks) > 30 {
-							e.Blocks = e.Blocks[1:]
-						}
-						var prices gasPrices = []*big.Int{}
-						for _, block := range e.Blocks {
-							for _, tx := range block.Transactions {
-								prices = append(prices, tx.GasPrice.BigInt())
-							}
-						}
-						e.gasPrice = prices.bestGasPrice()
+	e.stopChan = make(chan bool)
+	heads, unsubscribe := SubscribeHeads()
+	go func() {
+		defer unsubscribe()
+		var next *big.Int
+		for {
+			select {
+			case <-e.stopChan:
+				return
+			case head := <-heads:
+				latest := head.Number.BigInt()
+				if nil == next {
+					next = new(big.Int).Sub(latest, big.NewInt(30))
+				} else if latest.Cmp(next) < 0 {
+					next.Set(latest)
+				}
+				for ; next.Cmp(latest) <= 0; next.Add(next, big.NewInt(1)) {
+					blockInterface, err := accessor.GetFullBlock(next, true)
+					if nil != err {
+						log.Errorf("gasPriceEvaluator, get block:%s err:%s", next.String(), err.Error())
+						break
 					}
+					e.addBlock(blockInterface.(*BlockWithTxAndReceipt))
 				}
 			}
-		}()
+		}
+	}()
+}
 
+func (e *GasPriceEvaluator) addBlock(blockWithTxAndReceipt *BlockWithTxAndReceipt) {
+	e.Blocks = append(e.Blocks, blockWithTxAndReceipt)
+	if len(e.Blocks) > 30 {
+		e.Blocks = e.Blocks[1:]
+	}
+	var prices gasPrices = []*big.Int{}
+	for _, block := range e.Blocks {
+		for _, tx := range block.Transactions {
+			prices = append(prices, tx.GasPrice.BigInt())
+		}
 	}
+	e.gasPrice = prices.bestGasPrice()
+	log.Debugf("gasPriceEvaluator, blockNumber:%s, gasPrice:%s", blockWithTxAndReceipt.Number.BigInt().String(), e.gasPrice.String())
 }
 
 func (e *GasPriceEvaluator) stop() {
@@ -0,0 +1,214 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ethaccessor
+
+import (
+	"context"
+	"errors"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/rpc"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	headPollInterval     = 3 * time.Second
+	headResubscribeDelay = 30 * time.Second
+	headStaleTimeout     = 2 * time.Minute
+	headDialTimeout      = 10 * time.Second
+)
+
+var errHeadStale = errors.New("no new head received")
+
+// Head 最新块头,来自newHeads订阅或者轮询
+type Head struct {
+	Number     types.Big   `json:"number"`
+	Hash       common.Hash `json:"hash"`
+	ParentHash common.Hash `json:"parentHash"`
+	Timestamp  types.Big   `json:"timestamp"`
+}
+
+// headStream 全局共享的块头流,优先使用websocket订阅,订阅断开后退回轮询并定时重新订阅
+type headStream struct {
+	wsUrls []string
+
+	mtx    sync.RWMutex
+	latest *Head
+	subs   map[chan *Head]bool
+}
+
+func newHeadStream(wsUrls []string) *headStream {
+	s := &headStream{}
+	s.wsUrls = wsUrls
+	s.subs = make(map[chan *Head]bool)
+	return s
+}
+
+// SubscribeHeads 订阅最新块头,channel只保留最新的一个块头,调用返回的函数取消订阅
+func SubscribeHeads() (<-chan *Head, func()) {
+	return accessor.heads.subscribeHeads()
+}
+
+// LatestHead 返回当前已知的最新块头
+func LatestHead() (*Head, bool) {
+	return accessor.heads.latestHead()
+}
+
+// WaitBlockNumber 阻塞直到最新块高度不小于blockNumber,quit关闭时返回false
+func WaitBlockNumber(blockNumber *big.Int, quit chan bool) bool {
+	return accessor.heads.wait(blockNumber, quit)
+}
+
+func (s *headStream) subscribeHeads() (<-chan *Head, func()) {
+	ch := make(chan *Head, 1)
+	s.mtx.Lock()
+	s.subs[ch] = true
+	s.mtx.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			s.mtx.Lock()
+			delete(s.subs, ch)
+			s.mtx.Unlock()
+		})
+	}
+}
+
+func (s *headStream) latestHead() (*Head, bool) {
+	s.mtx.RLock()
+	defer s.mtx.RUnlock()
+	return s.latest, nil != s.latest
+}
+
+func (s *headStream) wait(blockNumber *big.Int, quit chan bool) bool {
+	heads, unsubscribe := s.subscribeHeads()
+	defer unsubscribe()
+
+	for {
+		if head, ok := s.latestHead(); ok && head.Number.BigInt().Cmp(blockNumber) >= 0 {
+			return true
+		}
+		select {
+		case <-heads:
+		case <-quit:
+			return false
+		}
+	}
+}
+
+// publish 记录最新块头并通知所有订阅者,订阅者未读取的旧块头直接被替换
+func (s *headStream) publish(head *Head) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	if nil != s.latest && s.latest.Hash == head.Hash {
+		return
+	}
+	s.latest = head
+	for ch := range s.subs {
+		select {
+		case <-ch:
+		default:
+		}
+		select {
+		case ch <- head:
+		default:
+		}
+	}
+}
+
+func (s *headStream) start() {
+	s.poll()
+	go func() {
+		for {
+			s.subscribe()
+			resubscribe := time.After(headResubscribeDelay)
+		polling:
+			for {
+				select {
+				case <-time.After(headPollInterval):
+					s.poll()
+				case <-resubscribe:
+					if len(s.wsUrls) > 0 {
+						break polling
+					}
+				}
+			}
+		}
+	}()
+}
+
+func (s *headStream) poll() {
+	head := &Head{}
+	if err := accessor.RetryCall("latest", 2, head, "eth_getBlockByNumber", "latest", false); nil != err {
+		log.Errorf("ethaccessor, poll latest head err:%s", err.Error())
+		return
+	}
+	s.publish(head)
+}
+
+// subscribe 依次尝试订阅newHeads,订阅成功时阻塞直到订阅断开
+func (s *headStream) subscribe() {
+	for _, url := range s.wsUrls {
+		ctx, cancel := context.WithTimeout(context.Background(), headDialTimeout)
+		client, err := rpc.DialWebsocket(ctx, url, "")
+		cancel()
+		if nil != err {
+			log.Errorf("ethaccessor, dial websocket:%s err:%s", url, err.Error())
+			continue
+		}
+
+		heads := make(chan *Head, 16)
+		sub, err := client.EthSubscribe(context.Background(), heads, "newHeads")
+		if nil != err {
+			log.Errorf("ethaccessor, subscribe newHeads from:%s err:%s", url, err.Error())
+			client.Close()
+			continue
+		}
+		log.Infof("ethaccessor, subscribed newHeads from:%s", url)
+
+		// 订阅建立前可能错过的块由一次轮询补上
+		s.poll()
+		err = s.receive(heads, sub)
+		sub.Unsubscribe()
+		client.Close()
+		log.Errorf("ethaccessor, newHeads subscription from:%s dropped:%s, fallback to polling", url, err.Error())
+		return
+	}
+}
+
+func (s *headStream) receive(heads chan *Head, sub *rpc.ClientSubscription) error {
+	for {
+		select {
+		case head := <-heads:
+			s.publish(head)
+		case err := <-sub.Err():
+			if nil == err {
+				err = rpc.ErrClientQuit
+			}
+			return err
+		case <-time.After(headStaleTimeout):
+			return errHeadStale
+		}
+	}
+}
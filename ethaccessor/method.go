@@ -32,6 +32,7 @@ import (
 	"github.com/ethereum/go-ethereum/rlp"
 	"github.com/ethereum/go-ethereum/rpc"
 	"math/big"
+	"strings"
 	"time"
 )
 
@@ -310,6 +311,61 @@ func (accessor *ethNodeAccessor) EstimateGas(routeParam string, callData []byte,
 	return
 }
 
+// Error(string)的函数选择器,solidity require/revert带有原因时返回该前缀的数据
+const revertReasonSelector = "0x08c379a0"
+
+func (accessor *ethNodeAccessor) SimulateTransaction(routeParam string, sender, to common.Address, value *big.Int, callData []byte) error {
+	callArg := newTransactionCallArg(sender, to, value, callData)
+
+	var result string
+	if err := accessor.RetryCall(routeParam, 2, &result, "eth_call", callArg, routeParam); nil != err {
+		return err
+	}
+	if strings.HasPrefix(result, revertReasonSelector) {
+		return fmt.Errorf("execution reverted:%s", unpackRevertReason(result))
+	}
+	return nil
+}
+
+func (accessor *ethNodeAccessor) EstimateTransactionGas(routeParam string, sender, to common.Address, value *big.Int, callData []byte) (*big.Int, error) {
+	callArg := newTransactionCallArg(sender, to, value, callData)
+
+	var gas types.Big
+	if err := accessor.RetryCall(routeParam, 2, &gas, "eth_estimateGas", callArg); nil != err {
+		return nil, err
+	}
+	return gas.BigInt(), nil
+}
+
+func newTransactionCallArg(sender, to common.Address, value *big.Int, callData []byte) *CallArg {
+	callArg := &CallArg{}
+	callArg.From = sender
+	callArg.To = to
+	callArg.Data = common.ToHex(callData)
+	if nil != value {
+		callArg.Value = new(types.Big).SetInt(value)
+	}
+	return callArg
+}
+
+// unpackRevertReason 解析abi编码的Error(string),格式:selector(4) + offset(32) + length(32) + data
+func unpackRevertReason(result string) string {
+	data := common.FromHex(result)
+	if len(data) < 4+64 {
+		return result
+	}
+	data = data[4:]
+	offset := new(big.Int).SetBytes(data[:32]).Uint64()
+	if offset+32 > uint64(len(data)) {
+		return result
+	}
+	length := new(big.Int).SetBytes(data[offset : offset+32]).Uint64()
+	if offset+32+length > uint64(len(data)) {
+		return result
+	}
+	return string(data[offset+32 : offset+32+length])
+}
+
 func (accessor *ethNodeAccessor) ContractCallMethod(a *abi.ABI, contractAddress common.Address) func(result interface{}, methodName, blockParameter string, args ...interface{}) error {
 	return func(result interface{}, methodName string, blockParameter string, args ...interface{}) error {
 		if callData, err := a.Pack(methodName, args...); nil != err {
@@ -361,10 +417,6 @@ func (accessor *ethNodeAccessor) ContractSendTransactionByData(routeParam string
 	if value == nil {
 		value = big.NewInt(0)
 	}
-	//todo:modify it
-	//if gas.Cmp(big.NewInt(int64(350000)))  {
-	gas.SetString("500000", 0)
-	//}
 	transaction := ethTypes.NewTransaction(nonce.Uint64(),
 		common.HexToAddress(to.Hex()),
 		value,
@@ -393,6 +445,25 @@ func (accessor *ethNodeAccessor) ContractSendTransactionByData(routeParam string
 	return txHash, nil
 }
 
+func (accessor *ethNodeAccessor) SendTransactionWithNonce(sender common.Address, to common.Address, nonce, gas, gasPrice, value *big.Int, callData []byte) (string, error) {
+	if nil == gasPrice || gasPrice.Sign() <= 0 {
+		return "", errors.New("gasPrice must be setted.")
+	}
+	if nil == gas || gas.Sign() <= 0 {
+		return "", errors.New("gas must be setted.")
+	}
+	if value == nil {
+		value = big.NewInt(0)
+	}
+
+	var txHash string
+	transaction := ethTypes.NewTransaction(nonce.Uint64(), to, value, gas, gasPrice, callData)
+	if err := accessor.SignAndSendTransaction(&txHash, sender, transaction); nil != err {
+		return "", err
+	}
+	return txHash, nil
+}
+
 //gas, gasPrice can be set to nil
 func (accessor *ethNodeAccessor) ContractSendTransactionMethod(routeParam string, a *abi.ABI, contractAddress common.Address) func(sender common.Address, methodName string, gas, gasPrice, value *big.Int, args ...interface{}) (string, error) {
 	return func(sender common.Address, methodName string, gas, gasPrice, value *big.Int, args ...interface{}) (string, error) {
@@ -416,25 +487,8 @@ func (iterator *BlockIterator) Next() (interface{}, error) {
 		return nil, errors.New("finished")
 	}
 
-	var blockNumber types.Big
-	if err := iterator.ethClient.RetryCall("latest", 2, &blockNumber, "eth_blockNumber"); nil != err {
-		log.Errorf("err:%s", err.Error())
-		return nil, err
-	} else {
-		confirmNumber := iterator.currentNumber.Uint64() + iterator.confirms
-		if blockNumber.Uint64() < confirmNumber {
-		hasNext:
-			for {
-				select {
-				// todo(fk):modify this duration
-				case <-time.After(time.Duration(5 * time.Second)):
-					if err1 := iterator.ethClient.RetryCall("latest", 2, &blockNumber, "eth_blockNumber"); nil == err1 && blockNumber.Uint64() >= confirmNumber {
-						break hasNext
-					}
-				}
-			}
-		}
-	}
+	confirmNumber := new(big.Int).SetUint64(iterator.currentNumber.Uint64() + iterator.confirms)
+	iterator.ethClient.heads.wait(confirmNumber, nil)
 
 	block, err := iterator.ethClient.GetFullBlock(iterator.currentNumber, iterator.withTxData)
 	if nil == err {
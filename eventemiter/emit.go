@@ -47,6 +47,7 @@ const (
 	RingHashSubmitted   = "RingHashSubmitted"
 	AddressAuthorized   = "AddressAuthorized"
 	AddressDeAuthorized = "AddressDeAuthorized"
+	ContractEvent       = "ContractEvent" // extractor.contracts中登记的合约事件
 
 	MinedOrderState            = "MinedOrderState" //orderbook send orderstate to miner
 	WalletTransactionSubmitted = "WalletTransactionSubmitted"
@@ -69,11 +70,19 @@ const (
 	Block_New = "Block_New"
 	Block_End = "Block_End"
 
+	// Account
+	AccountFundsSynced = "AccountFundsSynced" // block end,balance/allowance have been synced from eth node
+
 	// Extractor
 	SyncChainComplete = "SyncChainComplete"
 	ChainForkDetected = "ChainForkDetected"
 	ExtractorWarning  = "ExtractorWarning"
 
+	// 确认深度事件,数据为*types.ChainEvent
+	UnconfirmedChainEvent = "UnconfirmedChainEvent"
+	ConfirmedChainEvent   = "ConfirmedChainEvent"
+	RemovedChainEvent     = "RemovedChainEvent"
+
 	// Transaction
 	TransactionEvent   = "TransactionEvent"
 	PendingTransaction = "PendingTransaction"
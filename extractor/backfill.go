@@ -0,0 +1,171 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package extractor
+
+import (
+	"fmt"
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	backfillMaxRetry       = 10
+	backfillReportInterval = 100
+)
+
+type backfillHandler struct {
+	topic  string
+	handle func(input eventemitter.EventData) error
+}
+
+// BackfillProgress Current为最后处理的块号,Handled/Failed按handler名称统计事件处理次数
+type BackfillProgress struct {
+	From         *big.Int
+	To           *big.Int
+	Current      *big.Int
+	Blocks       int
+	Transactions int
+	Handled      map[string]int
+	Failed       map[string]int
+}
+
+// Backfiller 重新提取历史区间的块,只发出给注册的handler,不保存块也不做分叉检测,
+// 与正在运行的extractor互不影响。handler需要按txhash等判断是否已经处理过,保证可以重复执行
+type Backfiller struct {
+	extractor *ExtractorServiceImpl
+	from      *big.Int
+	to        *big.Int
+	handlers  map[string][]backfillHandler
+	progress  BackfillProgress
+	mtx       sync.Mutex
+}
+
+func NewBackfiller(options config.ExtractorOptions, db dao.RdsService, from, to *big.Int) *Backfiller {
+	b := &Backfiller{from: from, to: to}
+	b.handlers = make(map[string][]backfillHandler)
+
+	l := &ExtractorServiceImpl{}
+	l.options = options
+	l.dao = db
+	l.processor = newAbiProcessor(db, &l.options)
+	b.extractor = l
+
+	return b
+}
+
+// Register 同一个名称可以注册多个topic
+func (b *Backfiller) Register(name, topic string, handle func(input eventemitter.EventData) error) {
+	b.handlers[name] = append(b.handlers[name], backfillHandler{topic: topic, handle: handle})
+}
+
+func (b *Backfiller) HandlerNames() []string {
+	var names []string
+	for name := range b.handlers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Run 按块号顺序处理[from, to],names为空时使用全部handler,每处理backfillReportInterval个块调用一次report
+func (b *Backfiller) Run(names []string, report func(progress BackfillProgress)) error {
+	if b.from.Cmp(b.to) > 0 {
+		return fmt.Errorf("backfill,from:%s is greater than to:%s", b.from.String(), b.to.String())
+	}
+	if len(names) == 0 {
+		names = b.HandlerNames()
+	}
+
+	b.progress = BackfillProgress{
+		From:    b.from,
+		To:      b.to,
+		Handled: make(map[string]int),
+		Failed:  make(map[string]int),
+	}
+
+	for _, name := range names {
+		handlers, ok := b.handlers[name]
+		if !ok {
+			return fmt.Errorf("backfill,unsupported handler:%s, supported:%v", name, b.HandlerNames())
+		}
+		for _, handler := range handlers {
+			watcher := &eventemitter.Watcher{Concurrent: false, Handle: b.countedHandle(name, handler.handle)}
+			eventemitter.On(handler.topic, watcher)
+			defer eventemitter.Un(handler.topic, watcher)
+		}
+	}
+
+	next := new(big.Int).Set(b.from)
+	iterator := ethaccessor.NewBlockIterator(new(big.Int).Set(b.from), b.to, true, b.extractor.options.ConfirmBlockNumber)
+	for retry := 0; next.Cmp(b.to) <= 0; {
+		inter, err := iterator.Next()
+		if nil != err {
+			if retry++; retry > backfillMaxRetry {
+				return fmt.Errorf("backfill,get block:%s error:%s", next.String(), err.Error())
+			}
+			log.Errorf("backfill,get block:%s error:%s, retry:%d", next.String(), err.Error(), retry)
+			time.Sleep(1 * time.Second)
+			continue
+		}
+		retry = 0
+
+		block := inter.(*ethaccessor.BlockWithTxAndReceipt)
+		if block.Number.BigInt().Cmp(next) != 0 {
+			return fmt.Errorf("backfill,block number not match,expect:%s,got:%s", next.String(), block.Number.BigInt().String())
+		}
+		for idx, transaction := range block.Transactions {
+			receipt := block.Receipts[idx]
+			b.extractor.processMinedTransaction(&transaction, &receipt, block.Timestamp.BigInt(), types.CHAIN_STATE_UNKNOWN)
+		}
+
+		b.progress.Current = new(big.Int).Set(next)
+		b.progress.Blocks++
+		b.progress.Transactions += len(block.Transactions)
+		next.Add(next, big.NewInt(1))
+		if nil != report && (b.progress.Blocks%backfillReportInterval == 0 || next.Cmp(b.to) > 0) {
+			report(b.progress)
+		}
+	}
+
+	return nil
+}
+
+func (b *Backfiller) countedHandle(name string, handle func(input eventemitter.EventData) error) func(input eventemitter.EventData) error {
+	return func(input eventemitter.EventData) error {
+		err := handle(input)
+
+		// 同一topic的多个handler并行执行
+		b.mtx.Lock()
+		defer b.mtx.Unlock()
+		if nil != err {
+			b.progress.Failed[name]++
+		} else {
+			b.progress.Handled[name]++
+		}
+		return err
+	}
+}
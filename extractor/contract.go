@@ -122,6 +122,9 @@ type AbiProcessor struct {
 	delegates   map[common.Address]string
 	db          dao.RdsService
 	options     *config.ExtractorOptions
+
+	// extractor.contracts中登记的合约事件,按合约地址及事件id查找
+	contractEvents map[common.Address]map[common.Hash]*contractEvent
 }
 
 // 这里无需考虑版本问题，对解析来说，不接受版本升级带来数据结构变化的可能性
@@ -133,6 +136,7 @@ func newAbiProcessor(db dao.RdsService, option *config.ExtractorOptions) *AbiPro
 	processor.methods = make(map[string]MethodData)
 	processor.protocols = make(map[common.Address]string)
 	processor.delegates = make(map[common.Address]string)
+	processor.contractEvents = make(map[common.Address]map[common.Hash]*contractEvent)
 	processor.db = db
 
 	processor.options = option
@@ -141,6 +145,8 @@ func newAbiProcessor(db dao.RdsService, option *config.ExtractorOptions) *AbiPro
 	processor.loadErc20Contract()
 	processor.loadWethContract()
 	processor.loadProtocolContract()
+	processor.loadRinghashRegistryContract()
+	processor.loadCustomContracts()
 	//processor.loadTokenRegisterContract()
 	//processor.loadTokenTransferDelegateProtocol()
 
@@ -212,6 +218,10 @@ func (processor *AbiProcessor) SupportedEvents(receipt *ethaccessor.TransactionR
 		if _, ok := processor.erc20Events[id]; ok {
 			return true
 		}
+		// custom contracts event
+		if _, ok := processor.getContractEvent(evtlog); ok {
+			return true
+		}
 	}
 
 	return false
@@ -230,6 +240,54 @@ func (processor *AbiProcessor) SupportedMethod(tx *ethaccessor.Transaction) bool
 	return ok
 }
 
+// LogFilterAddresses 日志模式下eth_getLogs过滤的合约地址
+func (processor *AbiProcessor) LogFilterAddresses() []common.Address {
+	var addresses []common.Address
+	for addr := range processor.protocols {
+		addresses = append(addresses, addr)
+	}
+	return addresses
+}
+
+// LogFilterTopics 日志模式下eth_getLogs过滤的事件id,包括erc20事件及extractor.contracts中的事件
+func (processor *AbiProcessor) LogFilterTopics() []common.Hash {
+	var topics []common.Hash
+	added := make(map[common.Hash]bool)
+	for id := range processor.events {
+		topics = append(topics, id)
+		added[id] = true
+	}
+	for id := range processor.erc20Events {
+		if !added[id] {
+			topics = append(topics, id)
+			added[id] = true
+		}
+	}
+	for _, events := range processor.contractEvents {
+		for id := range events {
+			if !added[id] {
+				topics = append(topics, id)
+				added[id] = true
+			}
+		}
+	}
+	return topics
+}
+
+// emit 未确认的事件只发出UnconfirmedChainEvent,不影响订单及账户状态,
+// 确认后再发出原事件以及ConfirmedChainEvent
+func (processor *AbiProcessor) emit(topic string, event interface{}, info types.TxInfo) {
+	switch info.ChainState {
+	case types.CHAIN_STATE_UNCONFIRMED:
+		eventemitter.Emit(eventemitter.UnconfirmedChainEvent, types.NewChainEvent(topic, event, info))
+	case types.CHAIN_STATE_CONFIRMED:
+		eventemitter.Emit(topic, event)
+		eventemitter.Emit(eventemitter.ConfirmedChainEvent, types.NewChainEvent(topic, event, info))
+	default:
+		eventemitter.Emit(topic, event)
+	}
+}
+
 // HasSpender check approve spender address have ever been load
 func (processor *AbiProcessor) HasSpender(spender common.Address) bool {
 	_, ok := processor.delegates[spender]
@@ -254,6 +312,12 @@ func (processor *AbiProcessor) loadProtocolAddress() {
 		log.Infof("extractor,contract protocol %s->%s", protocolSymbol, v.ContractAddress.Hex())
 		log.Infof("extractor,contract protocol %s->%s", tokenRegisterSymbol, v.TokenRegistryAddress.Hex())
 		log.Infof("extractor,contract protocol %s->%s", delegateSymbol, v.DelegateAddress.Hex())
+
+		if !types.IsZeroAddress(v.RinghashRegistryAddress) {
+			ringhashRegistrySymbol := "ringhash_registry"
+			processor.protocols[v.RinghashRegistryAddress] = ringhashRegistrySymbol
+			log.Infof("extractor,contract protocol %s->%s", ringhashRegistrySymbol, v.RinghashRegistryAddress.Hex())
+		}
 	}
 }
 
@@ -432,6 +496,28 @@ func (processor *AbiProcessor) loadTokenRegisterContract() {
 	}
 }
 
+// loadRinghashRegistryContract 未配置ringhash registry abi时不解析
+func (processor *AbiProcessor) loadRinghashRegistryContract() {
+	registryAbi := ethaccessor.RinghashRegistryAbi()
+	if nil == registryAbi {
+		return
+	}
+
+	for name, event := range registryAbi.Events {
+		if name != ethaccessor.EVENT_RINGHASH_SUBMITTED {
+			continue
+		}
+
+		contract := newEventData(&event, registryAbi)
+		contract.Event = &ethaccessor.RinghashSubmittedEvent{}
+		watcher := &eventemitter.Watcher{Concurrent: false, Handle: processor.handleRinghashSubmittedEvent}
+
+		eventemitter.On(contract.Id.Hex(), watcher)
+		processor.events[contract.Id] = contract
+		log.Infof("extractor,contract event name:%s -> key:%s", contract.Name, contract.Id.Hex())
+	}
+}
+
 func (processor *AbiProcessor) loadTokenTransferDelegateProtocol() {
 	for name, event := range ethaccessor.DelegateAbi().Events {
 		if name != ethaccessor.EVENT_ADDRESS_AUTHORIZED && name != ethaccessor.EVENT_ADDRESS_DEAUTHORIZED {
@@ -491,7 +577,7 @@ func (processor *AbiProcessor) handleSubmitRingMethod(input eventemitter.EventDa
 
 	log.Debugf("extractor,tx:%s submitRing method gas:%s, gasprice:%s, status:%s", event.TxHash.Hex(), event.GasUsed.String(), event.GasPrice.String(), types.StatusStr(event.Status))
 
-	eventemitter.Emit(eventemitter.Miner_SubmitRing_Method, event)
+	processor.emit(eventemitter.Miner_SubmitRing_Method, event, event.TxInfo)
 
 	return nil
 }
@@ -522,7 +608,7 @@ func (processor *AbiProcessor) handleCancelOrderMethod(input eventemitter.EventD
 	tmCancelEvent.TxInfo = contract.TxInfo
 	tmCancelEvent.OrderHash = order.Hash
 	tmCancelEvent.AmountCancelled = cancelAmount
-	eventemitter.Emit(eventemitter.CancelOrder, tmCancelEvent)
+	processor.emit(eventemitter.CancelOrder, tmCancelEvent, tmCancelEvent.TxInfo)
 
 	return nil
 }
@@ -542,7 +628,7 @@ func (processor *AbiProcessor) handleCutoffMethod(input eventemitter.EventData)
 	cutoff.Owner = cutoff.From
 	log.Debugf("extractor,tx:%s cutoff method owner:%s, cutoff:%d, status:%d", contract.TxHash.Hex(), cutoff.Owner.Hex(), cutoff.Cutoff.Int64(), cutoff.Status)
 
-	eventemitter.Emit(eventemitter.CutoffAll, cutoff)
+	processor.emit(eventemitter.CutoffAll, cutoff, cutoff.TxInfo)
 
 	return nil
 }
@@ -563,7 +649,7 @@ func (processor *AbiProcessor) handleCutoffPairMethod(input eventemitter.EventDa
 
 	log.Debugf("extractor,tx:%s cutoffpair method owenr:%s, token1:%s, token2:%s, cutoff:%d", contract.TxHash.Hex(), cutoffpair.Owner.Hex(), cutoffpair.Token1.Hex(), cutoffpair.Token2.Hex(), cutoffpair.Cutoff.Int64())
 
-	eventemitter.Emit(eventemitter.CutoffPair, cutoffpair)
+	processor.emit(eventemitter.CutoffPair, cutoffpair, cutoffpair.TxInfo)
 
 	return nil
 }
@@ -584,7 +670,7 @@ func (processor *AbiProcessor) handleApproveMethod(input eventemitter.EventData)
 
 	log.Debugf("extractor,tx:%s approve method owner:%s, spender:%s, value:%s", contractData.TxHash.Hex(), approve.Owner.Hex(), approve.Spender.Hex(), approve.Amount.String())
 
-	eventemitter.Emit(eventemitter.Approve, approve)
+	processor.emit(eventemitter.Approve, approve, approve.TxInfo)
 
 	return nil
 }
@@ -605,7 +691,7 @@ func (processor *AbiProcessor) handleTransferMethod(input eventemitter.EventData
 
 	log.Debugf("extractor,tx:%s transfer method sender:%s, receiver:%s, value:%s", transfer.TxHash.Hex(), transfer.Sender.Hex(), transfer.Receiver.Hex(), transfer.Amount.String())
 
-	eventemitter.Emit(eventemitter.Transfer, transfer)
+	processor.emit(eventemitter.Transfer, transfer, transfer.TxInfo)
 	return nil
 }
 
@@ -619,7 +705,7 @@ func (processor *AbiProcessor) handleWethDepositMethod(input eventemitter.EventD
 
 	log.Debugf("extractor,tx:%s wethDeposit method from:%s, to:%s, value:%s", contractData.TxHash.Hex(), deposit.From.Hex(), deposit.To.Hex(), deposit.Amount.String())
 
-	eventemitter.Emit(eventemitter.WethDeposit, &deposit)
+	processor.emit(eventemitter.WethDeposit, &deposit, deposit.TxInfo)
 
 	return nil
 }
@@ -640,7 +726,7 @@ func (processor *AbiProcessor) handleWethWithdrawalMethod(input eventemitter.Eve
 
 	log.Debugf("extractor,tx:%s wethWithdrawal method from:%s, to:%s, value:%s", contractData.TxHash.Hex(), withdrawal.From.Hex(), withdrawal.To.Hex(), withdrawal.Amount.String())
 
-	eventemitter.Emit(eventemitter.WethWithdrawal, withdrawal)
+	processor.emit(eventemitter.WethWithdrawal, withdrawal, withdrawal.TxInfo)
 
 	return nil
 }
@@ -676,7 +762,7 @@ func (processor *AbiProcessor) handleRingMinedEvent(input eventemitter.EventData
 		ringmined.Ringhash.Hex(),
 		ringmined.RingIndex.String())
 
-	eventemitter.Emit(eventemitter.RingMined, ringmined)
+	processor.emit(eventemitter.RingMined, ringmined, ringmined.TxInfo)
 
 	var (
 		fillList      []*types.OrderFilledEvent
@@ -740,7 +826,7 @@ func (processor *AbiProcessor) handleRingMinedEvent(input eventemitter.EventData
 
 		log.Debugf("extractor,tx:%s orderFilled event match fillIndex:%d and order:%s", contractData.TxHash.Hex(), fill.FillIndex.Int64(), ord.OrderHash)
 
-		eventemitter.Emit(eventemitter.OrderFilled, fill)
+		processor.emit(eventemitter.OrderFilled, fill, fill.TxInfo)
 	}
 	return nil
 }
@@ -760,7 +846,7 @@ func (processor *AbiProcessor) handleOrderCancelledEvent(input eventemitter.Even
 
 	log.Debugf("extractor,tx:%s orderCancelled event delegate:%s, orderhash:%s, cancelAmount:%s", contractData.TxHash.Hex(), evt.DelegateAddress.Hex(), evt.OrderHash.Hex(), evt.AmountCancelled.String())
 
-	eventemitter.Emit(eventemitter.CancelOrder, evt)
+	processor.emit(eventemitter.CancelOrder, evt, evt.TxInfo)
 
 	return nil
 }
@@ -780,7 +866,7 @@ func (processor *AbiProcessor) handleCutoffEvent(input eventemitter.EventData) e
 
 	log.Debugf("extractor,tx:%s cutoffTimestampChanged event delegate:%s, ownerAddress:%s, cutOffTime:%s, status:%d", contractData.TxHash.Hex(), evt.DelegateAddress.Hex(), evt.Owner.Hex(), evt.Cutoff.String(), evt.Status)
 
-	eventemitter.Emit(eventemitter.CutoffAll, evt)
+	processor.emit(eventemitter.CutoffAll, evt, evt.TxInfo)
 
 	return nil
 }
@@ -800,7 +886,7 @@ func (processor *AbiProcessor) handleCutoffPairEvent(input eventemitter.EventDat
 
 	log.Debugf("extractor,tx:%s cutoffPair event delegate:%s, ownerAddress:%s, token1:%s, token2:%s, cutOffTime:%s", contractData.TxHash.Hex(), evt.DelegateAddress.Hex(), evt.Owner.Hex(), evt.Token1.Hex(), evt.Token2.Hex(), evt.Cutoff.String())
 
-	eventemitter.Emit(eventemitter.CutoffPair, evt)
+	processor.emit(eventemitter.CutoffPair, evt, evt.TxInfo)
 
 	return nil
 }
@@ -822,7 +908,7 @@ func (processor *AbiProcessor) handleTransferEvent(input eventemitter.EventData)
 
 	log.Debugf("extractor,tx:%s tokenTransfer event, methodName:%s, logIndex:%d, from:%s, to:%s, value:%s", contractData.TxHash.Hex(), transfer.Identify, transfer.TxLogIndex, transfer.Sender.Hex(), transfer.Receiver.Hex(), transfer.Amount.String())
 
-	eventemitter.Emit(eventemitter.Transfer, transfer)
+	processor.emit(eventemitter.Transfer, transfer, transfer.TxInfo)
 
 	return nil
 }
@@ -843,7 +929,7 @@ func (processor *AbiProcessor) handleApprovalEvent(input eventemitter.EventData)
 
 	log.Debugf("extractor,tx:%s approval event owner:%s, spender:%s, value:%s", contractData.TxHash.Hex(), approve.Owner.Hex(), approve.Spender.Hex(), approve.Amount.String())
 
-	eventemitter.Emit(eventemitter.Approve, approve)
+	processor.emit(eventemitter.Approve, approve, approve.TxInfo)
 
 	return nil
 }
@@ -857,7 +943,7 @@ func (processor *AbiProcessor) handleTokenRegisteredEvent(input eventemitter.Eve
 
 	log.Debugf("extractor,tx:%s tokenRegistered event address:%s, symbol:%s", contractData.TxHash.Hex(), evt.Token.Hex(), evt.Symbol)
 
-	eventemitter.Emit(eventemitter.TokenRegistered, evt)
+	processor.emit(eventemitter.TokenRegistered, evt, evt.TxInfo)
 
 	return nil
 }
@@ -871,7 +957,28 @@ func (processor *AbiProcessor) handleTokenUnRegisteredEvent(input eventemitter.E
 
 	log.Debugf("extractor,tx:%s tokenUnregistered event address:%s, symbol:%s", contractData.TxHash.Hex(), evt.Token.Hex(), evt.Symbol)
 
-	eventemitter.Emit(eventemitter.TokenUnRegistered, evt)
+	processor.emit(eventemitter.TokenUnRegistered, evt, evt.TxInfo)
+
+	return nil
+}
+
+func (processor *AbiProcessor) handleRinghashSubmittedEvent(input eventemitter.EventData) error {
+	contractData := input.(EventData)
+	if len(contractData.Topics) < 3 {
+		log.Errorf("extractor,tx:%s ringhashSubmitted event indexed fields number error", contractData.TxHash.Hex())
+		return nil
+	}
+
+	contractEvent := contractData.Event.(*ethaccessor.RinghashSubmittedEvent)
+	contractEvent.RingMiner = common.HexToAddress(contractData.Topics[1])
+	contractEvent.RingHash = common.HexToHash(contractData.Topics[2])
+
+	evt := contractEvent.ConvertDown()
+	evt.TxInfo = contractData.TxInfo
+
+	log.Debugf("extractor,tx:%s ringhashSubmitted event ringminer:%s, ringhash:%s", contractData.TxHash.Hex(), evt.RingMiner.Hex(), evt.Ringhash.Hex())
+
+	processor.emit(eventemitter.RingHashSubmitted, evt, evt.TxInfo)
 
 	return nil
 }
@@ -891,7 +998,7 @@ func (processor *AbiProcessor) handleAddressAuthorizedEvent(input eventemitter.E
 
 	log.Debugf("extractor,tx:%s addressAuthorized event address:%s, number:%d", contractData.TxHash.Hex(), evt.Protocol.Hex(), evt.Number)
 
-	eventemitter.Emit(eventemitter.AddressAuthorized, evt)
+	processor.emit(eventemitter.AddressAuthorized, evt, evt.TxInfo)
 
 	return nil
 }
@@ -911,7 +1018,7 @@ func (processor *AbiProcessor) handleAddressDeAuthorizedEvent(input eventemitter
 
 	log.Debugf("extractor,tx:%s addressDeAuthorized event address:%s, number:%d", contractData.TxHash.Hex(), evt.Protocol.Hex(), evt.Number)
 
-	eventemitter.Emit(eventemitter.AddressAuthorized, evt)
+	processor.emit(eventemitter.AddressAuthorized, evt, evt.TxInfo)
 
 	return nil
 }
@@ -930,7 +1037,7 @@ func (processor *AbiProcessor) handleWethDepositEvent(input eventemitter.EventDa
 
 	log.Debugf("extractor,tx:%s wethDeposit event deposit to:%s, number:%s", contractData.TxHash.Hex(), evt.Dst.Hex(), evt.Amount.String())
 
-	eventemitter.Emit(eventemitter.WethDeposit, evt)
+	processor.emit(eventemitter.WethDeposit, evt, evt.TxInfo)
 
 	return nil
 }
@@ -950,12 +1057,12 @@ func (processor *AbiProcessor) handleWethWithdrawalEvent(input eventemitter.Even
 
 	log.Debugf("extractor,tx:%s wethWithdrawal event withdrawal to:%s, number:%s", contractData.TxHash.Hex(), evt.Src.Hex(), evt.Amount.String())
 
-	eventemitter.Emit(eventemitter.WethWithdrawal, evt)
+	processor.emit(eventemitter.WethWithdrawal, evt, evt.TxInfo)
 
 	return nil
 }
 
-func (processor *AbiProcessor) handleEthTransfer(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, time *big.Int) error {
+func (processor *AbiProcessor) handleEthTransfer(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, time *big.Int, state types.ChainState) error {
 	var dst types.TransferEvent
 
 	dst.From = common.HexToAddress(tx.From)
@@ -965,7 +1072,9 @@ func (processor *AbiProcessor) handleEthTransfer(tx *ethaccessor.Transaction, re
 	dst.Value = tx.Value.BigInt()
 	dst.TxLogIndex = 0
 	dst.BlockNumber = tx.BlockNumber.BigInt()
+	dst.BlockHash = common.HexToHash(tx.BlockHash)
 	dst.BlockTime = time.Int64()
+	dst.ChainState = state
 
 	dst.GasLimit = tx.Gas.BigInt()
 	dst.GasPrice = tx.GasPrice.BigInt()
@@ -977,7 +1086,7 @@ func (processor *AbiProcessor) handleEthTransfer(tx *ethaccessor.Transaction, re
 
 	log.Debugf("extractor,tx:%s handleEthTransfer from:%s, to:%s, value:%s, gasUsed:%s, status:%d", tx.Hash, tx.From, tx.To, tx.Value.BigInt().String(), dst.GasUsed.String(), dst.Status)
 
-	eventemitter.Emit(eventemitter.EthTransferEvent, &dst)
+	processor.emit(eventemitter.EthTransferEvent, &dst, dst.TxInfo)
 
 	return nil
 }
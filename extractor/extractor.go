@@ -60,6 +60,8 @@ type ExtractorServiceImpl struct {
 	startBlockNumber *big.Int
 	endBlockNumber   *big.Int
 	iterator         *ethaccessor.BlockIterator
+	source           blockSource
+	unconfirmed      *unconfirmedTracker
 	pendingTxWatcher *eventemitter.Watcher
 	syncComplete     bool
 	forkComplete     bool
@@ -79,6 +81,10 @@ func NewExtractorService(options config.ExtractorOptions, db dao.RdsService) *Ex
 	l.stop = make(chan bool, 1)
 	l.setBlockNumberRange()
 
+	if options.UnconfirmedEvents {
+		l.unconfirmed = newUnconfirmedTracker(options.ConfirmBlockNumber, l.processUnconfirmedBlock)
+	}
+
 	l.pendingTxWatcher = &eventemitter.Watcher{Concurrent: false, Handle: l.WatchingPendingTransaction}
 	eventemitter.On(eventemitter.PendingTransaction, l.pendingTxWatcher)
 
@@ -94,6 +100,21 @@ func (l *ExtractorServiceImpl) Start() {
 	l.syncComplete = false
 
 	l.iterator = ethaccessor.NewBlockIterator(l.startBlockNumber, l.endBlockNumber, true, l.options.ConfirmBlockNumber)
+	if nil != l.source {
+		l.source.Stop()
+		l.source = nil
+	}
+	if l.options.Mode == EXTRACT_MODE_LOG {
+		l.source = newLogBlockSource(l.processor, l.startBlockNumber, l.endBlockNumber, l.options.ConfirmBlockNumber, l.options.LogBatchBlocks, l.options.LogEthTransfer)
+	} else if l.options.FetchConcurrency > 1 {
+		l.source = newBlockPrefetcher(l.startBlockNumber, l.endBlockNumber, l.options.ConfirmBlockNumber, l.options.FetchConcurrency, l.options.PrefetchBlocks)
+	}
+	if nil != l.source {
+		l.source.Start()
+	}
+	if nil != l.unconfirmed {
+		l.unconfirmed.Start(new(big.Int).Sub(l.startBlockNumber, big.NewInt(1)))
+	}
 	go func() {
 		for {
 			select {
@@ -114,6 +135,12 @@ func (l *ExtractorServiceImpl) Stop() {
 		return
 	}
 
+	if nil != l.source {
+		l.source.Stop()
+	}
+	if nil != l.unconfirmed {
+		l.unconfirmed.Stop()
+	}
 	l.stop <- true
 }
 
@@ -175,14 +202,25 @@ func (l *ExtractorServiceImpl) WatchingPendingTransaction(input eventemitter.Eve
 	return l.ProcessPendingTransaction(tx)
 }
 
-func (l *ExtractorServiceImpl) ProcessBlock() error {
+// nextBlock 开启预取或者日志模式时从source获取,顺序与iterator相同
+func (l *ExtractorServiceImpl) nextBlock() (*ethaccessor.BlockWithTxAndReceipt, error) {
+	if nil != l.source {
+		return l.source.Next()
+	}
 	inter, err := l.iterator.Next()
+	if err != nil {
+		return nil, err
+	}
+	return inter.(*ethaccessor.BlockWithTxAndReceipt), nil
+}
+
+func (l *ExtractorServiceImpl) ProcessBlock() error {
+	block, err := l.nextBlock()
 	if err != nil {
 		return fmt.Errorf("extractor,iterator next error:%s", err.Error())
 	}
 
 	// get current block
-	block := inter.(*ethaccessor.BlockWithTxAndReceipt)
 	log.Infof("extractor,get block:%s->%s, transaction number:%d", block.Number.BigInt().String(), block.Hash.Hex(), len(block.Transactions))
 
 	currentBlock := &types.Block{}
@@ -213,45 +251,74 @@ func (l *ExtractorServiceImpl) ProcessBlock() error {
 	blockEvent.BlockTime = block.Timestamp.Int64()
 	eventemitter.Emit(eventemitter.Block_New, blockEvent)
 
+	if nil != l.unconfirmed {
+		l.unconfirmed.confirm(currentBlock.BlockNumber, currentBlock.BlockHash)
+	}
+
 	if len(block.Transactions) > 0 {
+		l.lock.Lock()
 		for idx, transaction := range block.Transactions {
 			receipt := block.Receipts[idx]
 			l.debug("extractor,tx:%s", transaction.Hash)
 			l.ProcessMinedTransaction(&transaction, &receipt, block.Timestamp.BigInt())
 		}
+		l.lock.Unlock()
 	}
 
 	eventemitter.Emit(eventemitter.Block_End, blockEvent)
 	return nil
 }
 
+// processUnconfirmedBlock 与主流程共用processor,需要串行处理
+func (l *ExtractorServiceImpl) processUnconfirmedBlock(block *ethaccessor.BlockWithTxAndReceipt) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	for idx, transaction := range block.Transactions {
+		receipt := block.Receipts[idx]
+		l.processMinedTransaction(&transaction, &receipt, block.Timestamp.BigInt(), types.CHAIN_STATE_UNCONFIRMED)
+	}
+}
+
 func (l *ExtractorServiceImpl) ProcessPendingTransaction(tx *ethaccessor.Transaction) error {
 	log.Debugf("extractor,process pending transaction %s", tx.Hash)
 
 	blockTime := big.NewInt(time.Now().Unix())
 
 	if l.processor.SupportedMethod(tx) {
-		return l.ProcessMethod(tx, nil, blockTime)
+		return l.ProcessMethod(tx, nil, blockTime, types.CHAIN_STATE_UNKNOWN)
 	}
 
-	return l.processor.handleEthTransfer(tx, nil, blockTime)
+	return l.processor.handleEthTransfer(tx, nil, blockTime, types.CHAIN_STATE_UNKNOWN)
 }
 
 func (l *ExtractorServiceImpl) ProcessMinedTransaction(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, blockTime *big.Int) error {
-	l.debug("extractor,process mined transaction,tx:%s status :%s,logs:%d", tx.Hash, receipt.Status.BigInt().String(), len(receipt.Logs))
+	return l.processMinedTransaction(tx, receipt, blockTime, l.confirmedState())
+}
+
+func (l *ExtractorServiceImpl) processMinedTransaction(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, blockTime *big.Int, state types.ChainState) error {
+	l.debug("extractor,process mined transaction,tx:%s status :%s,logs:%d,state:%s", tx.Hash, receipt.Status.BigInt().String(), len(receipt.Logs), types.ChainStateStr(state))
 
 	if l.processor.SupportedEvents(receipt) {
-		return l.ProcessEvent(tx, receipt, blockTime)
+		return l.ProcessEvent(tx, receipt, blockTime, state)
 	}
 
 	if l.processor.SupportedMethod(tx) {
-		return l.ProcessMethod(tx, receipt, blockTime)
+		return l.ProcessMethod(tx, receipt, blockTime, state)
 	}
 
-	return l.processor.handleEthTransfer(tx, receipt, blockTime)
+	return l.processor.handleEthTransfer(tx, receipt, blockTime, state)
 }
 
-func (l *ExtractorServiceImpl) ProcessMethod(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, blockTime *big.Int) error {
+// confirmedState 开启未确认事件时,主流程处理的块都已经有ConfirmBlockNumber个确认
+func (l *ExtractorServiceImpl) confirmedState() types.ChainState {
+	if nil != l.unconfirmed {
+		return types.CHAIN_STATE_CONFIRMED
+	}
+	return types.CHAIN_STATE_UNKNOWN
+}
+
+func (l *ExtractorServiceImpl) ProcessMethod(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, blockTime *big.Int, state types.ChainState) error {
 	method, ok := l.processor.GetMethod(tx)
 	if !ok {
 		l.debug("extractor,process method,tx:%s,unsupported contract method", tx.Hash)
@@ -260,12 +327,13 @@ func (l *ExtractorServiceImpl) ProcessMethod(tx *ethaccessor.Transaction, receip
 
 	gas, status := l.processor.getGasAndStatus(tx, receipt)
 	method.FullFilled(tx, gas, blockTime, status, method.Name)
+	method.ChainState = state
 	eventemitter.Emit(method.Id, method)
 
 	return nil
 }
 
-func (l *ExtractorServiceImpl) ProcessEvent(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, blockTime *big.Int) error {
+func (l *ExtractorServiceImpl) ProcessEvent(tx *ethaccessor.Transaction, receipt *ethaccessor.TransactionReceipt, blockTime *big.Int, state types.ChainState) error {
 	methodName := l.processor.GetMethodName(tx)
 
 	// 如果是submitRing的相关事件，必须保证fill在前，transfer在后
@@ -283,6 +351,10 @@ func (l *ExtractorServiceImpl) ProcessEvent(tx *ethaccessor.Transaction, receipt
 	}
 
 	for _, evtLog := range receipt.Logs {
+		if err := l.processor.handleContractEvent(tx, &evtLog, receipt.GasUsed.BigInt(), blockTime, methodName, state); err != nil {
+			log.Errorf("extractor,process event,tx:%s %s", tx.Hash, err.Error())
+		}
+
 		event, ok := l.processor.GetEvent(evtLog)
 		if !ok {
 			l.debug("extractor,process event,tx:%s,unsupported contract event", tx.Hash)
@@ -298,6 +370,7 @@ func (l *ExtractorServiceImpl) ProcessEvent(tx *ethaccessor.Transaction, receipt
 		}
 
 		event.FullFilled(tx, &evtLog, receipt.GasUsed.BigInt(), blockTime, methodName)
+		event.ChainState = state
 		eventemitter.Emit(event.Id.Hex(), event)
 	}
 
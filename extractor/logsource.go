@@ -0,0 +1,269 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package extractor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/log"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	EXTRACT_MODE_FULL = "full"
+	EXTRACT_MODE_LOG  = "log"
+
+	defaultLogBatchBlocks = 100
+)
+
+// logBlockSource 用eth_getLogs按区间过滤关心的合约事件,只获取有相关日志的交易及receipt,
+// 组装成只包含这些交易的块,后续处理与全块模式相同。
+// ethTransfer为true时块内交易一起获取,用于处理eth转账以及没有日志的合约调用
+type logBlockSource struct {
+	processor   *AbiProcessor
+	current     *big.Int
+	end         *big.Int
+	head        *chainHead
+	batch       int64
+	ethTransfer bool
+
+	blocks chan *ethaccessor.BlockWithTxAndReceipt
+	quit   chan bool
+	once   sync.Once
+}
+
+func newLogBlockSource(processor *AbiProcessor, start, end *big.Int, confirms uint64, batch int64, ethTransfer bool) *logBlockSource {
+	if batch <= 0 {
+		batch = defaultLogBatchBlocks
+	}
+	return &logBlockSource{
+		processor:   processor,
+		current:     new(big.Int).Set(start),
+		end:         end,
+		head:        newChainHead(confirms),
+		batch:       batch,
+		ethTransfer: ethTransfer,
+		blocks:      make(chan *ethaccessor.BlockWithTxAndReceipt, batch),
+		quit:        make(chan bool),
+	}
+}
+
+func (s *logBlockSource) Start() {
+	go s.dispatch()
+}
+
+func (s *logBlockSource) Stop() {
+	s.once.Do(func() {
+		close(s.quit)
+	})
+}
+
+func (s *logBlockSource) Next() (*ethaccessor.BlockWithTxAndReceipt, error) {
+	select {
+	case block := <-s.blocks:
+		return block, nil
+	case <-s.quit:
+	}
+	return nil, errors.New("log block source stopped")
+}
+
+func (s *logBlockSource) dispatch() {
+	for {
+		if nil != s.end && s.end.Sign() > 0 && s.end.Cmp(s.current) < 0 {
+			return
+		}
+		if !s.head.wait(s.current, s.quit) {
+			return
+		}
+
+		to := new(big.Int).Add(s.current, big.NewInt(s.batch-1))
+		if confirmed := s.head.confirmed(); confirmed.Cmp(to) < 0 {
+			to = confirmed
+		}
+		if nil != s.end && s.end.Sign() > 0 && s.end.Cmp(to) < 0 {
+			to = new(big.Int).Set(s.end)
+		}
+
+		blocks, err := s.fetchRange(s.current, to)
+		if nil != err {
+			log.Errorf("extractor,fetch logs from block:%s to:%s error:%s", s.current.String(), to.String(), err.Error())
+			select {
+			case <-time.After(1 * time.Second):
+				continue
+			case <-s.quit:
+				return
+			}
+		}
+
+		for _, block := range blocks {
+			select {
+			case s.blocks <- block:
+			case <-s.quit:
+				return
+			}
+		}
+		s.current = new(big.Int).Add(to, big.NewInt(1))
+	}
+}
+
+// fetchRange 区间内每个块都返回,没有相关交易的块只有块头,保证块号连续以便分叉检测
+func (s *logBlockSource) fetchRange(from, to *big.Int) ([]*ethaccessor.BlockWithTxAndReceipt, error) {
+	var (
+		logs       []ethaccessor.Log
+		routeParam = fmt.Sprintf("%#x", to)
+	)
+
+	query := &ethaccessor.FilterQuery{
+		FromBlock: fmt.Sprintf("%#x", from),
+		ToBlock:   routeParam,
+		Address:   s.processor.LogFilterAddresses(),
+		Topics:    [][]common.Hash{s.processor.LogFilterTopics()},
+	}
+	if err := ethaccessor.GetLogs(&logs, query); nil != err {
+		return nil, err
+	}
+
+	var headerReqs ethaccessor.BatchBlockReqs
+	for n := new(big.Int).Set(from); n.Cmp(to) <= 0; n = new(big.Int).Add(n, big.NewInt(1)) {
+		req := &ethaccessor.BatchBlockReq{BlockNumber: n, WithObject: s.ethTransfer}
+		if s.ethTransfer {
+			req.Block = &ethaccessor.BlockWithTxObject{}
+		} else {
+			req.Block = &ethaccessor.BlockWithTxHash{}
+		}
+		headerReqs = append(headerReqs, req)
+	}
+	if err := ethaccessor.BatchCall(routeParam, []ethaccessor.BatchReq{headerReqs}); nil != err {
+		return nil, err
+	}
+
+	var (
+		blocks    = make([]*ethaccessor.BlockWithTxAndReceipt, len(headerReqs))
+		blockIdx  = make(map[string]int)
+		txBlock   = make(map[string]int)
+		txs       = make(map[string]*ethaccessor.Transaction)
+		txHashes  []string
+		addTxHash = func(idx int, txHash string) {
+			if _, ok := txBlock[txHash]; !ok {
+				txBlock[txHash] = idx
+				txHashes = append(txHashes, txHash)
+			}
+		}
+	)
+	for idx, req := range headerReqs {
+		if nil != req.BlockErr {
+			return nil, req.BlockErr
+		}
+		block := &ethaccessor.BlockWithTxAndReceipt{}
+		if s.ethTransfer {
+			withObject := req.Block.(*ethaccessor.BlockWithTxObject)
+			block.Block = withObject.Block
+			for i := range withObject.Transactions {
+				tx := withObject.Transactions[i]
+				if tx.Value.BigInt().Sign() > 0 || s.processor.SupportedContract(common.HexToAddress(tx.To)) {
+					txs[tx.Hash] = &tx
+					addTxHash(idx, tx.Hash)
+				}
+			}
+		} else {
+			block.Block = req.Block.(*ethaccessor.BlockWithTxHash).Block
+		}
+		if block.Number.BigInt().Cmp(req.BlockNumber) != 0 {
+			return nil, fmt.Errorf("can't get block:%s", req.BlockNumber.String())
+		}
+		blocks[idx] = block
+		blockIdx[strings.ToLower(block.Hash.Hex())] = idx
+	}
+
+	for _, evtLog := range logs {
+		if evtLog.Removed {
+			continue
+		}
+		idx, ok := blockIdx[strings.ToLower(evtLog.BlockHash)]
+		if !ok {
+			return nil, fmt.Errorf("log of tx:%s in block:%s not match block header, chain may be reorganized", evtLog.TransactionHash, evtLog.BlockHash)
+		}
+		addTxHash(idx, evtLog.TransactionHash)
+	}
+
+	if len(txHashes) == 0 {
+		return blocks, nil
+	}
+
+	var (
+		txReqs []*ethaccessor.BatchTransactionReq
+		rcReqs = make([]*ethaccessor.BatchTransactionRecipientReq, len(txHashes))
+	)
+	for idx, txHash := range txHashes {
+		if _, ok := txs[txHash]; !ok {
+			txReqs = append(txReqs, &ethaccessor.BatchTransactionReq{TxHash: txHash})
+		}
+		rcReqs[idx] = &ethaccessor.BatchTransactionRecipientReq{TxHash: txHash}
+	}
+	if len(txReqs) > 0 {
+		if err := ethaccessor.BatchTransactions(txReqs, routeParam); nil != err {
+			return nil, err
+		}
+		for _, req := range txReqs {
+			txs[req.TxHash] = &req.TxContent
+		}
+	}
+	if err := ethaccessor.BatchTransactionRecipients(rcReqs, routeParam); nil != err {
+		return nil, err
+	}
+
+	for _, req := range rcReqs {
+		block := blocks[txBlock[req.TxHash]]
+		if !strings.EqualFold(req.TxContent.BlockHash, block.Hash.Hex()) {
+			return nil, fmt.Errorf("receipt of tx:%s in block:%s not match block header, chain may be reorganized", req.TxHash, req.TxContent.BlockHash)
+		}
+		block.Transactions = append(block.Transactions, *txs[req.TxHash])
+		block.Receipts = append(block.Receipts, req.TxContent)
+	}
+
+	// 与全块模式一样按交易在块内的顺序处理
+	for _, block := range blocks {
+		sort.Sort(txWithReceiptSorter{block})
+	}
+
+	return blocks, nil
+}
+
+type txWithReceiptSorter struct {
+	block *ethaccessor.BlockWithTxAndReceipt
+}
+
+func (s txWithReceiptSorter) Len() int {
+	return len(s.block.Transactions)
+}
+
+func (s txWithReceiptSorter) Less(i, j int) bool {
+	return s.block.Receipts[i].TransactionIndex.Int64() < s.block.Receipts[j].TransactionIndex.Int64()
+}
+
+func (s txWithReceiptSorter) Swap(i, j int) {
+	s.block.Transactions[i], s.block.Transactions[j] = s.block.Transactions[j], s.block.Transactions[i]
+	s.block.Receipts[i], s.block.Receipts[j] = s.block.Receipts[j], s.block.Receipts[i]
+}
@@ -0,0 +1,208 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package extractor
+
+import (
+	"errors"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const defaultPrefetchFactor = 2
+
+type prefetchResult struct {
+	block *ethaccessor.BlockWithTxAndReceipt
+}
+
+// blockPrefetcher 同时获取多个块的交易以及receipt,Next依然按块号顺序返回。
+// 已获取但还未被处理的块最多window个,超过之后停止预取
+type blockPrefetcher struct {
+	current *big.Int
+	end     *big.Int
+	head    *chainHead
+
+	pending chan chan prefetchResult
+	workers chan bool
+	quit    chan bool
+	once    sync.Once
+
+	fetch func(blockNumber *big.Int) (*ethaccessor.BlockWithTxAndReceipt, error)
+}
+
+func newBlockPrefetcher(start, end *big.Int, confirms uint64, concurrency, window int) *blockPrefetcher {
+	if window < concurrency {
+		window = concurrency * defaultPrefetchFactor
+	}
+	p := &blockPrefetcher{
+		current: new(big.Int).Set(start),
+		end:     end,
+		head:    newChainHead(confirms),
+		pending: make(chan chan prefetchResult, window),
+		workers: make(chan bool, concurrency),
+		quit:    make(chan bool),
+	}
+	p.fetch = func(blockNumber *big.Int) (*ethaccessor.BlockWithTxAndReceipt, error) {
+		block, err := ethaccessor.GetFullBlock(blockNumber, true)
+		if nil != err {
+			return nil, err
+		}
+		return block.(*ethaccessor.BlockWithTxAndReceipt), nil
+	}
+	return p
+}
+
+func (p *blockPrefetcher) Start() {
+	go p.dispatch()
+}
+
+func (p *blockPrefetcher) Stop() {
+	p.once.Do(func() {
+		close(p.quit)
+	})
+}
+
+// Next 返回下一个块,块在获取成功之前一直重试
+func (p *blockPrefetcher) Next() (*ethaccessor.BlockWithTxAndReceipt, error) {
+	select {
+	case resChan := <-p.pending:
+		select {
+		case res := <-resChan:
+			return res.block, nil
+		case <-p.quit:
+		}
+	case <-p.quit:
+	}
+	return nil, errors.New("block prefetcher stopped")
+}
+
+func (p *blockPrefetcher) dispatch() {
+	for {
+		if nil != p.end && p.end.Sign() > 0 && p.end.Cmp(p.current) < 0 {
+			return
+		}
+		if !p.head.wait(p.current, p.quit) {
+			return
+		}
+
+		// pending满了说明处理跟不上,阻塞在这里
+		resChan := make(chan prefetchResult, 1)
+		select {
+		case p.pending <- resChan:
+		case <-p.quit:
+			return
+		}
+		select {
+		case p.workers <- true:
+		case <-p.quit:
+			return
+		}
+
+		go p.work(new(big.Int).Set(p.current), resChan)
+		p.current.Add(p.current, big.NewInt(1))
+	}
+}
+
+func (p *blockPrefetcher) work(blockNumber *big.Int, resChan chan prefetchResult) {
+	defer func() {
+		<-p.workers
+	}()
+
+	for {
+		block, err := p.fetch(blockNumber)
+		if nil == err {
+			resChan <- prefetchResult{block: block}
+			return
+		}
+		log.Errorf("extractor,prefetch block:%s error:%s", blockNumber.String(), err.Error())
+		select {
+		case <-time.After(1 * time.Second):
+		case <-p.quit:
+			return
+		}
+	}
+}
+
+// blockSource 按块号顺序返回需要处理的块,代替BlockIterator
+type blockSource interface {
+	Start()
+	Stop()
+	Next() (*ethaccessor.BlockWithTxAndReceipt, error)
+}
+
+// chainHead 缓存最新块号,历史同步时不需要每个块都查询一次
+type chainHead struct {
+	number   *big.Int
+	confirms uint64
+	latest   func() (*big.Int, error)
+}
+
+func newChainHead(confirms uint64) *chainHead {
+	return &chainHead{confirms: confirms, latest: latestBlockNumber}
+}
+
+// latestBlockNumber 优先使用共享块头流里的最新块号
+func latestBlockNumber() (*big.Int, error) {
+	if head, ok := ethaccessor.LatestHead(); ok {
+		return head.Number.BigInt(), nil
+	}
+	var blockNumber types.Big
+	err := ethaccessor.BlockNumber(&blockNumber)
+	return blockNumber.BigInt(), err
+}
+
+// confirmed 已经有confirms个确认的最大块号
+func (head *chainHead) confirmed() *big.Int {
+	if nil == head.number {
+		return big.NewInt(-1)
+	}
+	return new(big.Int).Sub(head.number, new(big.Int).SetUint64(head.confirms))
+}
+
+// wait 与BlockIterator一样等待块号之后有confirms个块,收到新块头时重新检查,quit关闭时返回false
+func (head *chainHead) wait(blockNumber *big.Int, quit chan bool) bool {
+	if head.confirmed().Cmp(blockNumber) >= 0 {
+		return true
+	}
+	heads, unsubscribe := ethaccessor.SubscribeHeads()
+	defer unsubscribe()
+
+	for {
+		if head.confirmed().Cmp(blockNumber) >= 0 {
+			return true
+		}
+		if latest, err := head.latest(); nil == err {
+			head.number = latest
+			if head.confirmed().Cmp(blockNumber) >= 0 {
+				return true
+			}
+		} else {
+			log.Errorf("extractor,get latest block number error:%s", err.Error())
+		}
+		select {
+		case <-heads:
+		case <-time.After(5 * time.Second):
+		case <-quit:
+			return false
+		}
+	}
+}
@@ -0,0 +1,277 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package extractor
+
+import (
+	"fmt"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
+	"math/big"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// contractEvent extractor.contracts中登记的事件,不需要为每个事件定义结构体
+type contractEvent struct {
+	contract   string
+	address    common.Address
+	cabi       *abi.ABI
+	event      abi.Event
+	dataType   reflect.Type // 非indexed参数的解析结构,字段通过fieldId对应参数位置,全部为indexed时为nil
+	ownerParam string       // 作为owner的address参数名,为空时使用交易发送者
+}
+
+func newContractEvent(contract string, address common.Address, cabi *abi.ABI, event abi.Event, ownerParam string) *contractEvent {
+	e := &contractEvent{contract: contract, address: address, cabi: cabi, event: event}
+	for _, input := range event.Inputs {
+		if input.Name == ownerParam && input.Type.T == abi.AddressTy {
+			e.ownerParam = ownerParam
+		}
+	}
+
+	var fields []reflect.StructField
+	for i, input := range event.Inputs {
+		if input.Indexed {
+			continue
+		}
+		fields = append(fields, reflect.StructField{
+			Name: "Field" + strconv.Itoa(i),
+			Type: reflect.TypeOf((*interface{})(nil)).Elem(),
+			Tag:  reflect.StructTag(fmt.Sprintf(`fieldId:"%d"`, i)),
+		})
+	}
+	if len(fields) > 0 {
+		e.dataType = reflect.StructOf(fields)
+	}
+
+	return e
+}
+
+// unpack indexed参数从topics中解析,动态类型只能得到hash;其余参数从data中解析
+func (e *contractEvent) unpack(evtLog *ethaccessor.Log) (map[string]interface{}, error) {
+	params := make(map[string]interface{})
+
+	topics := evtLog.Topics
+	if len(topics) > 0 {
+		topics = topics[1:]
+	}
+
+	values := make(map[int]interface{})
+	if e.dataType != nil {
+		data, err := hexutil.Decode(evtLog.Data)
+		if err != nil {
+			return params, err
+		}
+		if len(e.event.Inputs) > 1 {
+			v := reflect.New(e.dataType)
+			if err := e.cabi.Unpack(v.Interface(), e.event.Name, data, abi.SEL_UNPACK_EVENT); err != nil {
+				return params, err
+			}
+			for i := 0; i < e.dataType.NumField(); i++ {
+				id, _ := strconv.Atoi(e.dataType.Field(i).Tag.Get("fieldId"))
+				values[id] = v.Elem().Field(i).Interface()
+			}
+		} else {
+			var v interface{}
+			if err := e.cabi.Unpack(&v, e.event.Name, data, abi.SEL_UNPACK_EVENT); err != nil {
+				return params, err
+			}
+			values[0] = v
+		}
+	}
+
+	for i, input := range e.event.Inputs {
+		name := input.Name
+		if name == "" {
+			name = "arg" + strconv.Itoa(i)
+		}
+		if input.Indexed {
+			if len(topics) == 0 {
+				return params, fmt.Errorf("event %s missing topic of %s", e.event.Name, name)
+			}
+			params[name] = abiJsonValue(topicValue(input.Type, common.HexToHash(topics[0])))
+			topics = topics[1:]
+		} else {
+			params[name] = abiJsonValue(values[i])
+		}
+	}
+
+	return params, nil
+}
+
+// owner 事件中配置的address参数,没有配置或者事件没有该参数时为交易发送者
+func (e *contractEvent) owner(params map[string]interface{}, from common.Address) common.Address {
+	if e.ownerParam == "" {
+		return from
+	}
+	if v, ok := params[e.ownerParam].(string); ok && common.IsHexAddress(v) {
+		return common.HexToAddress(v)
+	}
+	return from
+}
+
+func topicValue(t abi.Type, topic common.Hash) interface{} {
+	switch t.T {
+	case abi.AddressTy:
+		return common.BytesToAddress(topic.Bytes())
+	case abi.BoolTy:
+		return topic.Big().Sign() != 0
+	case abi.UintTy:
+		return topic.Big()
+	case abi.IntTy:
+		return math.S256(topic.Big())
+	case abi.FixedBytesTy:
+		return topic.Bytes()[:t.Size]
+	default:
+		return topic
+	}
+}
+
+// abiJsonValue 转换为json友好的值,整数均为十进制字符串,地址及字节为hex
+func abiJsonValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case nil:
+		return nil
+	case *big.Int:
+		return value.String()
+	case common.Address:
+		return value.Hex()
+	case common.Hash:
+		return value.Hex()
+	case []byte:
+		return hexutil.Encode(value)
+	case string, bool:
+		return value
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatInt(rv.Int(), 10)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatUint(rv.Uint(), 10)
+	case reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			return hexutil.Encode(b)
+		}
+		fallthrough
+	case reflect.Slice:
+		list := make([]interface{}, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			list[i] = abiJsonValue(rv.Index(i).Interface())
+		}
+		return list
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func (processor *AbiProcessor) loadCustomContracts() {
+	for _, c := range processor.options.Contracts {
+		if !common.IsHexAddress(c.Address) {
+			log.Fatalf("extractor,custom contract %s address %s invalid", c.Name, c.Address)
+		}
+		cabi, err := abi.JSON(strings.NewReader(c.Abi))
+		if err != nil {
+			log.Fatalf("extractor,custom contract %s abi invalid:%s", c.Name, err.Error())
+		}
+
+		address := common.HexToAddress(c.Address)
+		events := make(map[common.Hash]*contractEvent)
+		for name, event := range cabi.Events {
+			if event.Anonymous || (len(c.Events) > 0 && !containsString(c.Events, name)) {
+				continue
+			}
+			events[event.Id()] = newContractEvent(c.Name, address, &cabi, event, c.OwnerParam)
+			log.Infof("extractor,custom contract %s event name:%s -> key:%s", c.Name, name, event.Id().Hex())
+		}
+		for _, name := range c.Events {
+			if _, ok := cabi.Events[name]; !ok {
+				log.Fatalf("extractor,custom contract %s event %s not found in abi", c.Name, name)
+			}
+		}
+
+		processor.contractEvents[address] = events
+		if _, ok := processor.protocols[address]; !ok {
+			processor.protocols[address] = c.Name
+		}
+		log.Infof("extractor,contract protocol %s->%s", c.Name, address.Hex())
+	}
+}
+
+func (processor *AbiProcessor) getContractEvent(evtLog ethaccessor.Log) (*contractEvent, bool) {
+	id := evtLog.EventId()
+	if id == types.NilHash {
+		return nil, false
+	}
+	events, ok := processor.contractEvents[common.HexToAddress(evtLog.Address)]
+	if !ok {
+		return nil, false
+	}
+	event, ok := events[id]
+	return event, ok
+}
+
+// handleContractEvent 与内置事件的解析相互独立,同一条日志可能同时产生两种事件
+func (processor *AbiProcessor) handleContractEvent(tx *ethaccessor.Transaction, evtLog *ethaccessor.Log, gasUsed, blockTime *big.Int, methodName string, state types.ChainState) error {
+	ce, ok := processor.getContractEvent(*evtLog)
+	if !ok {
+		return nil
+	}
+
+	params, err := ce.unpack(evtLog)
+	if err != nil {
+		return fmt.Errorf("custom contract %s event %s unpack error:%s", ce.contract, ce.event.Name, err.Error())
+	}
+
+	evt := &types.ContractEvent{}
+	evt.TxInfo = setTxInfo(tx, gasUsed, blockTime, methodName)
+	evt.Protocol = ce.address
+	evt.TxLogIndex = evtLog.LogIndex.Int64()
+	evt.Status = types.TX_STATUS_SUCCESS
+	evt.ChainState = state
+	evt.Owner = ce.owner(params, evt.From)
+	evt.ContractName = ce.contract
+	evt.EventName = ce.event.Name
+	evt.EventId = ce.event.Id()
+	evt.Params = params
+
+	log.Debugf("extractor,tx:%s custom contract %s event %s,params:%v", tx.Hash, ce.contract, ce.event.Name, params)
+
+	processor.emit(eventemitter.ContractEvent, evt, evt.TxInfo)
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,114 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package extractor
+
+import (
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+const testContractAbi = `[
+{"anonymous":false,"inputs":[{"indexed":false,"name":"amount","type":"uint256"}],"name":"Value","type":"event"},
+{"anonymous":false,"inputs":[{"indexed":true,"name":"user","type":"address"},{"indexed":false,"name":"amount","type":"uint256"}],"name":"Staked","type":"event"},
+{"anonymous":false,"inputs":[{"indexed":true,"name":"from","type":"address"},{"indexed":false,"name":"to","type":"address"},{"indexed":true,"name":"id","type":"uint256"},{"indexed":false,"name":"","type":"uint256"}],"name":"Moved","type":"event"}
+]`
+
+var (
+	testUser   = common.HexToAddress("0x1b978a1d302335a6f2ebe4b8823b5e17c3c84135")
+	testTarget = common.HexToAddress("0xb1018949b241d76a1ab2094f473e9befeabb5ead")
+)
+
+func newTestContractEvent(t *testing.T, name, ownerParam string) *contractEvent {
+	cabi, err := abi.JSON(strings.NewReader(testContractAbi))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	return newContractEvent("test", common.Address{}, &cabi, cabi.Events[name], ownerParam)
+}
+
+func testLog(e *contractEvent, topics []common.Hash, words ...[]byte) *ethaccessor.Log {
+	evtLog := &ethaccessor.Log{}
+	evtLog.Topics = append(evtLog.Topics, e.event.Id().Hex())
+	for _, topic := range topics {
+		evtLog.Topics = append(evtLog.Topics, topic.Hex())
+	}
+	var data []byte
+	for _, word := range words {
+		data = append(data, common.LeftPadBytes(word, 32)...)
+	}
+	evtLog.Data = hexutil.Encode(data)
+	return evtLog
+}
+
+func TestContractEvent_UnpackSingleNonIndexed(t *testing.T) {
+	e := newTestContractEvent(t, "Value", "")
+	params, err := e.unpack(testLog(e, nil, big.NewInt(1000).Bytes()))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if params["amount"] != "1000" {
+		t.Fatalf("amount:%v", params["amount"])
+	}
+}
+
+func TestContractEvent_UnpackIndexedAndNonIndexed(t *testing.T) {
+	e := newTestContractEvent(t, "Staked", "user")
+	params, err := e.unpack(testLog(e, []common.Hash{common.BytesToHash(testUser.Bytes())}, big.NewInt(20).Bytes()))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if params["user"] != testUser.Hex() || params["amount"] != "20" {
+		t.Fatalf("params:%v", params)
+	}
+	if owner := e.owner(params, testTarget); owner != testUser {
+		t.Fatalf("owner:%s", owner.Hex())
+	}
+}
+
+func TestContractEvent_UnpackMixed(t *testing.T) {
+	e := newTestContractEvent(t, "Moved", "to")
+	topics := []common.Hash{common.BytesToHash(testUser.Bytes()), common.BigToHash(big.NewInt(7))}
+	params, err := e.unpack(testLog(e, topics, testTarget.Bytes(), big.NewInt(3).Bytes()))
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if params["from"] != testUser.Hex() || params["to"] != testTarget.Hex() || params["id"] != "7" || params["arg3"] != "3" {
+		t.Fatalf("params:%v", params)
+	}
+	if owner := e.owner(params, testUser); owner != testTarget {
+		t.Fatalf("owner:%s", owner.Hex())
+	}
+
+	if _, err := e.unpack(testLog(e, topics[:1], testTarget.Bytes(), big.NewInt(3).Bytes())); err == nil {
+		t.Fatalf("missing topic should fail")
+	}
+}
+
+func TestContractEvent_OwnerDefaultsToSender(t *testing.T) {
+	e := newTestContractEvent(t, "Staked", "amount")
+	params := map[string]interface{}{"user": testUser.Hex(), "amount": "20"}
+	if owner := e.owner(params, testTarget); owner != testTarget {
+		t.Fatalf("owner:%s", owner.Hex())
+	}
+}
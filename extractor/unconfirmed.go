@@ -0,0 +1,239 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package extractor
+
+import (
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// 没有收到新块头时也定时检查一次
+const unconfirmedPollInterval = 3 * time.Second
+
+type unconfirmedBlock struct {
+	hash   common.Hash
+	events []*types.ChainEvent
+}
+
+// unconfirmedTracker 跟踪最新块,还没有足够确认的块里的事件先以unconfirmed状态发出。
+// 块被替换(分叉)或者确认时块hash不同,发出的事件以removed状态再发一次
+type unconfirmedTracker struct {
+	mtx       sync.Mutex
+	confirms  uint64
+	confirmed *big.Int // 主流程已经处理的块号
+	blocks    map[int64]*unconfirmedBlock
+	quit      chan bool
+	watcher   *eventemitter.Watcher
+
+	process func(block *ethaccessor.BlockWithTxAndReceipt)
+	latest  func() (*big.Int, error)
+	fetch   func(blockNumber *big.Int) (*ethaccessor.BlockWithTxAndReceipt, error)
+}
+
+func newUnconfirmedTracker(confirms uint64, process func(block *ethaccessor.BlockWithTxAndReceipt)) *unconfirmedTracker {
+	t := &unconfirmedTracker{
+		confirms: confirms,
+		blocks:   make(map[int64]*unconfirmedBlock),
+		process:  process,
+		latest:   latestBlockNumber,
+	}
+	t.fetch = func(blockNumber *big.Int) (*ethaccessor.BlockWithTxAndReceipt, error) {
+		block, err := ethaccessor.GetFullBlock(blockNumber, true)
+		if nil != err {
+			return nil, err
+		}
+		return block.(*ethaccessor.BlockWithTxAndReceipt), nil
+	}
+	t.watcher = &eventemitter.Watcher{Concurrent: false, Handle: t.handleUnconfirmedEvent}
+	return t
+}
+
+// Start 已跟踪的块在重启(分叉)之后保留,确认或者被替换时再发出removed
+func (t *unconfirmedTracker) Start(confirmed *big.Int) {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if nil != t.quit {
+		return
+	}
+	t.confirmed = new(big.Int).Set(confirmed)
+	t.quit = make(chan bool)
+	eventemitter.On(eventemitter.UnconfirmedChainEvent, t.watcher)
+	go t.loop(t.quit)
+}
+
+func (t *unconfirmedTracker) Stop() {
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if nil == t.quit {
+		return
+	}
+	close(t.quit)
+	t.quit = nil
+	eventemitter.Un(eventemitter.UnconfirmedChainEvent, t.watcher)
+}
+
+func (t *unconfirmedTracker) loop(quit chan bool) {
+	heads, unsubscribe := ethaccessor.SubscribeHeads()
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-quit:
+			return
+		case <-heads:
+		case <-time.After(unconfirmedPollInterval):
+		}
+		if err := t.poll(quit); nil != err {
+			log.Errorf("extractor,track unconfirmed blocks error:%s", err.Error())
+		}
+	}
+}
+
+func (t *unconfirmedTracker) poll(quit chan bool) error {
+	head, err := t.latest()
+	if nil != err {
+		return err
+	}
+
+	// 最新块回退时,高于最新块的块已经被移除
+	t.removeAbove(head)
+
+	from := new(big.Int).Sub(head, new(big.Int).SetUint64(t.confirms))
+	from.Add(from, big.NewInt(1))
+	t.mtx.Lock()
+	if t.confirmed.Cmp(from) >= 0 {
+		from = new(big.Int).Add(t.confirmed, big.NewInt(1))
+	}
+	t.mtx.Unlock()
+
+	for n := from; n.Cmp(head) <= 0; n = new(big.Int).Add(n, big.NewInt(1)) {
+		select {
+		case <-quit:
+			return nil
+		default:
+		}
+
+		block, err := t.fetch(n)
+		if nil != err {
+			return err
+		}
+		if !t.track(n, block.Hash) {
+			continue
+		}
+		log.Debugf("extractor,unconfirmed block:%s->%s, transaction number:%d", n.String(), block.Hash.Hex(), len(block.Transactions))
+		t.process(block)
+	}
+
+	return nil
+}
+
+// track 块hash与已跟踪的相同时不需要再处理,不同时移除之前的事件
+func (t *unconfirmedTracker) track(blockNumber *big.Int, blockHash common.Hash) bool {
+	t.mtx.Lock()
+	if blockNumber.Cmp(t.confirmed) <= 0 {
+		t.mtx.Unlock()
+		return false
+	}
+	old, ok := t.blocks[blockNumber.Int64()]
+	if ok && old.hash == blockHash {
+		t.mtx.Unlock()
+		return false
+	}
+	t.blocks[blockNumber.Int64()] = &unconfirmedBlock{hash: blockHash}
+	t.mtx.Unlock()
+
+	if ok {
+		t.remove(old)
+	}
+	return true
+}
+
+// confirm 主流程处理块之前调用,之后该块的事件以confirmed状态发出
+func (t *unconfirmedTracker) confirm(blockNumber *big.Int, blockHash common.Hash) {
+	var removed []*unconfirmedBlock
+
+	t.mtx.Lock()
+	for number, block := range t.blocks {
+		if number > blockNumber.Int64() {
+			continue
+		}
+		if number == blockNumber.Int64() && block.hash != blockHash {
+			removed = append(removed, block)
+		}
+		delete(t.blocks, number)
+	}
+	if nil == t.confirmed || t.confirmed.Cmp(blockNumber) != 0 {
+		t.confirmed = new(big.Int).Set(blockNumber)
+	}
+	t.mtx.Unlock()
+
+	for _, block := range removed {
+		t.remove(block)
+	}
+}
+
+func (t *unconfirmedTracker) removeAbove(head *big.Int) {
+	var removed []*unconfirmedBlock
+
+	t.mtx.Lock()
+	for number, block := range t.blocks {
+		if number > head.Int64() {
+			removed = append(removed, block)
+			delete(t.blocks, number)
+		}
+	}
+	t.mtx.Unlock()
+
+	for _, block := range removed {
+		t.remove(block)
+	}
+}
+
+func (t *unconfirmedTracker) remove(block *unconfirmedBlock) {
+	for _, evt := range block.events {
+		removed := *evt
+		removed.State = types.CHAIN_STATE_REMOVED
+		log.Debugf("extractor,remove unconfirmed event:%s of tx:%s in block:%s", removed.Topic, removed.TxHash.Hex(), removed.BlockHash.Hex())
+		eventemitter.Emit(eventemitter.RemovedChainEvent, &removed)
+	}
+}
+
+// handleUnconfirmedEvent 记录发出的未确认事件,以便分叉时发出removed
+func (t *unconfirmedTracker) handleUnconfirmedEvent(input eventemitter.EventData) error {
+	evt := input.(*types.ChainEvent)
+	if nil == evt.BlockNumber {
+		return nil
+	}
+
+	t.mtx.Lock()
+	defer t.mtx.Unlock()
+
+	if block, ok := t.blocks[evt.BlockNumber.Int64()]; ok && block.hash == evt.BlockHash {
+		block.events = append(block.events, evt)
+	}
+	return nil
+}
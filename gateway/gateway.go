@@ -30,9 +30,11 @@ import (
 	"github.com/Loopring/relay/marketcap"
 	"github.com/Loopring/relay/ordermanager"
 	"github.com/Loopring/relay/types"
+	"github.com/Loopring/relay/usermanager"
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
 	"qiniupkg.com/x/errors.v7"
+	"sync"
 	"time"
 )
 
@@ -44,6 +46,7 @@ type Gateway struct {
 	maxBroadcastTime int
 	ipfsPubService   IPFSPubService
 	marketCap        marketcap.MarketCapProvider
+	orderLimitFilter *OrderLimitFilter
 }
 
 var gateway Gateway
@@ -52,7 +55,7 @@ type Filter interface {
 	filter(o *types.Order) (bool, error)
 }
 
-func Initialize(filterOptions *config.GatewayFiltersOptions, options *config.GateWayOptions, ipfsOptions *config.IpfsOptions, om ordermanager.OrderManager, marketCap marketcap.MarketCapProvider, am market.AccountManager) {
+func Initialize(filterOptions *config.GatewayFiltersOptions, options *config.GateWayOptions, ipfsOptions *config.IpfsOptions, om ordermanager.OrderManager, marketCap marketcap.MarketCapProvider, am market.AccountManager, um usermanager.UserManager) {
 	// add gateway watcher
 	gatewayWatcher := &eventemitter.Watcher{Concurrent: false, Handle: HandleOrder}
 	eventemitter.On(eventemitter.GatewayNewOrder, gatewayWatcher)
@@ -93,11 +96,21 @@ func Initialize(filterOptions *config.GatewayFiltersOptions, options *config.Gat
 	// new cutoff filter
 	cutoffFilter := &CutoffFilter{om: om}
 
+	// new order limit filter
+	orderLimitFilter := &OrderLimitFilter{
+		MaxOpenOrders:    filterOptions.OrderLimitFilter.MaxOpenOrders,
+		MaxOpenUsdAmount: filterOptions.OrderLimitFilter.MaxOpenUsdAmount,
+		om:               om,
+		um:               um,
+	}
+
 	gateway.filters = append(gateway.filters, powFilter)
 	gateway.filters = append(gateway.filters, baseFilter)
 	gateway.filters = append(gateway.filters, signFilter)
 	gateway.filters = append(gateway.filters, tokenFilter)
 	gateway.filters = append(gateway.filters, cutoffFilter)
+	gateway.filters = append(gateway.filters, orderLimitFilter)
+	gateway.orderLimitFilter = orderLimitFilter
 }
 
 func HandleInputOrder(input eventemitter.EventData) (orderHash string, err error) {
@@ -113,21 +126,17 @@ func HandleInputOrder(input eventemitter.EventData) (orderHash string, err error
 
 	//TODO(xiaolu) 这里需要测试一下，超时error和查询数据为空的error，处理方式不应该一样
 	if state, err = gateway.om.GetOrderByHash(order.Hash); err != nil && err.Error() == "record not found" {
-		if err = generatePrice(order); err != nil {
+		if err = filterOrder(order); err != nil {
 			return orderHash, err
 		}
-
-		for _, v := range gateway.filters {
-			valid, err := v.filter(order)
-			if !valid {
-				log.Errorf(err.Error())
-				return orderHash, err
-			}
-		}
 		state = &types.OrderState{}
 		state.RawOrder = *order
 		//broadcastTime = 0
 		eventemitter.Emit(eventemitter.NewOrder, state)
+		// 订单保存成功后才计入挂单统计
+		if _, err := gateway.om.GetOrderByHash(order.Hash); err == nil {
+			gateway.orderLimitFilter.recordAccepted(order)
+		}
 	} else {
 		//broadcastTime = state.BroadcastTime
 		log.Infof("gateway,order %s exist,will not insert again", order.Hash.Hex())
@@ -149,6 +158,47 @@ func HandleInputOrder(input eventemitter.EventData) (orderHash string, err error
 	return orderHash, err
 }
 
+// HandleConditionalOrder 条件单与普通订单使用相同的校验,通过后交由TriggerManager保存直到触发
+func HandleConditionalOrder(order *types.Order, trigger *types.OrderTrigger, triggerManager *ordermanager.TriggerManager) (orderHash string, err error) {
+	order.Hash = order.GenerateHash()
+	orderHash = order.Hash.Hex()
+
+	if !trigger.IsValid() {
+		return orderHash, errors.New("invalid trigger, priceSource/condition/triggerPrice is illegal")
+	}
+
+	if _, err = gateway.om.GetOrderByHash(order.Hash); err == nil || err.Error() != "record not found" {
+		log.Infof("gateway,conditional order %s exist,will not insert again", order.Hash.Hex())
+		return orderHash, errors.New("order existed, please not submit again")
+	}
+
+	if err = filterOrder(order); err != nil {
+		return orderHash, err
+	}
+
+	state := &types.OrderState{}
+	state.RawOrder = *order
+	if err = triggerManager.AddConditionalOrder(state, trigger); err == nil {
+		gateway.orderLimitFilter.recordAccepted(order)
+	}
+	return orderHash, err
+}
+
+func filterOrder(order *types.Order) error {
+	if err := generatePrice(order); err != nil {
+		return err
+	}
+
+	for _, v := range gateway.filters {
+		valid, err := v.filter(order)
+		if !valid {
+			log.Errorf(err.Error())
+			return err
+		}
+	}
+	return nil
+}
+
 func HandleOrder(input eventemitter.EventData) error {
 	_, err := HandleInputOrder(input)
 	return err
@@ -347,6 +397,163 @@ func (f *CutoffFilter) filter(o *types.Order) (bool, error) {
 	return true, nil
 }
 
+// OrderLimitFilter 限制单个用户在同一市场的挂单数量以及所有挂单的美元总价值,
+// 做市商等用户可以通过usermanager单独配置,0表示不限制
+type OrderLimitFilter struct {
+	MaxOpenOrders    int
+	MaxOpenUsdAmount float64
+	om               ordermanager.OrderManager
+	um               usermanager.UserManager
+	mtx              sync.Mutex
+	summaries        map[common.Address]*openOrderSummary
+}
+
+var openOrderStatusSet = []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL, types.ORDER_PENDING_FOR_P2P, types.ORDER_PENDING_TRIGGER}
+
+// openOrderSummaryTtl 挂单统计缓存的秒数,期间保存成功的订单直接累加,成交和取消在缓存过期后体现
+const openOrderSummaryTtl = 30
+
+// openOrderSummary 用户在各市场的挂单数量以及挂单的美元总价值
+type openOrderSummary struct {
+	markets    map[string]int
+	usdAmount  float64
+	createTime int64
+}
+
+func (f *OrderLimitFilter) filter(o *types.Order) (bool, error) {
+	maxOpenOrders, maxOpenUsdAmount := f.MaxOpenOrders, f.MaxOpenUsdAmount
+	if f.um != nil {
+		if limit, ok := f.um.GetOrderLimit(o.Owner); ok {
+			maxOpenOrders, maxOpenUsdAmount = limit.MaxOpenOrders, limit.MaxOpenUsdAmount
+		}
+	}
+	if maxOpenOrders <= 0 && maxOpenUsdAmount <= 0 {
+		return true, nil
+	}
+
+	mkt, err := util.WrapMarketByAddress(o.TokenS.Hex(), o.TokenB.Hex())
+	if err != nil {
+		return false, err
+	}
+	var usdAmount float64
+	if maxOpenUsdAmount > 0 {
+		if usdAmount, err = usdValue(o.TokenS, new(big.Rat).SetInt(o.AmountS)); err != nil {
+			return false, err
+		}
+	}
+
+	summary, err := f.openOrderSummary(o.Owner)
+	if err != nil {
+		return false, err
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if maxOpenOrders > 0 && summary.markets[mkt]+1 > maxOpenOrders {
+		log.Debugf("gateway,order limit filter,owner:%s market:%s open orders:%d exceed %d", o.Owner.Hex(), mkt, summary.markets[mkt]+1, maxOpenOrders)
+		return false, errors.New(ORDER_60001)
+	}
+	if maxOpenUsdAmount > 0 && summary.usdAmount+usdAmount > maxOpenUsdAmount {
+		log.Debugf("gateway,order limit filter,owner:%s open usd amount:%f exceed %f", o.Owner.Hex(), summary.usdAmount+usdAmount, maxOpenUsdAmount)
+		return false, errors.New(ORDER_60002)
+	}
+
+	return true, nil
+}
+
+// recordAccepted 订单保存成功后累加到缓存的挂单统计,缓存不存在或者过期时下次从数据库统计
+func (f *OrderLimitFilter) recordAccepted(o *types.Order) {
+	if nil == f {
+		return
+	}
+	mkt, err := util.WrapMarketByAddress(o.TokenS.Hex(), o.TokenB.Hex())
+	if err != nil {
+		return
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	summary, ok := f.summaries[o.Owner]
+	if !ok || time.Now().Unix()-summary.createTime >= openOrderSummaryTtl {
+		return
+	}
+	summary.markets[mkt]++
+	if usdAmount, err := usdValue(o.TokenS, new(big.Rat).SetInt(o.AmountS)); err == nil {
+		summary.usdAmount += usdAmount
+	}
+}
+
+// openOrderSummary 缓存过期时从数据库重新统计,无法获取价格的挂单不计入美元总价值
+func (f *OrderLimitFilter) openOrderSummary(owner common.Address) (*openOrderSummary, error) {
+	nowtime := time.Now().Unix()
+
+	f.mtx.Lock()
+	summary, ok := f.summaries[owner]
+	f.mtx.Unlock()
+	if ok && nowtime-summary.createTime < openOrderSummaryTtl {
+		return summary, nil
+	}
+
+	orders, err := f.om.GetOpenOrders(owner, openOrderStatusSet)
+	if err != nil {
+		return nil, fmt.Errorf("gateway,order limit filter,get open orders error:%s", err.Error())
+	}
+
+	summary = &openOrderSummary{markets: make(map[string]int), createTime: nowtime}
+	for _, v := range orders {
+		summary.markets[openOrderMarket(&v)]++
+
+		remainedAmountS, _ := v.RemainedAmount()
+		if remainedAmountS.Sign() <= 0 {
+			continue
+		}
+		value, err := usdValue(v.RawOrder.TokenS, remainedAmountS)
+		if err != nil {
+			log.Debugf("gateway,order limit filter,skip usd value of order:%s error:%s", v.RawOrder.Hash.Hex(), err.Error())
+			continue
+		}
+		summary.usdAmount += value
+	}
+
+	f.mtx.Lock()
+	defer f.mtx.Unlock()
+
+	if nil == f.summaries {
+		f.summaries = make(map[common.Address]*openOrderSummary)
+	}
+	for addr, v := range f.summaries {
+		if nowtime-v.createTime >= openOrderSummaryTtl {
+			delete(f.summaries, addr)
+		}
+	}
+	f.summaries[owner] = summary
+
+	return summary, nil
+}
+
+func openOrderMarket(state *types.OrderState) string {
+	if state.RawOrder.Market != "" {
+		return state.RawOrder.Market
+	}
+	mkt, _ := util.WrapMarketByAddress(state.RawOrder.TokenS.Hex(), state.RawOrder.TokenB.Hex())
+	return mkt
+}
+
+func usdValue(token common.Address, amount *big.Rat) (float64, error) {
+	tk, err := util.AddressToToken(token)
+	if err != nil {
+		return 0, fmt.Errorf("token %s is not support now", token.Hex())
+	}
+	price, err := gateway.marketCap.GetMarketCapByCurrency(token, "USD")
+	if err != nil || price == nil {
+		return 0, fmt.Errorf("get price error. please retry later")
+	}
+	value, _ := new(big.Rat).Mul(price, new(big.Rat).Quo(amount, new(big.Rat).SetInt(tk.Decimals))).Float64()
+	return value, nil
+}
+
 type PowFilter struct {
 	Difficulty *big.Int
 }
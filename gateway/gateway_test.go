@@ -34,7 +34,7 @@ func TestGetPow(t *testing.T) {
 	um := usermanager.NewUserManager(&globalConfig.UserManager, rds)
 	mc := marketcap.NewMarketCapProvider(globalConfig.MarketCap)
 
-	om := ordermanager.NewOrderManager(&globalConfig.OrderManager, rds, um, mc)
+	om := ordermanager.NewOrderManager(&globalConfig.OrderManager, rds, um, mc, nil)
 	trendm := market.NewTrendManager(rds)
 
 	am := market.NewAccountManager()
@@ -42,7 +42,7 @@ func TestGetPow(t *testing.T) {
 	ethf := gateway.EthForwarder{}
 	collector := market.NewCollector()
 	protocols := make(map[string]string)
-	ws := gateway.NewWalletService(trendm, om, am, mc, &ethf, *collector, rds, "", protocols)
+	ws := gateway.NewWalletService(trendm, om, am, mc, &ethf, *collector, rds, nil, "", protocols)
 	oq := gateway.OrderQuery{}
 	oq.ContractVersion = "v1.4"
 	oq.Side = "buy"
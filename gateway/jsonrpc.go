@@ -40,21 +40,36 @@ type JsonrpcService interface {
 type JsonrpcServiceImpl struct {
 	port          string
 	walletService *WalletServiceImpl
+	services      map[string]interface{}
 }
 
 func NewJsonrpcService(port string, walletService *WalletServiceImpl) *JsonrpcServiceImpl {
 	l := &JsonrpcServiceImpl{}
 	l.port = port
 	l.walletService = walletService
+	l.services = make(map[string]interface{})
 	return l
 }
 
+// RegisterService 注册其他namespace的服务,需要在Start之前调用
+func (j *JsonrpcServiceImpl) RegisterService(namespace string, service interface{}) {
+	j.services[namespace] = service
+}
+
 func (j *JsonrpcServiceImpl) Start() {
 
 	handler := rpc.NewServer()
-	if err := handler.RegisterName("loopring", j.walletService); err != nil {
-		fmt.Println(err)
-		return
+	if nil != j.walletService {
+		if err := handler.RegisterName("loopring", j.walletService); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+	for namespace, service := range j.services {
+		if err := handler.RegisterName(namespace, service); err != nil {
+			fmt.Println(err)
+			return
+		}
 	}
 
 	var (
@@ -150,8 +150,9 @@ func NewSocketIOService(port string, walletService WalletServiceImpl) *SocketIOS
 	//eventemitter.On(eventemitter.DepthUpdated, depthWatcher)
 	//transactionWatcher := &eventemitter.Watcher{Concurrent: false, Handle: so.handleTransactionUpdate}
 	//eventemitter.On(eventemitter.TransactionEvent, transactionWatcher)
-	//pendingTxWatcher := &eventemitter.Watcher{Concurrent: false, Handle: so.handlePendingTransaction}
-	//eventemitter.On(eventemitter.TransactionEvent, pendingTxWatcher)
+	// 未确认块中的交易及被移除的交易由txmanager更新pending记录后立即推送
+	pendingTxWatcher := &eventemitter.Watcher{Concurrent: false, Handle: so.handlePendingTransaction}
+	eventemitter.On(eventemitter.TransactionEvent, pendingTxWatcher)
 	return so
 }
 
@@ -710,25 +711,18 @@ func (so *SocketIOServiceImpl) handleTransactionUpdate(input eventemitter.EventD
 
 func (so *SocketIOServiceImpl) handlePendingTransaction(input eventemitter.EventData) (err error) {
 
-	log.Infof("[SOCKETIO-RECEIVE-EVENT] transaction input (for pending). %s", input)
+	log.Debugf("[SOCKETIO-RECEIVE-EVENT] transaction input (for pending). %s", input)
 
 	req := input.(*txtyp.TransactionView)
 	owner := req.Owner.Hex()
-	log.Infof("received owner is %s ", owner)
-	fmt.Println(so.connIdMap)
 	so.connIdMap.Range(func(key, value interface{}) bool {
 		v := value.(socketio.Conn)
-		fmt.Println(key)
-		fmt.Println(value)
 		if v.Context() != nil {
 			businesses := v.Context().(map[string]string)
 			ctx, ok := businesses[eventKeyPendingTx]
-			log.Infof("cxt contains event key %b", ok)
-
 			if ok {
 				txQuery := &SingleOwner{}
 				err = json.Unmarshal([]byte(ctx), txQuery)
-				log.Info("single owner is: " + txQuery.Owner)
 				if err != nil {
 					log.Error("tx query unmarshal error, " + err.Error())
 				} else if strings.ToUpper(owner) == strings.ToUpper(txQuery.Owner) {
@@ -22,6 +22,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/Loopring/relay/cache"
+	"github.com/Loopring/relay/crypto"
 	"github.com/Loopring/relay/dao"
 	"github.com/Loopring/relay/ethaccessor"
 	"github.com/Loopring/relay/eventemiter"
@@ -53,6 +54,8 @@ const P2P_50004 = "50004"
 const P2P_50005 = "50005"
 const P2P_50006 = "50006"
 const P2P_50008 = "50008"
+const ORDER_60001 = "60001" // 同一市场挂单数量超过限制
+const ORDER_60002 = "60002" // 挂单总价值超过限制
 
 type Portfolio struct {
 	Token      string `json:"token"`
@@ -147,6 +150,13 @@ type TransactionQuery struct {
 	PageSize  int      `json:"pageSize"`
 }
 
+type ContractEventQuery struct {
+	Owner     string `json:"owner"`
+	Contract  string `json:"contract"`
+	PageIndex int    `json:"pageIndex"`
+	PageSize  int    `json:"pageSize"`
+}
+
 type OrderQuery struct {
 	Status          string `json:"status"`
 	PageIndex       int    `json:"pageIndex"`
@@ -217,6 +227,7 @@ type OrderJsonResult struct {
 	DealtAmountB     string             `json:"dealtAmountB"`
 	CancelledAmountS string             `json:"cancelledAmountS"`
 	CancelledAmountB string             `json:"cancelledAmountB"`
+	FundableAmountS  string             `json:"fundableAmountS"`
 	Status           string             `json:"status"`
 }
 
@@ -282,6 +293,22 @@ type P2PRingRequest struct {
 	MakerOrderHash string `json:"makerOrderHash"`
 }
 
+type ConditionalOrderRequest struct {
+	Order        types.OrderJsonRequest `json:"order"`
+	PriceSource  string                 `json:"priceSource"` // loopring or exchange
+	Condition    string                 `json:"condition"`   // gte or lte
+	TriggerPrice float64                `json:"triggerPrice"`
+}
+
+// CancelConditionalOrderRequest 条件单尚未上链,取消时由owner对(orderHash,timestamp)签名证明身份
+type CancelConditionalOrderRequest struct {
+	OrderHash string        `json:"orderHash"`
+	Timestamp int64         `json:"timestamp"`
+	V         uint8         `json:"v"`
+	R         types.Bytes32 `json:"r"`
+	S         types.Bytes32 `json:"s"`
+}
+
 type WalletServiceImpl struct {
 	trendManager    market.TrendManager
 	orderManager    ordermanager.OrderManager
@@ -289,11 +316,12 @@ type WalletServiceImpl struct {
 	marketCap       marketcap.MarketCapProvider
 	tickerCollector market.CollectorImpl
 	rds             dao.RdsService
+	triggerManager  *ordermanager.TriggerManager
 	oldWethAddress  string
 }
 
 func NewWalletService(trendManager market.TrendManager, orderManager ordermanager.OrderManager, accountManager market.AccountManager,
-	capProvider marketcap.MarketCapProvider, collector market.CollectorImpl, rds dao.RdsService, oldWethAddress string) *WalletServiceImpl {
+	capProvider marketcap.MarketCapProvider, collector market.CollectorImpl, rds dao.RdsService, triggerManager *ordermanager.TriggerManager, oldWethAddress string) *WalletServiceImpl {
 	w := &WalletServiceImpl{}
 	w.trendManager = trendManager
 	w.orderManager = orderManager
@@ -301,6 +329,7 @@ func NewWalletService(trendManager market.TrendManager, orderManager ordermanage
 	w.marketCap = capProvider
 	w.tickerCollector = collector
 	w.rds = rds
+	w.triggerManager = triggerManager
 	w.oldWethAddress = oldWethAddress
 	return w
 }
@@ -488,6 +517,71 @@ func (w *WalletServiceImpl) SubmitOrder(order *types.OrderJsonRequest) (res stri
 	return HandleInputOrder(types.ToOrder(order))
 }
 
+func (w *WalletServiceImpl) SubmitConditionalOrder(req *ConditionalOrderRequest) (res string, err error) {
+	if w.triggerManager == nil {
+		return res, errors.New("conditional order is not supported")
+	}
+
+	// 条件单只能进入订单簿撮合
+	req.Order.OrderType = types.ORDER_TYPE_MARKET
+	trigger := &types.OrderTrigger{
+		PriceSource:  req.PriceSource,
+		Condition:    req.Condition,
+		TriggerPrice: req.TriggerPrice,
+	}
+	return HandleConditionalOrder(types.ToOrder(&req.Order), trigger, w.triggerManager)
+}
+
+func (w *WalletServiceImpl) CancelConditionalOrder(req CancelConditionalOrderRequest) (res string, err error) {
+	if w.triggerManager == nil {
+		return res, errors.New("conditional order is not supported")
+	}
+
+	// 签名有效期10分钟,避免签名被重放
+	if req.Timestamp < time.Now().Unix()-600 || req.Timestamp > time.Now().Unix()+600 {
+		return res, errors.New("timestamp had expired")
+	}
+
+	orderHash := common.HexToHash(req.OrderHash)
+	trigger, err := w.triggerManager.GetOrderTrigger(orderHash)
+	if err != nil {
+		return res, err
+	}
+
+	hash := crypto.GenerateHash(orderHash.Bytes(), []byte(fmt.Sprintf("%d", req.Timestamp)))
+	sig, err := crypto.VRSToSig(req.V, req.R.Bytes(), req.S.Bytes())
+	if err != nil {
+		return res, err
+	}
+	addressBytes, err := crypto.SigToAddress(hash, sig)
+	if err != nil {
+		return res, err
+	}
+	if common.BytesToAddress(addressBytes) != trigger.Owner {
+		return res, errors.New("signer address is not the order owner")
+	}
+
+	if err = w.triggerManager.CancelConditionalOrder(orderHash); err != nil {
+		return res, err
+	}
+	return "SUCCESS", nil
+}
+
+func (w *WalletServiceImpl) GetConditionalOrderTrigger(query OrderQuery) (trigger types.OrderTrigger, err error) {
+	if w.triggerManager == nil {
+		return trigger, errors.New("conditional order is not supported")
+	}
+	if len(query.OrderHash) == 0 {
+		return trigger, errors.New("order hash can't be null")
+	}
+
+	rst, err := w.triggerManager.GetOrderTrigger(common.HexToHash(query.OrderHash))
+	if err != nil {
+		return trigger, err
+	}
+	return *rst, nil
+}
+
 func (w *WalletServiceImpl) GetOrders(query *OrderQuery) (res PageResult, err error) {
 	orderQuery, statusList, pi, ps := convertFromQuery(query)
 	queryRst, err := w.orderManager.GetOrders(orderQuery, statusList, pi, ps)
@@ -844,6 +938,31 @@ func (w *WalletServiceImpl) GetTransactions(query TransactionQuery) (PageResult,
 	return rst, nil
 }
 
+// GetContractEvents extractor.contracts中登记合约的事件,contract为空时返回全部合约
+func (w *WalletServiceImpl) GetContractEvents(query ContractEventQuery) (PageResult, error) {
+	var (
+		rst           PageResult
+		limit, offset int
+		err           error
+	)
+
+	rst.Data = make([]interface{}, 0)
+	rst.PageIndex, rst.PageSize, limit, offset = pagination(query.PageIndex, query.PageSize)
+	rst.Total, err = txmanager.GetContractEventCount(query.Owner, query.Contract)
+	if err != nil {
+		return rst, err
+	}
+	events, err := txmanager.GetContractEvents(query.Owner, query.Contract, limit, offset)
+	if err != nil {
+		return rst, err
+	}
+	for _, v := range events {
+		rst.Data = append(rst.Data, v)
+	}
+
+	return rst, nil
+}
+
 func pagination(pageIndex, pageSize int) (int, int, int, int) {
 	if pageIndex <= 0 {
 		pageIndex = 1
@@ -941,6 +1060,8 @@ func convertStatus(s string) []types.OrderStatus {
 		return []types.OrderStatus{types.ORDER_CUTOFF}
 	case "ORDER_EXPIRE":
 		return []types.OrderStatus{types.ORDER_EXPIRE}
+	case "ORDER_PENDING_TRIGGER":
+		return []types.OrderStatus{types.ORDER_PENDING_TRIGGER}
 	}
 	return []types.OrderStatus{}
 }
@@ -971,6 +1092,8 @@ func getStringStatus(order types.OrderState) string {
 		return "ORDER_PENDING"
 	case types.ORDER_EXPIRE:
 		return "ORDER_EXPIRE"
+	case types.ORDER_PENDING_TRIGGER:
+		return "ORDER_PENDING_TRIGGER"
 	}
 	return "ORDER_UNKNOWN"
 }
@@ -994,6 +1117,11 @@ func (w *WalletServiceImpl) calculateDepth(states []types.OrderState, length int
 
 		price := *s.RawOrder.Price
 		amountS, amountB := s.RemainedAmount()
+		// 余额或授权不足时只展示可成交部分
+		if s.FundableAmountS != nil && new(big.Rat).SetInt(s.FundableAmountS).Cmp(amountS) < 0 {
+			amountS = new(big.Rat).SetInt(s.FundableAmountS)
+			amountB = new(big.Rat).Mul(amountS, new(big.Rat).SetFrac(s.RawOrder.AmountB, s.RawOrder.AmountS))
+		}
 		amountS = amountS.Quo(amountS, new(big.Rat).SetFrac(tokenSDecimal, big.NewInt(1)))
 		amountB = amountB.Quo(amountB, new(big.Rat).SetFrac(tokenBDecimal, big.NewInt(1)))
 
@@ -1216,6 +1344,9 @@ func orderStateToJson(src types.OrderState) OrderJsonResult {
 	rst.DealtAmountS = types.BigintToHex(src.DealtAmountS)
 	rst.CancelledAmountB = types.BigintToHex(src.CancelledAmountB)
 	rst.CancelledAmountS = types.BigintToHex(src.CancelledAmountS)
+	if src.FundableAmountS != nil {
+		rst.FundableAmountS = types.BigintToHex(src.FundableAmountS)
+	}
 	rst.Status = getStringStatus(src)
 	rawOrder := RawOrderJsonResult{}
 	rawOrder.Protocol = src.RawOrder.Protocol.Hex()
@@ -1231,9 +1362,12 @@ func orderStateToJson(src types.OrderState) OrderJsonResult {
 	rawOrder.LrcFee = types.BigintToHex(src.RawOrder.LrcFee)
 	rawOrder.BuyNoMoreThanAmountB = src.RawOrder.BuyNoMoreThanAmountB
 	rawOrder.MarginSplitPercentage = types.BigintToHex(big.NewInt(int64(src.RawOrder.MarginSplitPercentage)))
-	rawOrder.V = types.BigintToHex(big.NewInt(int64(src.RawOrder.V)))
-	rawOrder.R = src.RawOrder.R.Hex()
-	rawOrder.S = src.RawOrder.S.Hex()
+	// 条件单触发前不公开签名,避免被他人提前提交
+	if src.Status != types.ORDER_PENDING_TRIGGER {
+		rawOrder.V = types.BigintToHex(big.NewInt(int64(src.RawOrder.V)))
+		rawOrder.R = src.RawOrder.R.Hex()
+		rawOrder.S = src.RawOrder.S.Hex()
+	}
 	rawOrder.WalletAddress = src.RawOrder.WalletAddress.Hex()
 	rawOrder.AuthAddr = src.RawOrder.AuthAddr.Hex()
 	rawOrder.Market = src.RawOrder.Market
@@ -510,6 +510,30 @@ func (b *ChangedOfBlock) batchAllowanceReqs() ethaccessor.BatchErc20AllowanceReq
 	return reqs
 }
 
+// fundsChanges 当前区块内balance或allowance发生变化的owner/token,已去重
+func (b *ChangedOfBlock) fundsChanges() []types.FundsChange {
+	changes := []types.FundsChange{}
+	changed := make(map[types.FundsChange]bool)
+
+	if balancesData, err := rcache.SMembers(b.cacheBalanceKey()); nil == err {
+		for _, data := range balancesData {
+			owner, token := b.parseCacheBalanceField(data)
+			changed[types.FundsChange{Owner: owner, Token: token}] = true
+		}
+	}
+	if allowancesData, err := rcache.SMembers(b.cacheAllowanceKey()); nil == err {
+		for _, data := range allowancesData {
+			owner, token, _ := b.parseCacheAllowanceField(data)
+			changed[types.FundsChange{Owner: owner, Token: token}] = true
+		}
+	}
+	for change := range changed {
+		changes = append(changes, change)
+	}
+
+	return changes
+}
+
 func (b *ChangedOfBlock) syncAndSaveAllowances() error {
 
 	reqs := b.batchAllowanceReqs()
@@ -740,6 +764,10 @@ func (a *AccountManager) handleBlockEnd(input eventemitter.EventData) error {
 	a.block.syncAndSaveBalances()
 	a.block.syncAndSaveAllowances()
 
+	if changes := a.block.fundsChanges(); len(changes) > 0 {
+		eventemitter.Emit(eventemitter.AccountFundsSynced, &types.AccountFundsSyncedEvent{BlockNumber: event.BlockNumber, Changes: changes})
+	}
+
 	removeExpiredBlock(a.block.currentBlockNumber, a.block.cachedDuration)
 
 	return nil
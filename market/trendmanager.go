@@ -1035,6 +1035,9 @@ func ConvertUp(src dao.Trend) Trend {
 	}
 }
 
+// processed_event中trend的handler,同一个fill只追加一次到ticker缓存
+const trendOrderFilledHandler = "trendmanager.OrderFilled"
+
 func (t *TrendManager) HandleOrderFilled(input eventemitter.EventData) (err error) {
 
 	log.Info("HandleOrderFilled invoked")
@@ -1067,6 +1070,18 @@ func (t *TrendManager) HandleOrderFilled(input eventemitter.EventData) (err erro
 			return
 		}
 
+		// 只登记事件,登记提交成功后再更新缓存,登记失败重试时不会重复追加
+		processed, processErr := t.rds.ProcessEventOnce(event.TxHash.Hex(), event.FillIndex.Int64(), trendOrderFilledHandler, event.BlockNumber.Int64(), func(rds dao.RdsService) error {
+			return nil
+		})
+		if processErr != nil {
+			err = processErr
+			return
+		}
+		if !processed {
+			return
+		}
+
 		if trendInCache, err := redisCache.Get(buildTrendKey(OneHour, market)); err == nil {
 			var tc Cache
 			json.Unmarshal(trendInCache, &tc)
@@ -53,7 +53,7 @@ func prepare() {
 	rdsService := dao.NewRdsService(globalConfig.Mysql)
 	userManager := usermanager.NewUserManager(&globalConfig.UserManager, rdsService)
 	marketCapProvider := marketcap.NewMarketCapProvider(globalConfig.MarketCap)
-	orderManager := ordermanager.NewOrderManager(&globalConfig.OrderManager, rdsService, userManager, marketCapProvider)
+	orderManager := ordermanager.NewOrderManager(&globalConfig.OrderManager, rdsService, userManager, marketCapProvider, nil)
 	gateway.Initialize(&globalConfig.GatewayFilters, &globalConfig.Gateway, &globalConfig.Ipfs, orderManager, marketCapProvider)
 	baseFilter := &gateway.BaseFilter{
 		MinLrcFee:             big.NewInt(globalConfig.GatewayFilters.BaseFilter.MinLrcFee),
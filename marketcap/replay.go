@@ -0,0 +1,121 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package marketcap
+
+import (
+	"errors"
+	"github.com/Loopring/relay/market/util"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sort"
+)
+
+type replayPrice struct {
+	time  int64
+	price *big.Rat
+}
+
+// CapProvider_Replay 回测使用的价格,token价格为模拟时间之前最近一次以eth计价的价格,
+// eth的法币价格固定,只支持一种法币
+type CapProvider_Replay struct {
+	currency string
+	ethPrice *big.Rat
+	prices   map[common.Address][]replayPrice
+	now      int64
+}
+
+func NewReplayCap(currency string, ethPrice *big.Rat) *CapProvider_Replay {
+	p := &CapProvider_Replay{}
+	p.currency = currency
+	p.ethPrice = ethPrice
+	p.prices = make(map[common.Address][]replayPrice)
+	return p
+}
+
+func (p *CapProvider_Replay) Start() {
+	for token, prices := range p.prices {
+		sort.Slice(prices, func(i, j int) bool {
+			return prices[i].time < prices[j].time
+		})
+		p.prices[token] = prices
+	}
+}
+
+func (p *CapProvider_Replay) Stop() {}
+
+// AddPrice price为1个token可以兑换的eth数量,需要在Start之前添加
+func (p *CapProvider_Replay) AddPrice(tokenAddress common.Address, time int64, priceInEth *big.Rat) {
+	p.prices[tokenAddress] = append(p.prices[tokenAddress], replayPrice{time: time, price: priceInEth})
+}
+
+func (p *CapProvider_Replay) SetTime(time int64) {
+	p.now = time
+}
+
+func (p *CapProvider_Replay) LegalCurrencyValue(tokenAddress common.Address, amount *big.Rat) (*big.Rat, error) {
+	return p.LegalCurrencyValueByCurrency(tokenAddress, amount, p.currency)
+}
+
+func (p *CapProvider_Replay) LegalCurrencyValueOfEth(amount *big.Rat) (*big.Rat, error) {
+	return p.LegalCurrencyValueByCurrency(util.AllTokens["WETH"].Protocol, amount, p.currency)
+}
+
+func (p *CapProvider_Replay) LegalCurrencyValueByCurrency(tokenAddress common.Address, amount *big.Rat, currencyStr string) (*big.Rat, error) {
+	token, err := util.AddressToToken(tokenAddress)
+	if nil != err {
+		return nil, err
+	}
+	price, err := p.GetMarketCapByCurrency(tokenAddress, currencyStr)
+	if nil != err {
+		return nil, err
+	}
+	v := new(big.Rat).SetInt(token.Decimals)
+	v.Quo(amount, v)
+	return v.Mul(v, price), nil
+}
+
+func (p *CapProvider_Replay) GetMarketCap(tokenAddress common.Address) (*big.Rat, error) {
+	return p.GetMarketCapByCurrency(tokenAddress, p.currency)
+}
+
+func (p *CapProvider_Replay) GetEthCap() (*big.Rat, error) {
+	return new(big.Rat).Set(p.ethPrice), nil
+}
+
+func (p *CapProvider_Replay) GetMarketCapByCurrency(tokenAddress common.Address, currencyStr string) (*big.Rat, error) {
+	if StringToLegalCurrency(currencyStr) != StringToLegalCurrency(p.currency) {
+		return nil, errors.New("replay cap only support currency:" + p.currency)
+	}
+	if tokenAddress == util.AllTokens["WETH"].Protocol {
+		return new(big.Rat).Set(p.ethPrice), nil
+	}
+
+	prices := p.prices[tokenAddress]
+	idx := sort.Search(len(prices), func(i int) bool {
+		return prices[i].time > p.now
+	})
+	if len(prices) == 0 {
+		return nil, errors.New("no price for token:" + tokenAddress.Hex())
+	}
+	// 回测开始时还没有成交价格的使用第一个价格
+	if idx > 0 {
+		idx -= 1
+	}
+	return new(big.Rat).Mul(prices[idx].price, p.ethPrice), nil
+}
@@ -0,0 +1,324 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// RinghashCommitter commit-reveal提交环路:
+// 1.queued的环路按protocol凑批,通过submitRinghash/batchSubmitRinghash登记ringhash,状态改为registering
+// 2.ordermanager保存的RinghashSubmitted事件经过ConfirmBlocks个块之后,再提交完整环路
+// 3.登记交易失败或者超过RegisterTtlBlocks仍未确认,环路记为失败
+// ringminer使用feeReceipt,合约在submitRing时根据feeRecipient检查预留
+type RinghashCommitter struct {
+	submitter *RingSubmitter
+
+	batchSize         int
+	batchInterval     time.Duration
+	confirmBlocks     int64
+	registerTtlBlocks int64
+
+	mtx      sync.Mutex
+	queued   []*commitItem
+	batches  []*registerBatch
+	stopChan chan bool
+}
+
+type commitItem struct {
+	daoInfo   *dao.RingSubmitInfo
+	ringState *types.RingSubmitInfo
+	uniqueId  common.Hash
+}
+
+type registerBatch struct {
+	items     []*commitItem
+	txHash    common.Hash
+	sentBlock int64
+}
+
+func NewRinghashCommitter(submitter *RingSubmitter, options config.CommitReveal) *RinghashCommitter {
+	committer := &RinghashCommitter{}
+	committer.submitter = submitter
+	committer.batchSize = options.BatchSize
+	if committer.batchSize <= 0 {
+		committer.batchSize = 10
+	}
+	committer.batchInterval = time.Duration(options.BatchInterval) * time.Millisecond
+	if committer.batchInterval <= 0 {
+		committer.batchInterval = 2 * time.Second
+	}
+	committer.confirmBlocks = options.ConfirmBlocks
+	if committer.confirmBlocks <= 0 {
+		committer.confirmBlocks = 1
+	}
+	committer.registerTtlBlocks = options.RegisterTtlBlocks
+	if committer.registerTtlBlocks <= 0 {
+		committer.registerTtlBlocks = 20
+	}
+	committer.queued = []*commitItem{}
+	committer.batches = []*registerBatch{}
+	return committer
+}
+
+func (committer *RinghashCommitter) Start() {
+	committer.stopChan = make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-time.After(committer.batchInterval):
+				committer.flush(false)
+			case <-committer.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+func (committer *RinghashCommitter) Stop() {
+	close(committer.stopChan)
+}
+
+// add 环路已经保存到outbox,凑够batchSize之后立即发送登记交易
+func (committer *RinghashCommitter) add(daoInfo *dao.RingSubmitInfo, ringState *types.RingSubmitInfo, uniqueId common.Hash) {
+	committer.mtx.Lock()
+	committer.queued = append(committer.queued, &commitItem{daoInfo: daoInfo, ringState: ringState, uniqueId: uniqueId})
+	full := len(committer.queued) >= committer.batchSize
+	committer.mtx.Unlock()
+
+	if full {
+		committer.flush(true)
+	}
+}
+
+// resume 重启之后registering的环路,已经登记的等待确认,未登记的重新登记
+func (committer *RinghashCommitter) resume(daoInfo *dao.RingSubmitInfo, ringState *types.RingSubmitInfo, uniqueId common.Hash) {
+	item := &commitItem{daoInfo: daoInfo, ringState: ringState, uniqueId: uniqueId}
+	if _, registered := committer.registeredBlocks([]*commitItem{item})[ringState.Ringhash]; registered {
+		committer.mtx.Lock()
+		committer.batches = append(committer.batches, &registerBatch{items: []*commitItem{item}, txHash: types.NilHash, sentBlock: committer.submitter.currentBlockNumber})
+		committer.mtx.Unlock()
+	} else {
+		committer.add(daoInfo, ringState, uniqueId)
+	}
+}
+
+func (committer *RinghashCommitter) flush(onlyFull bool) {
+	committer.mtx.Lock()
+	if len(committer.queued) <= 0 || (onlyFull && len(committer.queued) < committer.batchSize) {
+		committer.mtx.Unlock()
+		return
+	}
+	size := committer.batchSize
+	if len(committer.queued) < size {
+		size = len(committer.queued)
+	}
+	items := committer.queued[:size]
+	committer.queued = committer.queued[size:]
+	committer.mtx.Unlock()
+
+	// 不同版本的protocol对应不同的registry
+	groups := make(map[common.Address][]*commitItem)
+	for _, item := range items {
+		groups[item.ringState.ProtocolAddress] = append(groups[item.ringState.ProtocolAddress], item)
+	}
+	for protocol, group := range groups {
+		committer.register(protocol, group)
+	}
+}
+
+func (committer *RinghashCommitter) register(protocol common.Address, items []*commitItem) {
+	impl, ok := ethaccessor.ProtocolAddresses()[protocol]
+	if !ok || types.IsZeroAddress(impl.RinghashRegistryAddress) {
+		log.Infof("Miner committer,protocol:%s hasn't ringhash registry, submit rings directly", protocol.Hex())
+		for _, item := range items {
+			committer.submitter.sendQueued(item.daoInfo, item.ringState, item.uniqueId)
+		}
+		return
+	}
+
+	txHash, err := committer.sendRegisterTransaction(impl.RinghashRegistryAddress, items)
+	if nil != err {
+		log.Errorf("Miner committer,register %d ringhashes err:%s", len(items), err.Error())
+		committer.fail(items, err)
+		return
+	}
+
+	log.Debugf("Miner committer,register %d ringhashes, tx:%s", len(items), txHash.Hex())
+	for _, item := range items {
+		item.daoInfo.SubmitStatus = int(types.RING_SUBMIT_REGISTERING)
+		if err := committer.submitter.dbService.Save(item.daoInfo); nil != err {
+			log.Errorf("Miner committer,update ring:%s err:%s", item.ringState.Ringhash.Hex(), err.Error())
+		}
+	}
+	committer.mtx.Lock()
+	committer.batches = append(committer.batches, &registerBatch{items: items, txHash: txHash, sentBlock: committer.submitter.currentBlockNumber})
+	committer.mtx.Unlock()
+}
+
+func (committer *RinghashCommitter) sendRegisterTransaction(registry common.Address, items []*commitItem) (common.Hash, error) {
+	submitter := committer.submitter
+	ringhashes := []common.Hash{}
+	gasPrice := big.NewInt(0)
+	for _, item := range items {
+		ringhashes = append(ringhashes, item.ringState.Ringhash)
+		if nil != item.ringState.ProtocolGasPrice && item.ringState.ProtocolGasPrice.Cmp(gasPrice) > 0 {
+			gasPrice.Set(item.ringState.ProtocolGasPrice)
+		}
+	}
+
+	data, err := ethaccessor.GenerateSubmitRinghashInputsData(submitter.feeReceipt, ringhashes, ethaccessor.RinghashRegistryAbi())
+	if nil != err {
+		return types.NilHash, err
+	}
+
+	sender, err := submitter.selectSenderAddress()
+	if nil != err {
+		return types.NilHash, err
+	}
+
+	gas, err := ethaccessor.EstimateTransactionGas(sender, registry, nil, data, "pending")
+	if nil != err {
+		return types.NilHash, err
+	}
+	// 与submitRing相同,估算值超过maxGasLimit时不发送,加上gasMarginPercentage后限制在minGasLimit与maxGasLimit之间
+	if submitter.maxGasLimit.Sign() > 0 && gas.Cmp(submitter.maxGasLimit) > 0 {
+		return types.NilHash, fmt.Errorf("estimated register gas:%s exceeds max gas limit:%s", gas.String(), submitter.maxGasLimit.String())
+	}
+	gas.Mul(gas, big.NewInt(100+submitter.gasMarginPercentage))
+	gas.Div(gas, big.NewInt(100))
+	if submitter.maxGasLimit.Sign() > 0 && gas.Cmp(submitter.maxGasLimit) > 0 {
+		gas.Set(submitter.maxGasLimit)
+	}
+	if submitter.minGasLimit.Sign() > 0 && gas.Cmp(submitter.minGasLimit) < 0 {
+		gas.Set(submitter.minGasLimit)
+	}
+
+	nonce, err := submitter.nonceManager.Allocate(sender)
+	if nil != err {
+		return types.NilHash, err
+	}
+	txHash, err := ethaccessor.SendTransactionWithNonce(sender, registry, nonce, gas, gasPrice, nil, data)
+	if nil != err {
+		submitter.nonceManager.Release(sender, nonce, err)
+		submitter.senderMonitor.RecordFailure(sender, err)
+		return types.NilHash, err
+	}
+	submitter.nonceManager.Confirm(sender, nonce)
+	return common.HexToHash(txHash), nil
+}
+
+// check 每个新块检查登记结果,确认之后提交环路
+func (committer *RinghashCommitter) check(blockNumber int64) {
+	committer.mtx.Lock()
+	batches := committer.batches
+	committer.batches = []*registerBatch{}
+	committer.mtx.Unlock()
+
+	remained := []*registerBatch{}
+	for _, batch := range batches {
+		// 启动之后还没有收到新块时发送的登记,从第一个块开始计算超时
+		if batch.sentBlock <= 0 {
+			batch.sentBlock = blockNumber
+		}
+		registered := committer.registeredBlocks(batch.items)
+		waiting := []*commitItem{}
+		for _, item := range batch.items {
+			if registeredBlock, ok := registered[item.ringState.Ringhash]; ok {
+				if blockNumber-registeredBlock >= committer.confirmBlocks {
+					committer.submitter.sendQueued(item.daoInfo, item.ringState, item.uniqueId)
+				} else {
+					waiting = append(waiting, item)
+				}
+			} else {
+				waiting = append(waiting, item)
+			}
+		}
+		if len(waiting) <= 0 {
+			continue
+		}
+		batch.items = waiting
+
+		if blockNumber-batch.sentBlock > committer.registerTtlBlocks {
+			committer.fail(waiting, errors.New("ringhash registration timeout"))
+		} else if committer.registerFailed(batch) {
+			committer.fail(waiting, errors.New("ringhash registration failed"))
+		} else {
+			remained = append(remained, batch)
+		}
+	}
+
+	committer.mtx.Lock()
+	committer.batches = append(committer.batches, remained...)
+	committer.mtx.Unlock()
+}
+
+// registeredBlocks 由feeReceipt登记的ringhash -> 登记的块号
+func (committer *RinghashCommitter) registeredBlocks(items []*commitItem) map[common.Hash]int64 {
+	registered := make(map[common.Hash]int64)
+	ringhashes := []string{}
+	for _, item := range items {
+		ringhashes = append(ringhashes, item.ringState.Ringhash.Hex())
+	}
+	events, err := committer.submitter.dbService.GetRinghashSubmittedByHashes(ringhashes)
+	if nil != err {
+		log.Errorf("Miner committer,get ringhash submitted events err:%s", err.Error())
+		return registered
+	}
+	for _, evt := range events {
+		if common.HexToAddress(evt.RingMiner) == committer.submitter.feeReceipt {
+			registered[common.HexToHash(evt.RingHash)] = evt.BlockNumber
+		}
+	}
+	return registered
+}
+
+// registerFailed 登记交易已经打包但是没有产生RinghashSubmitted事件
+func (committer *RinghashCommitter) registerFailed(batch *registerBatch) bool {
+	if types.IsZeroHash(batch.txHash) {
+		return false
+	}
+	var receipt ethaccessor.TransactionReceipt
+	if err := ethaccessor.GetTransactionReceipt(&receipt, batch.txHash.Hex(), "latest"); nil != err || "" == receipt.TransactionHash {
+		return false
+	}
+	return receipt.HasNoLog()
+}
+
+func (committer *RinghashCommitter) fail(items []*commitItem, err error) {
+	for _, item := range items {
+		log.Errorf("Miner committer,ring:%s err:%s", item.ringState.Ringhash.Hex(), err.Error())
+		item.daoInfo.SubmitStatus = int(types.RING_SUBMIT_FAILED)
+		item.daoInfo.Err = err.Error()
+		if err1 := committer.submitter.dbService.Save(item.daoInfo); nil != err1 {
+			log.Errorf("Miner committer,update ring:%s err:%s", item.ringState.Ringhash.Hex(), err1.Error())
+		}
+		committer.submitter.submitResult(item.ringState.Ringhash, item.uniqueId, types.NilHash, types.TX_STATUS_FAILED, big.NewInt(0), big.NewInt(0), big.NewInt(0), err)
+	}
+}
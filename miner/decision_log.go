@@ -0,0 +1,98 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"strings"
+	"sync"
+)
+
+const (
+	DECISION_CANDIDATE = "candidate" // 收益为正,进入候选列表的环路
+	DECISION_REJECTED  = "rejected"  // 被丢弃的环路,reason为丢弃原因
+	DECISION_EXCLUDED  = "excluded"  // 提交失败次数过多,本轮不参与撮合的订单
+	DECISION_SUBMITTED = "submitted" // 发送给submitter的环路
+)
+
+const defaultDecisionLogSize = 1000
+
+// Decision 撮合过程中的一次决定,金额均为法币
+type Decision struct {
+	Time      int64    `json:"time"`
+	Round     string   `json:"round"`
+	Market    string   `json:"market"`
+	Type      string   `json:"type"`
+	Orders    []string `json:"orders"`
+	Ringhash  string   `json:"ringhash,omitempty"`
+	Received  string   `json:"received,omitempty"`
+	LegalCost string   `json:"legalCost,omitempty"`
+	Cvs       string   `json:"cvs,omitempty"`
+	Reason    string   `json:"reason,omitempty"`
+}
+
+// DecisionLog 只保留最近size条决定,超出之后覆盖最早的记录
+type DecisionLog struct {
+	mtx     sync.RWMutex
+	entries []Decision
+	next    int
+	full    bool
+}
+
+func NewDecisionLog(size int) *DecisionLog {
+	if size <= 0 {
+		size = defaultDecisionLogSize
+	}
+	return &DecisionLog{entries: make([]Decision, size)}
+}
+
+func (l *DecisionLog) Add(decision Decision) {
+	l.mtx.Lock()
+	defer l.mtx.Unlock()
+
+	l.entries[l.next] = decision
+	l.next++
+	if l.next >= len(l.entries) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// Query 按时间倒序返回,market与decisionType为空时不过滤
+func (l *DecisionLog) Query(market, decisionType string, limit int) []Decision {
+	l.mtx.RLock()
+	defer l.mtx.RUnlock()
+
+	count := l.next
+	if l.full {
+		count = len(l.entries)
+	}
+	res := []Decision{}
+	for i := 0; i < count && (limit <= 0 || len(res) < limit); i++ {
+		idx := (l.next - 1 - i + len(l.entries)) % len(l.entries)
+		decision := l.entries[idx]
+		if "" != market && !strings.EqualFold(market, decision.Market) {
+			continue
+		}
+		if "" != decisionType && decisionType != decision.Type {
+			continue
+		}
+		res = append(res, decision)
+	}
+	return res
+}
@@ -40,6 +40,7 @@ type Evaluator struct {
 
 	minGasPrice, maxGasPrice *big.Int
 	feeReceipt               common.Address
+	gasPriceEstimator        func(minGasPrice, maxGasPrice *big.Int) *big.Int
 
 	matcher Matcher
 }
@@ -174,13 +175,14 @@ func (e *Evaluator) ComputeRing(ringState *types.Ring) error {
 	if nil != err {
 		return err
 	} else {
+		ringState.Cvs = cvs
 		if cvs.Int64() <= e.rateRatioCVSThreshold {
 			return nil
 		} else {
 			for _, o := range ringState.Orders {
 				log.Debugf("cvs bigger than RateRatioCVSThreshold orderhash:%s", o.OrderState.RawOrder.Hash.Hex())
 			}
-			return errors.New("Miner,cvs must less than RateRatioCVSThreshold")
+			return fmt.Errorf("Miner,cvs:%s must less than RateRatioCVSThreshold:%d", cvs.String(), e.rateRatioCVSThreshold)
 		}
 	}
 
@@ -368,7 +370,7 @@ func (e *Evaluator) getLegalCurrency(tokenAddress common.Address, amount *big.Ra
 
 func (e *Evaluator) evaluateReceived(ringState *types.Ring) {
 	ringState.Received = big.NewRat(int64(0), int64(1))
-	ringState.GasPrice = ethaccessor.EstimateGasPrice(e.minGasPrice, e.maxGasPrice)
+	ringState.GasPrice = e.gasPriceEstimator(e.minGasPrice, e.maxGasPrice)
 	//log.Debugf("len(ringState.Orders):%d", len(ringState.Orders))
 	ringState.Gas = new(big.Int)
 	ringState.Gas.Set(e.gasUsedWithLength[len(ringState.Orders)])
@@ -404,9 +406,15 @@ func NewEvaluator(marketCapProvider marketcap.MarketCapProvider, minerOptions co
 	e.walletSplit.SetFloat64(minerOptions.WalletSplit)
 	e.minGasPrice = big.NewInt(minerOptions.MinGasLimit)
 	e.maxGasPrice = big.NewInt(minerOptions.MaxGasLimit)
+	e.gasPriceEstimator = ethaccessor.EstimateGasPrice
 	return e
 }
 
 func (e *Evaluator) SetMatcher(matcher Matcher) {
 	e.matcher = matcher
 }
+
+// SetGasPriceEstimator 默认使用ethaccessor的gasPrice,回测时替换为历史gasPrice
+func (e *Evaluator) SetGasPriceEstimator(estimator func(minGasPrice, maxGasPrice *big.Int) *big.Int) {
+	e.gasPriceEstimator = estimator
+}
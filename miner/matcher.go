@@ -28,3 +28,29 @@ type Matcher interface {
 	Stop()
 	GetAccountAvailableAmount(address, tokenAddress, spender common.Address) (*big.Rat, error)
 }
+
+// MatcherInspector matcher实现之后可以通过miner_ rpc查询撮合状态
+type MatcherInspector interface {
+	MarketStates() []MarketState
+	DecisionLog() *DecisionLog
+	InFlightRings() ([]InFlightRing, error)
+	OrderWaitMetrics() *OrderWaitMetrics
+}
+
+// MarketState market最近一轮撮合加载的订单
+type MarketState struct {
+	Market        string `json:"market"`
+	Protocol      string `json:"protocol"`
+	RoundNumber   string `json:"roundNumber"`
+	AtoBOrders    int    `json:"atoBOrders"`
+	BtoAOrders    int    `json:"btoAOrders"`
+	LastMatchTime int64  `json:"lastMatchTime"`
+	Leased        bool   `json:"leased"`
+}
+
+// InFlightRing 已经提交但还没有被打包或确认失败的环路,订单的成交量在撮合时会被扣除
+type InFlightRing struct {
+	Ringhash string   `json:"ringhash"`
+	UniqueId string   `json:"uniqueId"`
+	Orders   []string `json:"orders"`
+}
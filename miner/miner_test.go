@@ -95,11 +95,11 @@ func TestMatch(t *testing.T) {
 	ethaccessor.IncludeGasPriceEvaluator()
 
 	marketCapProvider := marketcap.NewMarketCapProvider(cfg.MarketCap)
-	om := ordermanager.NewOrderManager(&cfg.OrderManager, rdsService, userManager, marketCapProvider)
+	om := ordermanager.NewOrderManager(&cfg.OrderManager, rdsService, userManager, marketCapProvider, &accountManager)
 	submitter, _ := miner.NewSubmitter(cfg.Miner, rdsService, marketCapProvider)
 	evaluator := miner.NewEvaluator(marketCapProvider, cfg.Miner)
 	rds := test.GenerateDaoService()
-	matcher := timing_matcher.NewTimingMatcher(cfg.Miner.TimingMatcher, submitter, evaluator, om, &accountManager, rds)
+	matcher := timing_matcher.NewTimingMatcher(cfg.Miner, submitter, evaluator, om, &accountManager, rds)
 	evaluator.SetMatcher(matcher)
 
 	m := miner.NewMiner(submitter, matcher, evaluator, marketCapProvider)
@@ -0,0 +1,347 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"errors"
+	"github.com/Loopring/relay/cache"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	NonceNextPrefix      = "miner_nonce_next_"      // 下一个可分配的nonce
+	NonceReleasedPrefix  = "miner_nonce_released_"  // 发送失败归还的nonce,zset,score为nonce
+	NonceAllocatedPrefix = "miner_nonce_allocated_" // 已分配还未确认发送成功的nonce,hash,value为分配或归还的时间
+	NonceSentPrefix      = "miner_nonce_sent_"      // 已经发送到节点的nonce,hash,value为发送时间,可能排在空缺之后而未计入pending nonce
+)
+
+// NonceManager 在redis中为sender分配nonce,多个miner实例共享同一个sender时也不会冲突
+// 定时与eth_getTransactionCount对比,归还的以及分配后长时间未确认发送的nonce通过发送0值交易给自己填补
+type NonceManager struct {
+	senders    []*NormalSenderAddress
+	gapTimeout int64
+	stopChan   chan bool
+}
+
+func NewNonceManager(senders []*NormalSenderAddress, gapTimeout int64) *NonceManager {
+	manager := &NonceManager{}
+	manager.senders = senders
+	if gapTimeout > 0 {
+		manager.gapTimeout = gapTimeout
+	} else {
+		manager.gapTimeout = 60
+	}
+	return manager
+}
+
+func (manager *NonceManager) Start() {
+	manager.stopChan = make(chan bool)
+	for _, sender := range manager.senders {
+		if err := manager.Sync(sender.Address); nil != err {
+			log.Errorf("nonce manager,sync nonce of %s err:%s", sender.Address.Hex(), err.Error())
+		}
+	}
+	go func() {
+		for {
+			select {
+			case <-time.After(10 * time.Second):
+				for _, sender := range manager.senders {
+					if err := manager.Sync(sender.Address); nil != err {
+						log.Errorf("nonce manager,sync nonce of %s err:%s", sender.Address.Hex(), err.Error())
+						continue
+					}
+					manager.fillGaps(sender)
+				}
+			case <-manager.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+func (manager *NonceManager) Stop() {
+	if nil != manager.stopChan {
+		manager.stopChan <- true
+		close(manager.stopChan)
+	}
+}
+
+// Allocate 优先使用归还的最小nonce,没有时分配新的nonce
+func (manager *NonceManager) Allocate(address common.Address) (*big.Int, error) {
+	nonce, err := manager.popReleased(address)
+	if nil != err {
+		return nil, err
+	}
+
+	if nil == nonce {
+		if exists, err := cache.Exists(nonceNextKey(address)); nil != err {
+			return nil, err
+		} else if !exists {
+			if err := manager.Sync(address); nil != err {
+				return nil, err
+			}
+		}
+		next, err := cache.IncrBy(nonceNextKey(address), 1)
+		if nil != err {
+			return nil, err
+		}
+		nonce = big.NewInt(next - 1)
+	}
+
+	if err := cache.HMSet(nonceAllocatedKey(address), int64(0), []byte(nonce.String()), []byte(strconv.FormatInt(time.Now().Unix(), 10))); nil != err {
+		log.Errorf("nonce manager,mark nonce:%s of %s allocated err:%s", nonce.String(), address.Hex(), err.Error())
+	}
+	return nonce, nil
+}
+
+// Confirm 交易已经发送到节点,记录到sent中,填补空缺时跳过
+func (manager *NonceManager) Confirm(address common.Address, nonce *big.Int) {
+	if err := cache.HMSet(nonceSentKey(address), int64(0), []byte(nonce.String()), []byte(strconv.FormatInt(time.Now().Unix(), 10))); nil != err {
+		log.Errorf("nonce manager,mark nonce:%s of %s sent err:%s", nonce.String(), address.Hex(), err.Error())
+	}
+	if _, err := cache.HDel(nonceAllocatedKey(address), []byte(nonce.String())); nil != err {
+		log.Errorf("nonce manager,confirm nonce:%s of %s err:%s", nonce.String(), address.Hex(), err.Error())
+	}
+}
+
+// Release 交易发送失败,nonce可以再次分配;nonce too low说明已经被使用,此时重新同步
+func (manager *NonceManager) Release(address common.Address, nonce *big.Int, sendErr error) {
+	if nil != sendErr && strings.Contains(sendErr.Error(), "nonce too low") {
+		manager.Confirm(address, nonce)
+		if err := manager.Sync(address); nil != err {
+			log.Errorf("nonce manager,sync nonce of %s err:%s", address.Hex(), err.Error())
+		}
+		return
+	}
+
+	if err := cache.ZAdd(nonceReleasedKey(address), int64(0), []byte(nonce.String()), []byte(nonce.String())); nil != err {
+		log.Errorf("nonce manager,release nonce:%s of %s err:%s", nonce.String(), address.Hex(), err.Error())
+		return
+	}
+	cache.HMSet(nonceAllocatedKey(address), int64(0), []byte(nonce.String()), []byte(strconv.FormatInt(time.Now().Unix(), 10)))
+}
+
+// Sync 链上pending nonce大于redis中的值时(其他程序使用了该地址或者redis数据丢失),以链上为准
+func (manager *NonceManager) Sync(address common.Address) error {
+	pendingNonce, err := manager.pendingNonce(address)
+	if nil != err {
+		return err
+	}
+
+	if exists, err := cache.Exists(nonceNextKey(address)); nil != err {
+		return err
+	} else if !exists {
+		return cache.Set(nonceNextKey(address), []byte(strconv.FormatInt(pendingNonce, 10)), int64(0))
+	}
+
+	next, err := manager.nextNonce(address)
+	if nil != err {
+		return err
+	}
+	if pendingNonce > next {
+		log.Infof("nonce manager,nonce of %s in redis:%d less than pending nonce:%d", address.Hex(), next, pendingNonce)
+		if _, err := cache.IncrBy(nonceNextKey(address), pendingNonce-next); nil != err {
+			return err
+		}
+	}
+
+	// 已经上链或者进入交易池的nonce不能再次分配
+	if _, err := cache.ZRemRangeByScore(nonceReleasedKey(address), int64(0), pendingNonce-1); nil != err {
+		return err
+	}
+	for _, key := range []string{nonceAllocatedKey(address), nonceSentKey(address)} {
+		if data, err := cache.HGetAll(key); nil == err {
+			for i := 0; i+1 < len(data); i += 2 {
+				if nonce, err := strconv.ParseInt(string(data[i]), 10, 64); nil == err && nonce < pendingNonce {
+					cache.HDel(key, data[i])
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// fillGaps [pendingNonce, next)之间的空缺会阻塞后面的交易,发送0值交易填补
+func (manager *NonceManager) fillGaps(sender *NormalSenderAddress) {
+	address := sender.Address
+	pendingNonce, err := manager.pendingNonce(address)
+	if nil != err {
+		log.Errorf("nonce manager,get pending nonce of %s err:%s", address.Hex(), err.Error())
+		return
+	}
+	next, err := manager.nextNonce(address)
+	if nil != err {
+		log.Errorf("nonce manager,get next nonce of %s err:%s", address.Hex(), err.Error())
+		return
+	}
+	if pendingNonce >= next {
+		return
+	}
+
+	allocated, err := nonceTimes(nonceAllocatedKey(address))
+	if nil != err {
+		log.Errorf("nonce manager,get allocated nonces of %s err:%s", address.Hex(), err.Error())
+		return
+	}
+	sent, err := nonceTimes(nonceSentKey(address))
+	if nil != err {
+		log.Errorf("nonce manager,get sent nonces of %s err:%s", address.Hex(), err.Error())
+		return
+	}
+	released, err := manager.releasedNonces(address)
+	if nil != err {
+		log.Errorf("nonce manager,get released nonces of %s err:%s", address.Hex(), err.Error())
+		return
+	}
+
+	for _, nonce := range gapNonces(pendingNonce, next, allocated, sent, released, time.Now().Unix(), manager.gapTimeout) {
+		field := []byte(strconv.FormatInt(nonce, 10))
+		// 同一个nonce可能正在被其他实例分配或者填补,只有成功移除的一方可以使用
+		var removed int64
+		if released[nonce] {
+			removed, err = cache.ZRem(nonceReleasedKey(address), field)
+		} else {
+			removed, err = cache.HDel(nonceAllocatedKey(address), field)
+		}
+		if nil != err || removed <= 0 {
+			continue
+		}
+
+		gasPrice := ethaccessor.EstimateGasPrice(nil, sender.GasPriceLimit)
+		txHash, err := ethaccessor.SendTransactionWithNonce(address, address, big.NewInt(nonce), big.NewInt(cancelTxGas), gasPrice, big.NewInt(0), nil)
+		if nil != err {
+			log.Errorf("nonce manager,fill nonce gap:%d of %s err:%s", nonce, address.Hex(), err.Error())
+			manager.Release(address, big.NewInt(nonce), err)
+			continue
+		}
+		manager.Confirm(address, big.NewInt(nonce))
+		log.Infof("nonce manager,nonce gap:%d of %s filled by tx:%s", nonce, address.Hex(), txHash)
+	}
+}
+
+// gapNonces 需要填补的nonce:归还后没有再被分配的,以及分配后超过gapTimeout仍未确认发送的(例如发送前进程退出)。
+// 已经发送的nonce排在空缺之后时同样不计入pending nonce,不能填补,否则会替换掉真正的环路交易
+func gapNonces(pendingNonce, next int64, allocated, sent map[int64]int64, released map[int64]bool, nowTime, gapTimeout int64) []int64 {
+	var gaps []int64
+	for nonce := pendingNonce; nonce < next; nonce++ {
+		if _, ok := sent[nonce]; ok {
+			continue
+		}
+		if released[nonce] {
+			gaps = append(gaps, nonce)
+			continue
+		}
+		if allocatedTime, ok := allocated[nonce]; ok && nowTime-allocatedTime >= gapTimeout {
+			gaps = append(gaps, nonce)
+		}
+	}
+	return gaps
+}
+
+func nonceTimes(key string) (map[int64]int64, error) {
+	data, err := cache.HGetAll(key)
+	if nil != err {
+		return nil, err
+	}
+	times := make(map[int64]int64)
+	for i := 0; i+1 < len(data); i += 2 {
+		nonce, err := strconv.ParseInt(string(data[i]), 10, 64)
+		if nil != err {
+			continue
+		}
+		if t, err := strconv.ParseInt(string(data[i+1]), 10, 64); nil == err {
+			times[nonce] = t
+		}
+	}
+	return times, nil
+}
+
+func (manager *NonceManager) popReleased(address common.Address) (*big.Int, error) {
+	for {
+		members, err := cache.ZRange(nonceReleasedKey(address), int64(0), int64(0), false)
+		if nil != err {
+			return nil, err
+		}
+		if len(members) <= 0 || len(members[0]) <= 0 {
+			return nil, nil
+		}
+		if removed, err := cache.ZRem(nonceReleasedKey(address), members[0]); nil != err {
+			return nil, err
+		} else if removed > 0 {
+			nonce, ok := new(big.Int).SetString(string(members[0]), 10)
+			if !ok {
+				return nil, errors.New("invalid released nonce:" + string(members[0]))
+			}
+			return nonce, nil
+		}
+	}
+}
+
+func (manager *NonceManager) releasedNonces(address common.Address) (map[int64]bool, error) {
+	members, err := cache.ZRange(nonceReleasedKey(address), int64(0), int64(-1), false)
+	if nil != err {
+		return nil, err
+	}
+	released := make(map[int64]bool)
+	for _, member := range members {
+		if nonce, err := strconv.ParseInt(string(member), 10, 64); nil == err {
+			released[nonce] = true
+		}
+	}
+	return released, nil
+}
+
+func (manager *NonceManager) nextNonce(address common.Address) (int64, error) {
+	data, err := cache.Get(nonceNextKey(address))
+	if nil != err {
+		return 0, err
+	}
+	return strconv.ParseInt(string(data), 10, 64)
+}
+
+func (manager *NonceManager) pendingNonce(address common.Address) (int64, error) {
+	var nonce types.Big
+	if err := ethaccessor.GetTransactionCount(&nonce, address, "pending"); nil != err {
+		return 0, err
+	}
+	return nonce.Int64(), nil
+}
+
+func nonceNextKey(address common.Address) string {
+	return NonceNextPrefix + strings.ToLower(address.Hex())
+}
+
+func nonceReleasedKey(address common.Address) string {
+	return NonceReleasedPrefix + strings.ToLower(address.Hex())
+}
+
+func nonceAllocatedKey(address common.Address) string {
+	return NonceAllocatedPrefix + strings.ToLower(address.Hex())
+}
+
+func nonceSentKey(address common.Address) string {
+	return NonceSentPrefix + strings.ToLower(address.Hex())
+}
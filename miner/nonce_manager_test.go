@@ -0,0 +1,54 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestGapNonces_SentNonceBehindGapNotFilled(t *testing.T) {
+	// nonce 10已分配但从未确认发送,11、12已经发送,排在10之后没有计入pending nonce
+	allocated := map[int64]int64{10: 100}
+	sent := map[int64]int64{11: 100, 12: 100}
+
+	if gaps := gapNonces(10, 13, allocated, sent, map[int64]bool{}, 200, 60); !reflect.DeepEqual(gaps, []int64{10}) {
+		t.Fatalf("expect only nonce 10 filled, got:%v", gaps)
+	}
+}
+
+func TestGapNonces(t *testing.T) {
+	allocated := map[int64]int64{
+		10: 100, // 超时未确认
+		11: 180, // 刚分配,还在发送中
+		12: 100, // 归还的nonce同时保留分配时间
+	}
+	released := map[int64]bool{12: true, 14: true}
+	sent := map[int64]int64{13: 100}
+
+	// 15没有任何记录,不填补
+	gaps := gapNonces(10, 16, allocated, sent, released, 200, 60)
+	if !reflect.DeepEqual(gaps, []int64{10, 12, 14}) {
+		t.Fatalf("unexpected gaps:%v", gaps)
+	}
+
+	if gaps := gapNonces(16, 16, allocated, sent, released, 200, 60); len(gaps) != 0 {
+		t.Fatalf("expect no gaps, got:%v", gaps)
+	}
+}
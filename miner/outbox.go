@@ -0,0 +1,132 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+)
+
+// enqueue 发送之前在同一个数据库事务中保存环路以及filledOrder,状态为queued
+func (submitter *RingSubmitter) enqueue(ringState *types.RingSubmitInfo) (*dao.RingSubmitInfo, error) {
+	daoInfo := &dao.RingSubmitInfo{}
+	daoInfo.ConvertDown(ringState, nil)
+	daoInfo.Status = int(types.TX_STATUS_PENDING)
+	daoInfo.SubmitStatus = int(types.RING_SUBMIT_QUEUED)
+
+	err := submitter.dbService.Transaction(func(rds dao.RdsService) error {
+		if err := rds.Add(daoInfo); nil != err {
+			return err
+		}
+		for _, filledOrder := range ringState.RawRing.Orders {
+			daoOrder := &dao.FilledOrder{}
+			daoOrder.ConvertDown(filledOrder, ringState.Ringhash)
+			if err := rds.Add(daoOrder); nil != err {
+				return fmt.Errorf("insert filled order:%s err:%s", filledOrder.OrderState.RawOrder.Hash.Hex(), err.Error())
+			}
+		}
+		return nil
+	})
+	if nil != err {
+		return nil, err
+	}
+
+	submitter.profitAccountant.estimate(ringState)
+	return daoInfo, nil
+}
+
+// dispatch 开启commit-reveal时先登记ringhash,否则直接发送
+func (submitter *RingSubmitter) dispatch(daoInfo *dao.RingSubmitInfo, ringState *types.RingSubmitInfo, uniqueId common.Hash) {
+	if nil != submitter.committer {
+		submitter.committer.add(daoInfo, ringState, uniqueId)
+	} else {
+		submitter.sendQueued(daoInfo, ringState, uniqueId)
+	}
+}
+
+// sendQueued 发送outbox中的环路,发送之后更新为sent或者failed
+func (submitter *RingSubmitter) sendQueued(daoInfo *dao.RingSubmitInfo, ringState *types.RingSubmitInfo, uniqueId common.Hash) {
+	txHash, status, err := submitter.submitRing(ringState)
+	ringState.SubmitTxHash = txHash
+
+	daoInfo.ConvertDown(ringState, err)
+	daoInfo.Status = int(status)
+	if nil == err {
+		daoInfo.SubmitStatus = int(types.RING_SUBMIT_SENT)
+	} else {
+		daoInfo.SubmitStatus = int(types.RING_SUBMIT_FAILED)
+	}
+	if err1 := submitter.dbService.Save(daoInfo); nil != err1 {
+		log.Errorf("Miner submitter,update ring:%s err:%s", ringState.Ringhash.Hex(), err1.Error())
+	}
+
+	submitter.submitResult(ringState.Ringhash, uniqueId, txHash, status, big.NewInt(0), big.NewInt(0), big.NewInt(0), err)
+	if nil == err {
+		submitter.pendingTracker.add(ringState, uniqueId, submitter.currentBlockNumber)
+	}
+}
+
+// resumeOutbox 重启之后重新发送queued的环路,sent状态的交易已经不在链上也不在交易池中时记为失败
+// registering的环路交给committer继续等待登记确认,关闭commit-reveal之后直接发送
+func (submitter *RingSubmitter) resumeOutbox() {
+	infos, err := submitter.dbService.GetRingSubmitInfosBySubmitStatus([]types.RingSubmitStatus{types.RING_SUBMIT_QUEUED, types.RING_SUBMIT_REGISTERING, types.RING_SUBMIT_SENT})
+	if nil != err {
+		log.Errorf("Miner submitter,resume outbox err:%s", err.Error())
+		return
+	}
+
+	for idx := range infos {
+		daoInfo := &infos[idx]
+		ringState := &types.RingSubmitInfo{}
+		daoInfo.ConvertUp(ringState)
+		uniqueId := common.HexToHash(daoInfo.UniqueId)
+
+		switch types.RingSubmitStatus(daoInfo.SubmitStatus) {
+		case types.RING_SUBMIT_QUEUED:
+			log.Infof("Miner submitter,resume queued ring:%s", ringState.Ringhash.Hex())
+			submitter.dispatch(daoInfo, ringState, uniqueId)
+		case types.RING_SUBMIT_REGISTERING:
+			log.Infof("Miner submitter,resume registering ring:%s", ringState.Ringhash.Hex())
+			if nil != submitter.committer {
+				submitter.committer.resume(daoInfo, ringState, uniqueId)
+			} else {
+				submitter.sendQueued(daoInfo, ringState, uniqueId)
+			}
+		case types.RING_SUBMIT_SENT:
+			var receipt ethaccessor.TransactionReceipt
+			if err := ethaccessor.GetTransactionReceipt(&receipt, ringState.SubmitTxHash.Hex(), "latest"); nil == err && "" != receipt.TransactionHash {
+				// 已经被打包,由ringmined事件更新结果
+				continue
+			}
+			var tx ethaccessor.Transaction
+			if err := ethaccessor.GetTransactionByHash(&tx, ringState.SubmitTxHash.Hex(), "pending"); nil == err {
+				submitter.pendingTracker.add(ringState, uniqueId, submitter.currentBlockNumber)
+				continue
+			}
+			log.Infof("Miner submitter,ring:%s tx:%s was dropped", ringState.Ringhash.Hex(), ringState.SubmitTxHash.Hex())
+			submitter.submitResult(ringState.Ringhash, uniqueId, ringState.SubmitTxHash, types.TX_STATUS_FAILED, big.NewInt(0), big.NewInt(0), big.NewInt(0), errors.New("transaction dropped"))
+		}
+	}
+}
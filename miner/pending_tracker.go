@@ -0,0 +1,255 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sync"
+)
+
+const cancelTxGas = 21000
+
+// pendingRingTx 同一个nonce上发送过的所有环路交易,txHashes中最后一个为当前有效的交易
+type pendingRingTx struct {
+	submitInfo   *types.RingSubmitInfo
+	uniqueId     common.Hash
+	nonce        *big.Int
+	gasPrice     *big.Int
+	txHashes     []common.Hash
+	cancelled    bool
+	replaceBlock int64
+}
+
+// PendingTxTracker 跟踪normal miner发送的环路交易,pending超过MaxPendingTtl个块之后,
+// 在GasPriceLimit之内提高gasPrice重新发送同一个nonce,环路不再有收益时发送0值交易给自己取消
+type PendingTxTracker struct {
+	mtx            sync.Mutex
+	submitter      *RingSubmitter
+	bumpPercentage int64
+	txs            map[common.Hash]*pendingRingTx
+}
+
+func newPendingTxTracker(submitter *RingSubmitter, bumpPercentage int64) *PendingTxTracker {
+	tracker := &PendingTxTracker{}
+	tracker.submitter = submitter
+	tracker.txs = make(map[common.Hash]*pendingRingTx)
+	if bumpPercentage > 0 {
+		tracker.bumpPercentage = bumpPercentage
+	} else {
+		tracker.bumpPercentage = 10
+	}
+	return tracker
+}
+
+func (tracker *PendingTxTracker) add(submitInfo *types.RingSubmitInfo, uniqueId common.Hash, blockNumber int64) {
+	if nil == tracker.senderOf(submitInfo.Miner) {
+		return
+	}
+
+	var tx ethaccessor.Transaction
+	if err := ethaccessor.GetTransactionByHash(&tx, submitInfo.SubmitTxHash.Hex(), "pending"); nil != err {
+		log.Errorf("pending tracker,get transaction:%s err:%s", submitInfo.SubmitTxHash.Hex(), err.Error())
+		return
+	}
+
+	tracker.mtx.Lock()
+	defer tracker.mtx.Unlock()
+
+	ptx := &pendingRingTx{}
+	ptx.submitInfo = submitInfo
+	ptx.uniqueId = uniqueId
+	ptx.nonce = tx.Nonce.BigInt()
+	ptx.gasPrice = new(big.Int).Set(submitInfo.ProtocolGasPrice)
+	ptx.txHashes = []common.Hash{submitInfo.SubmitTxHash}
+	ptx.replaceBlock = blockNumber
+	tracker.txs[submitInfo.Ringhash] = ptx
+}
+
+// remove 环路已经有了提交结果
+func (tracker *PendingTxTracker) remove(ringhash common.Hash) {
+	tracker.mtx.Lock()
+	defer tracker.mtx.Unlock()
+
+	delete(tracker.txs, ringhash)
+}
+
+func (tracker *PendingTxTracker) senderOf(address common.Address) *NormalSenderAddress {
+	for _, sender := range tracker.submitter.normalMinerAddresses {
+		if sender.Address == address {
+			return sender
+		}
+	}
+	return nil
+}
+
+// check 只在新块事件的goroutine中执行,pendingRingTx的字段只在这里修改
+func (tracker *PendingTxTracker) check(blockNumber int64) {
+	tracker.mtx.Lock()
+	txs := make(map[common.Hash]*pendingRingTx)
+	for ringhash, ptx := range tracker.txs {
+		txs[ringhash] = ptx
+	}
+	tracker.mtx.Unlock()
+
+	for ringhash, ptx := range txs {
+		sender := tracker.senderOf(ptx.submitInfo.Miner)
+		if nil == sender || sender.MaxPendingTtl <= 0 {
+			tracker.remove(ringhash)
+			continue
+		}
+
+		var nonce types.Big
+		if err := ethaccessor.GetTransactionCount(&nonce, sender.Address, "latest"); nil != err {
+			log.Errorf("pending tracker,get nonce of %s err:%s", sender.Address.Hex(), err.Error())
+			continue
+		}
+		if nonce.BigInt().Cmp(ptx.nonce) > 0 {
+			tracker.remove(ringhash)
+			tracker.resolve(ptx)
+			continue
+		}
+
+		if blockNumber-ptx.replaceBlock < int64(sender.MaxPendingTtl) {
+			continue
+		}
+		if err := tracker.replace(ptx, sender); nil != err {
+			log.Errorf("pending tracker,replace ring:%s nonce:%s err:%s", ringhash.Hex(), ptx.nonce.String(), err.Error())
+			continue
+		}
+		ptx.replaceBlock = blockNumber
+	}
+}
+
+func (tracker *PendingTxTracker) replace(ptx *pendingRingTx, sender *NormalSenderAddress) error {
+	gasPrice := new(big.Int).Mul(ptx.gasPrice, big.NewInt(100+tracker.bumpPercentage))
+	gasPrice.Div(gasPrice, big.NewInt(100))
+	if sender.GasPriceLimit.Sign() > 0 && gasPrice.Cmp(sender.GasPriceLimit) > 0 {
+		gasPrice.Set(sender.GasPriceLimit)
+	}
+	if gasPrice.Cmp(ptx.gasPrice) <= 0 {
+		return fmt.Errorf("gasPrice:%s has reached gasPriceLimit", ptx.gasPrice.String())
+	}
+
+	if ptx.cancelled {
+		return tracker.cancel(ptx, gasPrice)
+	}
+
+	info := ptx.submitInfo
+	if reason := tracker.unprofitableReason(info, gasPrice); "" != reason {
+		log.Infof("pending tracker,ring:%s will be cancelled, %s", info.Ringhash.Hex(), reason)
+		ptx.cancelled = true
+		return tracker.cancel(ptx, gasPrice)
+	}
+
+	txHashStr, err := ethaccessor.SendTransactionWithNonce(info.Miner, info.ProtocolAddress, ptx.nonce, info.ProtocolGas, gasPrice, nil, info.ProtocolData)
+	if nil != err {
+		return err
+	}
+	txHash := common.HexToHash(txHashStr)
+	log.Infof("pending tracker,ring:%s nonce:%s replaced by tx:%s, gasPrice:%s->%s", info.Ringhash.Hex(), ptx.nonce.String(), txHashStr, ptx.gasPrice.String(), gasPrice.String())
+
+	// 新交易单独保存一条记录,无论哪一笔被打包都能通过txhash找到对应的环路
+	replaced := *info
+	replaced.ProtocolGasPrice = gasPrice
+	replaced.SubmitTxHash = txHash
+	daoInfo := &dao.RingSubmitInfo{}
+	daoInfo.ConvertDown(&replaced, nil)
+	daoInfo.UniqueId = ptx.uniqueId.Hex()
+	daoInfo.Status = int(types.TX_STATUS_PENDING)
+	daoInfo.SubmitStatus = int(types.RING_SUBMIT_SENT)
+	if err := tracker.submitter.dbService.Add(daoInfo); nil != err {
+		log.Errorf("pending tracker,insert replaced ring err:%s", err.Error())
+	}
+
+	ptx.gasPrice = gasPrice
+	ptx.txHashes = append(ptx.txHashes, txHash)
+	return nil
+}
+
+func (tracker *PendingTxTracker) cancel(ptx *pendingRingTx, gasPrice *big.Int) error {
+	sender := ptx.submitInfo.Miner
+	txHash, err := ethaccessor.SendTransactionWithNonce(sender, sender, ptx.nonce, big.NewInt(cancelTxGas), gasPrice, big.NewInt(0), nil)
+	if nil != err {
+		return err
+	}
+	log.Infof("pending tracker,ring:%s nonce:%s cancelled by tx:%s, gasPrice:%s", ptx.submitInfo.Ringhash.Hex(), ptx.nonce.String(), txHash, gasPrice.String())
+	ptx.gasPrice = gasPrice
+	return nil
+}
+
+// unprofitableReason 订单已经变化导致预执行失败,或者提高gasPrice之后收益小于0
+func (tracker *PendingTxTracker) unprofitableReason(info *types.RingSubmitInfo, gasPrice *big.Int) string {
+	if err := ethaccessor.SimulateTransaction(info.Miner, info.ProtocolAddress, nil, info.ProtocolData, "latest"); nil != err {
+		return "simulation failed:" + err.Error()
+	}
+
+	ring := info.RawRing
+	if nil == ring || nil == ring.LegalCost || nil == ring.Received || nil == info.ProtocolGasPrice || info.ProtocolGasPrice.Sign() <= 0 {
+		return ""
+	}
+	legalFee := new(big.Rat).Add(ring.Received, ring.LegalCost)
+	legalCost := new(big.Rat).Mul(ring.LegalCost, new(big.Rat).SetFrac(gasPrice, info.ProtocolGasPrice))
+	if legalFee.Cmp(legalCost) <= 0 {
+		return fmt.Sprintf("legalFee:%s less than legalCost:%s", legalFee.FloatString(2), legalCost.FloatString(2))
+	}
+	return ""
+}
+
+// resolve nonce已经被使用,没有被打包的交易记录为失败,全部没有被打包时说明环路已被取消
+func (tracker *PendingTxTracker) resolve(ptx *pendingRingTx) {
+	var (
+		minedTxHash     = types.NilHash
+		pendingTxHashes []common.Hash
+	)
+	for _, txHash := range ptx.txHashes {
+		var receipt ethaccessor.TransactionReceipt
+		if err := ethaccessor.GetTransactionReceipt(&receipt, txHash.Hex(), "latest"); nil == err && "" != receipt.TransactionHash {
+			minedTxHash = txHash
+		} else {
+			pendingTxHashes = append(pendingTxHashes, txHash)
+		}
+	}
+
+	info := ptx.submitInfo
+	uniqueId := ptx.uniqueId
+	if types.IsZeroHash(minedTxHash) {
+		last := pendingTxHashes[len(pendingTxHashes)-1]
+		pendingTxHashes = pendingTxHashes[:len(pendingTxHashes)-1]
+		tracker.submitter.submitResult(info.Ringhash, uniqueId, last, types.TX_STATUS_FAILED, big.NewInt(0), big.NewInt(0), big.NewInt(0), errors.New("ring submission cancelled"))
+	}
+	for _, txHash := range pendingTxHashes {
+		resultEvt := &types.RingSubmitResultEvent{
+			RingHash:     info.Ringhash,
+			RingUniqueId: uniqueId,
+			TxHash:       txHash,
+			Status:       types.TX_STATUS_FAILED,
+			Err:          fmt.Errorf("replaced by other transaction with nonce:%s", ptx.nonce.String()),
+		}
+		if err := tracker.submitter.dbService.UpdateRingSubmitInfoResult(resultEvt); nil != err {
+			log.Errorf("err:%s", err.Error())
+		}
+	}
+}
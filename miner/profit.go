@@ -0,0 +1,344 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"encoding/json"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/marketcap"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sort"
+	"time"
+)
+
+const (
+	profitReconcileInterval = 30 * time.Second
+	profitReconcileMaxAge   = 86400 // 打包超过一天仍取不到fill的环路不再补齐
+	profitReconcileBatch    = 100
+)
+
+// ProfitAccountant 记录环路的预估收益,并在打包之后根据fill事件以及实际gas计算实际收益
+type ProfitAccountant struct {
+	dbService         dao.RdsService
+	marketCapProvider marketcap.MarketCapProvider
+	stopChan          chan bool
+}
+
+func NewProfitAccountant(dbService dao.RdsService, marketCapProvider marketcap.MarketCapProvider) *ProfitAccountant {
+	accountant := &ProfitAccountant{}
+	accountant.dbService = dbService
+	accountant.marketCapProvider = marketCapProvider
+	return accountant
+}
+
+func (accountant *ProfitAccountant) Start() {
+	accountant.stopChan = make(chan bool)
+	go func() {
+		for {
+			select {
+			case <-time.After(profitReconcileInterval):
+				accountant.reconcile()
+			case <-accountant.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+func (accountant *ProfitAccountant) Stop() {
+	if nil != accountant.stopChan {
+		close(accountant.stopChan)
+	}
+}
+
+// estimate 环路进入outbox时记录撮合时的预估值
+func (accountant *ProfitAccountant) estimate(ringState *types.RingSubmitInfo) {
+	profit := &dao.RingProfit{}
+	profit.RingHash = ringState.Ringhash.Hex()
+	profit.Miner = ringState.Miner.Hex()
+	profit.Status = uint8(types.TX_STATUS_PENDING)
+	profit.CreateTime = time.Now().Unix()
+	if ring := ringState.RawRing; nil != ring {
+		if len(ring.Orders) > 0 {
+			order := ring.Orders[0].OrderState.RawOrder
+			profit.Market, _ = util.WrapMarketByAddress(order.TokenS.Hex(), order.TokenB.Hex())
+		}
+		profit.EstimatedFee = ratToFloat(ring.LegalFee)
+		profit.EstimatedCost = ratToFloat(ring.LegalCost)
+		profit.EstimatedReceived = ratToFloat(ring.Received)
+	}
+	if err := accountant.dbService.Add(profit); nil != err {
+		log.Errorf("profit accountant,insert ring:%s err:%s", profit.RingHash, err.Error())
+	}
+}
+
+// realize 环路交易被打包之后,gas费用按照打包时的价格计算,收入从fill事件中计算
+func (accountant *ProfitAccountant) realize(ringhash common.Hash, evt *types.RingMinedEvent) {
+	profit, err := accountant.dbService.FindRingProfitByRinghash(ringhash)
+	if nil != err {
+		log.Errorf("profit accountant,ring:%s err:%s", ringhash.Hex(), err.Error())
+		return
+	}
+	if profit.Reconciled {
+		return
+	}
+
+	profit.TxHash = evt.TxHash.Hex()
+	profit.Status = uint8(evt.Status)
+	profit.BlockNumber = evt.BlockNumber.Int64()
+	profit.MinedTime = evt.BlockTime
+	if nil != evt.GasUsed && nil != evt.GasPrice {
+		profit.GasUsed = evt.GasUsed.String()
+		profit.GasPrice = evt.GasPrice.String()
+		costEth := new(big.Rat).SetInt(new(big.Int).Mul(evt.GasUsed, evt.GasPrice))
+		if legalCost, err := accountant.marketCapProvider.LegalCurrencyValueOfEth(costEth); nil == err {
+			profit.RealizedCost = ratToFloat(legalCost)
+		} else {
+			log.Errorf("profit accountant,ring:%s err:%s", ringhash.Hex(), err.Error())
+		}
+	}
+	if types.TX_STATUS_SUCCESS == evt.Status {
+		accountant.snapshotPrices(profit)
+		accountant.realizeFills(profit)
+	} else {
+		profit.Reconciled = true
+	}
+
+	if err := accountant.dbService.Save(profit); nil != err {
+		log.Errorf("profit accountant,update ring:%s err:%s", ringhash.Hex(), err.Error())
+	}
+}
+
+// realizeFills 矿工收入为lrcFee以及分润,需要扣除支付给用户的lrcReward
+func (accountant *ProfitAccountant) realizeFills(profit *dao.RingProfit) {
+	fills, err := accountant.dbService.FindFillsByRingHash(common.HexToHash(profit.RingHash))
+	if nil != err || len(fills) <= 0 {
+		return
+	}
+
+	prices := minedPrices(profit)
+	realizedFee := new(big.Rat)
+	for _, daoFill := range fills {
+		fill := &types.OrderFilledEvent{}
+		daoFill.ConvertUp(fill)
+		impl, exists := ethaccessor.ProtocolAddresses()[fill.Protocol]
+		if !exists {
+			continue
+		}
+		realizedFee.Add(realizedFee, accountant.legalValue(prices, impl.LrcTokenAddress, fill.LrcFee))
+		realizedFee.Sub(realizedFee, accountant.legalValue(prices, impl.LrcTokenAddress, fill.LrcReward))
+		realizedFee.Add(realizedFee, accountant.legalValue(prices, fill.TokenS, fill.SplitS))
+		realizedFee.Add(realizedFee, accountant.legalValue(prices, fill.TokenB, fill.SplitB))
+		if "" == profit.Market {
+			profit.Market = fill.Market
+		}
+	}
+	profit.RealizedFee = ratToFloat(realizedFee)
+	profit.Reconciled = true
+}
+
+// legalValue 优先使用打包时记录的价格,没有记录时使用当前价格
+func (accountant *ProfitAccountant) legalValue(prices map[common.Address]*big.Rat, token common.Address, amount *big.Int) *big.Rat {
+	if nil == amount || amount.Sign() == 0 {
+		return new(big.Rat)
+	}
+	if price, exists := prices[token]; exists {
+		return new(big.Rat).Mul(price, new(big.Rat).SetInt(amount))
+	}
+	v, err := accountant.marketCapProvider.LegalCurrencyValue(token, new(big.Rat).SetInt(amount))
+	if nil != err {
+		log.Errorf("profit accountant,token:%s err:%s", token.Hex(), err.Error())
+		return new(big.Rat)
+	}
+	return v
+}
+
+// snapshotPrices 记录打包时lrc以及市场两个token单位数量的法币价格,fill事件晚到时仍按打包时的价格计算
+func (accountant *ProfitAccountant) snapshotPrices(profit *dao.RingProfit) {
+	tokens := []common.Address{}
+	for _, impl := range ethaccessor.ProtocolAddresses() {
+		tokens = append(tokens, impl.LrcTokenAddress)
+	}
+	if "" != profit.Market {
+		tokenS, tokenB := util.UnWrap(profit.Market)
+		tokens = append(tokens, util.AliasToAddress(tokenS), util.AliasToAddress(tokenB))
+	}
+
+	prices := make(map[string]string)
+	for _, token := range tokens {
+		price, err := accountant.marketCapProvider.LegalCurrencyValue(token, big.NewRat(1, 1))
+		if nil != err {
+			log.Errorf("profit accountant,token:%s err:%s", token.Hex(), err.Error())
+			continue
+		}
+		prices[token.Hex()] = price.RatString()
+	}
+	if data, err := json.Marshal(prices); nil == err {
+		profit.MinedPrices = string(data)
+	}
+}
+
+func minedPrices(profit *dao.RingProfit) map[common.Address]*big.Rat {
+	res := make(map[common.Address]*big.Rat)
+	if "" == profit.MinedPrices {
+		return res
+	}
+	prices := make(map[string]string)
+	if err := json.Unmarshal([]byte(profit.MinedPrices), &prices); nil != err {
+		log.Errorf("profit accountant,ring:%s unmarshal prices err:%s", profit.RingHash, err.Error())
+		return res
+	}
+	for token, priceStr := range prices {
+		if price, ok := new(big.Rat).SetString(priceStr); ok {
+			res[common.HexToAddress(token)] = price
+		}
+	}
+	return res
+}
+
+// reconcile fill事件与ringmined事件不一定同时入库,没有取到fill的环路在这里补齐
+// 按id分页直到取完,超过profitReconcileMaxAge的环路不再处理,避免一直占用查询结果
+func (accountant *ProfitAccountant) reconcile() {
+	sinceMinedTime := time.Now().Unix() - profitReconcileMaxAge
+	afterId := 0
+	for {
+		profits, err := accountant.dbService.GetUnreconciledRingProfits(sinceMinedTime, afterId, profitReconcileBatch)
+		if nil != err {
+			log.Errorf("profit accountant,reconcile err:%s", err.Error())
+			return
+		}
+		for idx := range profits {
+			profit := &profits[idx]
+			afterId = profit.ID
+			accountant.realizeFills(profit)
+			if !profit.Reconciled {
+				continue
+			}
+			if err := accountant.dbService.Save(profit); nil != err {
+				log.Errorf("profit accountant,update ring:%s err:%s", profit.RingHash, err.Error())
+			}
+		}
+		if len(profits) < profitReconcileBatch {
+			return
+		}
+	}
+}
+
+type ProfitSummary struct {
+	Key               string  `json:"key"`
+	RingCount         int     `json:"ringCount"`
+	MinedCount        int     `json:"minedCount"`
+	FailedCount       int     `json:"failedCount"`
+	EstimatedFee      float64 `json:"estimatedFee"`
+	EstimatedCost     float64 `json:"estimatedCost"`
+	EstimatedProfit   float64 `json:"estimatedProfit"`
+	RealizedFee       float64 `json:"realizedFee"`
+	RealizedCost      float64 `json:"realizedCost"`
+	RealizedProfit    float64 `json:"realizedProfit"`
+	UnreconciledCount int     `json:"unreconciledCount"`
+}
+
+type ProfitReport struct {
+	Start    int64            `json:"start"`
+	End      int64            `json:"end"`
+	Total    *ProfitSummary   `json:"total"`
+	ByMarket []*ProfitSummary `json:"byMarket"`
+	BySender []*ProfitSummary `json:"bySender"`
+	ByDay    []*ProfitSummary `json:"byDay"`
+}
+
+// GenerateProfitReport 按照市场、发送地址以及日期(UTC)汇总[start, end)之间的环路收益
+func GenerateProfitReport(dbService dao.RdsService, start, end int64) (*ProfitReport, error) {
+	profits, err := dbService.GetRingProfits(start, end)
+	if nil != err {
+		return nil, err
+	}
+
+	report := &ProfitReport{Start: start, End: end, Total: &ProfitSummary{Key: "total"}}
+	byMarket := make(map[string]*ProfitSummary)
+	bySender := make(map[string]*ProfitSummary)
+	byDay := make(map[string]*ProfitSummary)
+	for idx := range profits {
+		profit := &profits[idx]
+		t := profit.CreateTime
+		if profit.MinedTime > 0 {
+			t = profit.MinedTime
+		}
+		day := time.Unix(t, 0).UTC().Format("2006-01-02")
+
+		report.Total.add(profit)
+		summaryOf(byMarket, profit.Market).add(profit)
+		summaryOf(bySender, profit.Miner).add(profit)
+		summaryOf(byDay, day).add(profit)
+	}
+	report.ByMarket = sortedSummaries(byMarket)
+	report.BySender = sortedSummaries(bySender)
+	report.ByDay = sortedSummaries(byDay)
+	return report, nil
+}
+
+func (summary *ProfitSummary) add(profit *dao.RingProfit) {
+	summary.RingCount += 1
+	switch types.TxStatus(profit.Status) {
+	case types.TX_STATUS_SUCCESS:
+		summary.MinedCount += 1
+	case types.TX_STATUS_FAILED:
+		summary.FailedCount += 1
+	}
+	if types.TX_STATUS_SUCCESS == types.TxStatus(profit.Status) && !profit.Reconciled {
+		summary.UnreconciledCount += 1
+	}
+	summary.EstimatedFee += profit.EstimatedFee
+	summary.EstimatedCost += profit.EstimatedCost
+	summary.EstimatedProfit += profit.EstimatedFee - profit.EstimatedCost
+	summary.RealizedFee += profit.RealizedFee
+	summary.RealizedCost += profit.RealizedCost
+	summary.RealizedProfit += profit.RealizedFee - profit.RealizedCost
+}
+
+func summaryOf(summaries map[string]*ProfitSummary, key string) *ProfitSummary {
+	if _, exists := summaries[key]; !exists {
+		summaries[key] = &ProfitSummary{Key: key}
+	}
+	return summaries[key]
+}
+
+func sortedSummaries(summaries map[string]*ProfitSummary) []*ProfitSummary {
+	res := []*ProfitSummary{}
+	for _, summary := range summaries {
+		res = append(res, summary)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+	return res
+}
+
+func ratToFloat(v *big.Rat) float64 {
+	if nil == v {
+		return 0
+	}
+	f, _ := v.Float64()
+	return f
+}
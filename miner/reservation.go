@@ -0,0 +1,119 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const reservationRefreshInterval = 5
+
+// RingReservations 其他miner在ringhash registry中登记的环路,预留期内合约只接受登记者提交,撮合时需要避开
+// 登记的只有ringhash,因此对每个登记过的ringminer按所有可能的feeSelections计算候选环路的ringhash进行比较
+type RingReservations struct {
+	db         dao.RdsService
+	feeReceipt common.Address
+	ttl        int64
+
+	mtx         sync.RWMutex
+	ringhashes  map[common.Hash]bool
+	ringminers  map[common.Address]bool
+	lastRefresh int64
+}
+
+func NewRingReservations(db dao.RdsService, feeReceipt common.Address, ttl int64) *RingReservations {
+	reservations := &RingReservations{}
+	reservations.db = db
+	reservations.feeReceipt = feeReceipt
+	reservations.ttl = ttl
+	if reservations.ttl <= 0 {
+		reservations.ttl = 600
+	}
+	reservations.ringhashes = make(map[common.Hash]bool)
+	reservations.ringminers = make(map[common.Address]bool)
+	return reservations
+}
+
+func (reservations *RingReservations) refresh() {
+	now := time.Now().Unix()
+	reservations.mtx.RLock()
+	fresh := now-reservations.lastRefresh < reservationRefreshInterval
+	reservations.mtx.RUnlock()
+	if fresh {
+		return
+	}
+
+	events, err := reservations.db.GetRinghashSubmittedSince(now - reservations.ttl)
+	if nil != err {
+		log.Errorf("Miner reservations,get ringhash submitted events err:%s", err.Error())
+		return
+	}
+	ringhashes := make(map[common.Hash]bool)
+	ringminers := make(map[common.Address]bool)
+	for _, evt := range events {
+		ringminer := common.HexToAddress(evt.RingMiner)
+		if ringminer == reservations.feeReceipt {
+			continue
+		}
+		ringhashes[common.HexToHash(evt.RingHash)] = true
+		ringminers[ringminer] = true
+	}
+
+	reservations.mtx.Lock()
+	reservations.ringhashes = ringhashes
+	reservations.ringminers = ringminers
+	reservations.lastRefresh = now
+	reservations.mtx.Unlock()
+}
+
+// IsReserved 这组订单组成的环路是否已被其他miner登记
+func (reservations *RingReservations) IsReserved(orderhashes ...common.Hash) bool {
+	if len(orderhashes) <= 0 {
+		return false
+	}
+	reservations.refresh()
+
+	reservations.mtx.RLock()
+	defer reservations.mtx.RUnlock()
+	if len(reservations.ringhashes) <= 0 {
+		return false
+	}
+
+	uniqueIdBytes := orderhashes[0].Bytes()
+	for _, orderhash := range orderhashes[1:] {
+		uniqueIdBytes = types.Xor(uniqueIdBytes, orderhash.Bytes())
+	}
+	uniqueId := common.BytesToHash(uniqueIdBytes)
+
+	selectionsCount := int64(1) << uint(len(orderhashes))
+	for ringminer := range reservations.ringminers {
+		for feeSelections := int64(0); feeSelections < selectionsCount; feeSelections++ {
+			if reservations.ringhashes[types.GenerateRinghash(uniqueId, ringminer, big.NewInt(feeSelections))] {
+				return true
+			}
+		}
+	}
+	return false
+}
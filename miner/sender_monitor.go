@@ -0,0 +1,172 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"github.com/Loopring/relay/ethaccessor"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const (
+	defaultSenderCheckInterval = 60
+	weiPerEther                = 1e18
+)
+
+type SenderHealth struct {
+	Address       common.Address `json:"address"`
+	Balance       *types.Big     `json:"balance"`
+	PendingCount  int64          `json:"pendingCount"`
+	Nonce         int64          `json:"nonce"`
+	Excluded      bool           `json:"excluded"` // 余额低于阈值,不再用于提交环路
+	LastCheckTime int64          `json:"lastCheckTime"`
+	LastFailure   string         `json:"lastFailure"`
+	LastFailTime  int64          `json:"lastFailTime"`
+}
+
+// SenderMonitor 定时检查所有miner地址的eth余额,低于阈值的地址不再用于提交环路,充值之后自动恢复
+type SenderMonitor struct {
+	mtx        sync.RWMutex
+	addresses  []common.Address
+	minBalance *big.Int
+	interval   time.Duration
+	health     map[common.Address]*SenderHealth
+	stopChan   chan bool
+}
+
+func NewSenderMonitor(addresses []common.Address, minEthBalance float64, interval int64) *SenderMonitor {
+	monitor := &SenderMonitor{}
+	monitor.addresses = addresses
+	monitor.minBalance, _ = new(big.Float).Mul(big.NewFloat(minEthBalance), big.NewFloat(weiPerEther)).Int(nil)
+	if interval <= 0 {
+		interval = defaultSenderCheckInterval
+	}
+	monitor.interval = time.Duration(interval) * time.Second
+	monitor.health = make(map[common.Address]*SenderHealth)
+	for _, addr := range addresses {
+		monitor.health[addr] = &SenderHealth{Address: addr}
+	}
+	return monitor
+}
+
+func (monitor *SenderMonitor) Start() {
+	monitor.stopChan = make(chan bool)
+	monitor.checkAll()
+	go func() {
+		for {
+			select {
+			case <-time.After(monitor.interval):
+				monitor.checkAll()
+			case <-monitor.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+func (monitor *SenderMonitor) Stop() {
+	if nil != monitor.stopChan {
+		close(monitor.stopChan)
+	}
+}
+
+func (monitor *SenderMonitor) checkAll() {
+	for _, addr := range monitor.addresses {
+		if err := monitor.check(addr); nil != err {
+			log.Errorf("sender monitor,check %s err:%s", addr.Hex(), err.Error())
+		}
+	}
+}
+
+func (monitor *SenderMonitor) check(addr common.Address) error {
+	var balance, blockedTxCount, txCount types.Big
+	if err := ethaccessor.GetBalance(&balance, addr, "latest"); nil != err {
+		return err
+	}
+	if err := ethaccessor.GetTransactionCount(&blockedTxCount, addr, "latest"); nil != err {
+		return err
+	}
+	if err := ethaccessor.GetTransactionCount(&txCount, addr, "pending"); nil != err {
+		return err
+	}
+	monitor.update(addr, balance.BigInt(), txCount.Int64()-blockedTxCount.Int64(), txCount.Int64())
+	return nil
+}
+
+func (monitor *SenderMonitor) update(addr common.Address, balance *big.Int, pendingCount, nonce int64) {
+	monitor.mtx.Lock()
+	defer monitor.mtx.Unlock()
+
+	health, exists := monitor.health[addr]
+	if !exists {
+		return
+	}
+	health.Balance = types.NewBigPtr(balance)
+	health.PendingCount = pendingCount
+	health.Nonce = nonce
+	health.LastCheckTime = time.Now().Unix()
+
+	excluded := balance.Cmp(monitor.minBalance) < 0
+	if excluded && !health.Excluded {
+		log.Errorf("sender monitor,alert: balance of %s is %s wei, less than %s wei, it will not be used to submit ring until topped up", addr.Hex(), balance.String(), monitor.minBalance.String())
+	} else if !excluded && health.Excluded {
+		log.Infof("sender monitor,balance of %s is %s wei, it will be used to submit ring again", addr.Hex(), balance.String())
+	}
+	health.Excluded = excluded
+}
+
+// RecordFailure 记录提交失败的原因,查询时返回最近的一次
+func (monitor *SenderMonitor) RecordFailure(addr common.Address, err error) {
+	if nil == err {
+		return
+	}
+	monitor.mtx.Lock()
+	defer monitor.mtx.Unlock()
+
+	if health, exists := monitor.health[addr]; exists {
+		health.LastFailure = err.Error()
+		health.LastFailTime = time.Now().Unix()
+	}
+}
+
+// IsExcluded 还没有检查过的地址不会被排除
+func (monitor *SenderMonitor) IsExcluded(addr common.Address) bool {
+	monitor.mtx.RLock()
+	defer monitor.mtx.RUnlock()
+
+	if health, exists := monitor.health[addr]; exists {
+		return health.Excluded
+	}
+	return false
+}
+
+func (monitor *SenderMonitor) Health() []SenderHealth {
+	monitor.mtx.RLock()
+	defer monitor.mtx.RUnlock()
+
+	list := []SenderHealth{}
+	for _, addr := range monitor.addresses {
+		list = append(list, *monitor.health[addr])
+	}
+	return list
+}
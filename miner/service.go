@@ -0,0 +1,144 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"errors"
+	"github.com/Loopring/relay/dao"
+	"math/big"
+	"time"
+)
+
+const (
+	defaultProfitReportDuration = int64(7 * 24 * 3600)
+	defaultDecisionQueryLimit   = 100
+)
+
+type ProfitReportQuery struct {
+	Start int64 `json:"start"`
+	End   int64 `json:"end"`
+}
+
+type DecisionQuery struct {
+	Market string `json:"market"`
+	Type   string `json:"type"`
+	Limit  int    `json:"limit"`
+}
+
+type OrderWaitQuery struct {
+	Market string `json:"market"`
+}
+
+type MatcherState struct {
+	RoundNumber string        `json:"roundNumber"`
+	Markets     []MarketState `json:"markets"`
+}
+
+// MinerServiceImpl 以miner为namespace注册到jsonrpc
+type MinerServiceImpl struct {
+	dbService     dao.RdsService
+	senderMonitor *SenderMonitor
+	inspector     MatcherInspector
+}
+
+func NewMinerService(dbService dao.RdsService, minerInstance *Miner) *MinerServiceImpl {
+	s := &MinerServiceImpl{dbService: dbService, senderMonitor: minerInstance.submitter.senderMonitor}
+	if inspector, ok := minerInstance.matcher.(MatcherInspector); ok {
+		s.inspector = inspector
+	}
+	return s
+}
+
+// GetProfitReport 默认查询最近7天
+func (s *MinerServiceImpl) GetProfitReport(query *ProfitReportQuery) (*ProfitReport, error) {
+	end := time.Now().Unix()
+	start := end - defaultProfitReportDuration
+	if nil != query {
+		if query.End > 0 {
+			end = query.End
+		}
+		if query.Start > 0 {
+			start = query.Start
+		} else {
+			start = end - defaultProfitReportDuration
+		}
+	}
+	if start >= end {
+		return nil, errors.New("start must be less than end")
+	}
+	return GenerateProfitReport(s.dbService, start, end)
+}
+
+// GetSenderHealth 返回所有miner地址最近一次检查的余额、pending交易数、nonce以及最近一次提交失败的原因
+func (s *MinerServiceImpl) GetSenderHealth() ([]SenderHealth, error) {
+	return s.senderMonitor.Health(), nil
+}
+
+// GetMatcherState 当前的撮合轮次以及每个market最近一轮加载的订单数
+func (s *MinerServiceImpl) GetMatcherState() (*MatcherState, error) {
+	if nil == s.inspector {
+		return nil, errors.New("matcher doesn't support inspection")
+	}
+	state := &MatcherState{Markets: s.inspector.MarketStates()}
+	roundNumber := big.NewInt(0)
+	for _, market := range state.Markets {
+		if round, ok := new(big.Int).SetString(market.RoundNumber, 10); ok && round.Cmp(roundNumber) > 0 {
+			roundNumber = round
+		}
+	}
+	state.RoundNumber = roundNumber.String()
+	return state, nil
+}
+
+// GetDecisions 最近的撮合决定,按时间倒序,默认返回100条
+func (s *MinerServiceImpl) GetDecisions(query *DecisionQuery) ([]Decision, error) {
+	if nil == s.inspector {
+		return nil, errors.New("matcher doesn't support inspection")
+	}
+	market, decisionType, limit := "", "", defaultDecisionQueryLimit
+	if nil != query {
+		market = query.Market
+		decisionType = query.Type
+		if query.Limit > 0 {
+			limit = query.Limit
+		}
+	}
+	return s.inspector.DecisionLog().Query(market, decisionType, limit), nil
+}
+
+// GetInFlightRings 已经提交还未完成的环路
+func (s *MinerServiceImpl) GetInFlightRings() ([]InFlightRing, error) {
+	if nil == s.inspector {
+		return nil, errors.New("matcher doesn't support inspection")
+	}
+	return s.inspector.InFlightRings()
+}
+
+// GetOrderWaitStats 订单从创建到第一次被撮合的等待时间,market为空时统计所有market
+func (s *MinerServiceImpl) GetOrderWaitStats(query *OrderWaitQuery) (*OrderWaitStats, error) {
+	if nil == s.inspector {
+		return nil, errors.New("matcher doesn't support inspection")
+	}
+	market := ""
+	if nil != query {
+		market = query.Market
+	}
+	stats := s.inspector.OrderWaitMetrics().Stats(market)
+	return &stats, nil
+}
@@ -23,6 +23,7 @@ import (
 	"math/big"
 
 	"encoding/json"
+	"fmt"
 	"github.com/Loopring/relay/cache"
 	"github.com/Loopring/relay/config"
 	"github.com/Loopring/relay/dao"
@@ -43,12 +44,20 @@ const SubmitRingMethod_LastId = "submitringmethod_lastid"
 type RingSubmitter struct {
 	minerAccountForSign accounts.Account
 	//minerNameInfos      map[common.Address][]*types.NameRegistryInfo
-	feeReceipt       common.Address
-	currentBlockTime int64
+	feeReceipt         common.Address
+	currentBlockTime   int64
+	currentBlockNumber int64
 
 	maxGasLimit *big.Int
 	minGasLimit *big.Int
 
+	gasMarginPercentage int64
+	pendingTracker      *PendingTxTracker
+	nonceManager        *NonceManager
+	profitAccountant    *ProfitAccountant
+	senderMonitor       *SenderMonitor
+	committer           *RinghashCommitter
+
 	normalMinerAddresses  []*NormalSenderAddress
 	percentMinerAddresses []*SplitMinerAddress
 
@@ -68,6 +77,11 @@ func NewSubmitter(options config.MinerOptions, dbService dao.RdsService, marketC
 	submitter := &RingSubmitter{}
 	submitter.maxGasLimit = big.NewInt(options.MaxGasLimit)
 	submitter.minGasLimit = big.NewInt(options.MinGasLimit)
+	if options.GasMarginPercentage > 0 {
+		submitter.gasMarginPercentage = options.GasMarginPercentage
+	} else {
+		submitter.gasMarginPercentage = 20
+	}
 	if common.IsHexAddress(options.FeeReceipt) {
 		submitter.feeReceipt = common.HexToAddress(options.FeeReceipt)
 	} else {
@@ -106,34 +120,45 @@ func NewSubmitter(options config.MinerOptions, dbService dao.RdsService, marketC
 	submitter.dbService = dbService
 	submitter.marketCapProvider = marketCapProvider
 
+	submitter.pendingTracker = newPendingTxTracker(submitter, options.GasPriceBumpPercentage)
+	submitter.nonceManager = NewNonceManager(submitter.normalMinerAddresses, options.NonceGapTimeout)
+	submitter.profitAccountant = NewProfitAccountant(dbService, marketCapProvider)
+	submitter.senderMonitor = NewSenderMonitor(submitter.senderAddresses(), options.MinSenderEthBalance, options.SenderCheckInterval)
+	if nil != options.CommitReveal && options.CommitReveal.Open {
+		if nil == ethaccessor.RinghashRegistryAbi() {
+			return submitter, errors.New("miner.commitReveal requires common.protocolImpl.ringhashRegistryAbi")
+		}
+		submitter.committer = NewRinghashCommitter(submitter, *options.CommitReveal)
+	}
+
 	submitter.stopFuncs = []func(){}
 	return submitter, nil
 }
 
+// listenBlockNew 使用ethaccessor共享的块头流,不再依赖extractor处理完块后发出的Block_New
 func (submitter *RingSubmitter) listenBlockNew() {
-	blockEventChan := make(chan *types.BlockEvent)
+	heads, unsubscribe := ethaccessor.SubscribeHeads()
+	stopChan := make(chan bool)
 	go func() {
 		for {
 			select {
-			case blockEvent := <-blockEventChan:
-				submitter.currentBlockTime = blockEvent.BlockTime
+			case head := <-heads:
+				log.Debugf("submitter.listenBlockNew blockNumber:%s, blocktime:%s", head.Number.BigInt().String(), head.Timestamp.BigInt().String())
+				submitter.currentBlockTime = head.Timestamp.Int64()
+				submitter.currentBlockNumber = head.Number.Int64()
+				submitter.pendingTracker.check(submitter.currentBlockNumber)
+				if nil != submitter.committer {
+					submitter.committer.check(submitter.currentBlockNumber)
+				}
+			case <-stopChan:
+				return
 			}
 		}
 	}()
 
-	watcher := &eventemitter.Watcher{
-		Concurrent: false,
-		Handle: func(eventData eventemitter.EventData) error {
-			e := eventData.(*types.BlockEvent)
-			log.Debugf("submitter.listenBlockNew blockNumber:%s, blocktime:%d", e.BlockNumber.String(), e.BlockTime)
-			blockEventChan <- e
-			return nil
-		},
-	}
-	eventemitter.On(eventemitter.Block_New, watcher)
 	submitter.stopFuncs = append(submitter.stopFuncs, func() {
-		close(blockEventChan)
-		eventemitter.Un(eventemitter.Block_New, watcher)
+		unsubscribe()
+		close(stopChan)
 	})
 }
 
@@ -175,23 +200,13 @@ func (submitter *RingSubmitter) listenNewRings() {
 			//ringSubmitInfoChan <- e
 			if nil != ringInfos {
 				for _, ringState := range ringInfos {
-					txHash, status, err1 := submitter.submitRing(ringState)
-					ringState.SubmitTxHash = txHash
-
-					daoInfo := &dao.RingSubmitInfo{}
-					daoInfo.ConvertDown(ringState, err1)
-					if err := submitter.dbService.Add(daoInfo); nil != err {
+					uniqueId := ringState.RawRing.GenerateUniqueId()
+					if daoInfo, err := submitter.enqueue(ringState); nil != err {
 						log.Errorf("Miner submitter,insert new ring err:%s", err.Error())
+						submitter.submitResult(ringState.Ringhash, uniqueId, types.NilHash, types.TX_STATUS_FAILED, big.NewInt(0), big.NewInt(0), big.NewInt(0), err)
 					} else {
-						for _, filledOrder := range ringState.RawRing.Orders {
-							daoOrder := &dao.FilledOrder{}
-							daoOrder.ConvertDown(filledOrder, ringState.Ringhash)
-							if err1 := submitter.dbService.Add(daoOrder); nil != err1 {
-								log.Errorf("Miner submitter,insert filled Order err:%s", err1.Error())
-							}
-						}
+						submitter.dispatch(daoInfo, ringState, uniqueId)
 					}
-					submitter.submitResult(ringState.Ringhash, ringState.RawRing.GenerateUniqueId(), txHash, status, big.NewInt(0), big.NewInt(0), big.NewInt(0), err1)
 				}
 			}
 			return nil
@@ -211,15 +226,17 @@ func (submitter *RingSubmitter) canSubmit(ringState *types.RingSubmitInfo) error
 
 func (submitter *RingSubmitter) submitRing(ringSubmitInfo *types.RingSubmitInfo) (common.Hash, types.TxStatus, error) {
 	status := types.TX_STATUS_PENDING
-	ordersStr, _ := json.Marshal(ringSubmitInfo.RawRing.Orders)
-	log.Debugf("submitring hash:%s, orders:%s", ringSubmitInfo.Ringhash.Hex(), string(ordersStr))
+	if nil != ringSubmitInfo.RawRing {
+		ordersStr, _ := json.Marshal(ringSubmitInfo.RawRing.Orders)
+		log.Debugf("submitring hash:%s, orders:%s", ringSubmitInfo.Ringhash.Hex(), string(ordersStr))
+	}
 
 	txHash := types.NilHash
-	var err error
+	err := submitter.simulateRing(ringSubmitInfo)
 
 	if nil == err {
 		txHashStr := "0x"
-		txHashStr, err = ethaccessor.SignAndSendTransaction(ringSubmitInfo.Miner, ringSubmitInfo.ProtocolAddress, ringSubmitInfo.ProtocolGas, ringSubmitInfo.ProtocolGasPrice, nil, ringSubmitInfo.ProtocolData, false)
+		txHashStr, err = submitter.sendTransaction(ringSubmitInfo)
 		if nil != err {
 			log.Errorf("submitring hash:%s, err:%s", ringSubmitInfo.Ringhash.Hex(), err.Error())
 			status = types.TX_STATUS_FAILED
@@ -229,10 +246,56 @@ func (submitter *RingSubmitter) submitRing(ringSubmitInfo *types.RingSubmitInfo)
 		log.Errorf("submitring hash:%s, protocol:%s, err:%s", ringSubmitInfo.Ringhash.Hex(), ringSubmitInfo.ProtocolAddress.Hex(), err.Error())
 		status = types.TX_STATUS_FAILED
 	}
+	submitter.senderMonitor.RecordFailure(ringSubmitInfo.Miner, err)
 
 	return txHash, status, err
 }
 
+// sendTransaction nonce由nonceManager分配,发送失败时归还
+func (submitter *RingSubmitter) sendTransaction(ringSubmitInfo *types.RingSubmitInfo) (string, error) {
+	nonce, err := submitter.nonceManager.Allocate(ringSubmitInfo.Miner)
+	if nil != err {
+		return "", err
+	}
+
+	txHash, err := ethaccessor.SendTransactionWithNonce(ringSubmitInfo.Miner, ringSubmitInfo.ProtocolAddress, nonce, ringSubmitInfo.ProtocolGas, ringSubmitInfo.ProtocolGasPrice, nil, ringSubmitInfo.ProtocolData)
+	if nil != err {
+		submitter.nonceManager.Release(ringSubmitInfo.Miner, nonce, err)
+		return "", err
+	}
+	submitter.nonceManager.Confirm(ringSubmitInfo.Miner, nonce)
+	return txHash, nil
+}
+
+// simulateRing 签名之前基于pending状态预执行,会revert的环路直接丢弃,失败原因随ringSubmitInfo一起保存
+// 预执行成功后使用eth_estimateGas的结果加上gasMarginPercentage作为gas limit,同样限制在minGasLimit与maxGasLimit之间,
+// 估算值本身超过maxGasLimit的环路直接丢弃
+func (submitter *RingSubmitter) simulateRing(ringSubmitInfo *types.RingSubmitInfo) error {
+	if err := ethaccessor.SimulateTransaction(ringSubmitInfo.Miner, ringSubmitInfo.ProtocolAddress, nil, ringSubmitInfo.ProtocolData, "pending"); nil != err {
+		return fmt.Errorf("ring simulation failed:%s", err.Error())
+	}
+
+	gas, err := ethaccessor.EstimateTransactionGas(ringSubmitInfo.Miner, ringSubmitInfo.ProtocolAddress, nil, ringSubmitInfo.ProtocolData, "pending")
+	if nil != err {
+		return fmt.Errorf("ring simulation failed, estimate gas err:%s", err.Error())
+	}
+	if submitter.maxGasLimit.Sign() > 0 && gas.Cmp(submitter.maxGasLimit) > 0 {
+		return fmt.Errorf("ring simulation failed, estimated gas:%s exceeds max gas limit:%s", gas.String(), submitter.maxGasLimit.String())
+	}
+	gas.Mul(gas, big.NewInt(100+submitter.gasMarginPercentage))
+	gas.Div(gas, big.NewInt(100))
+	if submitter.maxGasLimit.Sign() > 0 && gas.Cmp(submitter.maxGasLimit) > 0 {
+		gas.Set(submitter.maxGasLimit)
+	}
+	if submitter.minGasLimit.Sign() > 0 && gas.Cmp(submitter.minGasLimit) < 0 {
+		gas.Set(submitter.minGasLimit)
+	}
+
+	log.Debugf("submitring hash:%s, simulation succeed, gas:%s", ringSubmitInfo.Ringhash.Hex(), gas.String())
+	ringSubmitInfo.ProtocolGas = gas
+	return nil
+}
+
 func (submitter *RingSubmitter) listenSubmitRingMethodEventFromMysql() {
 
 	processSubmitRingMethod := func() {
@@ -273,6 +336,7 @@ func (submitter *RingSubmitter) listenSubmitRingMethodEventFromMysql() {
 							uniqueId := common.HexToHash(info.UniqueId)
 
 							submitter.submitResult(ringhash, uniqueId, evt.TxHash, evt.Status, big.NewInt(0), evt.BlockNumber, evt.GasUsed, err1)
+							submitter.profitAccountant.realize(ringhash, evt)
 						}
 					}
 				} else {
@@ -355,6 +419,9 @@ func (submitter *RingSubmitter) submitResult(ringhash, uniqeId, txhash common.Ha
 	if err := submitter.dbService.UpdateRingSubmitInfoResult(resultEvt); nil != err {
 		log.Errorf("err:%s", err.Error())
 	}
+	if status != types.TX_STATUS_PENDING {
+		submitter.pendingTracker.remove(ringhash)
+	}
 	eventemitter.Emit(eventemitter.Miner_RingSubmitResult, resultEvt)
 }
 
@@ -433,6 +500,17 @@ func (submitter *RingSubmitter) stop() {
 }
 
 func (submitter *RingSubmitter) start() {
+	submitter.nonceManager.Start()
+	submitter.stopFuncs = append(submitter.stopFuncs, submitter.nonceManager.Stop)
+	submitter.profitAccountant.Start()
+	submitter.stopFuncs = append(submitter.stopFuncs, submitter.profitAccountant.Stop)
+	submitter.senderMonitor.Start()
+	submitter.stopFuncs = append(submitter.stopFuncs, submitter.senderMonitor.Stop)
+	if nil != submitter.committer {
+		submitter.committer.Start()
+		submitter.stopFuncs = append(submitter.stopFuncs, submitter.committer.Stop)
+	}
+	submitter.resumeOutbox()
 	submitter.listenNewRings()
 	submitter.listenSubmitRingMethodEventFromMysql()
 	submitter.listenBlockNew()
@@ -441,7 +519,12 @@ func (submitter *RingSubmitter) start() {
 
 func (submitter *RingSubmitter) availableSenderAddresses() []*NormalSenderAddress {
 	senderAddresses := []*NormalSenderAddress{}
+	fundedAddresses := []*NormalSenderAddress{}
 	for _, minerAddress := range submitter.normalMinerAddresses {
+		if submitter.senderMonitor.IsExcluded(minerAddress.Address) {
+			continue
+		}
+		fundedAddresses = append(fundedAddresses, minerAddress)
 		var blockedTxCount, txCount types.Big
 		//todo:change it by event
 		ethaccessor.GetTransactionCount(&blockedTxCount, minerAddress.Address, "latest")
@@ -456,12 +539,31 @@ func (submitter *RingSubmitter) availableSenderAddresses() []*NormalSenderAddres
 		}
 	}
 
-	if len(senderAddresses) <= 0 {
-		senderAddresses = append(senderAddresses, submitter.normalMinerAddresses[0])
+	if len(senderAddresses) <= 0 && len(fundedAddresses) > 0 {
+		senderAddresses = append(senderAddresses, fundedAddresses[0])
 	}
 	return senderAddresses
 }
 
+// senderAddresses normalMiners与percentMiners中的所有地址
+func (submitter *RingSubmitter) senderAddresses() []common.Address {
+	addresses := []common.Address{}
+	exists := make(map[common.Address]bool)
+	for _, minerAddress := range submitter.normalMinerAddresses {
+		if !exists[minerAddress.Address] {
+			exists[minerAddress.Address] = true
+			addresses = append(addresses, minerAddress.Address)
+		}
+	}
+	for _, minerAddress := range submitter.percentMinerAddresses {
+		if !exists[minerAddress.Address] {
+			exists[minerAddress.Address] = true
+			addresses = append(addresses, minerAddress.Address)
+		}
+	}
+	return addresses
+}
+
 func (submitter *RingSubmitter) selectSenderAddress() (common.Address, error) {
 	senderAddresses := submitter.availableSenderAddresses()
 	if len(senderAddresses) <= 0 {
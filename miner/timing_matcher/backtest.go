@@ -0,0 +1,398 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package timing_matcher
+
+import (
+	"errors"
+	"github.com/Loopring/relay/cache"
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/market"
+	"github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/marketcap"
+	"github.com/Loopring/relay/miner"
+	"github.com/Loopring/relay/ordermanager"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sort"
+)
+
+const defaultBacktestBlockInterval = 15
+
+/**
+回测:按照模拟时钟,把历史订单、fill、cancel事件依次放入内存订单簿,
+使用TimingMatcher与Evaluator进行撮合,统计成环数量、预估收益以及订单成交比例,不发送任何交易。
+假设:
+1、所有用户的余额与授权都足够
+2、token价格使用1小时趋势的收盘价,eth法币价格固定
+3、gasPrice使用模拟时间之前最近一次环路交易的gasPrice
+4、环路撮合之后立即成交,同一订单的历史成交仍然会计入
+*/
+
+type BacktestOptions struct {
+	Start         int64 // unix秒
+	End           int64
+	BlockInterval int64 // 每一轮撮合模拟时间前进的秒数,默认15
+	Currency      string
+	EthPrice      float64  // eth的法币价格
+	GasPrice      *big.Int // 没有历史环路交易时使用
+}
+
+type BacktestMarketReport struct {
+	Market            string  `json:"market"`
+	Rings             int     `json:"rings"`
+	Orders            int     `json:"orders"`
+	MatchedOrders     int     `json:"matchedOrders"`
+	FillRate          float64 `json:"fillRate"`
+	EstimatedFee      float64 `json:"estimatedFee"`
+	EstimatedCost     float64 `json:"estimatedCost"`
+	EstimatedReceived float64 `json:"estimatedReceived"`
+}
+
+type BacktestReport struct {
+	Start             int64                   `json:"start"`
+	End               int64                   `json:"end"`
+	Rounds            int                     `json:"rounds"`
+	Rings             int                     `json:"rings"`
+	Orders            int                     `json:"orders"`
+	MatchedOrders     int                     `json:"matchedOrders"`
+	FillRate          float64                 `json:"fillRate"` // 所有订单按照amountS计算的平均成交比例
+	EstimatedFee      float64                 `json:"estimatedFee"`
+	EstimatedCost     float64                 `json:"estimatedCost"`
+	EstimatedReceived float64                 `json:"estimatedReceived"`
+	Markets           []*BacktestMarketReport `json:"markets"`
+}
+
+type Backtester struct {
+	options      BacktestOptions
+	minerOptions config.MinerOptions
+	rds          dao.RdsService
+
+	now        int64
+	feeReceipt common.Address
+	gasPrices  []*dao.RingMinedEvent
+}
+
+func NewBacktester(minerOptions config.MinerOptions, rds dao.RdsService, options BacktestOptions) *Backtester {
+	if options.BlockInterval <= 0 {
+		options.BlockInterval = defaultBacktestBlockInterval
+	}
+	backtester := &Backtester{}
+	backtester.options = options
+	backtester.minerOptions = minerOptions
+	backtester.rds = rds
+	backtester.feeReceipt = common.HexToAddress(minerOptions.FeeReceipt)
+	return backtester
+}
+
+func (b *Backtester) Run() (*BacktestReport, error) {
+	opts := b.options
+	if opts.End <= opts.Start {
+		return nil, errors.New("backtest end must be later than start")
+	}
+	if opts.EthPrice <= 0 {
+		return nil, errors.New("backtest eth price must be positive")
+	}
+
+	// 撮合过程中的缓存只保存在内存中,不影响正在运行的miner
+	cache.NewMemoryCache()
+
+	orders, err := b.rds.GetOrdersForReplay(opts.Start, opts.End)
+	if nil != err {
+		return nil, err
+	}
+	fills, err := b.rds.GetFillsForReplay(opts.Start, opts.End)
+	if nil != err {
+		return nil, err
+	}
+	cancels, err := b.rds.GetCancelsForReplay(opts.Start, opts.End)
+	if nil != err {
+		return nil, err
+	}
+	ringMinedEvents, err := b.rds.GetRingMinedForReplay(opts.Start, opts.End)
+	if nil != err {
+		return nil, err
+	}
+	for idx := range ringMinedEvents {
+		if len(ringMinedEvents[idx].GasPrice) > 0 {
+			b.gasPrices = append(b.gasPrices, &ringMinedEvents[idx])
+		}
+	}
+	sort.SliceStable(b.gasPrices, func(i, j int) bool {
+		return b.gasPrices[i].Time < b.gasPrices[j].Time
+	})
+
+	feed, err := b.loadPrices()
+	if nil != err {
+		return nil, err
+	}
+	feed.Start()
+
+	evaluator := miner.NewEvaluator(feed, b.minerOptions)
+	evaluator.SetGasPriceEstimator(b.estimateGasPrice)
+	source := ordermanager.NewReplaySource(feed, func() int64 { return b.now })
+	matcher := newTimingMatcher(b.minerOptions, &backtestRingGenerator{feeReceipt: b.feeReceipt}, evaluator, source, backtestBalance{}, b.rds)
+	evaluator.SetMatcher(matcher)
+
+	var rings []*types.RingSubmitInfo
+	watcher := &eventemitter.Watcher{Concurrent: false, Handle: func(eventData eventemitter.EventData) error {
+		rings = append(rings, eventData.([]*types.RingSubmitInfo)...)
+		return nil
+	}}
+	eventemitter.On(eventemitter.Miner_NewRing, watcher)
+	defer eventemitter.Un(eventemitter.Miner_NewRing, watcher)
+
+	report := &BacktestReport{Start: opts.Start, End: opts.End}
+	stats := newBacktestStats()
+
+	orderIdx, fillIdx, cancelIdx := 0, 0, 0
+	for b.now = opts.Start; b.now < opts.End; b.now += opts.BlockInterval {
+		for ; orderIdx < len(orders) && orders[orderIdx].CreateTime <= b.now; orderIdx++ {
+			state := &types.OrderState{}
+			if err := orders[orderIdx].ConvertUp(state); nil != err {
+				log.Errorf("backtest, order:%s err:%s", orders[orderIdx].OrderHash, err.Error())
+				continue
+			}
+			source.AddOrder(state)
+			stats.addOrder(state)
+		}
+		for ; fillIdx < len(fills) && fills[fillIdx].CreateTime <= b.now; fillIdx++ {
+			fill := fills[fillIdx]
+			source.ApplyFill(common.HexToHash(fill.OrderHash), stringToInt(fill.AmountS), stringToInt(fill.AmountB), stringToInt(fill.SplitS), stringToInt(fill.SplitB))
+		}
+		for ; cancelIdx < len(cancels) && cancels[cancelIdx].CreateTime <= b.now; cancelIdx++ {
+			cancel := cancels[cancelIdx]
+			source.ApplyCancel(common.HexToHash(cancel.OrderHash), stringToInt(cancel.AmountCancelled))
+		}
+		feed.SetTime(b.now)
+
+		// 与正常运行时一致,roundNumber为毫秒时间戳
+		roundNumber := big.NewInt(b.now * 1000)
+		for _, m := range matcher.markets {
+			m.match(roundNumber)
+		}
+		report.Rounds += 1
+
+		for _, ringState := range rings {
+			stats.addRing(ringState)
+			for _, filledOrder := range ringState.RawRing.Orders {
+				splitS := big.NewInt(0)
+				if filledOrder.FeeSelection > 0 && nil != filledOrder.FeeS {
+					splitS = ratToInt(filledOrder.FeeS)
+				}
+				source.ApplyFill(filledOrder.OrderState.RawOrder.Hash, ratToInt(filledOrder.FillAmountS), ratToInt(filledOrder.FillAmountB), splitS, big.NewInt(0))
+			}
+			// 模拟环路已经被打包
+			RemoveMinedRingAndReturnOrderhashes(ringState.Ringhash)
+		}
+		rings = nil
+	}
+
+	stats.fillReport(report)
+	return report, nil
+}
+
+// loadPrices 使用以WETH计价的市场的1小时趋势收盘价作为token价格
+func (b *Backtester) loadPrices() (*marketcap.CapProvider_Replay, error) {
+	feed := marketcap.NewReplayCap(b.options.Currency, new(big.Rat).SetFloat64(b.options.EthPrice))
+	weth, exists := util.AllTokens["WETH"]
+	if !exists {
+		return nil, errors.New("backtest need WETH token")
+	}
+	for _, mkt := range util.AllMarkets {
+		symbolS, symbolB := util.UnWrap(mkt)
+		if symbolB != weth.Symbol {
+			continue
+		}
+		token, exists := util.AllTokens[symbolS]
+		if !exists {
+			continue
+		}
+		// 开始之前的最后一个价格作为初始价格
+		trends, err := b.rds.TrendQueryByInterval(market.OneHour, mkt, b.options.Start-3600, b.options.End)
+		if nil != err {
+			return nil, err
+		}
+		for _, trend := range trends {
+			if trend.Close > 0 {
+				feed.AddPrice(token.Protocol, trend.End, new(big.Rat).SetFloat64(trend.Close))
+			}
+		}
+	}
+	return feed, nil
+}
+
+func (b *Backtester) estimateGasPrice(minGasPrice, maxGasPrice *big.Int) *big.Int {
+	gasPrice := new(big.Int)
+	idx := sort.Search(len(b.gasPrices), func(i int) bool {
+		return b.gasPrices[i].Time > b.now
+	})
+	if idx > 0 {
+		idx -= 1
+	}
+	if idx < len(b.gasPrices) {
+		gasPrice.SetString(b.gasPrices[idx].GasPrice, 0)
+	} else if nil != b.options.GasPrice {
+		gasPrice.Set(b.options.GasPrice)
+	}
+
+	if nil != maxGasPrice && maxGasPrice.Sign() > 0 && maxGasPrice.Cmp(gasPrice) < 0 {
+		gasPrice.Set(maxGasPrice)
+	} else if nil != minGasPrice && minGasPrice.Cmp(gasPrice) > 0 {
+		gasPrice.Set(minGasPrice)
+	}
+	return gasPrice
+}
+
+// backtestBalance 回测时认为所有用户的余额与授权都足够
+type backtestBalance struct{}
+
+func (backtestBalance) GetBalanceAndAllowance(owner, token, spender common.Address) (balance, allowance *big.Int, err error) {
+	max := new(big.Int).Lsh(big.NewInt(1), 128)
+	return max, new(big.Int).Set(max), nil
+}
+
+// backtestRingGenerator 只生成ringhash,不选择sender,也不生成合约调用数据
+type backtestRingGenerator struct {
+	feeReceipt common.Address
+}
+
+func (g *backtestRingGenerator) GenerateRingSubmitInfo(ringState *types.Ring) (*types.RingSubmitInfo, error) {
+	ringSubmitInfo := &types.RingSubmitInfo{RawRing: ringState, ProtocolGasPrice: ringState.GasPrice, ProtocolGas: ringState.Gas}
+	if types.IsZeroHash(ringState.Hash) {
+		ringState.Hash = ringState.GenerateHash(g.feeReceipt)
+	}
+	ringSubmitInfo.ProtocolAddress = ringState.Orders[0].OrderState.RawOrder.Protocol
+	ringSubmitInfo.OrdersCount = big.NewInt(int64(len(ringState.Orders)))
+	ringSubmitInfo.Ringhash = ringState.Hash
+	ringSubmitInfo.Miner = g.feeReceipt
+	return ringSubmitInfo, nil
+}
+
+type backtestOrderStats struct {
+	market  string
+	amountS *big.Rat
+	filledS *big.Rat
+}
+
+type backtestStats struct {
+	orders  map[common.Hash]*backtestOrderStats
+	markets map[string]*BacktestMarketReport
+	total   *BacktestMarketReport
+}
+
+func newBacktestStats() *backtestStats {
+	stats := &backtestStats{}
+	stats.orders = make(map[common.Hash]*backtestOrderStats)
+	stats.markets = make(map[string]*BacktestMarketReport)
+	stats.total = &BacktestMarketReport{}
+	return stats
+}
+
+func (stats *backtestStats) marketReport(mkt string) *BacktestMarketReport {
+	report, exists := stats.markets[mkt]
+	if !exists {
+		report = &BacktestMarketReport{Market: mkt}
+		stats.markets[mkt] = report
+	}
+	return report
+}
+
+func (stats *backtestStats) addOrder(state *types.OrderState) {
+	mkt, _ := util.WrapMarketByAddress(state.RawOrder.TokenS.Hex(), state.RawOrder.TokenB.Hex())
+	stats.orders[state.RawOrder.Hash] = &backtestOrderStats{market: mkt, amountS: new(big.Rat).SetInt(state.RawOrder.AmountS), filledS: new(big.Rat)}
+	stats.marketReport(mkt).Orders += 1
+	stats.total.Orders += 1
+}
+
+func (stats *backtestStats) addRing(ringState *types.RingSubmitInfo) {
+	ring := ringState.RawRing
+	order := ring.Orders[0].OrderState.RawOrder
+	mkt, _ := util.WrapMarketByAddress(order.TokenS.Hex(), order.TokenB.Hex())
+	for _, report := range []*BacktestMarketReport{stats.marketReport(mkt), stats.total} {
+		report.Rings += 1
+		report.EstimatedFee += ratToFloat(ring.LegalFee)
+		report.EstimatedCost += ratToFloat(ring.LegalCost)
+		report.EstimatedReceived += ratToFloat(ring.Received)
+	}
+	for _, filledOrder := range ring.Orders {
+		if orderStats, exists := stats.orders[filledOrder.OrderState.RawOrder.Hash]; exists {
+			if orderStats.filledS.Sign() == 0 {
+				stats.marketReport(orderStats.market).MatchedOrders += 1
+				stats.total.MatchedOrders += 1
+			}
+			orderStats.filledS.Add(orderStats.filledS, filledOrder.FillAmountS)
+		}
+	}
+}
+
+func (stats *backtestStats) fillReport(report *BacktestReport) {
+	rates := make(map[string]float64)
+	totalRate := float64(0)
+	for _, orderStats := range stats.orders {
+		if orderStats.amountS.Sign() <= 0 {
+			continue
+		}
+		rate := ratToFloat(new(big.Rat).Quo(orderStats.filledS, orderStats.amountS))
+		if rate > 1 {
+			rate = 1
+		}
+		rates[orderStats.market] += rate
+		totalRate += rate
+	}
+
+	for mkt, marketReport := range stats.markets {
+		if marketReport.Orders > 0 {
+			marketReport.FillRate = rates[mkt] / float64(marketReport.Orders)
+		}
+		report.Markets = append(report.Markets, marketReport)
+	}
+	sort.Slice(report.Markets, func(i, j int) bool {
+		return report.Markets[i].Market < report.Markets[j].Market
+	})
+
+	report.Rings = stats.total.Rings
+	report.Orders = stats.total.Orders
+	report.MatchedOrders = stats.total.MatchedOrders
+	report.EstimatedFee = stats.total.EstimatedFee
+	report.EstimatedCost = stats.total.EstimatedCost
+	report.EstimatedReceived = stats.total.EstimatedReceived
+	if report.Orders > 0 {
+		report.FillRate = totalRate / float64(report.Orders)
+	}
+}
+
+func stringToInt(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 0)
+	if !ok {
+		return big.NewInt(0)
+	}
+	return v
+}
+
+func ratToFloat(rat *big.Rat) float64 {
+	if nil == rat {
+		return 0
+	}
+	f, _ := rat.Float64()
+	return f
+}
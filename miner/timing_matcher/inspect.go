@@ -0,0 +1,165 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package timing_matcher
+
+import (
+	marketUtilLib "github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/miner"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"time"
+)
+
+func (market *Market) name() string {
+	if mkt, err := marketUtilLib.WrapMarketByAddress(market.TokenA.Hex(), market.TokenB.Hex()); nil == err {
+		return mkt
+	}
+	return market.TokenA.Hex() + "-" + market.TokenB.Hex()
+}
+
+// newDecision 撮合过程中的决定写入matcher的decision log
+func (market *Market) newDecision(decisionType string, orderhashes []common.Hash, reason string) *miner.Decision {
+	decision := &miner.Decision{
+		Time:   time.Now().Unix(),
+		Market: market.name(),
+		Type:   decisionType,
+		Orders: []string{},
+		Reason: reason,
+	}
+	if nil != market.roundNumber {
+		decision.Round = market.roundNumber.String()
+	}
+	for _, orderhash := range orderhashes {
+		decision.Orders = append(decision.Orders, orderhash.Hex())
+	}
+	return decision
+}
+
+func (market *Market) record(decision *miner.Decision) {
+	market.matcher.decisions.Add(*decision)
+}
+
+func setDecisionValues(decision *miner.Decision, received, cost *big.Rat, cvs *big.Int) {
+	if nil != received {
+		decision.Received = received.FloatString(2)
+	}
+	if nil != cost {
+		decision.LegalCost = cost.FloatString(2)
+	}
+	if nil != cvs {
+		decision.Cvs = cvs.String()
+	}
+}
+
+func orderHashes(orders ...*types.OrderState) []common.Hash {
+	hashes := []common.Hash{}
+	for _, order := range orders {
+		hashes = append(hashes, order.RawOrder.Hash)
+	}
+	return hashes
+}
+
+// updateState 加载订单之后更新market的状态,match执行期间也可以读取
+func (market *Market) updateState() {
+	market.stateMtx.Lock()
+	defer market.stateMtx.Unlock()
+
+	market.state = miner.MarketState{
+		Market:        market.name(),
+		Protocol:      market.protocolImpl.ContractAddress.Hex(),
+		RoundNumber:   market.roundNumber.String(),
+		AtoBOrders:    len(market.AtoBOrders),
+		BtoAOrders:    len(market.BtoAOrders),
+		LastMatchTime: time.Now().Unix(),
+	}
+}
+
+func (matcher *TimingMatcher) MarketStates() []miner.MarketState {
+	states := []miner.MarketState{}
+	for _, market := range matcher.markets {
+		market.stateMtx.RLock()
+		state := market.state
+		market.stateMtx.RUnlock()
+		if "" == state.Market {
+			state.Market = market.name()
+			state.Protocol = market.protocolImpl.ContractAddress.Hex()
+		}
+		state.Leased = matcher.ownsMarket(market)
+		states = append(states, state)
+	}
+	return states
+}
+
+func (matcher *TimingMatcher) DecisionLog() *miner.DecisionLog {
+	return matcher.decisions
+}
+
+func (matcher *TimingMatcher) OrderWaitMetrics() *miner.OrderWaitMetrics {
+	return matcher.waitMetrics
+}
+
+// InFlightRings redis中缓存的环路,环路被打包或者提交失败之后删除
+func (matcher *TimingMatcher) InFlightRings() ([]miner.InFlightRing, error) {
+	rings := []miner.InFlightRing{}
+	ringhashes, err := CachedRinghashes()
+	if nil != err {
+		return rings, err
+	}
+	for _, ringhash := range ringhashes {
+		orderhashes, err := CachedRingOrderhashes(ringhash)
+		if nil != err {
+			return rings, err
+		}
+		ring := miner.InFlightRing{Ringhash: ringhash.Hex(), Orders: []string{}}
+		if len(orderhashes) > 0 {
+			uniqueId := orderhashes[0].Bytes()
+			for _, orderhash := range orderhashes {
+				ring.Orders = append(ring.Orders, orderhash.Hex())
+			}
+			for _, orderhash := range orderhashes[1:] {
+				uniqueId = types.Xor(uniqueId, orderhash.Bytes())
+			}
+			ring.UniqueId = common.BytesToHash(uniqueId).Hex()
+		}
+		rings = append(rings, ring)
+	}
+	return rings, nil
+}
+
+// recordFirstMatch 链上及已提交环路的成交量在加载时已计入DealtAmountS,为0说明订单第一次被撮合
+func (market *Market) recordFirstMatch(orders []*types.OrderState, now int64) {
+	for _, state := range orders {
+		if nil != state.DealtAmountS && state.DealtAmountS.Sign() > 0 {
+			continue
+		}
+		market.matcher.waitMetrics.Add(market.name(), now-state.RawOrder.CreateTime)
+	}
+}
+
+// orderRanks 订单在加载结果中的位置,加载顺序即priority的顺序
+func orderRanks(orderLists ...[]*types.OrderState) map[common.Hash]int {
+	ranks := make(map[common.Hash]int)
+	for _, orders := range orderLists {
+		for idx, order := range orders {
+			ranks[order.RawOrder.Hash] = idx
+		}
+	}
+	return ranks
+}
@@ -24,8 +24,6 @@ import (
 	"github.com/Loopring/relay/eventemiter"
 	"github.com/Loopring/relay/log"
 	"github.com/Loopring/relay/types"
-	"math/big"
-	"sync"
 	"time"
 )
 
@@ -118,47 +116,6 @@ func (matcher *TimingMatcher) listenOrderReady() {
 	})
 }
 
-func (matcher *TimingMatcher) listenTimingRound() {
-	stopChan := make(chan bool)
-
-	matchFunc := func() {
-		if !matcher.isOrdersReady {
-			return
-		}
-		//if ethaccessor.Synced() {
-		matcher.lastRoundNumber = big.NewInt(time.Now().UnixNano() / 1e6)
-		//matcher.rounds.appendNewRoundState(matcher.lastRoundNumber)
-		var wg sync.WaitGroup
-		for _, market := range matcher.markets {
-			wg.Add(1)
-			go func(m *Market) {
-				defer func() {
-					wg.Add(-1)
-				}()
-				m.match()
-			}(market)
-		}
-		wg.Wait()
-		//}
-	}
-	go func() {
-		matchFunc()
-		for {
-			select {
-			case <-time.After(time.Duration(matcher.duration.Int64()) * time.Millisecond):
-				matchFunc()
-			case <-stopChan:
-				return
-			}
-		}
-	}()
-
-	matcher.stopFuncs = append(matcher.stopFuncs, func() {
-		stopChan <- true
-		close(stopChan)
-	})
-}
-
 func (matcher *TimingMatcher) listenSubmitEvent() {
 	submitEventChan := make(chan *types.RingSubmitResultEvent)
 	go func() {
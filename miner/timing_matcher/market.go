@@ -24,16 +24,18 @@ import (
 	"github.com/Loopring/relay/eventemiter"
 	"github.com/Loopring/relay/log"
 	"github.com/Loopring/relay/miner"
-	"github.com/Loopring/relay/ordermanager"
 	"github.com/Loopring/relay/types"
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
 	"sort"
+	"sync"
+	"time"
 )
 
 type Market struct {
+	mtx          sync.Mutex
 	matcher      *TimingMatcher
-	om           ordermanager.OrderManager
+	om           orderSource
 	protocolImpl *ethaccessor.ProtocolAddress
 
 	TokenA     common.Address
@@ -43,9 +45,24 @@ type Market struct {
 
 	AtoBOrderHashesExcludeNextRound []common.Hash
 	BtoAOrderHashesExcludeNextRound []common.Hash
+
+	roundNumber *big.Int
+	orderRanks  map[common.Hash]int
+
+	stateMtx sync.RWMutex
+	state    miner.MarketState
 }
 
-func (market *Market) match() {
+// match 同一个market的撮合不能并发执行,roundNumber由撮合策略给出
+func (market *Market) match(roundNumber *big.Int) {
+	market.mtx.Lock()
+	defer market.mtx.Unlock()
+
+	if !market.matcher.ownsMarket(market) {
+		return
+	}
+
+	market.roundNumber = roundNumber
 	market.getOrdersForMatching(market.protocolImpl.DelegateAddress)
 	matchedOrderHashes := make(map[common.Hash]bool) //true:fullfilled, false:partfilled
 	ringSubmitInfos := []*types.RingSubmitInfo{}
@@ -55,31 +72,43 @@ func (market *Market) match() {
 	for _, a2BOrder := range market.AtoBOrders {
 		if failedCount, err1 := OrderExecuteFailedCount(a2BOrder.RawOrder.Hash); nil == err1 && failedCount > market.matcher.maxFailedCount {
 			log.Debugf("orderhash:%s has been failed to submit %d times", a2BOrder.RawOrder.Hash.Hex(), failedCount)
+			market.record(market.newDecision(miner.DECISION_EXCLUDED, orderHashes(a2BOrder), fmt.Sprintf("failed to submit %d times", failedCount)))
 
 			continue
 		}
 		for _, b2AOrder := range market.BtoAOrders {
 			if failedCount, err1 := OrderExecuteFailedCount(b2AOrder.RawOrder.Hash); nil == err1 && failedCount > market.matcher.maxFailedCount {
 				log.Debugf("orderhash:%s has been failed to submit %d times", b2AOrder.RawOrder.Hash.Hex(), failedCount)
+				market.record(market.newDecision(miner.DECISION_EXCLUDED, orderHashes(b2AOrder), fmt.Sprintf("failed to submit %d times", failedCount)))
 				continue
 			}
 			//todo:move a2BOrder.RawOrder.Owner != b2AOrder.RawOrder.Owner after contract fix bug
 			if miner.PriceValid(a2BOrder, b2AOrder) && a2BOrder.RawOrder.Owner != b2AOrder.RawOrder.Owner {
+				if market.matcher.isReservedByOthers(a2BOrder, b2AOrder) {
+					log.Debugf("orderhash:%s and orderhash:%s have been reserved by other miner", a2BOrder.RawOrder.Hash.Hex(), b2AOrder.RawOrder.Hash.Hex())
+					market.record(market.newDecision(miner.DECISION_REJECTED, orderHashes(a2BOrder, b2AOrder), "reserved by other miner"))
+					continue
+				}
 				if candidateRing, err := market.GenerateCandidateRing(a2BOrder, b2AOrder); nil != err {
 					log.Errorf("err:%s", err.Error())
 					continue
 				} else {
+					decision := market.newDecision(miner.DECISION_CANDIDATE, orderHashes(a2BOrder, b2AOrder), "")
+					setDecisionValues(decision, candidateRing.received, candidateRing.cost, candidateRing.cvs)
 					if candidateRing.received.Sign() > 0 {
 						candidateRingList = append(candidateRingList, *candidateRing)
 					} else {
 						log.Debugf("timing_matchher, market ringForSubmit received not enough, received:%s, cost:%s ", candidateRing.received.FloatString(0), candidateRing.cost.FloatString(0))
+						decision.Type = miner.DECISION_REJECTED
+						decision.Reason = "received not enough"
 					}
+					market.record(decision)
 				}
 			}
 		}
 	}
 
-	log.Debugf("match round:%s, market: %s -> %s , candidateRingList.length:%d", market.matcher.lastRoundNumber, market.TokenA.Hex(), market.TokenB.Hex(), len(candidateRingList))
+	log.Debugf("match round:%s, market: %s -> %s , candidateRingList.length:%d", market.roundNumber, market.TokenA.Hex(), market.TokenB.Hex(), len(candidateRingList))
 	//the ring that can get max received
 	list := candidateRingList
 	for {
@@ -100,6 +129,7 @@ func (market *Market) match() {
 		}
 		if ringForSubmit, err := market.generateRingSubmitInfo(orders...); nil != err {
 			log.Debugf("generate RingSubmitInfo err:%s", err.Error())
+			market.record(market.newDecision(miner.DECISION_REJECTED, orderHashes(orders...), err.Error()))
 			continue
 		} else {
 
@@ -108,6 +138,9 @@ func (market *Market) match() {
 					log.Error(err.Error())
 				} else {
 					log.Errorf("ringhash:%s has been submitted", ringForSubmit.Ringhash.Hex())
+					decision := market.newDecision(miner.DECISION_REJECTED, orderHashes(orders...), "ring has been submitted")
+					decision.Ringhash = ringForSubmit.Ringhash.Hex()
+					market.record(decision)
 				}
 				continue
 			}
@@ -115,11 +148,24 @@ func (market *Market) match() {
 			uniqueId := ringForSubmit.RawRing.GenerateUniqueId()
 			if failedCount, err := RingExecuteFailedCount(uniqueId); nil == err && failedCount > market.matcher.maxFailedCount {
 				log.Debugf("ringSubmitInfo.UniqueId:%s , ringhash: %s , has been failed to submit %d times", uniqueId.Hex(), ringForSubmit.Ringhash.Hex(), failedCount)
+				decision := market.newDecision(miner.DECISION_REJECTED, orderHashes(orders...), fmt.Sprintf("ring failed to submit %d times", failedCount))
+				decision.Ringhash = ringForSubmit.Ringhash.Hex()
+				market.record(decision)
 				continue
 			}
 
+			decision := market.newDecision(miner.DECISION_SUBMITTED, orderHashes(orders...), "")
+			decision.Ringhash = ringForSubmit.Ringhash.Hex()
+			setDecisionValues(decision, ringForSubmit.RawRing.Received, ringForSubmit.RawRing.LegalCost, ringForSubmit.RawRing.Cvs)
 			//todo:for test, release this limit
 			if ringForSubmit.RawRing.Received.Sign() > 0 {
+				if !market.matcher.confirmMarket(market) {
+					decision.Type = miner.DECISION_REJECTED
+					decision.Reason = "market lease lost"
+					market.record(decision)
+					break
+				}
+				market.recordFirstMatch(orders, time.Now().Unix())
 				for _, filledOrder := range ringForSubmit.RawRing.Orders {
 					orderState := market.reduceAmountAfterFilled(filledOrder)
 					isFullFilled := market.om.IsOrderFullFinished(orderState)
@@ -129,10 +175,16 @@ func (market *Market) match() {
 					list = market.reduceReceivedOfCandidateRing(list, filledOrder, isFullFilled)
 				}
 				AddMinedRing(ringForSubmit)
+				if nil != market.matcher.shard {
+					CacheRinghashToInstance(ringForSubmit.RawRing.Hash, market.matcher.shard.instanceId)
+				}
 				ringSubmitInfos = append(ringSubmitInfos, ringForSubmit)
 			} else {
 				log.Debugf("ring:%s will not be submitted,because of received:%s", ringForSubmit.RawRing.Hash.Hex(), ringForSubmit.RawRing.Received.String())
+				decision.Type = miner.DECISION_REJECTED
+				decision.Reason = "received not enough"
 			}
+			market.record(decision)
 		}
 	}
 
@@ -205,27 +257,28 @@ func (market *Market) getOrdersForMatching(delegateAddress common.Address) {
 	market.BtoAOrders = make(map[common.Hash]*types.OrderState)
 
 	// log.Debugf("timing matcher,market tokenA:%s, tokenB:%s, atob hash length:%d, btoa hash length:%d", market.TokenA.Hex(), market.TokenB.Hex(), len(market.AtoBOrderHashesExcludeNextRound), len(market.BtoAOrderHashesExcludeNextRound))
-	currentRoundNumber := market.matcher.lastRoundNumber.Int64()
+	currentRoundNumber := market.roundNumber.Int64()
 	deleyedNumber := market.matcher.delayedNumber + currentRoundNumber
 
-	atoBOrders := market.om.MinerOrders(delegateAddress, market.TokenA, market.TokenB, market.matcher.roundOrderCount, market.matcher.reservedTime, int64(0), currentRoundNumber, &types.OrderDelayList{OrderHash: market.AtoBOrderHashesExcludeNextRound, DelayedCount: deleyedNumber})
+	atoBOrders := market.om.MinerOrders(delegateAddress, market.TokenA, market.TokenB, market.matcher.roundOrderCount, market.matcher.reservedTime, int64(0), currentRoundNumber, market.matcher.priority, &types.OrderDelayList{OrderHash: market.AtoBOrderHashesExcludeNextRound, DelayedCount: deleyedNumber})
 
 	if len(atoBOrders) < market.matcher.roundOrderCount {
 		orderCount := market.matcher.roundOrderCount - len(atoBOrders)
-		orders := market.om.MinerOrders(delegateAddress, market.TokenA, market.TokenB, orderCount, market.matcher.reservedTime, currentRoundNumber+1, currentRoundNumber+market.matcher.delayedNumber)
+		orders := market.om.MinerOrders(delegateAddress, market.TokenA, market.TokenB, orderCount, market.matcher.reservedTime, currentRoundNumber+1, currentRoundNumber+market.matcher.delayedNumber, market.matcher.priority)
 		atoBOrders = append(atoBOrders, orders...)
 	}
 
-	btoAOrders := market.om.MinerOrders(delegateAddress, market.TokenB, market.TokenA, market.matcher.roundOrderCount, market.matcher.reservedTime, int64(0), currentRoundNumber, &types.OrderDelayList{OrderHash: market.BtoAOrderHashesExcludeNextRound, DelayedCount: deleyedNumber})
+	btoAOrders := market.om.MinerOrders(delegateAddress, market.TokenB, market.TokenA, market.matcher.roundOrderCount, market.matcher.reservedTime, int64(0), currentRoundNumber, market.matcher.priority, &types.OrderDelayList{OrderHash: market.BtoAOrderHashesExcludeNextRound, DelayedCount: deleyedNumber})
 	if len(btoAOrders) < market.matcher.roundOrderCount {
 		orderCount := market.matcher.roundOrderCount - len(btoAOrders)
-		orders := market.om.MinerOrders(delegateAddress, market.TokenB, market.TokenA, orderCount, market.matcher.reservedTime, currentRoundNumber+1, currentRoundNumber+market.matcher.delayedNumber)
+		orders := market.om.MinerOrders(delegateAddress, market.TokenB, market.TokenA, orderCount, market.matcher.reservedTime, currentRoundNumber+1, currentRoundNumber+market.matcher.delayedNumber, market.matcher.priority)
 		btoAOrders = append(btoAOrders, orders...)
 	}
 
 	//log.Debugf("#### %s,%s %d,%d %d",market.TokenA.Hex(),market.TokenB.Hex(), len(atoBOrders), len(btoAOrders),market.matcher.roundOrderCount)
 	market.AtoBOrderHashesExcludeNextRound = []common.Hash{}
 	market.BtoAOrderHashesExcludeNextRound = []common.Hash{}
+	market.orderRanks = orderRanks(atoBOrders, btoAOrders)
 
 	for _, order := range atoBOrders {
 		market.reduceRemainedAmountBeforeMatch(order)
@@ -234,7 +287,7 @@ func (market *Market) getOrdersForMatching(delegateAddress common.Address) {
 		} else {
 			market.AtoBOrderHashesExcludeNextRound = append(market.AtoBOrderHashesExcludeNextRound, order.RawOrder.Hash)
 		}
-		log.Debugf("order status in this new round:%s, orderhash:%s, DealtAmountS:%s, ", market.matcher.lastRoundNumber.String(), order.RawOrder.Hash.Hex(), order.DealtAmountS.String())
+		log.Debugf("order status in this new round:%s, orderhash:%s, DealtAmountS:%s, ", market.roundNumber.String(), order.RawOrder.Hash.Hex(), order.DealtAmountS.String())
 	}
 
 	for _, order := range btoAOrders {
@@ -244,8 +297,9 @@ func (market *Market) getOrdersForMatching(delegateAddress common.Address) {
 		} else {
 			market.BtoAOrderHashesExcludeNextRound = append(market.BtoAOrderHashesExcludeNextRound, order.RawOrder.Hash)
 		}
-		log.Debugf("order status in this new round:%s, orderhash:%s, DealtAmountS:%s", market.matcher.lastRoundNumber.String(), order.RawOrder.Hash.Hex(), order.DealtAmountS.String())
+		log.Debugf("order status in this new round:%s, orderhash:%s, DealtAmountS:%s", market.roundNumber.String(), order.RawOrder.Hash.Hex(), order.DealtAmountS.String())
 	}
+	market.updateState()
 }
 
 //sub the matched amount in new round.
@@ -287,6 +341,7 @@ func (market *Market) GenerateCandidateRing(orders ...*types.OrderState) (*Candi
 	for _, order := range orders {
 		if filledOrder, err := market.generateFilledOrder(order); nil != err {
 			log.Errorf("err:%s", err.Error())
+			market.record(market.newDecision(miner.DECISION_REJECTED, orderHashes(orders...), err.Error()))
 			return nil, err
 		} else {
 			filledOrders = append(filledOrders, filledOrder)
@@ -295,9 +350,15 @@ func (market *Market) GenerateCandidateRing(orders ...*types.OrderState) (*Candi
 
 	ringTmp := miner.NewRing(filledOrders)
 	if err := market.matcher.evaluator.ComputeRing(ringTmp); nil != err {
+		decision := market.newDecision(miner.DECISION_REJECTED, orderHashes(orders...), err.Error())
+		setDecisionValues(decision, ringTmp.Received, ringTmp.LegalCost, ringTmp.Cvs)
+		market.record(decision)
 		return nil, err
 	} else {
-		candidateRing := &CandidateRing{cost: ringTmp.LegalCost, received: ringTmp.Received, filledOrders: make(map[common.Hash]*big.Rat)}
+		candidateRing := &CandidateRing{cost: ringTmp.LegalCost, received: ringTmp.Received, cvs: ringTmp.Cvs, filledOrders: make(map[common.Hash]*big.Rat)}
+		for _, order := range orders {
+			candidateRing.rank += market.orderRanks[order.RawOrder.Hash]
+		}
 		for _, filledOrder := range ringTmp.Orders {
 			log.Debugf("match, orderhash:%s, filledOrder.FilledAmountS:%s", filledOrder.OrderState.RawOrder.Hash.Hex(), filledOrder.FillAmountS.FloatString(3))
 			candidateRing.filledOrders[filledOrder.OrderState.RawOrder.Hash] = filledOrder.FillAmountS
@@ -348,7 +409,7 @@ func (market *Market) generateRingSubmitInfo(orders ...*types.OrderState) (*type
 	}
 }
 
-func NewMarket(protocolAddress *ethaccessor.ProtocolAddress, tokenS, tokenB common.Address, matcher *TimingMatcher, om ordermanager.OrderManager) *Market {
+func NewMarket(protocolAddress *ethaccessor.ProtocolAddress, tokenS, tokenB common.Address, matcher *TimingMatcher, om orderSource) *Market {
 
 	m := &Market{}
 	m.om = om
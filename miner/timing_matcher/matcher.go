@@ -30,19 +30,34 @@ import (
 	"github.com/Loopring/relay/log"
 	marketLib "github.com/Loopring/relay/market"
 	marketUtilLib "github.com/Loopring/relay/market/util"
-	"strings"
+	"github.com/Loopring/relay/types"
 )
 
 /**
 定时从ordermanager中拉取n条order数据进行匹配成环，如果成环则通过调用evaluator进行费用估计，然后提交到submitter进行提交到以太坊
 */
 
+// orderSource 撮合需要的订单来源,正常运行时为OrderManager,回测时为ordermanager.ReplaySource
+type orderSource interface {
+	MinerOrders(protocol, tokenS, tokenB common.Address, length int, reservedTime, startBlockNumber, endBlockNumber int64, priority types.OrderPriority, filterOrderHashLists ...*types.OrderDelayList) []*types.OrderState
+	GetOrderBook(protocol, tokenS, tokenB common.Address, length int) ([]types.OrderState, error)
+	IsOrderFullFinished(state *types.OrderState) bool
+	IsValueDusted(tokenAddress common.Address, value *big.Rat) bool
+}
+
+type balanceSource interface {
+	GetBalanceAndAllowance(owner, token, spender common.Address) (balance, allowance *big.Int, err error)
+}
+
+type ringGenerator interface {
+	GenerateRingSubmitInfo(ringState *types.Ring) (*types.RingSubmitInfo, error)
+}
+
 type TimingMatcher struct {
 	//rounds          *RoundStates
 	markets         []*Market
-	submitter       *miner.RingSubmitter
+	submitter       ringGenerator
 	evaluator       *miner.Evaluator
-	lastRoundNumber *big.Int
 	duration        *big.Int
 	lagBlocks       int64
 	roundOrderCount int
@@ -51,14 +66,38 @@ type TimingMatcher struct {
 
 	maxCacheRoundsLength int
 	delayedNumber        int64
-	accountManager       *marketLib.AccountManager
+	accountManager       balanceSource
 	isOrdersReady        bool
 	db                   dao.RdsService
 
+	strategies   map[string]MatchStrategy
+	reservations *miner.RingReservations
+	decisions    *miner.DecisionLog
+	priority     types.OrderPriority
+	waitMetrics  *miner.OrderWaitMetrics
+	shard        *marketShard
+
 	stopFuncs []func()
 }
 
-func NewTimingMatcher(matcherOptions *config.TimingMatcher, submitter *miner.RingSubmitter, evaluator *miner.Evaluator, om ordermanager.OrderManager, accountManager *marketLib.AccountManager, rds dao.RdsService) *TimingMatcher {
+func NewTimingMatcher(options config.MinerOptions, submitter *miner.RingSubmitter, evaluator *miner.Evaluator, om ordermanager.OrderManager, accountManager *marketLib.AccountManager, rds dao.RdsService) *TimingMatcher {
+	matcher := newTimingMatcher(options, submitter, evaluator, om, accountManager, rds)
+	// 配置了ringhash registry时避开其他miner已登记的环路
+	if nil != ethaccessor.RinghashRegistryAbi() {
+		var ttl int64
+		if nil != options.CommitReveal {
+			ttl = options.CommitReveal.ReservationTtlTime
+		}
+		matcher.reservations = miner.NewRingReservations(rds, common.HexToAddress(options.FeeReceipt), ttl)
+	}
+	if nil != options.MarketShard && options.MarketShard.Open {
+		matcher.shard = newMarketShard(matcher, options.MarketShard)
+	}
+	return matcher
+}
+
+func newTimingMatcher(options config.MinerOptions, submitter ringGenerator, evaluator *miner.Evaluator, om orderSource, accountManager balanceSource, rds dao.RdsService) *TimingMatcher {
+	matcherOptions := options.TimingMatcher
 	matcher := &TimingMatcher{}
 	matcher.submitter = submitter
 	matcher.evaluator = evaluator
@@ -83,8 +122,17 @@ func NewTimingMatcher(matcherOptions *config.TimingMatcher, submitter *miner.Rin
 	matcher.duration = big.NewInt(matcherOptions.Duration)
 	matcher.delayedNumber = matcherOptions.DelayedNumber
 
-	matcher.lastRoundNumber = big.NewInt(0)
+	matcher.decisions = miner.NewDecisionLog(matcherOptions.DecisionLogSize)
+	matcher.waitMetrics = miner.NewOrderWaitMetrics(matcherOptions.WaitMetricsSize)
+	if priority, err := types.NewOrderPriority(matcherOptions.OrderPriority, matcherOptions.AgeBoostPercentage, matcherOptions.AgeBoostInterval, matcherOptions.MaxAgeBoostPercentage); nil != err {
+		log.Errorf("timing matcher, %s, use %s instead", err.Error(), types.ORDER_PRIORITY_PRICE_TIME)
+		matcher.priority, _ = types.NewOrderPriority(types.ORDER_PRIORITY_PRICE_TIME, 0, 0, 0)
+	} else {
+		matcher.priority = priority
+	}
+
 	matcher.stopFuncs = []func(){}
+	matcher.strategies = newMatchStrategies(matcher, options.EventMatcher)
 
 	for _, pair := range marketUtilLib.AllTokenPairs {
 		inited := false
@@ -106,39 +154,77 @@ func NewTimingMatcher(matcherOptions *config.TimingMatcher, submitter *miner.Rin
 				m.AtoBOrderHashesExcludeNextRound = []common.Hash{}
 				m.BtoAOrderHashesExcludeNextRound = []common.Hash{}
 				matcher.markets = append(matcher.markets, m)
+				matcher.strategyOf(m, options.MatchStrategies).AddMarket(m)
 			}
 		}
 	}
 	return matcher
 }
 
-func (matcher *TimingMatcher) cleanMissedCache() {
-	//如果程序不正确的停止，清除错误的缓存数据
-	if ringhashes, err := CachedRinghashes(); nil == err {
-		for _, ringhash := range ringhashes {
-
-			if submitInfo, err1 := matcher.db.GetRingForSubmitByHash(ringhash); nil == err1 {
-				if submitInfo.ID <= 0 {
-					RemoveMinedRingAndReturnOrderhashes(ringhash)
-					//cache.Del(RingHashPrefix + strings.ToLower(ringhash.Hex()))
-				}
-			} else {
-				if strings.Contains(err1.Error(), "record not found") {
-					RemoveMinedRingAndReturnOrderhashes(ringhash)
-				}
-				log.Errorf("err:%s", err1.Error())
+// ownsMarket 未开启market shard时撮合所有market
+func (matcher *TimingMatcher) ownsMarket(m *Market) bool {
+	return nil == matcher.shard || matcher.shard.owns(m)
+}
+
+func (matcher *TimingMatcher) confirmMarket(m *Market) bool {
+	return nil == matcher.shard || matcher.shard.confirm(m)
+}
+
+func (matcher *TimingMatcher) isReservedByOthers(orders ...*types.OrderState) bool {
+	if nil == matcher.reservations {
+		return false
+	}
+	orderhashes := []common.Hash{}
+	for _, order := range orders {
+		orderhashes = append(orderhashes, order.RawOrder.Hash)
+	}
+	return matcher.reservations.IsReserved(orderhashes...)
+}
+
+// recoverCache 程序不正确停止后,只保留outbox中还未完成(queued、registering、sent)的环路缓存,其余的清除
+func (matcher *TimingMatcher) recoverCache() {
+	ringhashes, err := CachedRinghashes()
+	if nil != err {
+		log.Errorf("err:%s", err.Error())
+		return
+	}
+	infos, err := matcher.db.GetRingSubmitInfosBySubmitStatus([]types.RingSubmitStatus{types.RING_SUBMIT_QUEUED, types.RING_SUBMIT_REGISTERING, types.RING_SUBMIT_SENT})
+	if nil != err {
+		log.Errorf("err:%s", err.Error())
+		return
+	}
+	unresolved := make(map[common.Hash]bool)
+	for _, info := range infos {
+		unresolved[common.HexToHash(info.RingHash)] = true
+	}
+	for _, ringhash := range ringhashes {
+		if unresolved[ringhash] {
+			continue
+		}
+		// 其他存活实例提交的环路由其自己清除,已停止的实例(如重启后pid变化)的环路由当前实例接管
+		if nil != matcher.shard {
+			if instanceId, err := GetInstanceByRinghash(ringhash); nil == err && "" != instanceId && instanceId != matcher.shard.instanceId && isShardInstanceAlive(instanceId) {
+				continue
 			}
 		}
-	} else {
-		log.Errorf("err:%s", err.Error())
+		RemoveMinedRingAndReturnOrderhashes(ringhash)
 	}
 }
 
 func (matcher *TimingMatcher) Start() {
 	matcher.listenSubmitEvent()
 	matcher.listenOrderReady()
-	matcher.listenTimingRound()
-	matcher.cleanMissedCache()
+	if nil != matcher.shard {
+		matcher.shard.Start()
+	}
+	for _, strategy := range matcher.strategies {
+		strategy.Start()
+		matcher.stopFuncs = append(matcher.stopFuncs, strategy.Stop)
+	}
+	if nil != matcher.shard {
+		matcher.stopFuncs = append(matcher.stopFuncs, matcher.shard.Stop)
+	}
+	matcher.recoverCache()
 
 	//syncWatcher := &eventemitter.Watcher{Concurrent: false, Handle: func(eventData eventemitter.EventData) error {
 	//	log.Debugf("TimingMatcher Start......")
@@ -0,0 +1,218 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package timing_matcher
+
+import (
+	"fmt"
+	"github.com/Loopring/relay/cache"
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ShardInstancePrefix  = "matcher_shard_instance_"
+	ShardInstancesKey    = "matcher_shard_instances"
+	ShardMarketPrefix    = "matcher_shard_market_"
+	defaultShardLeaseTtl = 15
+)
+
+// marketShard 多个miner实例共用redis,每个实例最多持有ceil(market数/存活实例数)个market的lease,
+// 持有的market超出时释放多余的,实例停止续约后其lease过期由其他实例接管。
+// 订单只属于一个market,同一时间只有一个实例撮合,订单已撮合的数量依然通过redis中的环路缓存共享
+type marketShard struct {
+	matcher    *TimingMatcher
+	instanceId string
+	ttl        int64
+
+	mtx      sync.RWMutex
+	owned    map[*Market]bool
+	stopChan chan bool
+}
+
+func newMarketShard(matcher *TimingMatcher, options *config.MarketShard) *marketShard {
+	shard := &marketShard{matcher: matcher, owned: make(map[*Market]bool)}
+	shard.instanceId = options.InstanceId
+	if "" == shard.instanceId {
+		hostname, _ := os.Hostname()
+		shard.instanceId = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	shard.ttl = options.LeaseTtl
+	if shard.ttl <= 0 {
+		shard.ttl = defaultShardLeaseTtl
+	}
+	return shard
+}
+
+func (shard *marketShard) instanceKey() string {
+	return ShardInstancePrefix + shard.instanceId
+}
+
+func (shard *marketShard) leaseKey(m *Market) string {
+	return ShardMarketPrefix + strings.ToLower(m.protocolImpl.ContractAddress.Hex()) + "_" + strings.ToLower(m.name())
+}
+
+func (shard *marketShard) Start() {
+	shard.stopChan = make(chan bool)
+	shard.balance()
+	go func() {
+		for {
+			select {
+			case <-time.After(time.Duration(shard.ttl) * time.Second / 3):
+				shard.balance()
+			case <-shard.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 主动释放lease,其他实例在下一次balance时即可接管
+func (shard *marketShard) Stop() {
+	if nil != shard.stopChan {
+		shard.stopChan <- true
+		close(shard.stopChan)
+	}
+	for _, m := range shard.ownedMarkets() {
+		shard.release(m)
+	}
+	cache.SRem(ShardInstancesKey, []byte(shard.instanceId))
+	cache.Del(shard.instanceKey())
+}
+
+// isShardInstanceAlive 实例的心跳key未过期
+func isShardInstanceAlive(instanceId string) bool {
+	exists, err := cache.Exists(ShardInstancePrefix + instanceId)
+	return nil != err || exists
+}
+
+// liveInstances 实例集合中心跳已过期的实例直接移除,避免每次balance都执行keys
+func (shard *marketShard) liveInstances() int {
+	members, err := cache.SMembers(ShardInstancesKey)
+	if nil != err {
+		log.Errorf("market shard, instance:%s get instances err:%s", shard.instanceId, err.Error())
+		return 1
+	}
+	instances := 0
+	for _, member := range members {
+		if isShardInstanceAlive(string(member)) {
+			instances += 1
+		} else {
+			cache.SRem(ShardInstancesKey, member)
+		}
+	}
+	if instances <= 0 {
+		instances = 1
+	}
+	return instances
+}
+
+func (shard *marketShard) owns(m *Market) bool {
+	shard.mtx.RLock()
+	defer shard.mtx.RUnlock()
+
+	return shard.owned[m]
+}
+
+// confirm 提交环路之前确认lease依然有效并续约,避免lease过期后与接管的实例同时使用订单的剩余数量
+func (shard *marketShard) confirm(m *Market) bool {
+	if !shard.owns(m) {
+		return false
+	}
+	ok, err := cache.CompareAndExpire(shard.leaseKey(m), []byte(shard.instanceId), shard.ttl)
+	if nil != err || !ok {
+		log.Errorf("market shard, instance:%s lost lease of market:%s", shard.instanceId, m.name())
+		shard.mtx.Lock()
+		delete(shard.owned, m)
+		shard.mtx.Unlock()
+		return false
+	}
+	return true
+}
+
+func (shard *marketShard) ownedMarkets() []*Market {
+	shard.mtx.RLock()
+	defer shard.mtx.RUnlock()
+
+	markets := []*Market{}
+	for _, m := range shard.matcher.markets {
+		if shard.owned[m] {
+			markets = append(markets, m)
+		}
+	}
+	return markets
+}
+
+func (shard *marketShard) balance() {
+	if err := cache.Set(shard.instanceKey(), []byte(shard.instanceId), shard.ttl); nil != err {
+		log.Errorf("market shard, instance:%s heartbeat err:%s", shard.instanceId, err.Error())
+		return
+	}
+	if err := cache.SAdd(ShardInstancesKey, 0, []byte(shard.instanceId)); nil != err {
+		log.Errorf("market shard, instance:%s register err:%s", shard.instanceId, err.Error())
+	}
+	instances := shard.liveInstances()
+	quota := (len(shard.matcher.markets) + instances - 1) / instances
+
+	owned := []*Market{}
+	for _, m := range shard.ownedMarkets() {
+		if shard.confirm(m) {
+			owned = append(owned, m)
+		}
+	}
+
+	for len(owned) > quota {
+		shard.release(owned[len(owned)-1])
+		owned = owned[:len(owned)-1]
+	}
+
+	for _, m := range shard.matcher.markets {
+		if len(owned) >= quota {
+			break
+		}
+		if shard.owns(m) {
+			continue
+		}
+		if ok, err := cache.SetNX(shard.leaseKey(m), []byte(shard.instanceId), shard.ttl); nil == err && ok {
+			log.Infof("market shard, instance:%s acquired market:%s", shard.instanceId, m.name())
+			shard.mtx.Lock()
+			shard.owned[m] = true
+			shard.mtx.Unlock()
+			owned = append(owned, m)
+		}
+	}
+}
+
+// release 先停止新的撮合,等待进行中的撮合结束后再删除lease
+func (shard *marketShard) release(m *Market) {
+	shard.mtx.Lock()
+	delete(shard.owned, m)
+	shard.mtx.Unlock()
+
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	if _, err := cache.CompareAndDel(shard.leaseKey(m), []byte(shard.instanceId)); nil != err {
+		log.Errorf("market shard, instance:%s release market:%s err:%s", shard.instanceId, m.name(), err.Error())
+	} else {
+		log.Infof("market shard, instance:%s released market:%s", shard.instanceId, m.name())
+	}
+}
@@ -0,0 +1,282 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package timing_matcher
+
+import (
+	"github.com/Loopring/relay/config"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	marketUtilLib "github.com/Loopring/relay/market/util"
+	"github.com/Loopring/relay/miner"
+	"github.com/Loopring/relay/types"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	STRATEGY_TIMING = "timing"
+	STRATEGY_EVENT  = "event"
+
+	defaultEventDebounce = 200
+)
+
+// MatchStrategy 决定market什么时候开始撮合,撮合本身以及提交由TimingMatcher完成
+type MatchStrategy interface {
+	Start()
+	Stop()
+	AddMarket(m *Market)
+}
+
+func newMatchStrategies(matcher *TimingMatcher, eventOptions *config.EventMatcher) map[string]MatchStrategy {
+	debounce := int64(defaultEventDebounce)
+	if nil != eventOptions && eventOptions.Debounce > 0 {
+		debounce = eventOptions.Debounce
+	}
+
+	strategies := make(map[string]MatchStrategy)
+	strategies[STRATEGY_TIMING] = &timingStrategy{matcher: matcher}
+	strategies[STRATEGY_EVENT] = &eventStrategy{matcher: matcher, debounce: time.Duration(debounce) * time.Millisecond}
+	return strategies
+}
+
+func (matcher *TimingMatcher) strategyOf(m *Market, marketStrategies map[string]string) MatchStrategy {
+	mkt, err := marketUtilLib.WrapMarketByAddress(m.TokenA.Hex(), m.TokenB.Hex())
+	if nil != err {
+		return matcher.strategies[STRATEGY_TIMING]
+	}
+	for k, v := range marketStrategies {
+		if !strings.EqualFold(k, mkt) {
+			continue
+		}
+		if strategy, exists := matcher.strategies[strings.ToLower(v)]; exists {
+			log.Debugf("timing matcher,market:%s use %s strategy", mkt, v)
+			return strategy
+		} else {
+			log.Errorf("timing matcher,market:%s strategy:%s not supported, use timing", mkt, v)
+		}
+	}
+	return matcher.strategies[STRATEGY_TIMING]
+}
+
+func newRoundNumber() *big.Int {
+	return big.NewInt(time.Now().UnixNano() / 1e6)
+}
+
+// timingStrategy 每隔duration毫秒对所有market进行一轮撮合
+type timingStrategy struct {
+	matcher  *TimingMatcher
+	markets  []*Market
+	stopChan chan bool
+}
+
+func (s *timingStrategy) AddMarket(m *Market) {
+	s.markets = append(s.markets, m)
+}
+
+func (s *timingStrategy) Start() {
+	if len(s.markets) <= 0 {
+		return
+	}
+	s.stopChan = make(chan bool)
+
+	matchFunc := func() {
+		if !s.matcher.isOrdersReady {
+			return
+		}
+		roundNumber := newRoundNumber()
+		var wg sync.WaitGroup
+		for _, market := range s.markets {
+			wg.Add(1)
+			go func(m *Market) {
+				defer func() {
+					wg.Add(-1)
+				}()
+				m.match(roundNumber)
+			}(market)
+		}
+		wg.Wait()
+	}
+	go func() {
+		matchFunc()
+		for {
+			select {
+			case <-time.After(time.Duration(s.matcher.duration.Int64()) * time.Millisecond):
+				matchFunc()
+			case <-s.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+func (s *timingStrategy) Stop() {
+	if nil != s.stopChan {
+		s.stopChan <- true
+		close(s.stopChan)
+	}
+}
+
+// eventStrategy 深度变化并且买卖盘出现交叉时撮合,debounce时间内的多次变化只撮合一次。
+// 深度事件只在relay进程内发出,一个duration内没有收到深度事件的market按timing的间隔撮合
+type eventStrategy struct {
+	matcher  *TimingMatcher
+	debounce time.Duration
+	markets  []*Market
+	watcher  *eventemitter.Watcher
+	stopChan chan bool
+
+	mtx       sync.Mutex
+	pending   map[*Market]bool
+	matching  map[*Market]bool
+	lastEvent map[*Market]time.Time
+}
+
+func (s *eventStrategy) AddMarket(m *Market) {
+	s.markets = append(s.markets, m)
+}
+
+func (s *eventStrategy) Start() {
+	if len(s.markets) <= 0 {
+		return
+	}
+	s.pending = make(map[*Market]bool)
+	s.matching = make(map[*Market]bool)
+	s.lastEvent = make(map[*Market]time.Time)
+	s.watcher = &eventemitter.Watcher{Concurrent: false, Handle: s.handleDepthUpdated}
+	eventemitter.On(eventemitter.DepthUpdated, s.watcher)
+
+	s.stopChan = make(chan bool)
+	go s.fallback(s.stopChan)
+}
+
+func (s *eventStrategy) Stop() {
+	if nil != s.watcher {
+		eventemitter.Un(eventemitter.DepthUpdated, s.watcher)
+	}
+	if nil != s.stopChan {
+		close(s.stopChan)
+		s.stopChan = nil
+	}
+}
+
+func (s *eventStrategy) handleDepthUpdated(input eventemitter.EventData) error {
+	event, ok := input.(types.DepthUpdateEvent)
+	if !ok {
+		return nil
+	}
+
+	for _, m := range s.markets {
+		if !strings.EqualFold(m.protocolImpl.DelegateAddress.Hex(), event.DelegateAddress) {
+			continue
+		}
+		if mkt, err := marketUtilLib.WrapMarketByAddress(m.TokenA.Hex(), m.TokenB.Hex()); nil != err || !strings.EqualFold(mkt, event.Market) {
+			continue
+		}
+		s.schedule(m)
+	}
+	return nil
+}
+
+// schedule 深度事件可能先于订单入库发出,延迟debounce之后再检查是否交叉
+func (s *eventStrategy) schedule(m *Market) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+
+	s.lastEvent[m] = time.Now()
+	if s.pending[m] {
+		return
+	}
+	s.pending[m] = true
+
+	time.AfterFunc(s.debounce, func() {
+		s.mtx.Lock()
+		delete(s.pending, m)
+		s.mtx.Unlock()
+
+		if !s.matcher.isOrdersReady || !s.crossed(m) {
+			return
+		}
+		log.Debugf("event matcher,market %s -> %s crossed, start matching", m.TokenA.Hex(), m.TokenB.Hex())
+		s.match(m, newRoundNumber())
+	})
+}
+
+// fallback 与timingStrategy相同的间隔,只撮合最近一个间隔内没有收到深度事件的market
+func (s *eventStrategy) fallback(stopChan chan bool) {
+	interval := time.Duration(s.matcher.duration.Int64()) * time.Millisecond
+	for {
+		select {
+		case <-time.After(interval):
+			if !s.matcher.isOrdersReady {
+				continue
+			}
+			roundNumber := newRoundNumber()
+			var wg sync.WaitGroup
+			for _, m := range s.markets {
+				s.mtx.Lock()
+				idle := time.Since(s.lastEvent[m]) >= interval
+				s.mtx.Unlock()
+				if !idle {
+					continue
+				}
+				wg.Add(1)
+				go func(m *Market) {
+					defer wg.Done()
+					s.match(m, roundNumber)
+				}(m)
+			}
+			wg.Wait()
+		case <-stopChan:
+			return
+		}
+	}
+}
+
+// match 事件触发与定时撮合可能同时发生,同一market同时只进行一轮撮合
+func (s *eventStrategy) match(m *Market, roundNumber *big.Int) {
+	s.mtx.Lock()
+	if s.matching[m] {
+		s.mtx.Unlock()
+		return
+	}
+	s.matching[m] = true
+	s.mtx.Unlock()
+
+	defer func() {
+		s.mtx.Lock()
+		delete(s.matching, m)
+		s.mtx.Unlock()
+	}()
+	m.match(roundNumber)
+}
+
+func (s *eventStrategy) crossed(m *Market) bool {
+	delegate := m.protocolImpl.DelegateAddress
+	atoB, err := m.om.GetOrderBook(delegate, m.TokenA, m.TokenB, 1)
+	if nil != err || len(atoB) <= 0 {
+		return false
+	}
+	btoA, err := m.om.GetOrderBook(delegate, m.TokenB, m.TokenA, 1)
+	if nil != err || len(btoA) <= 0 {
+		return false
+	}
+	return miner.PriceValid(&atoB[0], &btoA[0])
+}
@@ -240,6 +240,8 @@ type CandidateRing struct {
 	filledOrders map[common.Hash]*big.Rat
 	received     *big.Rat
 	cost         *big.Rat
+	cvs          *big.Int
+	rank         int // 环路中订单按priority加载时的位置之和,收益相同时rank小的优先
 }
 
 type CandidateRingList []CandidateRing
@@ -251,5 +253,8 @@ func (ringList CandidateRingList) Swap(i, j int) {
 	ringList[i], ringList[j] = ringList[j], ringList[i]
 }
 func (ringList CandidateRingList) Less(i, j int) bool {
-	return ringList[i].received.Cmp(ringList[j].received) > 0
+	if cmp := ringList[i].received.Cmp(ringList[j].received); cmp != 0 {
+		return cmp > 0
+	}
+	return ringList[i].rank < ringList[j].rank
 }
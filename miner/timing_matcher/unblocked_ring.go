@@ -40,6 +40,7 @@ const (
 	FailedRingPrefix         = "failed_ring_"
 	FailedOrderPrefix        = "failed_order_"
 	RinghashToUniqueIdPrefix = "ringhash_uniqid_"
+	RinghashToInstancePrefix = "ringhash_instance_"
 	cacheTtl                 = 86400 * 2
 )
 
@@ -195,6 +196,7 @@ func RemoveMinedRingAndReturnOrderhashes(ringhash common.Hash) ([]common.Hash, e
 		}
 	}
 
+	cache.Del(RinghashToInstancePrefix + strings.ToLower(ringhash.Hex()))
 	if err := cache.Del(cacheKey); nil != err {
 		return orderhashes, err
 	} else {
@@ -293,6 +295,16 @@ func GetUniqueIdByRinghash(ringhash common.Hash) (common.Hash, error) {
 	}
 }
 
+// CacheRinghashToInstance 开启market shard时记录提交环路的miner实例
+func CacheRinghashToInstance(ringhash common.Hash, instanceId string) {
+	cache.Set(RinghashToInstancePrefix+strings.ToLower(ringhash.Hex()), []byte(instanceId), cacheTtl)
+}
+
+func GetInstanceByRinghash(ringhash common.Hash) (string, error) {
+	data, err := cache.Get(RinghashToInstancePrefix + strings.ToLower(ringhash.Hex()))
+	return string(data), err
+}
+
 func AddFailedRingCache(uniqueId, txhash common.Hash, orderhashes []common.Hash) {
 	cache.SAdd(FailedRingPrefix+strings.ToLower(uniqueId.Hex()), cacheTtl, []byte(strings.ToLower(txhash.Hex())))
 	for _, orderhash := range orderhashes {
@@ -307,3 +319,19 @@ func RingExecuteFailedCount(uniqueId common.Hash) (int64, error) {
 func OrderExecuteFailedCount(orderhash common.Hash) (int64, error) {
 	return cache.SCard(FailedOrderPrefix + strings.ToLower(orderhash.Hex()))
 }
+
+// CachedRingOrderhashes 环路缓存中的订单,不修改缓存
+func CachedRingOrderhashes(ringhash common.Hash) ([]common.Hash, error) {
+	c := ringCache{}
+	c.ringhash = ringhash
+	orderhashes := []common.Hash{}
+	data, err := cache.SMembers(c.cacheKey())
+	if nil != err {
+		return orderhashes, err
+	}
+	for _, d := range data {
+		orderhash, _, _ := c.parseFiled(d)
+		orderhashes = append(orderhashes, orderhash)
+	}
+	return orderhashes, nil
+}
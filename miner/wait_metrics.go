@@ -0,0 +1,115 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package miner
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+const defaultWaitMetricsSize = 1000
+
+// OrderWaitStats 订单从创建到第一次被撮合的等待时间,单位为秒,分位数按最近的样本计算
+type OrderWaitStats struct {
+	Market  string `json:"market,omitempty"`
+	Count   int64  `json:"count"`
+	Samples int    `json:"samples"`
+	Average int64  `json:"average"`
+	P50     int64  `json:"p50"`
+	P90     int64  `json:"p90"`
+	Max     int64  `json:"max"`
+}
+
+type orderWaitSample struct {
+	market string
+	wait   int64
+}
+
+// OrderWaitMetrics 与DecisionLog一样只保留最近size个样本,Count为启动以来的累计值
+type OrderWaitMetrics struct {
+	mtx     sync.RWMutex
+	samples []orderWaitSample
+	next    int
+	full    bool
+	counts  map[string]int64
+}
+
+func NewOrderWaitMetrics(size int) *OrderWaitMetrics {
+	if size <= 0 {
+		size = defaultWaitMetricsSize
+	}
+	return &OrderWaitMetrics{samples: make([]orderWaitSample, size), counts: make(map[string]int64)}
+}
+
+func (m *OrderWaitMetrics) Add(market string, wait int64) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+
+	if wait < 0 {
+		wait = 0
+	}
+	m.samples[m.next] = orderWaitSample{market: market, wait: wait}
+	m.next++
+	if m.next >= len(m.samples) {
+		m.next = 0
+		m.full = true
+	}
+	m.counts[market]++
+}
+
+// Stats market为空时统计所有market
+func (m *OrderWaitMetrics) Stats(market string) OrderWaitStats {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	stats := OrderWaitStats{Market: market}
+	for mkt, count := range m.counts {
+		if "" == market || strings.EqualFold(market, mkt) {
+			stats.Count += count
+		}
+	}
+
+	count := m.next
+	if m.full {
+		count = len(m.samples)
+	}
+	waits := []int64{}
+	var sum int64
+	for _, sample := range m.samples[:count] {
+		if "" != market && !strings.EqualFold(market, sample.market) {
+			continue
+		}
+		waits = append(waits, sample.wait)
+		sum += sample.wait
+	}
+	if len(waits) == 0 {
+		return stats
+	}
+
+	sort.Slice(waits, func(i, j int) bool {
+		return waits[i] < waits[j]
+	})
+	stats.Samples = len(waits)
+	stats.Average = sum / int64(len(waits))
+	stats.P50 = waits[(len(waits)-1)*50/100]
+	stats.P90 = waits[(len(waits)-1)*90/100]
+	stats.Max = waits[len(waits)-1]
+	return stats
+}
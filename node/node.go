@@ -67,6 +67,7 @@ type RelayNode struct {
 	extractorService extractor.ExtractorService
 	trendManager     market.TrendManager
 	tickerCollector  market.CollectorImpl
+	triggerManager   *ordermanager.TriggerManager
 	jsonRpcService   gateway.JsonrpcServiceImpl
 	websocketService gateway.WebsocketServiceImpl
 	socketIOService  gateway.SocketIOServiceImpl
@@ -81,6 +82,7 @@ func (n *RelayNode) Start() {
 	//gateway.NewJsonrpcService("8080").Start()
 	fmt.Println("step in relay node start")
 	n.tickerCollector.Start()
+	n.triggerManager.Start()
 	go n.jsonRpcService.Start()
 	//n.websocketService.Start()
 	go n.socketIOService.Start()
@@ -89,14 +91,19 @@ func (n *RelayNode) Start() {
 
 func (n *RelayNode) Stop() {
 	n.txManager.Stop()
+	n.triggerManager.Stop()
 }
 
 type MineNode struct {
-	miner *miner.Miner
+	miner          *miner.Miner
+	jsonRpcService *gateway.JsonrpcServiceImpl
 }
 
 func (n *MineNode) Start() {
 	n.miner.Start()
+	if nil != n.jsonRpcService {
+		go n.jsonRpcService.Start()
+	}
 }
 func (n *MineNode) Stop() {
 	n.miner.Stop()
@@ -115,8 +122,8 @@ func NewNode(logger *zap.Logger, globalConfig *config.GlobalConfig) *Node {
 	n.registerMarketCap()
 	n.registerAccessor()
 	n.registerUserManager()
-	n.registerOrderManager()
 	n.registerAccountManager()
+	n.registerOrderManager()
 	n.registerGateway()
 	n.registerCrypto(nil)
 
@@ -128,6 +135,7 @@ func NewNode(logger *zap.Logger, globalConfig *config.GlobalConfig) *Node {
 		n.registerMineNode()
 		n.registerRelayNode()
 	}
+	n.registerMinerService()
 
 	return n
 }
@@ -138,6 +146,7 @@ func (n *Node) registerRelayNode() {
 	n.registerTransactionManager()
 	n.registerTrendManager()
 	n.registerTickerCollector()
+	n.registerTriggerManager()
 	n.registerWalletService()
 	n.registerJsonRpcService()
 	n.registerWebsocketService()
@@ -194,7 +203,23 @@ func (n *Node) Stop() {
 
 func (n *Node) registerCrypto(ks *keystore.KeyStore) {
 	c := crypto.NewKSCrypto(true, ks)
-	crypto.Initialize(c)
+	if len(n.globalConfig.Keystore.RemoteSigners) <= 0 {
+		crypto.Initialize(c)
+		return
+	}
+
+	routed := crypto.NewRoutedCrypto(true, c)
+	for _, options := range n.globalConfig.Keystore.RemoteSigners {
+		signer, err := crypto.NewRemoteSigner(true, options)
+		if nil != err {
+			log.Fatalf("err:%s", err.Error())
+		}
+		for _, addr := range signer.Addresses() {
+			log.Infof("address:%s will be signed by remote signer:%s", addr.Hex(), options.Url)
+			routed.Route(addr, signer)
+		}
+	}
+	crypto.Initialize(routed)
 }
 
 func (n *Node) registerMysql() {
@@ -218,7 +243,12 @@ func (n *Node) registerIPFSSubService() {
 }
 
 func (n *Node) registerOrderManager() {
-	n.orderManager = ordermanager.NewOrderManager(&n.globalConfig.OrderManager, n.rdsService, n.userManager, n.marketCapProvider)
+	// miner单独部署时收不到订单及链上事件,本地订单簿无法更新
+	if MODEL_MINER == n.globalConfig.Mode && n.globalConfig.OrderManager.LocalOrderBook {
+		log.Warnf("order manager,local order book is not supported in miner mode, query mysql instead")
+		n.globalConfig.OrderManager.LocalOrderBook = false
+	}
+	n.orderManager = ordermanager.NewOrderManager(&n.globalConfig.OrderManager, n.rdsService, n.userManager, n.marketCapProvider, &n.accountManager)
 }
 
 func (n *Node) registerTrendManager() {
@@ -237,9 +267,13 @@ func (n *Node) registerTickerCollector() {
 	n.relayNode.tickerCollector = *market.NewCollector(n.globalConfig.Market.CronJobLock)
 }
 
+func (n *Node) registerTriggerManager() {
+	n.relayNode.triggerManager = ordermanager.NewTriggerManager(n.rdsService, n.orderManager, n.marketCapProvider, &n.relayNode.trendManager, &n.relayNode.tickerCollector)
+}
+
 func (n *Node) registerWalletService() {
 	n.relayNode.walletService = *gateway.NewWalletService(n.relayNode.trendManager, n.orderManager,
-		n.accountManager, n.marketCapProvider, n.relayNode.tickerCollector, n.rdsService, n.globalConfig.Market.OldVersionWethAddress)
+		n.accountManager, n.marketCapProvider, n.relayNode.tickerCollector, n.rdsService, n.relayNode.triggerManager, n.globalConfig.Market.OldVersionWethAddress)
 }
 
 func (n *Node) registerJsonRpcService() {
@@ -261,13 +295,27 @@ func (n *Node) registerMiner() {
 		log.Fatalf("failed to init submitter, error:%s", err.Error())
 	}
 	evaluator := miner.NewEvaluator(n.marketCapProvider, n.globalConfig.Miner)
-	matcher := timing_matcher.NewTimingMatcher(n.globalConfig.Miner.TimingMatcher, submitter, evaluator, n.orderManager, &n.accountManager, n.rdsService)
+	matcher := timing_matcher.NewTimingMatcher(n.globalConfig.Miner, submitter, evaluator, n.orderManager, &n.accountManager, n.rdsService)
 	evaluator.SetMatcher(matcher)
 	n.mineNode.miner = miner.NewMiner(submitter, matcher, evaluator, n.marketCapProvider)
 }
 
+// registerMinerService full模式下与relay共用jsonrpc端口,miner模式单独启动
+func (n *Node) registerMinerService() {
+	if nil == n.mineNode {
+		return
+	}
+	minerService := miner.NewMinerService(n.rdsService, n.mineNode.miner)
+	if nil != n.relayNode {
+		n.relayNode.jsonRpcService.RegisterService("miner", minerService)
+	} else {
+		n.mineNode.jsonRpcService = gateway.NewJsonrpcService(n.globalConfig.Jsonrpc.Port, nil)
+		n.mineNode.jsonRpcService.RegisterService("miner", minerService)
+	}
+}
+
 func (n *Node) registerGateway() {
-	gateway.Initialize(&n.globalConfig.GatewayFilters, &n.globalConfig.Gateway, &n.globalConfig.Ipfs, n.orderManager, n.marketCapProvider, n.accountManager)
+	gateway.Initialize(&n.globalConfig.GatewayFilters, &n.globalConfig.Gateway, &n.globalConfig.Ipfs, n.orderManager, n.marketCapProvider, n.accountManager, n.userManager)
 }
 
 func (n *Node) registerUserManager() {
@@ -54,6 +54,14 @@ func (p *ForkProcessor) Fork(event *types.ForkedEvent) error {
 	from := event.ForkBlock.Int64()
 	to := event.DetectedBlock.Int64()
 
+	// ringhash登记不影响订单状态,即使没有订单相关的分叉事件也需要标记
+	if err := p.db.RollBackRinghashSubmitted(from, to); err != nil {
+		return fmt.Errorf("fork rollback ringhash submitted events error:%s", err.Error())
+	}
+	if err := p.db.RollBackProcessedEvent(processedEventPrefix, from, to); err != nil {
+		return fmt.Errorf("fork rollback processed events error:%s", err.Error())
+	}
+
 	list, _ := p.GetForkEvents(from, to)
 	if list.Len() == 0 {
 		log.Debugf("order manager fork:non fork events")
@@ -49,6 +49,6 @@ func TestForkProcessor_RollBack(t *testing.T) {
 	detectBlock := big.NewInt(8801)
 	event := &types.ForkedEvent{ForkBlock: forkBlock, DetectedBlock: detectBlock}
 	if err := p.Fork(event); err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err.Error())
 	}
 }
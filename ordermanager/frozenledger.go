@@ -0,0 +1,306 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ordermanager
+
+import (
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// 冻结金额对应的订单状态,与gateway中GetEstimatedAllocatedAllowance/GetFrozenLRCFee一致
+var frozenStatusSet = []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL, types.ORDER_PENDING_FOR_P2P}
+
+// FrozenLedger 按(owner,tokenS,delegate)累计订单冻结的tokenS数量,按owner累计冻结的lrcFee
+// 每个订单的贡献单独记录,订单变化时只调整差额,避免每次查询都从数据库汇总
+type FrozenLedger struct {
+	mtx     sync.RWMutex
+	orders  map[common.Hash]*frozenEntry
+	amounts map[frozenKey]*big.Int
+	lrcFees map[common.Address]*big.Int
+}
+
+type frozenKey struct {
+	owner    common.Address
+	token    common.Address
+	delegate common.Address
+}
+
+type frozenEntry struct {
+	key        frozenKey
+	amountS    *big.Int
+	lrcFee     *big.Int
+	validSince int64
+	validUntil int64
+	active     bool
+}
+
+func NewFrozenLedger() *FrozenLedger {
+	ledger := &FrozenLedger{}
+	ledger.orders = make(map[common.Hash]*frozenEntry)
+	ledger.amounts = make(map[frozenKey]*big.Int)
+	ledger.lrcFees = make(map[common.Address]*big.Int)
+
+	return ledger
+}
+
+// Load 从数据库全量重建
+func (ledger *FrozenLedger) Load(rds dao.RdsService) error {
+	fresh, err := loadFrozenLedger(rds)
+	if err != nil {
+		return err
+	}
+
+	ledger.mtx.Lock()
+	defer ledger.mtx.Unlock()
+
+	ledger.orders, ledger.amounts, ledger.lrcFees = fresh.orders, fresh.amounts, fresh.lrcFees
+	log.Debugf("frozen ledger,loaded %d orders", len(ledger.orders))
+
+	return nil
+}
+
+// Reconcile 与数据库全量计算结果对比,记录差异并以数据库为准,返回存在差异的条目数
+func (ledger *FrozenLedger) Reconcile(rds dao.RdsService) (int, error) {
+	fresh, err := loadFrozenLedger(rds)
+	if err != nil {
+		return 0, err
+	}
+
+	ledger.mtx.Lock()
+	defer ledger.mtx.Unlock()
+
+	diffs := 0
+	keys := make(map[frozenKey]bool)
+	for key := range fresh.amounts {
+		keys[key] = true
+	}
+	for key := range ledger.amounts {
+		keys[key] = true
+	}
+	for key := range keys {
+		if current, expect := ledger.amounts[key], fresh.amounts[key]; bigIntCmp(current, expect) != 0 {
+			log.Errorf("frozen ledger,reconcile owner:%s token:%s delegate:%s ledger:%s db:%s", key.owner.Hex(), key.token.Hex(), key.delegate.Hex(), bigIntString(current), bigIntString(expect))
+			diffs++
+		}
+	}
+
+	owners := make(map[common.Address]bool)
+	for owner := range fresh.lrcFees {
+		owners[owner] = true
+	}
+	for owner := range ledger.lrcFees {
+		owners[owner] = true
+	}
+	for owner := range owners {
+		if current, expect := ledger.lrcFees[owner], fresh.lrcFees[owner]; bigIntCmp(current, expect) != 0 {
+			log.Errorf("frozen ledger,reconcile owner:%s lrcFee ledger:%s db:%s", owner.Hex(), bigIntString(current), bigIntString(expect))
+			diffs++
+		}
+	}
+
+	ledger.orders, ledger.amounts, ledger.lrcFees = fresh.orders, fresh.amounts, fresh.lrcFees
+
+	return diffs, nil
+}
+
+// Upsert 订单写入数据库后调用,不在冻结状态的订单会被移除
+func (ledger *FrozenLedger) Upsert(state *types.OrderState) {
+	ledger.mtx.Lock()
+	defer ledger.mtx.Unlock()
+
+	ledger.upsert(state, time.Now().Unix())
+}
+
+func (ledger *FrozenLedger) Remove(orderhashList ...common.Hash) {
+	ledger.mtx.Lock()
+	defer ledger.mtx.Unlock()
+
+	for _, orderhash := range orderhashList {
+		ledger.remove(orderhash)
+	}
+}
+
+// Refresh 订单生效以及过期都不会产生事件,需要定时根据validSince/validUntil调整
+func (ledger *FrozenLedger) Refresh() {
+	ledger.mtx.Lock()
+	defer ledger.mtx.Unlock()
+
+	nowtime := time.Now().Unix()
+	for orderhash, entry := range ledger.orders {
+		if entry.validUntil < nowtime {
+			ledger.remove(orderhash)
+		} else if !entry.active && entry.validSince < nowtime {
+			ledger.activate(entry)
+		}
+	}
+}
+
+func (ledger *FrozenLedger) FrozenAmount(owner, token, delegate common.Address) *big.Int {
+	ledger.mtx.RLock()
+	defer ledger.mtx.RUnlock()
+
+	if amount, ok := ledger.amounts[frozenKey{owner: owner, token: token, delegate: delegate}]; ok {
+		return new(big.Int).Set(amount)
+	}
+	return big.NewInt(0)
+}
+
+func (ledger *FrozenLedger) FrozenLrcFee(owner common.Address) *big.Int {
+	ledger.mtx.RLock()
+	defer ledger.mtx.RUnlock()
+
+	if fee, ok := ledger.lrcFees[owner]; ok {
+		return new(big.Int).Set(fee)
+	}
+	return big.NewInt(0)
+}
+
+func (ledger *FrozenLedger) upsert(state *types.OrderState, nowtime int64) {
+	ledger.remove(state.RawOrder.Hash)
+
+	if !isFrozenStatus(state.Status) {
+		return
+	}
+
+	entry := &frozenEntry{}
+	entry.key = frozenKey{owner: state.RawOrder.Owner, token: state.RawOrder.TokenS, delegate: state.RawOrder.DelegateAddress}
+	entry.amountS = frozenAmountS(state)
+	entry.lrcFee = copyBigInt(state.RawOrder.LrcFee)
+	if state.RawOrder.ValidSince != nil {
+		entry.validSince = state.RawOrder.ValidSince.Int64()
+	}
+	if state.RawOrder.ValidUntil != nil {
+		entry.validUntil = state.RawOrder.ValidUntil.Int64()
+	}
+	if entry.validUntil < nowtime {
+		return
+	}
+
+	ledger.orders[state.RawOrder.Hash] = entry
+	if entry.validSince < nowtime {
+		ledger.activate(entry)
+	}
+}
+
+func (ledger *FrozenLedger) activate(entry *frozenEntry) {
+	entry.active = true
+	if _, ok := ledger.amounts[entry.key]; !ok {
+		ledger.amounts[entry.key] = big.NewInt(0)
+	}
+	ledger.amounts[entry.key].Add(ledger.amounts[entry.key], entry.amountS)
+	if _, ok := ledger.lrcFees[entry.key.owner]; !ok {
+		ledger.lrcFees[entry.key.owner] = big.NewInt(0)
+	}
+	ledger.lrcFees[entry.key.owner].Add(ledger.lrcFees[entry.key.owner], entry.lrcFee)
+}
+
+func (ledger *FrozenLedger) remove(orderhash common.Hash) {
+	entry, ok := ledger.orders[orderhash]
+	if !ok {
+		return
+	}
+	delete(ledger.orders, orderhash)
+	if !entry.active {
+		return
+	}
+
+	if amount, ok := ledger.amounts[entry.key]; ok {
+		if amount.Sub(amount, entry.amountS); amount.Sign() <= 0 {
+			delete(ledger.amounts, entry.key)
+		}
+	}
+	if fee, ok := ledger.lrcFees[entry.key.owner]; ok {
+		if fee.Sub(fee, entry.lrcFee); fee.Sign() <= 0 {
+			delete(ledger.lrcFees, entry.key.owner)
+		}
+	}
+}
+
+func loadFrozenLedger(rds dao.RdsService) (*FrozenLedger, error) {
+	models, err := rds.GetFrozenOrders(frozenStatusSet)
+	if err != nil {
+		return nil, err
+	}
+
+	fresh := NewFrozenLedger()
+	nowtime := time.Now().Unix()
+	for _, v := range models {
+		state := &types.OrderState{}
+		if err := v.ConvertUp(state); err != nil {
+			log.Debugf("frozen ledger,load order:%s error:%s", v.OrderHash, err.Error())
+			continue
+		}
+		fresh.upsert(state, nowtime)
+	}
+
+	return fresh, nil
+}
+
+// frozenAmountS 订单剩余的tokenS数量,ledger与数据库全量计算共用
+func frozenAmountS(state *types.OrderState) *big.Int {
+	remainedAmountS, _ := state.RemainedAmount()
+	amount := new(big.Int).Quo(remainedAmountS.Num(), remainedAmountS.Denom())
+	if amount.Sign() < 0 {
+		amount.SetInt64(0)
+	}
+	return amount
+}
+
+func isFrozenStatus(status types.OrderStatus) bool {
+	for _, v := range frozenStatusSet {
+		if v == status {
+			return true
+		}
+	}
+	return false
+}
+
+func isFrozenStatusSet(statusSet []types.OrderStatus) bool {
+	if len(statusSet) != len(frozenStatusSet) {
+		return false
+	}
+	for _, v := range statusSet {
+		if !isFrozenStatus(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func bigIntCmp(x, y *big.Int) int {
+	if x == nil {
+		x = big.NewInt(0)
+	}
+	if y == nil {
+		y = big.NewInt(0)
+	}
+	return x.Cmp(y)
+}
+
+func bigIntString(x *big.Int) string {
+	if x == nil {
+		return "0"
+	}
+	return x.String()
+}
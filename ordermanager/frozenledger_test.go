@@ -0,0 +1,96 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ordermanager
+
+import (
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestFrozenLedger(t *testing.T) {
+	owner := common.HexToAddress("0x48ff2269e58a373120ffdbbdee3fbcea854ac30a")
+	ledger := NewFrozenLedger()
+
+	order1 := newBookOrder("0x01", 100, 10, 1)
+	order1.RawOrder.Owner = owner
+	order1.RawOrder.LrcFee = big.NewInt(5)
+	order2 := newBookOrder("0x02", 200, 10, 2)
+	order2.RawOrder.Owner = owner
+	order2.RawOrder.LrcFee = big.NewInt(7)
+	ledger.Upsert(order1)
+	ledger.Upsert(order2)
+
+	if amount := ledger.FrozenAmount(owner, bookTokenS, bookDelegate); amount.Int64() != 300 {
+		t.Fatalf("frozen amount:%s, expect:300", amount.String())
+	}
+	if fee := ledger.FrozenLrcFee(owner); fee.Int64() != 12 {
+		t.Fatalf("frozen lrcFee:%s, expect:12", fee.String())
+	}
+
+	order1.DealtAmountS = big.NewInt(40)
+	order1.Status = types.ORDER_PARTIAL
+	ledger.Upsert(order1)
+	if amount := ledger.FrozenAmount(owner, bookTokenS, bookDelegate); amount.Int64() != 260 {
+		t.Fatalf("frozen amount after fill:%s, expect:260", amount.String())
+	}
+
+	order2.Status = types.ORDER_CANCEL
+	ledger.Upsert(order2)
+	if amount := ledger.FrozenAmount(owner, bookTokenS, bookDelegate); amount.Int64() != 60 {
+		t.Fatalf("frozen amount after cancel:%s, expect:60", amount.String())
+	}
+	if fee := ledger.FrozenLrcFee(owner); fee.Int64() != 5 {
+		t.Fatalf("frozen lrcFee after cancel:%s, expect:5", fee.String())
+	}
+
+	ledger.Remove(order1.RawOrder.Hash)
+	if amount := ledger.FrozenAmount(owner, bookTokenS, bookDelegate); amount.Sign() != 0 {
+		t.Fatalf("frozen amount after cutoff:%s, expect:0", amount.String())
+	}
+}
+
+func TestFrozenLedger_Refresh(t *testing.T) {
+	owner := common.HexToAddress("0x48ff2269e58a373120ffdbbdee3fbcea854ac30a")
+	ledger := NewFrozenLedger()
+
+	order := newBookOrder("0x01", 100, 10, 1)
+	order.RawOrder.Owner = owner
+	order.RawOrder.LrcFee = big.NewInt(0)
+	order.RawOrder.ValidSince = big.NewInt(time.Now().Unix() + 3600)
+	ledger.Upsert(order)
+	if amount := ledger.FrozenAmount(owner, bookTokenS, bookDelegate); amount.Sign() != 0 {
+		t.Fatalf("order not valid yet should not be frozen")
+	}
+
+	order.RawOrder.ValidSince = big.NewInt(time.Now().Unix() - 100)
+	order.RawOrder.ValidUntil = big.NewInt(time.Now().Unix() + 1)
+	ledger.Upsert(order)
+	if amount := ledger.FrozenAmount(owner, bookTokenS, bookDelegate); amount.Int64() != 100 {
+		t.Fatalf("frozen amount:%s, expect:100", amount.String())
+	}
+
+	time.Sleep(2 * time.Second)
+	ledger.Refresh()
+	if amount := ledger.FrozenAmount(owner, bookTokenS, bookDelegate); amount.Sign() != 0 {
+		t.Fatalf("expired order should be released")
+	}
+}
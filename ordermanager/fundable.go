@@ -0,0 +1,116 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ordermanager
+
+import (
+	"fmt"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+)
+
+// 同一owner卖出同一token的订单共享余额以及对delegate的授权,按下单先后依次分配可成交数量,
+// 分配不到的订单从深度以及miner订单中隐藏,余额或授权恢复后重新出现
+var fundableStatusSet = []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL}
+
+func (om *OrderManagerImpl) handleFundsSynced(input eventemitter.EventData) error {
+	event := input.(*types.AccountFundsSyncedEvent)
+	log.Debugf("order manager,handle funds synced,block:%s changes:%d", event.BlockNumber.String(), len(event.Changes))
+
+	for _, change := range event.Changes {
+		if err := om.updateFundableAmount(change.Owner, change.Token); err != nil {
+			log.Errorf("order manager,handle funds synced,owner:%s token:%s error:%s", change.Owner.Hex(), change.Token.Hex(), err.Error())
+		}
+	}
+
+	return nil
+}
+
+// updateFundableAmount 重新分配owner所有卖出tokenS订单的可成交数量,并同步到数据库以及订单簿
+func (om *OrderManagerImpl) updateFundableAmount(owner, tokenS common.Address) error {
+	if om.am == nil {
+		return nil
+	}
+
+	models, err := om.rds.GetFundableOrders(owner, tokenS, fundableStatusSet)
+	if err != nil || len(models) == 0 {
+		return err
+	}
+
+	var balanceLeft *big.Int
+	allowanceLeft := make(map[common.Address]*big.Int)
+	depthUpdated := make(map[types.DepthUpdateEvent]bool)
+
+	for _, model := range models {
+		state := &types.OrderState{}
+		if err := model.ConvertUp(state); err != nil {
+			log.Debugf("order manager,update fundable amount,order:%s error:%s", model.OrderHash, err.Error())
+			continue
+		}
+
+		delegate := state.RawOrder.DelegateAddress
+		if _, ok := allowanceLeft[delegate]; !ok {
+			balance, allowance, err := om.am.GetBalanceAndAllowance(owner, tokenS, delegate)
+			if err != nil {
+				return err
+			}
+			if balance == nil || allowance == nil {
+				return fmt.Errorf("order manager,update fundable amount,can't get balance or allowance of owner:%s token:%s", owner.Hex(), tokenS.Hex())
+			}
+			if balanceLeft == nil {
+				balanceLeft = new(big.Int).Set(balance)
+			}
+			allowanceLeft[delegate] = new(big.Int).Set(allowance)
+		}
+
+		remainedAmountS, _ := state.RemainedAmount()
+		fundable := new(big.Int).Quo(remainedAmountS.Num(), remainedAmountS.Denom())
+		if fundable.Sign() < 0 {
+			fundable.SetInt64(0)
+		}
+		if fundable.Cmp(balanceLeft) > 0 {
+			fundable.Set(balanceLeft)
+		}
+		if fundable.Cmp(allowanceLeft[delegate]) > 0 {
+			fundable.Set(allowanceLeft[delegate])
+		}
+		balanceLeft.Sub(balanceLeft, fundable)
+		allowanceLeft[delegate].Sub(allowanceLeft[delegate], fundable)
+
+		if state.FundableAmountS != nil && state.FundableAmountS.Cmp(fundable) == 0 {
+			continue
+		}
+		if err := om.rds.UpdateOrderFundableAmount(state.RawOrder.Hash, fundable); err != nil {
+			return err
+		}
+		state.FundableAmountS = fundable
+		if nil != om.book {
+			om.book.Upsert(state, model.MinerBlockMark)
+		}
+		depthUpdated[types.DepthUpdateEvent{DelegateAddress: model.DelegateAddress, Market: model.Market}] = true
+	}
+
+	for event := range depthUpdated {
+		eventemitter.Emit(eventemitter.DepthUpdated, event)
+	}
+
+	return nil
+}
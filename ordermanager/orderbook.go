@@ -0,0 +1,362 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ordermanager
+
+import (
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+)
+
+// OrderBook 内存订单簿,按delegate以及tokenS->tokenB分组,组内按价格倒序排列
+// 订单簿只保存可以继续成交的市场订单,数据库依然是订单状态的唯一来源,
+// ordermanager在处理完各个事件并写入数据库后同步更新订单簿
+type OrderBook struct {
+	mtx    sync.RWMutex
+	books  map[orderBookKey]*orderBookSide
+	orders map[common.Hash]*orderBookEntry
+	clock  func() int64
+}
+
+type orderBookKey struct {
+	delegate common.Address
+	tokenS   common.Address
+	tokenB   common.Address
+}
+
+type orderBookEntry struct {
+	key            orderBookKey
+	state          *types.OrderState
+	price          *big.Rat
+	minerBlockMark int64
+}
+
+// orderBookSide 同一个delegate下同一交易方向的订单,价格倒序,价格相同时先到先得
+type orderBookSide []*orderBookEntry
+
+func (side orderBookSide) search(entry *orderBookEntry) int {
+	return sort.Search(len(side), func(i int) bool {
+		return !orderBookEntryLess(side[i], entry)
+	})
+}
+
+func orderBookEntryLess(x, y *orderBookEntry) bool {
+	if cmp := x.price.Cmp(y.price); cmp != 0 {
+		return cmp > 0
+	}
+	if x.state.RawOrder.CreateTime != y.state.RawOrder.CreateTime {
+		return x.state.RawOrder.CreateTime < y.state.RawOrder.CreateTime
+	}
+	return x.state.RawOrder.Hash.Big().Cmp(y.state.RawOrder.Hash.Big()) < 0
+}
+
+func NewOrderBook() *OrderBook {
+	return NewOrderBookWithClock(func() int64 {
+		return time.Now().Unix()
+	})
+}
+
+// NewOrderBookWithClock 订单有效期按照clock判断,回测时使用模拟时钟
+func NewOrderBookWithClock(clock func() int64) *OrderBook {
+	book := &OrderBook{}
+	book.books = make(map[orderBookKey]*orderBookSide)
+	book.orders = make(map[common.Hash]*orderBookEntry)
+	book.clock = clock
+
+	return book
+}
+
+// Load 从数据库重建订单簿,在启动、分叉回滚以及定时刷新时调用
+func (book *OrderBook) Load(rds dao.RdsService) error {
+	statusSet := []types.OrderStatus{types.ORDER_NEW, types.ORDER_PARTIAL, types.ORDER_PENDING}
+	models, err := rds.GetOrdersForBook(statusSet)
+	if err != nil {
+		return err
+	}
+
+	books := make(map[orderBookKey]*orderBookSide)
+	orders := make(map[common.Hash]*orderBookEntry)
+	for _, v := range models {
+		state := &types.OrderState{}
+		if err := v.ConvertUp(state); err != nil {
+			log.Debugf("order book,load order:%s error:%s", v.OrderHash, err.Error())
+			continue
+		}
+		entry := newOrderBookEntry(state, v.MinerBlockMark)
+		side, ok := books[entry.key]
+		if !ok {
+			side = &orderBookSide{}
+			books[entry.key] = side
+		}
+		*side = append(*side, entry)
+		orders[state.RawOrder.Hash] = entry
+	}
+	for _, side := range books {
+		sort.SliceStable(*side, func(i, j int) bool {
+			return orderBookEntryLess((*side)[i], (*side)[j])
+		})
+	}
+
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	book.books = books
+	book.orders = orders
+	log.Debugf("order book,loaded %d orders in %d books", len(orders), len(books))
+
+	return nil
+}
+
+// Upsert 新增或者更新订单,订单进入不可变状态时从订单簿中移除
+func (book *OrderBook) Upsert(state *types.OrderState, minerBlockMark int64) {
+	if state.RawOrder.OrderType != types.ORDER_TYPE_MARKET {
+		return
+	}
+
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	if old, ok := book.orders[state.RawOrder.Hash]; ok {
+		minerBlockMark = old.minerBlockMark
+		book.remove(old)
+	}
+
+	if types.InUnchangeableStatus(state.Status) {
+		return
+	}
+
+	book.insert(newOrderBookEntry(state, minerBlockMark))
+}
+
+func (book *OrderBook) Remove(orderhashList ...common.Hash) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	for _, orderhash := range orderhashList {
+		if entry, ok := book.orders[orderhash]; ok {
+			book.remove(entry)
+		}
+	}
+}
+
+// RemoveExpired 过期订单不会产生链上事件,需要定时清理
+func (book *OrderBook) RemoveExpired() {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	nowtime := book.clock()
+	for _, entry := range book.orders {
+		if entry.state.RawOrder.ValidUntil != nil && entry.state.RawOrder.ValidUntil.Int64() < nowtime {
+			book.remove(entry)
+		}
+	}
+}
+
+// MarkMinerOrders 与dao.MarkMinerOrders保持一致,被标记的订单在blockNumber之前不再提供给miner
+func (book *OrderBook) MarkMinerOrders(orderhashList []common.Hash, blockNumber int64) {
+	book.mtx.Lock()
+	defer book.mtx.Unlock()
+
+	for _, orderhash := range orderhashList {
+		if entry, ok := book.orders[orderhash]; ok {
+			entry.minerBlockMark = blockNumber
+		}
+	}
+}
+
+// Depth 对应dao.GetOrderBook,返回当前有效的new/partial订单
+func (book *OrderBook) Depth(delegate, tokenS, tokenB common.Address, length int) []types.OrderState {
+	var list []types.OrderState
+
+	book.mtx.RLock()
+	defer book.mtx.RUnlock()
+
+	side, ok := book.books[orderBookKey{delegate: delegate, tokenS: tokenS, tokenB: tokenB}]
+	if !ok {
+		return list
+	}
+
+	nowtime := book.clock()
+	for _, entry := range *side {
+		if len(list) >= length {
+			break
+		}
+		if entry.state.Status != types.ORDER_NEW && entry.state.Status != types.ORDER_PARTIAL {
+			continue
+		}
+		if !entry.funded() || !entry.validAt(nowtime, nowtime) {
+			continue
+		}
+		list = append(list, *copyOrderState(entry.state))
+	}
+
+	return list
+}
+
+// MinerOrders 对应dao.GetOrdersForMiner,返回的订单为副本,miner可以直接修改
+func (book *OrderBook) MinerOrders(delegate, tokenS, tokenB common.Address, length int, reservedTime, startBlockNumber, endBlockNumber int64) []*types.OrderState {
+	return book.PriorityMinerOrders(nil, delegate, tokenS, tokenB, length, reservedTime, startBlockNumber, endBlockNumber)
+}
+
+// PriorityMinerOrders 可撮合的订单按priority排序后取前length个,priority为nil或price_time时即订单簿本身的顺序
+func (book *OrderBook) PriorityMinerOrders(priority types.OrderPriority, delegate, tokenS, tokenB common.Address, length int, reservedTime, startBlockNumber, endBlockNumber int64) []*types.OrderState {
+	var list []*types.OrderState
+
+	book.mtx.RLock()
+	defer book.mtx.RUnlock()
+
+	side, ok := book.books[orderBookKey{delegate: delegate, tokenS: tokenS, tokenB: tokenB}]
+	if !ok {
+		return list
+	}
+
+	nowtime := book.clock()
+	priceTime := nil == priority || types.ORDER_PRIORITY_PRICE_TIME == priority.Name()
+	entries := []*orderBookEntry{}
+	for _, entry := range *side {
+		if priceTime && len(entries) >= length {
+			break
+		}
+		if entry.minerBlockMark < startBlockNumber || entry.minerBlockMark > endBlockNumber {
+			continue
+		}
+		if !entry.funded() || !entry.validAt(nowtime, nowtime+reservedTime) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+
+	if !priceTime {
+		scores := make(map[*orderBookEntry]*big.Rat)
+		for _, entry := range entries {
+			scores[entry] = priority.Score(entry.state, entry.price, nowtime)
+		}
+		sort.SliceStable(entries, func(i, j int) bool {
+			return scores[entries[i]].Cmp(scores[entries[j]]) > 0
+		})
+		if len(entries) > length {
+			entries = entries[:length]
+		}
+	}
+
+	for _, entry := range entries {
+		list = append(list, copyOrderState(entry.state))
+	}
+
+	return list
+}
+
+func (book *OrderBook) insert(entry *orderBookEntry) {
+	side, ok := book.books[entry.key]
+	if !ok {
+		side = &orderBookSide{}
+		book.books[entry.key] = side
+	}
+
+	idx := side.search(entry)
+	*side = append(*side, nil)
+	copy((*side)[idx+1:], (*side)[idx:])
+	(*side)[idx] = entry
+	book.orders[entry.state.RawOrder.Hash] = entry
+}
+
+func (book *OrderBook) remove(entry *orderBookEntry) {
+	delete(book.orders, entry.state.RawOrder.Hash)
+
+	side, ok := book.books[entry.key]
+	if !ok {
+		return
+	}
+	for idx := side.search(entry); idx < len(*side); idx++ {
+		if (*side)[idx] == entry {
+			*side = append((*side)[:idx], (*side)[idx+1:]...)
+			break
+		}
+	}
+	if len(*side) == 0 {
+		delete(book.books, entry.key)
+	}
+}
+
+func newOrderBookEntry(state *types.OrderState, minerBlockMark int64) *orderBookEntry {
+	entry := &orderBookEntry{}
+	entry.key = orderBookKey{
+		delegate: state.RawOrder.DelegateAddress,
+		tokenS:   state.RawOrder.TokenS,
+		tokenB:   state.RawOrder.TokenB,
+	}
+	entry.state = copyOrderState(state)
+	entry.minerBlockMark = minerBlockMark
+
+	// 与dao保持一致,使用price字段排序,缺失时按amountS/amountB计算
+	if state.RawOrder.Price != nil {
+		entry.price = new(big.Rat).Set(state.RawOrder.Price)
+	} else if state.RawOrder.AmountB != nil && state.RawOrder.AmountB.Sign() > 0 {
+		entry.price = new(big.Rat).SetFrac(state.RawOrder.AmountS, state.RawOrder.AmountB)
+	} else {
+		entry.price = new(big.Rat)
+	}
+
+	return entry
+}
+
+func (entry *orderBookEntry) validAt(sinceTime, untilTime int64) bool {
+	raw := entry.state.RawOrder
+	if raw.ValidSince != nil && raw.ValidSince.Int64() >= sinceTime {
+		return false
+	}
+	if raw.ValidUntil != nil && raw.ValidUntil.Int64() < untilTime {
+		return false
+	}
+	return true
+}
+
+// funded 余额或授权不足以支付任何数量的订单不展示也不参与撮合,尚未计算过的订单视为有效
+func (entry *orderBookEntry) funded() bool {
+	return entry.state.FundableAmountS == nil || entry.state.FundableAmountS.Sign() > 0
+}
+
+func copyOrderState(src *types.OrderState) *types.OrderState {
+	dst := *src
+	dst.DealtAmountS = copyBigInt(src.DealtAmountS)
+	dst.DealtAmountB = copyBigInt(src.DealtAmountB)
+	dst.SplitAmountS = copyBigInt(src.SplitAmountS)
+	dst.SplitAmountB = copyBigInt(src.SplitAmountB)
+	dst.CancelledAmountS = copyBigInt(src.CancelledAmountS)
+	dst.CancelledAmountB = copyBigInt(src.CancelledAmountB)
+	if src.UpdatedBlock != nil {
+		dst.UpdatedBlock = new(big.Int).Set(src.UpdatedBlock)
+	}
+	if src.FundableAmountS != nil {
+		dst.FundableAmountS = new(big.Int).Set(src.FundableAmountS)
+	}
+	return &dst
+}
+
+func copyBigInt(x *big.Int) *big.Int {
+	if x == nil {
+		return big.NewInt(0)
+	}
+	return new(big.Int).Set(x)
+}
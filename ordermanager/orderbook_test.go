@@ -0,0 +1,189 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ordermanager
+
+import (
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+	"testing"
+	"time"
+)
+
+var (
+	bookDelegate = common.HexToAddress("0x17233e07c67d086464fD408148c3ABB56245FA64")
+	bookTokenS   = common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
+	bookTokenB   = common.HexToAddress("0xEF68e7C694F40c8202821eDF525dE3782458639f")
+)
+
+func newBookOrder(hash string, amountS, amountB int64, createTime int64) *types.OrderState {
+	state := &types.OrderState{}
+	state.RawOrder.Hash = common.HexToHash(hash)
+	state.RawOrder.DelegateAddress = bookDelegate
+	state.RawOrder.TokenS = bookTokenS
+	state.RawOrder.TokenB = bookTokenB
+	state.RawOrder.AmountS = big.NewInt(amountS)
+	state.RawOrder.AmountB = big.NewInt(amountB)
+	state.RawOrder.Price = new(big.Rat).SetFrac(state.RawOrder.AmountS, state.RawOrder.AmountB)
+	state.RawOrder.ValidSince = big.NewInt(time.Now().Unix() - 100)
+	state.RawOrder.ValidUntil = big.NewInt(time.Now().Unix() + 3600)
+	state.RawOrder.OrderType = types.ORDER_TYPE_MARKET
+	state.RawOrder.CreateTime = createTime
+	state.DealtAmountS = big.NewInt(0)
+	state.DealtAmountB = big.NewInt(0)
+	state.SplitAmountS = big.NewInt(0)
+	state.SplitAmountB = big.NewInt(0)
+	state.CancelledAmountS = big.NewInt(0)
+	state.CancelledAmountB = big.NewInt(0)
+	state.Status = types.ORDER_NEW
+	return state
+}
+
+func TestOrderBook_Depth(t *testing.T) {
+	book := NewOrderBook()
+	book.Upsert(newBookOrder("0x01", 100, 10, 1), 0)
+	book.Upsert(newBookOrder("0x02", 300, 10, 2), 0)
+	book.Upsert(newBookOrder("0x03", 200, 10, 3), 0)
+	book.Upsert(newBookOrder("0x04", 300, 10, 1), 0)
+
+	list := book.Depth(bookDelegate, bookTokenS, bookTokenB, 10)
+	expect := []string{"0x04", "0x02", "0x03", "0x01"}
+	if len(list) != len(expect) {
+		t.Fatalf("depth length:%d, expect:%d", len(list), len(expect))
+	}
+	for idx, v := range list {
+		if v.RawOrder.Hash != common.HexToHash(expect[idx]) {
+			t.Fatalf("depth index:%d, hash:%s, expect:%s", idx, v.RawOrder.Hash.Hex(), expect[idx])
+		}
+	}
+
+	if list := book.Depth(bookDelegate, bookTokenS, bookTokenB, 2); len(list) != 2 {
+		t.Fatalf("depth length:%d, expect:2", len(list))
+	}
+	if list := book.Depth(bookDelegate, bookTokenB, bookTokenS, 10); len(list) != 0 {
+		t.Fatalf("reversed depth should be empty, length:%d", len(list))
+	}
+}
+
+func TestOrderBook_Upsert(t *testing.T) {
+	book := NewOrderBook()
+	state := newBookOrder("0x01", 100, 10, 1)
+	book.Upsert(state, 0)
+	book.Upsert(newBookOrder("0x02", 200, 10, 2), 0)
+
+	state.Status = types.ORDER_PARTIAL
+	state.DealtAmountS = big.NewInt(50)
+	book.Upsert(state, 0)
+	list := book.Depth(bookDelegate, bookTokenS, bookTokenB, 10)
+	if len(list) != 2 || list[1].DealtAmountS.Int64() != 50 {
+		t.Fatalf("order should be updated in place")
+	}
+
+	state.Status = types.ORDER_FINISHED
+	book.Upsert(state, 0)
+	if list := book.Depth(bookDelegate, bookTokenS, bookTokenB, 10); len(list) != 1 {
+		t.Fatalf("finished order should be removed, length:%d", len(list))
+	}
+
+	book.Remove(common.HexToHash("0x02"))
+	if list := book.Depth(bookDelegate, bookTokenS, bookTokenB, 10); len(list) != 0 {
+		t.Fatalf("cutoff order should be removed, length:%d", len(list))
+	}
+}
+
+func TestOrderBook_MinerOrders(t *testing.T) {
+	book := NewOrderBook()
+	book.Upsert(newBookOrder("0x01", 100, 10, 1), 0)
+	book.Upsert(newBookOrder("0x02", 200, 10, 2), 0)
+	book.MarkMinerOrders([]common.Hash{common.HexToHash("0x02")}, 100)
+
+	list := book.MinerOrders(bookDelegate, bookTokenS, bookTokenB, 10, 0, 0, 50)
+	if len(list) != 1 || list[0].RawOrder.Hash != common.HexToHash("0x01") {
+		t.Fatalf("delayed order should not be provided to miner")
+	}
+
+	// miner修改返回的订单不应该影响订单簿
+	list[0].DealtAmountS.Add(list[0].DealtAmountS, big.NewInt(10))
+	if list := book.MinerOrders(bookDelegate, bookTokenS, bookTokenB, 10, 0, 0, 50); list[0].DealtAmountS.Sign() != 0 {
+		t.Fatalf("miner orders should be copied")
+	}
+
+	if list := book.MinerOrders(bookDelegate, bookTokenS, bookTokenB, 10, 0, 51, 100); len(list) != 1 || list[0].RawOrder.Hash != common.HexToHash("0x02") {
+		t.Fatalf("delayed order should be provided in next rounds")
+	}
+
+	if list := book.MinerOrders(bookDelegate, bookTokenS, bookTokenB, 10, 7200, 0, 100); len(list) != 0 {
+		t.Fatalf("orders expiring within reserved time should be filtered, length:%d", len(list))
+	}
+}
+
+func TestOrderBook_Unfunded(t *testing.T) {
+	book := NewOrderBook()
+	book.Upsert(newBookOrder("0x01", 100, 10, 1), 0)
+	state := newBookOrder("0x02", 200, 10, 2)
+	state.FundableAmountS = big.NewInt(0)
+	book.Upsert(state, 0)
+
+	if list := book.Depth(bookDelegate, bookTokenS, bookTokenB, 10); len(list) != 1 || list[0].RawOrder.Hash != common.HexToHash("0x01") {
+		t.Fatalf("unfunded order should be hidden from depth")
+	}
+	if list := book.MinerOrders(bookDelegate, bookTokenS, bookTokenB, 10, 0, 0, 50); len(list) != 1 {
+		t.Fatalf("unfunded order should not be provided to miner, length:%d", len(list))
+	}
+
+	state.FundableAmountS = big.NewInt(50)
+	book.Upsert(state, 0)
+	list := book.Depth(bookDelegate, bookTokenS, bookTokenB, 10)
+	if len(list) != 2 || list[0].FundableAmountS.Int64() != 50 {
+		t.Fatalf("order should be shown again after funded")
+	}
+}
+
+func TestOrderBook_PriorityMinerOrders(t *testing.T) {
+	now := time.Now().Unix()
+	book := NewOrderBookWithClock(func() int64 { return now })
+	fresh := newBookOrder("0x01", 200, 10, now-10)
+	fresh.RawOrder.LrcFee = big.NewInt(1)
+	book.Upsert(fresh, 0)
+	aged := newBookOrder("0x02", 190, 10, now-3600)
+	aged.RawOrder.LrcFee = big.NewInt(10)
+	book.Upsert(aged, 0)
+
+	for _, c := range []struct {
+		policy string
+		expect string
+	}{
+		{types.ORDER_PRIORITY_PRICE_TIME, "0x01"},
+		{types.ORDER_PRIORITY_FEE_WEIGHTED, "0x02"},
+		{types.ORDER_PRIORITY_AGE_BOOST, "0x02"},
+	} {
+		priority, err := types.NewOrderPriority(c.policy, 0, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list := book.PriorityMinerOrders(priority, bookDelegate, bookTokenS, bookTokenB, 1, 0, 0, 50)
+		if len(list) != 1 || list[0].RawOrder.Hash != common.HexToHash(c.expect) {
+			t.Fatalf("policy:%s should select %s first", c.policy, c.expect)
+		}
+	}
+
+	if _, err := types.NewOrderPriority("unknown", 0, 0, 0); err == nil {
+		t.Fatalf("unknown policy should be rejected")
+	}
+}
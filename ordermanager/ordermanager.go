@@ -24,18 +24,21 @@ import (
 	"github.com/Loopring/relay/dao"
 	"github.com/Loopring/relay/eventemiter"
 	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/market"
 	"github.com/Loopring/relay/market/util"
 	"github.com/Loopring/relay/marketcap"
 	"github.com/Loopring/relay/types"
 	"github.com/Loopring/relay/usermanager"
 	"github.com/ethereum/go-ethereum/common"
 	"math/big"
+	"sync"
+	"time"
 )
 
 type OrderManager interface {
 	Start()
 	Stop()
-	MinerOrders(protocol, tokenS, tokenB common.Address, length int, reservedTime, startBlockNumber, endBlockNumber int64, filterOrderHashLists ...*types.OrderDelayList) []*types.OrderState
+	MinerOrders(protocol, tokenS, tokenB common.Address, length int, reservedTime, startBlockNumber, endBlockNumber int64, priority types.OrderPriority, filterOrderHashLists ...*types.OrderDelayList) []*types.OrderState
 	GetOrderBook(protocol, tokenS, tokenB common.Address, length int) ([]types.OrderState, error)
 	GetOrders(query map[string]interface{}, statusList []types.OrderStatus, pageIndex, pageSize int) (dao.PageResult, error)
 	GetOrderByHash(hash common.Hash) (*types.OrderState, error)
@@ -49,6 +52,8 @@ type OrderManager interface {
 	IsValueDusted(tokenAddress common.Address, value *big.Rat) bool
 	GetFrozenAmount(owner common.Address, token common.Address, statusSet []types.OrderStatus, delegateAddress common.Address) (*big.Int, error)
 	GetFrozenLRCFee(owner common.Address, statusSet []types.OrderStatus) (*big.Int, error)
+	GetOpenOrders(owner common.Address, statusSet []types.OrderStatus) ([]types.OrderState, error)
+	ReleaseTriggeredOrder(state *types.OrderState, triggerTime int64) error
 }
 
 type OrderManagerImpl struct {
@@ -57,17 +62,25 @@ type OrderManagerImpl struct {
 	processor          *ForkProcessor
 	um                 usermanager.UserManager
 	mc                 marketcap.MarketCapProvider
+	am                 *market.AccountManager
 	cutoffCache        *CutoffCache
+	book               *OrderBook
+	bookOnce           sync.Once
+	ledger             *FrozenLedger
+	ledgerOnce         sync.Once
 	newOrderWatcher    *eventemitter.Watcher
 	ringMinedWatcher   *eventemitter.Watcher
+	ringhashWatcher    *eventemitter.Watcher
 	fillOrderWatcher   *eventemitter.Watcher
 	cancelOrderWatcher *eventemitter.Watcher
 	cutoffOrderWatcher *eventemitter.Watcher
 	cutoffPairWatcher  *eventemitter.Watcher
+	fundsSyncedWatcher *eventemitter.Watcher
 	forkWatcher        *eventemitter.Watcher
 	//syncWatcher             *eventemitter.Watcher
 	warningWatcher          *eventemitter.Watcher
 	submitRingMethodWatcher *eventemitter.Watcher
+	p2pFilledWatcher        *eventemitter.Watcher
 	//ordersValidForMiner     bool
 }
 
@@ -75,7 +88,8 @@ func NewOrderManager(
 	options *config.OrderManagerOptions,
 	rds dao.RdsService,
 	userManager usermanager.UserManager,
-	market marketcap.MarketCapProvider) *OrderManagerImpl {
+	market marketcap.MarketCapProvider,
+	accountManager *market.AccountManager) *OrderManagerImpl {
 
 	om := &OrderManagerImpl{}
 	om.options = options
@@ -83,7 +97,12 @@ func NewOrderManager(
 	om.processor = NewForkProcess(om.rds, market)
 	om.um = userManager
 	om.mc = market
+	om.am = accountManager
 	om.cutoffCache = NewCutoffCache(options.CutoffCacheCleanTime)
+	if options.LocalOrderBook {
+		om.book = NewOrderBook()
+	}
+	om.ledger = NewFrozenLedger()
 	//om.ordersValidForMiner = false
 
 	dustOrderValue = om.options.DustOrderValue
@@ -93,39 +112,66 @@ func NewOrderManager(
 
 // Start start orderbook as a service
 func (om *OrderManagerImpl) Start() {
+	if nil != om.book {
+		om.bookOnce.Do(om.startOrderBook)
+	}
+	om.ledgerOnce.Do(om.startFrozenLedger)
+
 	om.newOrderWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleGatewayOrder}
 	om.ringMinedWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleRingMined}
+	om.ringhashWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleRinghashSubmitted}
 	om.fillOrderWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleOrderFilled}
 	om.cancelOrderWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleOrderCancelled}
 	om.cutoffOrderWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleCutoff}
 	om.cutoffPairWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleCutoffPair}
+	om.fundsSyncedWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleFundsSynced}
 	//om.syncWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleSync}
 	om.forkWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleFork}
 	om.warningWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleWarning}
 	om.submitRingMethodWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleSubmitRingMethod}
+	om.p2pFilledWatcher = &eventemitter.Watcher{Concurrent: false, Handle: om.handleP2POrderFilled}
 
 	eventemitter.On(eventemitter.NewOrder, om.newOrderWatcher)
 	eventemitter.On(eventemitter.RingMined, om.ringMinedWatcher)
+	eventemitter.On(eventemitter.RingHashSubmitted, om.ringhashWatcher)
 	eventemitter.On(eventemitter.OrderFilled, om.fillOrderWatcher)
 	eventemitter.On(eventemitter.CancelOrder, om.cancelOrderWatcher)
 	eventemitter.On(eventemitter.CutoffAll, om.cutoffOrderWatcher)
 	eventemitter.On(eventemitter.CutoffPair, om.cutoffPairWatcher)
+	eventemitter.On(eventemitter.AccountFundsSynced, om.fundsSyncedWatcher)
 	//eventemitter.On(eventemitter.SyncChainComplete, om.syncWatcher)
 	eventemitter.On(eventemitter.ChainForkDetected, om.forkWatcher)
 	eventemitter.On(eventemitter.ExtractorWarning, om.warningWatcher)
 	eventemitter.On(eventemitter.Miner_SubmitRing_Method, om.submitRingMethodWatcher)
+	eventemitter.On(eventemitter.OrderFilled, om.p2pFilledWatcher)
+}
+
+// ChainEventHandlers 链上事件的处理,已经处理过的事件会跳过,回填历史块时可以重复执行
+func (om *OrderManagerImpl) ChainEventHandlers() map[string]func(input eventemitter.EventData) error {
+	return map[string]func(input eventemitter.EventData) error{
+		eventemitter.RingMined:         om.handleRingMined,
+		eventemitter.RingHashSubmitted: om.handleRinghashSubmitted,
+		eventemitter.OrderFilled:       om.handleOrderFilled,
+		eventemitter.CancelOrder:       om.handleOrderCancelled,
+		eventemitter.CutoffAll:         om.handleCutoff,
+		eventemitter.CutoffPair:        om.handleCutoffPair,
+	}
 }
 
 func (om *OrderManagerImpl) Stop() {
 	eventemitter.Un(eventemitter.NewOrder, om.newOrderWatcher)
 	eventemitter.Un(eventemitter.RingMined, om.ringMinedWatcher)
+	eventemitter.Un(eventemitter.RingHashSubmitted, om.ringhashWatcher)
 	eventemitter.Un(eventemitter.OrderFilled, om.fillOrderWatcher)
 	eventemitter.Un(eventemitter.CancelOrder, om.cancelOrderWatcher)
 	eventemitter.Un(eventemitter.CutoffAll, om.cutoffOrderWatcher)
+	eventemitter.Un(eventemitter.CutoffPair, om.cutoffPairWatcher)
+	eventemitter.Un(eventemitter.AccountFundsSynced, om.fundsSyncedWatcher)
 	//eventemitter.Un(eventemitter.SyncChainComplete, om.syncWatcher)
 	eventemitter.Un(eventemitter.ChainForkDetected, om.forkWatcher)
 	eventemitter.Un(eventemitter.ExtractorWarning, om.warningWatcher)
 	eventemitter.Un(eventemitter.Miner_SubmitRing_Method, om.submitRingMethodWatcher)
+	eventemitter.Un(eventemitter.OrderFilled, om.p2pFilledWatcher)
 
 	//om.ordersValidForMiner = false
 }
@@ -146,11 +192,76 @@ func (om *OrderManagerImpl) handleFork(input eventemitter.EventData) error {
 	if err := om.processor.Fork(input.(*types.ForkedEvent)); err != nil {
 		log.Fatalf("order manager,handle fork error:%s", err.Error())
 	}
+	if nil != om.book {
+		if err := om.book.Load(om.rds); err != nil {
+			log.Errorf("order manager,handle fork,reload order book error:%s", err.Error())
+		}
+	}
+	if err := om.ledger.Load(om.rds); err != nil {
+		log.Errorf("order manager,handle fork,reload frozen ledger error:%s", err.Error())
+	}
 	om.Start()
 
 	return nil
 }
 
+// 冻结金额账本随订单事件增量更新,定时处理订单生效/过期,并按配置与数据库全量计算结果核对
+func (om *OrderManagerImpl) startFrozenLedger() {
+	if err := om.ledger.Load(om.rds); err != nil {
+		log.Fatalf("order manager,load frozen ledger error:%s", err.Error())
+	}
+
+	go func() {
+		lastReconcile := time.Now().Unix()
+		for {
+			select {
+			case <-time.After(time.Minute):
+				if om.options.FrozenReconcileInterval > 0 && time.Now().Unix()-lastReconcile >= om.options.FrozenReconcileInterval {
+					if diffs, err := om.ledger.Reconcile(om.rds); err != nil {
+						log.Errorf("order manager,reconcile frozen ledger error:%s", err.Error())
+					} else {
+						log.Debugf("order manager,reconcile frozen ledger,%d diffs fixed", diffs)
+						lastReconcile = time.Now().Unix()
+					}
+				} else {
+					om.ledger.Refresh()
+				}
+			}
+		}
+	}()
+}
+
+const defaultOrderBookReloadInterval = 300
+
+// 启动时从数据库加载订单簿,并定时重新加载,弥补本进程没有处理到的更新(例如过期订单以及其他进程写入的订单)
+func (om *OrderManagerImpl) startOrderBook() {
+	if err := om.book.Load(om.rds); err != nil {
+		log.Fatalf("order manager,load order book error:%s", err.Error())
+	}
+
+	reloadInterval := om.options.OrderBookReloadInterval
+	if reloadInterval <= 0 {
+		reloadInterval = defaultOrderBookReloadInterval
+	}
+	go func() {
+		lastReload := time.Now().Unix()
+		for {
+			select {
+			case <-time.After(time.Minute):
+				if time.Now().Unix()-lastReload >= reloadInterval {
+					if err := om.book.Load(om.rds); err != nil {
+						log.Errorf("order manager,reload order book error:%s", err.Error())
+					} else {
+						lastReload = time.Now().Unix()
+					}
+				} else {
+					om.book.RemoveExpired()
+				}
+			}
+		}
+	}()
+}
+
 func (om *OrderManagerImpl) handleWarning(input eventemitter.EventData) error {
 	log.Debugf("order manager processing extractor warning")
 	om.Stop()
@@ -186,14 +297,80 @@ func (om *OrderManagerImpl) handleGatewayOrder(input eventemitter.EventData) err
 	state := input.(*types.OrderState)
 	log.Debugf("order manager,handle gateway order,order.hash:%s amountS:%s", state.RawOrder.Hash.Hex(), state.RawOrder.AmountS.String())
 
+	return om.saveNewOrder(state, func(rds dao.RdsService, model *dao.Order) error {
+		return rds.Add(model)
+	})
+}
+
+// ReleaseTriggeredOrder 条件单触发后覆盖原有的pending trigger记录,订单与条件单状态在同一个数据库事务中更新,
+// 失败时条件单依然是pending状态,下次检查价格时重试
+func (om *OrderManagerImpl) ReleaseTriggeredOrder(state *types.OrderState, triggerTime int64) error {
+	log.Debugf("order manager,release triggered order,order.hash:%s", state.RawOrder.Hash.Hex())
+
+	return om.saveNewOrder(state, func(rds dao.RdsService, model *dao.Order) error {
+		trigger, err := rds.GetOrderTrigger(state.RawOrder.Hash)
+		if err != nil {
+			return err
+		}
+		if trigger.Status != uint8(types.TRIGGER_STATUS_PENDING) {
+			return fmt.Errorf("order manager,order:%s trigger is not pending", state.RawOrder.Hash.Hex())
+		}
+		pending, err := rds.GetOrderByHash(state.RawOrder.Hash)
+		if err != nil {
+			return err
+		}
+		if pending.Status != uint8(types.ORDER_PENDING_TRIGGER) {
+			return fmt.Errorf("order manager,order:%s is not pending trigger", state.RawOrder.Hash.Hex())
+		}
+
+		model.ID = pending.ID
+		if err := rds.Save(model); err != nil {
+			return err
+		}
+		return rds.UpdateOrderTriggerStatus(state.RawOrder.Hash, types.TRIGGER_STATUS_TRIGGERED, triggerTime)
+	})
+}
+
+// saveNewOrder persist在数据库事务中写入订单,提交成功后再更新订单簿等内存状态
+func (om *OrderManagerImpl) saveNewOrder(state *types.OrderState, persist func(rds dao.RdsService, model *dao.Order) error) error {
 	model, err := newOrderEntity(state, om.mc, nil)
 	if err != nil {
 		log.Errorf("order manager,handle gateway order:%s error", state.RawOrder.Hash.Hex())
 		return err
 	}
 
+	if err := om.rds.Transaction(func(rds dao.RdsService) error {
+		return persist(rds, model)
+	}); err != nil {
+		return err
+	}
+
 	eventemitter.Emit(eventemitter.DepthUpdated, types.DepthUpdateEvent{DelegateAddress: model.DelegateAddress, Market: model.Market})
-	return om.rds.Add(model)
+
+	state.RawOrder.CreateTime = model.CreateTime
+	if nil != om.book {
+		om.book.Upsert(state, model.MinerBlockMark)
+	}
+	om.ledger.Upsert(state)
+
+	if err := om.updateFundableAmount(state.RawOrder.Owner, state.RawOrder.TokenS); err != nil {
+		log.Errorf("order manager,handle gateway order:%s update fundable amount error:%s", state.RawOrder.Hash.Hex(), err.Error())
+	}
+
+	return nil
+}
+
+// processed_event表中ordermanager的handler前缀,分叉时按前缀删除登记
+const processedEventPrefix = "ordermanager."
+
+// processOnce 以(txhash,logIndex,ordermanager.topic)登记事件,process在同一个数据库事务中执行,
+// 订单簿等内存状态需要在返回true之后再更新
+func (om *OrderManagerImpl) processOnce(topic string, info types.TxInfo, logIndex int64, process func(rds dao.RdsService) error) (bool, error) {
+	processed, err := om.rds.ProcessEventOnce(info.TxHash.Hex(), logIndex, processedEventPrefix+topic, info.BlockNumber.Int64(), process)
+	if err == nil && !processed {
+		log.Debugf("order manager,%s event tx:%s logIndex:%d has already been processed", topic, info.TxHash.Hex(), logIndex)
+	}
+	return processed, err
 }
 
 func (om *OrderManagerImpl) handleRingMined(input eventemitter.EventData) error {
@@ -203,83 +380,127 @@ func (om *OrderManagerImpl) handleRingMined(input eventemitter.EventData) error
 		return nil
 	}
 
-	var (
-		model = &dao.RingMinedEvent{}
-		err   error
-	)
+	_, err := om.processOnce(eventemitter.RingMined, event.TxInfo, event.TxLogIndex, func(rds dao.RdsService) error {
+		if _, err := rds.FindRingMined(event.TxHash.Hex()); err == nil {
+			log.Debugf("order manager,handle ringmined event,ring %s has already exist", event.Ringhash.Hex())
+			return nil
+		}
 
-	model, err = om.rds.FindRingMined(event.TxHash.Hex())
-	if err == nil {
-		return fmt.Errorf("order manager,handle ringmined event,ring %s has already exist", event.Ringhash.Hex())
-	}
-	model.ConvertDown(event)
-	if err = om.rds.Add(model); err != nil {
-		return fmt.Errorf("order manager,handle ringmined event,insert ring error:%s", err.Error())
-	}
+		model := &dao.RingMinedEvent{}
+		model.ConvertDown(event)
+		if err := rds.Add(model); err != nil {
+			return fmt.Errorf("order manager,handle ringmined event,insert ring error:%s", err.Error())
+		}
+		return nil
+	})
 
-	return nil
+	return err
 }
 
-func (om *OrderManagerImpl) handleOrderFilled(input eventemitter.EventData) error {
-	event := input.(*types.OrderFilledEvent)
+// handleRinghashSubmitted 保存ringhash登记,miner通过数据库确认自己的登记以及避开其他miner预留的环路
+func (om *OrderManagerImpl) handleRinghashSubmitted(input eventemitter.EventData) error {
+	event := input.(*types.RinghashSubmittedEvent)
 
 	if event.Status != types.TX_STATUS_SUCCESS {
 		return nil
 	}
 
-	// save fill event
-	_, err := om.rds.FindFillEvent(event.TxHash.Hex(), event.FillIndex.Int64())
-	if err == nil {
-		log.Debugf("order manager,handle order filled event,fill already exist tx:%s fillIndex:%d", event.TxHash.String(), event.FillIndex)
+	_, err := om.processOnce(eventemitter.RingHashSubmitted, event.TxInfo, event.TxLogIndex, func(rds dao.RdsService) error {
+		if _, err := rds.FindRinghashSubmitted(event.TxHash.Hex(), event.TxLogIndex); err == nil {
+			log.Debugf("order manager,handle ringhash submitted event,ringhash %s has already exist", event.Ringhash.Hex())
+			return nil
+		}
+
+		model := &dao.RinghashSubmittedEvent{}
+		model.ConvertDown(event)
+		if err := rds.Add(model); err != nil {
+			return fmt.Errorf("order manager,handle ringhash submitted event,insert error:%s", err.Error())
+		}
 		return nil
-	}
+	})
 
-	// get rds.Order and types.OrderState
-	state := &types.OrderState{UpdatedBlock: event.BlockNumber}
-	model, err := om.rds.GetOrderByHash(event.OrderHash)
-	if err != nil {
-		return err
-	}
-	if err := model.ConvertUp(state); err != nil {
-		return err
-	}
+	return err
+}
 
-	newFillModel := &dao.FillEvent{}
-	newFillModel.ConvertDown(event)
-	newFillModel.Fork = false
-	newFillModel.OrderType = state.RawOrder.OrderType
-	newFillModel.Side = util.GetSide(util.AddressToAlias(event.TokenS.Hex()), util.AddressToAlias(event.TokenB.Hex()))
-	if err := om.rds.Add(newFillModel); err != nil {
-		log.Debugf("order manager,handle order filled event error:fill %s insert failed", event.OrderHash.Hex())
-		return err
-	}
+// 同一个ringmined的fill共用logIndex,这里用fillIndex登记
+func (om *OrderManagerImpl) handleOrderFilled(input eventemitter.EventData) error {
+	event := input.(*types.OrderFilledEvent)
 
-	// judge order status
-	if state.Status == types.ORDER_CUTOFF || state.Status == types.ORDER_FINISHED || state.Status == types.ORDER_UNKNOWN {
-		log.Debugf("order manager,handle order filled event,order %s status is %d ", state.RawOrder.Hash.Hex(), state.Status)
+	if event.Status != types.TX_STATUS_SUCCESS {
 		return nil
 	}
 
-	// calculate dealt amount
-	state.UpdatedBlock = event.BlockNumber
-	state.DealtAmountS = new(big.Int).Add(state.DealtAmountS, event.AmountS)
-	state.DealtAmountB = new(big.Int).Add(state.DealtAmountB, event.AmountB)
-	state.SplitAmountS = new(big.Int).Add(state.SplitAmountS, event.SplitS)
-	state.SplitAmountB = new(big.Int).Add(state.SplitAmountB, event.SplitB)
+	var (
+		state   *types.OrderState
+		model   *dao.Order
+		updated bool
+	)
 
-	log.Debugf("order manager,handle order filled event orderhash:%s,dealAmountS:%s,dealtAmountB:%s", state.RawOrder.Hash.Hex(), state.DealtAmountS.String(), state.DealtAmountB.String())
+	processed, err := om.processOnce(eventemitter.OrderFilled, event.TxInfo, event.FillIndex.Int64(), func(rds dao.RdsService) error {
+		// save fill event
+		if _, err := rds.FindFillEvent(event.TxHash.Hex(), event.FillIndex.Int64()); err == nil {
+			log.Debugf("order manager,handle order filled event,fill already exist tx:%s fillIndex:%d", event.TxHash.String(), event.FillIndex)
+			return nil
+		}
 
-	// update order status
-	settleOrderStatus(state, om.mc, ORDER_FROM_FILL)
+		// get rds.Order and types.OrderState
+		var err error
+		state = &types.OrderState{UpdatedBlock: event.BlockNumber}
+		model, err = rds.GetOrderByHash(event.OrderHash)
+		if err != nil {
+			return err
+		}
+		if err := model.ConvertUp(state); err != nil {
+			return err
+		}
+
+		newFillModel := &dao.FillEvent{}
+		newFillModel.ConvertDown(event)
+		newFillModel.Fork = false
+		newFillModel.OrderType = state.RawOrder.OrderType
+		newFillModel.Side = util.GetSide(util.AddressToAlias(event.TokenS.Hex()), util.AddressToAlias(event.TokenB.Hex()))
+		if err := rds.Add(newFillModel); err != nil {
+			log.Debugf("order manager,handle order filled event error:fill %s insert failed", event.OrderHash.Hex())
+			return err
+		}
+
+		// judge order status
+		if state.Status == types.ORDER_CUTOFF || state.Status == types.ORDER_FINISHED || state.Status == types.ORDER_UNKNOWN {
+			log.Debugf("order manager,handle order filled event,order %s status is %d ", state.RawOrder.Hash.Hex(), state.Status)
+			return nil
+		}
+
+		// calculate dealt amount
+		state.UpdatedBlock = event.BlockNumber
+		state.DealtAmountS = new(big.Int).Add(state.DealtAmountS, event.AmountS)
+		state.DealtAmountB = new(big.Int).Add(state.DealtAmountB, event.AmountB)
+		state.SplitAmountS = new(big.Int).Add(state.SplitAmountS, event.SplitS)
+		state.SplitAmountB = new(big.Int).Add(state.SplitAmountB, event.SplitB)
+
+		log.Debugf("order manager,handle order filled event orderhash:%s,dealAmountS:%s,dealtAmountB:%s", state.RawOrder.Hash.Hex(), state.DealtAmountS.String(), state.DealtAmountB.String())
 
-	// update rds.Order
-	if err := model.ConvertDown(state); err != nil {
-		log.Errorf(err.Error())
+		// update order status
+		settleOrderStatus(state, om.mc, ORDER_FROM_FILL)
+
+		// update rds.Order
+		if err := model.ConvertDown(state); err != nil {
+			log.Errorf(err.Error())
+			return err
+		}
+		if err := rds.UpdateOrderWhileFill(state.RawOrder.Hash, state.Status, state.DealtAmountS, state.DealtAmountB, state.SplitAmountS, state.SplitAmountB, state.UpdatedBlock); err != nil {
+			return err
+		}
+		updated = true
+		return nil
+	})
+	if err != nil || !processed || !updated {
 		return err
 	}
-	if err := om.rds.UpdateOrderWhileFill(state.RawOrder.Hash, state.Status, state.DealtAmountS, state.DealtAmountB, state.SplitAmountS, state.SplitAmountB, state.UpdatedBlock); err != nil {
-		return err
+
+	if nil != om.book {
+		om.book.Upsert(state, model.MinerBlockMark)
 	}
+	om.ledger.Upsert(state)
 
 	return nil
 }
@@ -291,48 +512,71 @@ func (om *OrderManagerImpl) handleOrderCancelled(input eventemitter.EventData) e
 		return nil
 	}
 
-	// save cancel event
-	_, err := om.rds.GetCancelEvent(event.TxHash)
-	if err == nil {
-		log.Debugf("order manager,handle order cancelled event error:event %s have already exist", event.OrderHash.Hex())
-		return nil
-	}
-	newCancelEventModel := &dao.CancelEvent{}
-	newCancelEventModel.ConvertDown(event)
-	newCancelEventModel.Fork = false
-	if err := om.rds.Add(newCancelEventModel); err != nil {
-		return err
-	}
+	var (
+		state   *types.OrderState
+		model   *dao.Order
+		updated bool
+	)
 
-	// get rds.Order and types.OrderState
-	state := &types.OrderState{}
-	model, err := om.rds.GetOrderByHash(event.OrderHash)
-	if err != nil {
-		return err
-	}
-	if err := model.ConvertUp(state); err != nil {
-		return err
-	}
+	processed, err := om.processOnce(eventemitter.CancelOrder, event.TxInfo, event.TxLogIndex, func(rds dao.RdsService) error {
+		// save cancel event
+		if _, err := rds.GetCancelEvent(event.TxHash); err == nil {
+			log.Debugf("order manager,handle order cancelled event,event %s have already exist", event.OrderHash.Hex())
+			return nil
+		}
+		newCancelEventModel := &dao.CancelEvent{}
+		newCancelEventModel.ConvertDown(event)
+		newCancelEventModel.Fork = false
+		if err := rds.Add(newCancelEventModel); err != nil {
+			return err
+		}
 
-	// calculate remainAmount and cancelled amount should be saved whether order is finished or not
-	if state.RawOrder.BuyNoMoreThanAmountB {
-		state.CancelledAmountB = new(big.Int).Add(state.CancelledAmountB, event.AmountCancelled)
-		log.Debugf("order manager,handle order cancelled event,order:%s cancelled amountb:%s", state.RawOrder.Hash.Hex(), state.CancelledAmountB.String())
-	} else {
-		state.CancelledAmountS = new(big.Int).Add(state.CancelledAmountS, event.AmountCancelled)
-		log.Debugf("order manager,handle order cancelled event,order:%s cancelled amounts:%s", state.RawOrder.Hash.Hex(), state.CancelledAmountS.String())
-	}
+		// get rds.Order and types.OrderState
+		var err error
+		state = &types.OrderState{}
+		model, err = rds.GetOrderByHash(event.OrderHash)
+		if err != nil {
+			return err
+		}
+		if err := model.ConvertUp(state); err != nil {
+			return err
+		}
 
-	// update order status
-	settleOrderStatus(state, om.mc, ORDER_FROM_CANCEL)
-	state.UpdatedBlock = event.BlockNumber
+		// calculate remainAmount and cancelled amount should be saved whether order is finished or not
+		if state.RawOrder.BuyNoMoreThanAmountB {
+			state.CancelledAmountB = new(big.Int).Add(state.CancelledAmountB, event.AmountCancelled)
+			log.Debugf("order manager,handle order cancelled event,order:%s cancelled amountb:%s", state.RawOrder.Hash.Hex(), state.CancelledAmountB.String())
+		} else {
+			state.CancelledAmountS = new(big.Int).Add(state.CancelledAmountS, event.AmountCancelled)
+			log.Debugf("order manager,handle order cancelled event,order:%s cancelled amounts:%s", state.RawOrder.Hash.Hex(), state.CancelledAmountS.String())
+		}
+
+		// update order status
+		settleOrderStatus(state, om.mc, ORDER_FROM_CANCEL)
+		state.UpdatedBlock = event.BlockNumber
 
-	// update rds.Order
-	if err := model.ConvertDown(state); err != nil {
+		// update rds.Order
+		if err := model.ConvertDown(state); err != nil {
+			return err
+		}
+		if err := rds.UpdateOrderWhileCancel(state.RawOrder.Hash, state.Status, state.CancelledAmountS, state.CancelledAmountB, state.UpdatedBlock); err != nil {
+			return err
+		}
+		updated = true
+		return nil
+	})
+	if err != nil || !processed || !updated {
 		return err
 	}
-	if err := om.rds.UpdateOrderWhileCancel(state.RawOrder.Hash, state.Status, state.CancelledAmountS, state.CancelledAmountB, state.UpdatedBlock); err != nil {
-		return err
+
+	if nil != om.book {
+		om.book.Upsert(state, model.MinerBlockMark)
+	}
+	om.ledger.Upsert(state)
+
+	// 取消的部分释放给同一owner的其他订单
+	if err := om.updateFundableAmount(state.RawOrder.Owner, state.RawOrder.TokenS); err != nil {
+		log.Errorf("order manager,handle order cancelled:%s update fundable amount error:%s", state.RawOrder.Hash.Hex(), err.Error())
 	}
 
 	return nil
@@ -346,40 +590,64 @@ func (om *OrderManagerImpl) handleCutoff(input eventemitter.EventData) error {
 		return nil
 	}
 
-	// check tx exist
-	_, err := om.rds.GetCutoffEvent(evt.TxHash)
-	if err == nil {
-		log.Debugf("order manager,handle order cutoff event error:event %s have already exist", evt.TxHash.Hex())
-		return nil
-	}
+	var (
+		orderHashList []common.Hash
+		tokens        = make(map[common.Address]bool)
+		updated       bool
+	)
 
-	lastCutoff := om.cutoffCache.GetCutoff(evt.Protocol, evt.Owner)
+	processed, err := om.processOnce(eventemitter.CutoffAll, evt.TxInfo, evt.TxLogIndex, func(rds dao.RdsService) error {
+		// check tx exist
+		if _, err := rds.GetCutoffEvent(evt.TxHash); err == nil {
+			log.Debugf("order manager,handle order cutoff event,event %s have already exist", evt.TxHash.Hex())
+			return nil
+		}
 
-	var orderHashList []common.Hash
+		lastCutoff := om.cutoffCache.GetCutoff(evt.Protocol, evt.Owner)
 
-	// 首次存储到缓存，lastCutoff == currentCutoff
-	if evt.Cutoff.Cmp(lastCutoff) < 0 {
-		log.Debugf("order manager,handle cutoff event, protocol:%s - owner:%s lastCutofftime:%s > currentCutoffTime:%s", evt.Protocol.Hex(), evt.Owner.Hex(), lastCutoff.String(), evt.Cutoff.String())
-	} else {
-		om.cutoffCache.UpdateCutoff(evt.Protocol, evt.Owner, evt.Cutoff)
-		if orders, _ := om.rds.GetCutoffOrders(evt.Owner, evt.Cutoff); len(orders) > 0 {
-			for _, v := range orders {
-				var state types.OrderState
-				v.ConvertUp(&state)
-				orderHashList = append(orderHashList, state.RawOrder.Hash)
+		// 首次存储到缓存，lastCutoff == currentCutoff
+		if evt.Cutoff.Cmp(lastCutoff) < 0 {
+			log.Debugf("order manager,handle cutoff event, protocol:%s - owner:%s lastCutofftime:%s > currentCutoffTime:%s", evt.Protocol.Hex(), evt.Owner.Hex(), lastCutoff.String(), evt.Cutoff.String())
+		} else {
+			updated = true
+			if orders, _ := rds.GetCutoffOrders(evt.Owner, evt.Cutoff); len(orders) > 0 {
+				for _, v := range orders {
+					var state types.OrderState
+					v.ConvertUp(&state)
+					orderHashList = append(orderHashList, state.RawOrder.Hash)
+					tokens[state.RawOrder.TokenS] = true
+				}
+				if err := rds.SetCutOffOrders(orderHashList, evt.BlockNumber); err != nil {
+					return err
+				}
 			}
-			om.rds.SetCutOffOrders(orderHashList, evt.BlockNumber)
+			log.Debugf("order manager,handle cutoff event, owner:%s, cutoffTimestamp:%s", evt.Owner.Hex(), evt.Cutoff.String())
 		}
-		log.Debugf("order manager,handle cutoff event, owner:%s, cutoffTimestamp:%s", evt.Owner.Hex(), evt.Cutoff.String())
+
+		// save cutoff event
+		evt.OrderHashList = orderHashList
+		newCutoffEventModel := &dao.CutOffEvent{}
+		newCutoffEventModel.ConvertDown(evt)
+		newCutoffEventModel.Fork = false
+
+		return rds.Add(newCutoffEventModel)
+	})
+	if err != nil || !processed || !updated {
+		return err
 	}
 
-	// save cutoff event
-	evt.OrderHashList = orderHashList
-	newCutoffEventModel := &dao.CutOffEvent{}
-	newCutoffEventModel.ConvertDown(evt)
-	newCutoffEventModel.Fork = false
+	om.cutoffCache.UpdateCutoff(evt.Protocol, evt.Owner, evt.Cutoff)
+	if len(orderHashList) > 0 {
+		if nil != om.book {
+			om.book.Remove(orderHashList...)
+		}
+		om.ledger.Remove(orderHashList...)
+		for token := range tokens {
+			om.updateFundableAmount(evt.Owner, token)
+		}
+	}
 
-	return om.rds.Add(newCutoffEventModel)
+	return nil
 }
 
 func (om *OrderManagerImpl) handleCutoffPair(input eventemitter.EventData) error {
@@ -389,39 +657,61 @@ func (om *OrderManagerImpl) handleCutoffPair(input eventemitter.EventData) error
 		return nil
 	}
 
-	// check tx exist
-	_, err := om.rds.GetCutoffPairEvent(evt.TxHash)
-	if err == nil {
-		log.Debugf("order manager,handle order cutoffPair event error:event %s have already exist", evt.TxHash.Hex())
-		return nil
-	}
+	var (
+		orderHashList []common.Hash
+		updated       bool
+	)
 
-	lastCutoffPair := om.cutoffCache.GetCutoffPair(evt.Protocol, evt.Owner, evt.Token1, evt.Token2)
+	processed, err := om.processOnce(eventemitter.CutoffPair, evt.TxInfo, evt.TxLogIndex, func(rds dao.RdsService) error {
+		// check tx exist
+		if _, err := rds.GetCutoffPairEvent(evt.TxHash); err == nil {
+			log.Debugf("order manager,handle order cutoffPair event,event %s have already exist", evt.TxHash.Hex())
+			return nil
+		}
 
-	var orderHashList []common.Hash
-	// 首次存储到缓存，lastCutoffPair == currentCutoffPair
-	if evt.Cutoff.Cmp(lastCutoffPair) < 0 {
-		log.Debugf("order manager,handle cutoffPair event, protocol:%s - owner:%s lastCutoffPairtime:%s > currentCutoffPairTime:%s", evt.Protocol.Hex(), evt.Owner.Hex(), lastCutoffPair.String(), evt.Cutoff.String())
-	} else {
-		om.cutoffCache.UpdateCutoffPair(evt.Protocol, evt.Owner, evt.Token1, evt.Token2, evt.Cutoff)
-		if orders, _ := om.rds.GetCutoffPairOrders(evt.Owner, evt.Token1, evt.Token2, evt.Cutoff); len(orders) > 0 {
-			for _, v := range orders {
-				var state types.OrderState
-				v.ConvertUp(&state)
-				orderHashList = append(orderHashList, state.RawOrder.Hash)
+		lastCutoffPair := om.cutoffCache.GetCutoffPair(evt.Protocol, evt.Owner, evt.Token1, evt.Token2)
+
+		// 首次存储到缓存，lastCutoffPair == currentCutoffPair
+		if evt.Cutoff.Cmp(lastCutoffPair) < 0 {
+			log.Debugf("order manager,handle cutoffPair event, protocol:%s - owner:%s lastCutoffPairtime:%s > currentCutoffPairTime:%s", evt.Protocol.Hex(), evt.Owner.Hex(), lastCutoffPair.String(), evt.Cutoff.String())
+		} else {
+			updated = true
+			if orders, _ := rds.GetCutoffPairOrders(evt.Owner, evt.Token1, evt.Token2, evt.Cutoff); len(orders) > 0 {
+				for _, v := range orders {
+					var state types.OrderState
+					v.ConvertUp(&state)
+					orderHashList = append(orderHashList, state.RawOrder.Hash)
+				}
+				if err := rds.SetCutOffOrders(orderHashList, evt.BlockNumber); err != nil {
+					return err
+				}
 			}
-			om.rds.SetCutOffOrders(orderHashList, evt.BlockNumber)
+			log.Debugf("order manager,handle cutoffPair event, owner:%s, token1:%s, token2:%s, cutoffTimestamp:%s", evt.Owner.Hex(), evt.Token1.Hex(), evt.Token2.Hex(), evt.Cutoff.String())
 		}
-		log.Debugf("order manager,handle cutoffPair event, owner:%s, token1:%s, token2:%s, cutoffTimestamp:%s", evt.Owner.Hex(), evt.Token1.Hex(), evt.Token2.Hex(), evt.Cutoff.String())
+
+		// save transaction
+		evt.OrderHashList = orderHashList
+		newCutoffPairEventModel := &dao.CutOffPairEvent{}
+		newCutoffPairEventModel.ConvertDown(evt)
+		newCutoffPairEventModel.Fork = false
+
+		return rds.Add(newCutoffPairEventModel)
+	})
+	if err != nil || !processed || !updated {
+		return err
 	}
 
-	// save transaction
-	evt.OrderHashList = orderHashList
-	newCutoffPairEventModel := &dao.CutOffPairEvent{}
-	newCutoffPairEventModel.ConvertDown(evt)
-	newCutoffPairEventModel.Fork = false
+	om.cutoffCache.UpdateCutoffPair(evt.Protocol, evt.Owner, evt.Token1, evt.Token2, evt.Cutoff)
+	if len(orderHashList) > 0 {
+		if nil != om.book {
+			om.book.Remove(orderHashList...)
+		}
+		om.ledger.Remove(orderHashList...)
+		om.updateFundableAmount(evt.Owner, evt.Token1)
+		om.updateFundableAmount(evt.Owner, evt.Token2)
+	}
 
-	return om.rds.Add(newCutoffPairEventModel)
+	return nil
 }
 
 func (om *OrderManagerImpl) IsOrderFullFinished(state *types.OrderState) bool {
@@ -436,7 +726,7 @@ func (om *OrderManagerImpl) IsValueDusted(tokenAddress common.Address, value *bi
 	}
 }
 
-func (om *OrderManagerImpl) MinerOrders(protocol, tokenS, tokenB common.Address, length int, reservedTime, startBlockNumber, endBlockNumber int64, filterOrderHashLists ...*types.OrderDelayList) []*types.OrderState {
+func (om *OrderManagerImpl) MinerOrders(protocol, tokenS, tokenB common.Address, length int, reservedTime, startBlockNumber, endBlockNumber int64, priority types.OrderPriority, filterOrderHashLists ...*types.OrderDelayList) []*types.OrderState {
 	var list []*types.OrderState
 
 	// 订单在extractor同步结束后才可以提供给miner进行撮合
@@ -444,33 +734,30 @@ func (om *OrderManagerImpl) MinerOrders(protocol, tokenS, tokenB common.Address,
 	//	return list
 	//}
 
-	var (
-		modelList    []*dao.Order
-		err          error
-		filterStatus = []types.OrderStatus{types.ORDER_FINISHED, types.ORDER_CUTOFF, types.ORDER_CANCEL}
-	)
-
 	for _, orderDelay := range filterOrderHashLists {
 		orderHashes := []string{}
 		for _, hash := range orderDelay.OrderHash {
 			orderHashes = append(orderHashes, hash.Hex())
 		}
 		if len(orderHashes) > 0 && orderDelay.DelayedCount != 0 {
-			if err = om.rds.MarkMinerOrders(orderHashes, orderDelay.DelayedCount); err != nil {
+			// 数据库中同样标记,订单簿重新加载后延迟依然有效
+			if err := om.rds.MarkMinerOrders(orderHashes, orderDelay.DelayedCount); err != nil {
 				log.Debugf("order manager,provide orders for miner error:%s", err.Error())
 			}
+			if nil != om.book {
+				om.book.MarkMinerOrders(orderDelay.OrderHash, orderDelay.DelayedCount)
+			}
 		}
 	}
 
-	// 从数据库获取订单
-	if modelList, err = om.rds.GetOrdersForMiner(protocol.Hex(), tokenS.Hex(), tokenB.Hex(), length, filterStatus, reservedTime, startBlockNumber, endBlockNumber); err != nil {
-		log.Errorf("err:%s", err.Error())
-		return list
+	var states []*types.OrderState
+	if nil != om.book {
+		states = om.book.PriorityMinerOrders(priority, protocol, tokenS, tokenB, length, reservedTime, startBlockNumber, endBlockNumber)
+	} else {
+		states = om.minerOrdersFromDb(protocol, tokenS, tokenB, length, reservedTime, startBlockNumber, endBlockNumber, priority)
 	}
 
-	for _, v := range modelList {
-		state := &types.OrderState{}
-		v.ConvertUp(state)
+	for _, state := range states {
 		if om.um.InWhiteList(state.RawOrder.Owner) {
 			list = append(list, state)
 		} else {
@@ -481,22 +768,56 @@ func (om *OrderManagerImpl) MinerOrders(protocol, tokenS, tokenB common.Address,
 	return list
 }
 
+// minerOrdersCandidateFactor 按价格以外的优先级排序时,从数据库多取一些候选订单
+const minerOrdersCandidateFactor = 4
+
+// minerOrdersFromDb 没有本地订单簿时从数据库查询,放入临时订单簿以保持与本地订单簿相同的筛选和排序规则
+func (om *OrderManagerImpl) minerOrdersFromDb(protocol, tokenS, tokenB common.Address, length int, reservedTime, startBlockNumber, endBlockNumber int64, priority types.OrderPriority) []*types.OrderState {
+	filterStatus := []types.OrderStatus{types.ORDER_FINISHED, types.ORDER_CUTOFF, types.ORDER_CANCEL}
+	candidates := length
+	if nil != priority && types.ORDER_PRIORITY_PRICE_TIME != priority.Name() {
+		candidates = length * minerOrdersCandidateFactor
+	}
+
+	modelList, err := om.rds.GetOrdersForMiner(protocol.Hex(), tokenS.Hex(), tokenB.Hex(), candidates, filterStatus, reservedTime, startBlockNumber, endBlockNumber)
+	if err != nil {
+		log.Errorf("err:%s", err.Error())
+		return nil
+	}
+
+	book := NewOrderBook()
+	for _, v := range modelList {
+		state := &types.OrderState{}
+		if err := v.ConvertUp(state); err != nil {
+			log.Debugf("order manager,miner orders,order:%s error:%s", v.OrderHash, err.Error())
+			continue
+		}
+		book.Upsert(state, v.MinerBlockMark)
+	}
+
+	return book.PriorityMinerOrders(priority, protocol, tokenS, tokenB, length, reservedTime, startBlockNumber, endBlockNumber)
+}
+
 func (om *OrderManagerImpl) GetOrderBook(protocol, tokenS, tokenB common.Address, length int) ([]types.OrderState, error) {
-	var list []types.OrderState
+	if nil != om.book {
+		return om.book.Depth(protocol, tokenS, tokenB, length), nil
+	}
+
 	models, err := om.rds.GetOrderBook(protocol, tokenS, tokenB, length)
 	if err != nil {
-		return list, err
+		return nil, err
 	}
 
+	book := NewOrderBook()
 	for _, v := range models {
-		var state types.OrderState
-		if err := v.ConvertUp(&state); err != nil {
+		state := &types.OrderState{}
+		if err := v.ConvertUp(state); err != nil {
 			continue
 		}
-		list = append(list, state)
+		book.Upsert(state, v.MinerBlockMark)
 	}
 
-	return list, nil
+	return book.Depth(protocol, tokenS, tokenB, length), nil
 }
 
 func (om *OrderManagerImpl) GetOrders(query map[string]interface{}, statusList []types.OrderStatus, pageIndex, pageSize int) (dao.PageResult, error) {
@@ -567,6 +888,10 @@ func (om *OrderManagerImpl) IsOrderCutoff(protocol, owner, token1, token2 common
 }
 
 func (om *OrderManagerImpl) GetFrozenAmount(owner common.Address, token common.Address, statusSet []types.OrderStatus, delegateAddress common.Address) (*big.Int, error) {
+	if isFrozenStatusSet(statusSet) {
+		return om.ledger.FrozenAmount(owner, token, delegateAddress), nil
+	}
+
 	orderList, err := om.rds.GetFrozenAmount(owner, token, statusSet, delegateAddress)
 	if err != nil {
 		return nil, err
@@ -583,14 +908,17 @@ func (om *OrderManagerImpl) GetFrozenAmount(owner common.Address, token common.A
 		if err := v.ConvertUp(&state); err != nil {
 			continue
 		}
-		rs, _ := state.RemainedAmount()
-		totalAmount.Add(totalAmount, rs.Num())
+		totalAmount.Add(totalAmount, frozenAmountS(&state))
 	}
 
 	return totalAmount, nil
 }
 
 func (om *OrderManagerImpl) GetFrozenLRCFee(owner common.Address, statusSet []types.OrderStatus) (*big.Int, error) {
+	if isFrozenStatusSet(statusSet) {
+		return om.ledger.FrozenLrcFee(owner), nil
+	}
+
 	orderList, err := om.rds.GetFrozenLrcFee(owner, statusSet)
 	if err != nil {
 		return nil, err
@@ -609,3 +937,23 @@ func (om *OrderManagerImpl) GetFrozenLRCFee(owner common.Address, statusSet []ty
 
 	return totalAmount, nil
 }
+
+// GetOpenOrders 用户所有未过期的挂单,gateway用于检查挂单数量以及挂单总价值限制
+func (om *OrderManagerImpl) GetOpenOrders(owner common.Address, statusSet []types.OrderStatus) ([]types.OrderState, error) {
+	var list []types.OrderState
+
+	models, err := om.rds.GetOpenOrdersByOwner(owner, statusSet)
+	if err != nil {
+		return list, err
+	}
+
+	for _, v := range models {
+		var state types.OrderState
+		if err := v.ConvertUp(&state); err != nil {
+			continue
+		}
+		list = append(list, state)
+	}
+
+	return list, nil
+}
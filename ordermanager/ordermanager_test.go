@@ -33,7 +33,7 @@ func TestOrderManagerImpl_MinerOrders(t *testing.T) {
 	tokenS := common.HexToAddress("0xC02aaA39b223FE8D0A0e5C4F27eAD9083C756Cc2")
 	tokenB := common.HexToAddress("0xEF68e7C694F40c8202821eDF525dE3782458639f")
 
-	states := om.MinerOrders(common.HexToAddress("0x7b126ab811f278f288bf1d62d47334351dA20d1d"), tokenS, tokenB, 10, 0, 200000000, &types.OrderDelayList{})
+	states := om.MinerOrders(common.HexToAddress("0x7b126ab811f278f288bf1d62d47334351dA20d1d"), tokenS, tokenB, 10, 0, 0, 200000000, nil, &types.OrderDelayList{})
 	for _, v := range states {
 		t.Logf("owner:%s, hash:%s", v.RawOrder.Owner.Hex(), v.RawOrder.Hash.Hex())
 		//t.Logf("list number %d, order.hash %s", k, v.RawOrder.Hash.Hex())
@@ -61,7 +61,7 @@ func TestOrderManagerImpl_GetOrderBook(t *testing.T) {
 	}
 
 	for _, v := range list {
-		t.Logf("orderhash", v.RawOrder.Hash.Hex())
+		t.Logf("orderhash:%s", v.RawOrder.Hash.Hex())
 	}
 }
 
@@ -72,7 +72,7 @@ func TestOrderManagerImpl_GetOrders(t *testing.T) {
 	status := []types.OrderStatus{}
 	pageRes, err := om.GetOrders(query, status, 0, 20)
 	if err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err.Error())
 	}
 	for _, v := range pageRes.Data {
 		state := v.(types.OrderState)
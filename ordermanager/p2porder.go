@@ -20,6 +20,7 @@ package ordermanager
 
 import (
 	"github.com/Loopring/relay/cache"
+	"github.com/Loopring/relay/dao"
 	"github.com/Loopring/relay/eventemiter"
 	"github.com/Loopring/relay/types"
 	"strings"
@@ -29,10 +30,8 @@ const DefaultP2POrderExpireTime = 3600 * 24 * 7
 const p2pOrderPreKey = "P2P_OWNER_"
 const p2pRelationPreKey = "P2P_RELATION_"
 
-func init() {
-	p2pRingMinedWatcher := &eventemitter.Watcher{Concurrent: false, Handle: HandleP2PRingMined}
-	eventemitter.On(eventemitter.OrderFilled, p2pRingMinedWatcher)
-}
+// processed_event中p2p订单解锁的handler,与handleOrderFilled分开登记
+const p2pOrderFilledTopic = "P2POrderFilled"
 
 func SaveP2POrderRelation(takerOwner, taker, makerOwner, maker, txHash string) error {
 
@@ -57,6 +56,21 @@ func IsP2PMakerLocked(maker string) bool {
 	return false
 }
 
+// handleP2POrderFilled 每个fill只解锁一次p2p订单关系
+func (om *OrderManagerImpl) handleP2POrderFilled(input eventemitter.EventData) error {
+	evt, ok := input.(*types.OrderFilledEvent)
+	if !ok || evt == nil || evt.Status != types.TX_STATUS_SUCCESS {
+		return nil
+	}
+	processed, err := om.processOnce(p2pOrderFilledTopic, evt.TxInfo, evt.FillIndex.Int64(), func(rds dao.RdsService) error {
+		return nil
+	})
+	if err != nil || !processed {
+		return err
+	}
+	return HandleP2PRingMined(evt)
+}
+
 func HandleP2PRingMined(input eventemitter.EventData) error {
 	if evt, ok := input.(*types.OrderFilledEvent); ok && evt != nil && evt.Status == types.TX_STATUS_SUCCESS {
 		cache.SRem(p2pOrderPreKey+strings.ToLower(evt.Owner.Hex()), []byte(strings.ToLower(evt.OrderHash.Hex())))
@@ -0,0 +1,113 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ordermanager
+
+import (
+	"github.com/Loopring/relay/marketcap"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"math/big"
+)
+
+// ReplaySource 回测使用的订单来源,只维护内存订单簿,不读写数据库,
+// 订单状态由回测按照历史fill、cancel事件更新,提供给miner的方法与OrderManager一致
+type ReplaySource struct {
+	book   *OrderBook
+	states map[common.Hash]*types.OrderState
+	mc     marketcap.MarketCapProvider
+}
+
+func NewReplaySource(mc marketcap.MarketCapProvider, clock func() int64) *ReplaySource {
+	source := &ReplaySource{}
+	source.book = NewOrderBookWithClock(clock)
+	source.states = make(map[common.Hash]*types.OrderState)
+	source.mc = mc
+	return source
+}
+
+// AddOrder 订单按照刚提交时的状态加入订单簿
+func (source *ReplaySource) AddOrder(state *types.OrderState) {
+	state.Status = types.ORDER_NEW
+	state.DealtAmountS = big.NewInt(0)
+	state.DealtAmountB = big.NewInt(0)
+	state.SplitAmountS = big.NewInt(0)
+	state.SplitAmountB = big.NewInt(0)
+	state.CancelledAmountS = big.NewInt(0)
+	state.CancelledAmountB = big.NewInt(0)
+	state.FundableAmountS = nil
+	source.states[state.RawOrder.Hash] = state
+	source.book.Upsert(state, 0)
+}
+
+func (source *ReplaySource) Order(orderhash common.Hash) (*types.OrderState, bool) {
+	state, exists := source.states[orderhash]
+	return state, exists
+}
+
+func (source *ReplaySource) ApplyFill(orderhash common.Hash, amountS, amountB, splitS, splitB *big.Int) {
+	state, exists := source.states[orderhash]
+	if !exists {
+		return
+	}
+	state.DealtAmountS.Add(state.DealtAmountS, amountS)
+	state.DealtAmountB.Add(state.DealtAmountB, amountB)
+	state.SplitAmountS.Add(state.SplitAmountS, splitS)
+	state.SplitAmountB.Add(state.SplitAmountB, splitB)
+	settleOrderStatus(state, source.mc, ORDER_FROM_FILL)
+	source.book.Upsert(state, 0)
+}
+
+func (source *ReplaySource) ApplyCancel(orderhash common.Hash, amountCancelled *big.Int) {
+	state, exists := source.states[orderhash]
+	if !exists {
+		return
+	}
+	if state.RawOrder.BuyNoMoreThanAmountB {
+		state.CancelledAmountB.Add(state.CancelledAmountB, amountCancelled)
+	} else {
+		state.CancelledAmountS.Add(state.CancelledAmountS, amountCancelled)
+	}
+	settleOrderStatus(state, source.mc, ORDER_FROM_CANCEL)
+	source.book.Upsert(state, 0)
+}
+
+func (source *ReplaySource) MinerOrders(protocol, tokenS, tokenB common.Address, length int, reservedTime, startBlockNumber, endBlockNumber int64, priority types.OrderPriority, filterOrderHashLists ...*types.OrderDelayList) []*types.OrderState {
+	for _, orderDelay := range filterOrderHashLists {
+		if len(orderDelay.OrderHash) > 0 && orderDelay.DelayedCount != 0 {
+			source.book.MarkMinerOrders(orderDelay.OrderHash, orderDelay.DelayedCount)
+		}
+	}
+	return source.book.PriorityMinerOrders(priority, protocol, tokenS, tokenB, length, reservedTime, startBlockNumber, endBlockNumber)
+}
+
+func (source *ReplaySource) GetOrderBook(protocol, tokenS, tokenB common.Address, length int) ([]types.OrderState, error) {
+	return source.book.Depth(protocol, tokenS, tokenB, length), nil
+}
+
+func (source *ReplaySource) IsOrderFullFinished(state *types.OrderState) bool {
+	return isOrderFullFinished(state, source.mc)
+}
+
+func (source *ReplaySource) IsValueDusted(tokenAddress common.Address, value *big.Rat) bool {
+	if legalValue, err := source.mc.LegalCurrencyValue(tokenAddress, value); nil != err {
+		return false
+	} else {
+		return isValueDusted(legalValue)
+	}
+}
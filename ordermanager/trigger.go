@@ -0,0 +1,269 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package ordermanager
+
+import (
+	"errors"
+	"fmt"
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/eventemiter"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/market"
+	"github.com/Loopring/relay/marketcap"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"sync"
+	"time"
+)
+
+const triggerCheckInterval = 5 * time.Second
+
+// TriggerManager 管理止损/止盈条件单
+// 条件单提交后以ORDER_PENDING_TRIGGER状态保存在数据库中,不进入订单簿也不会提供给miner,
+// 当loopring成交价或者外部交易所ticker满足触发条件时,通过NewOrder事件按普通订单流程释放
+type TriggerManager struct {
+	rds          dao.RdsService
+	om           OrderManager
+	mc           marketcap.MarketCapProvider
+	trendManager *market.TrendManager
+	collector    *market.CollectorImpl
+	mtx          sync.Mutex
+	stopChan     chan bool
+	trendWatcher *eventemitter.Watcher
+}
+
+func NewTriggerManager(rds dao.RdsService, om OrderManager, mc marketcap.MarketCapProvider, trendManager *market.TrendManager, collector *market.CollectorImpl) *TriggerManager {
+	tm := &TriggerManager{}
+	tm.rds = rds
+	tm.om = om
+	tm.mc = mc
+	tm.trendManager = trendManager
+	tm.collector = collector
+	tm.stopChan = make(chan bool)
+
+	return tm
+}
+
+// Start 定时检查所有待触发的条件单,同时监听loopring价格变化尽快触发对应市场的条件单
+func (tm *TriggerManager) Start() {
+	tm.trendWatcher = &eventemitter.Watcher{Concurrent: true, Handle: tm.handleTrendUpdated}
+	eventemitter.On(eventemitter.TrendUpdated, tm.trendWatcher)
+
+	go func() {
+		ticker := time.NewTicker(triggerCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				tm.checkTriggers("")
+			case <-tm.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+func (tm *TriggerManager) Stop() {
+	eventemitter.Un(eventemitter.TrendUpdated, tm.trendWatcher)
+	close(tm.stopChan)
+}
+
+// AddConditionalOrder 保存已经通过gateway校验的订单以及触发条件
+func (tm *TriggerManager) AddConditionalOrder(state *types.OrderState, trigger *types.OrderTrigger) error {
+	if !trigger.IsValid() {
+		return errors.New("order manager,add conditional order:invalid trigger")
+	}
+
+	model, err := newOrderEntity(state, tm.mc, nil)
+	if err != nil {
+		return err
+	}
+	if state.Status != types.ORDER_NEW {
+		return fmt.Errorf("order manager,add conditional order:%s has been filled or cancelled", state.RawOrder.Hash.Hex())
+	}
+	model.Status = uint8(types.ORDER_PENDING_TRIGGER)
+
+	trigger.OrderHash = state.RawOrder.Hash
+	trigger.Owner = state.RawOrder.Owner
+	trigger.Market = model.Market
+	trigger.Status = types.TRIGGER_STATUS_PENDING
+	trigger.CreateTime = time.Now().Unix()
+	trigger.TriggerTime = 0
+
+	// 触发条件与订单在同一个事务中写入,订单写入失败时不留下没有订单的触发条件
+	triggerModel := &dao.OrderTrigger{}
+	triggerModel.ConvertDown(trigger)
+	if err := tm.rds.Transaction(func(rds dao.RdsService) error {
+		if err := rds.Add(triggerModel); err != nil {
+			return err
+		}
+		return rds.Add(model)
+	}); err != nil {
+		return err
+	}
+
+	log.Debugf("order manager,add conditional order:%s market:%s %s %f", trigger.OrderHash.Hex(), trigger.Market, trigger.Condition, trigger.TriggerPrice)
+	return nil
+}
+
+// CancelConditionalOrder 取消尚未触发的条件单,订单从未上链无需链上取消
+func (tm *TriggerManager) CancelConditionalOrder(orderhash common.Hash) error {
+	tm.mtx.Lock()
+	defer tm.mtx.Unlock()
+
+	trigger, err := tm.getTrigger(orderhash)
+	if err != nil {
+		return err
+	}
+	if trigger.Status != types.TRIGGER_STATUS_PENDING {
+		return fmt.Errorf("order manager,cancel conditional order:%s is not pending", orderhash.Hex())
+	}
+
+	return tm.closeTrigger(orderhash, types.TRIGGER_STATUS_CANCELLED, types.ORDER_CANCEL, time.Now().Unix())
+}
+
+// closeTrigger 条件单与订单状态在同一个数据库事务中更新
+func (tm *TriggerManager) closeTrigger(orderhash common.Hash, triggerStatus types.TriggerStatus, orderStatus types.OrderStatus, nowtime int64) error {
+	return tm.rds.Transaction(func(rds dao.RdsService) error {
+		if err := rds.UpdateOrderTriggerStatus(orderhash, triggerStatus, nowtime); err != nil {
+			return err
+		}
+		return rds.UpdateOrderStatus(orderhash, orderStatus)
+	})
+}
+
+func (tm *TriggerManager) GetOrderTrigger(orderhash common.Hash) (*types.OrderTrigger, error) {
+	return tm.getTrigger(orderhash)
+}
+
+func (tm *TriggerManager) handleTrendUpdated(input eventemitter.EventData) error {
+	if mkt, ok := input.(string); ok {
+		tm.checkTriggers(mkt)
+	}
+	return nil
+}
+
+// checkTriggers market为空时检查所有市场,否则只检查该市场中以loopring成交价触发的条件单
+func (tm *TriggerManager) checkTriggers(mkt string) {
+	tm.mtx.Lock()
+	defer tm.mtx.Unlock()
+
+	models, err := tm.rds.GetPendingOrderTriggers()
+	if err != nil {
+		log.Errorf("order manager,get pending order triggers error:%s", err.Error())
+		return
+	}
+
+	prices := make(map[string]float64)
+	for _, v := range models {
+		trigger := &types.OrderTrigger{}
+		v.ConvertUp(trigger)
+		if mkt != "" && (trigger.Market != mkt || trigger.PriceSource != types.TRIGGER_SOURCE_LOOPRING) {
+			continue
+		}
+
+		priceKey := trigger.PriceSource + "-" + trigger.Market
+		price, ok := prices[priceKey]
+		if !ok {
+			price = tm.getPrice(trigger.PriceSource, trigger.Market)
+			prices[priceKey] = price
+		}
+
+		if err := tm.release(trigger, price); err != nil {
+			log.Errorf("order manager,release conditional order:%s error:%s", trigger.OrderHash.Hex(), err.Error())
+		}
+	}
+}
+
+func (tm *TriggerManager) getPrice(source, mkt string) float64 {
+	switch source {
+	case types.TRIGGER_SOURCE_LOOPRING:
+		if tm.trendManager == nil {
+			return 0
+		}
+		if ticker, err := tm.trendManager.GetTickerByMarket(mkt); err == nil {
+			return ticker.Last
+		}
+	case types.TRIGGER_SOURCE_EXCHANGE:
+		if tm.collector == nil {
+			return 0
+		}
+		tickers, err := tm.collector.GetTickers(mkt)
+		if err != nil {
+			return 0
+		}
+		var (
+			sum   float64
+			count int
+		)
+		for _, v := range tickers {
+			if v.Last > 0 {
+				sum += v.Last
+				count++
+			}
+		}
+		if count > 0 {
+			return sum / float64(count)
+		}
+	}
+	return 0
+}
+
+// release 订单过期或者已被cutoff时直接关闭条件单,否则转为普通订单
+func (tm *TriggerManager) release(trigger *types.OrderTrigger, price float64) error {
+	model, err := tm.rds.GetOrderByHash(trigger.OrderHash)
+	if err != nil {
+		return err
+	}
+	state := &types.OrderState{}
+	if err := model.ConvertUp(state); err != nil {
+		return err
+	}
+
+	nowtime := time.Now().Unix()
+	if state.Status != types.ORDER_PENDING_TRIGGER {
+		return tm.rds.UpdateOrderTriggerStatus(trigger.OrderHash, types.TRIGGER_STATUS_CANCELLED, nowtime)
+	}
+
+	if state.RawOrder.ValidUntil.Int64() < nowtime {
+		return tm.closeTrigger(trigger.OrderHash, types.TRIGGER_STATUS_EXPIRED, types.ORDER_EXPIRE, nowtime)
+	}
+
+	if tm.om.IsOrderCutoff(state.RawOrder.DelegateAddress, state.RawOrder.Owner, state.RawOrder.TokenS, state.RawOrder.TokenB, state.RawOrder.ValidSince) {
+		return tm.closeTrigger(trigger.OrderHash, types.TRIGGER_STATUS_CANCELLED, types.ORDER_CUTOFF, nowtime)
+	}
+
+	if !trigger.Triggered(price) {
+		return nil
+	}
+
+	log.Debugf("order manager,conditional order:%s triggered, %s price:%f %s %f", trigger.OrderHash.Hex(), trigger.PriceSource, price, trigger.Condition, trigger.TriggerPrice)
+	state.Status = types.ORDER_NEW
+	return tm.om.ReleaseTriggeredOrder(state, nowtime)
+}
+
+func (tm *TriggerManager) getTrigger(orderhash common.Hash) (*types.OrderTrigger, error) {
+	model, err := tm.rds.GetOrderTrigger(orderhash)
+	if err != nil {
+		return nil, err
+	}
+	trigger := &types.OrderTrigger{}
+	model.ConvertUp(trigger)
+	return trigger, nil
+}
@@ -194,7 +194,8 @@ func GenerateUserManager() *usermanager.UserManagerImpl {
 func GenerateOrderManager() *ordermanager.OrderManagerImpl {
 	mc := GenerateMarketCap()
 	um := usermanager.NewUserManager(&cfg.UserManager, rds)
-	ob := ordermanager.NewOrderManager(&cfg.OrderManager, rds, um, mc)
+	am := GenerateAccountManager()
+	ob := ordermanager.NewOrderManager(&cfg.OrderManager, rds, um, mc, &am)
 	return ob
 }
 
@@ -29,6 +29,9 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 )
 
+// processed_event表中txmanager的handler前缀,分叉时按前缀删除登记
+const processedEventPrefix = "txmanager."
+
 type TransactionManager struct {
 	db                         dao.RdsService
 	accountmanager             *market.AccountManager
@@ -41,7 +44,10 @@ type TransactionManager struct {
 	transferEventWatcher       *eventemitter.Watcher
 	ethTransferEventWatcher    *eventemitter.Watcher
 	orderFilledEventWatcher    *eventemitter.Watcher
+	contractEventWatcher       *eventemitter.Watcher
 	forkDetectedEventWatcher   *eventemitter.Watcher
+	unconfirmedEventWatcher    *eventemitter.Watcher
+	removedEventWatcher        *eventemitter.Watcher
 }
 
 func NewTxManager(db dao.RdsService, accountmanager *market.AccountManager) TransactionManager {
@@ -83,8 +89,33 @@ func (tm *TransactionManager) Start() {
 	tm.orderFilledEventWatcher = &eventemitter.Watcher{Concurrent: false, Handle: tm.SaveOrderFilledEvent}
 	eventemitter.On(eventemitter.OrderFilled, tm.orderFilledEventWatcher)
 
+	tm.contractEventWatcher = &eventemitter.Watcher{Concurrent: false, Handle: tm.SaveContractEvent}
+	eventemitter.On(eventemitter.ContractEvent, tm.contractEventWatcher)
+
 	tm.forkDetectedEventWatcher = &eventemitter.Watcher{Concurrent: false, Handle: tm.ForkProcess}
 	eventemitter.On(eventemitter.ChainForkDetected, tm.forkDetectedEventWatcher)
+
+	tm.unconfirmedEventWatcher = &eventemitter.Watcher{Concurrent: false, Handle: tm.SaveUnconfirmedEvent}
+	eventemitter.On(eventemitter.UnconfirmedChainEvent, tm.unconfirmedEventWatcher)
+
+	tm.removedEventWatcher = &eventemitter.Watcher{Concurrent: false, Handle: tm.RemoveUnconfirmedEvent}
+	eventemitter.On(eventemitter.RemovedChainEvent, tm.removedEventWatcher)
+}
+
+// ChainEventHandlers 已经保存过的交易会跳过,回填历史块时可以重复执行
+func (tm *TransactionManager) ChainEventHandlers() map[string]func(input eventemitter.EventData) error {
+	return map[string]func(input eventemitter.EventData) error{
+		eventemitter.Approve:          tm.SaveApproveEvent,
+		eventemitter.CancelOrder:      tm.SaveOrderCancelledEvent,
+		eventemitter.CutoffAll:        tm.SaveCutoffAllEvent,
+		eventemitter.CutoffPair:       tm.SaveCutoffPairEvent,
+		eventemitter.WethDeposit:      tm.SaveWethDepositEvent,
+		eventemitter.WethWithdrawal:   tm.SaveWethWithdrawalEvent,
+		eventemitter.Transfer:         tm.SaveTransferEvent,
+		eventemitter.EthTransferEvent: tm.SaveEthTransferEvent,
+		eventemitter.OrderFilled:      tm.SaveOrderFilledEvent,
+		eventemitter.ContractEvent:    tm.SaveContractEvent,
+	}
 }
 
 func (tm *TransactionManager) Stop() {
@@ -97,7 +128,10 @@ func (tm *TransactionManager) Stop() {
 	eventemitter.Un(eventemitter.Transfer, tm.transferEventWatcher)
 	eventemitter.Un(eventemitter.EthTransferEvent, tm.ethTransferEventWatcher)
 	eventemitter.Un(eventemitter.OrderFilled, tm.orderFilledEventWatcher)
+	eventemitter.Un(eventemitter.ContractEvent, tm.contractEventWatcher)
 	eventemitter.Un(eventemitter.ChainForkDetected, tm.forkDetectedEventWatcher)
+	eventemitter.Un(eventemitter.UnconfirmedChainEvent, tm.unconfirmedEventWatcher)
+	eventemitter.Un(eventemitter.RemovedChainEvent, tm.removedEventWatcher)
 }
 
 // todo: check and test
@@ -114,6 +148,12 @@ func (tm *TransactionManager) ForkProcess(input eventemitter.EventData) error {
 	if err := tm.db.RollBackTxView(from, to); err != nil {
 		log.Debugf("txmanager,process fork error:%s", err.Error())
 	}
+	if err := tm.db.RollBackContractEvent(from, to); err != nil {
+		log.Debugf("txmanager,process fork error:%s", err.Error())
+	}
+	if err := tm.db.RollBackProcessedEvent(processedEventPrefix, from, to); err != nil {
+		log.Debugf("txmanager,process fork error:%s", err.Error())
+	}
 	if err := RollbackCache(from, to); err != nil {
 		log.Debugf("txmanager,process cache rollback error:%s", err.Error())
 	}
@@ -122,6 +162,97 @@ func (tm *TransactionManager) ForkProcess(input eventemitter.EventData) error {
 	return nil
 }
 
+// SaveUnconfirmedEvent 未确认块中的事件先保存为pending交易,块确认后由saveMinedTx删除
+func (tm *TransactionManager) SaveUnconfirmedEvent(input eventemitter.EventData) error {
+	evt := input.(*types.ChainEvent)
+	if evt.Topic == eventemitter.ContractEvent {
+		return nil
+	}
+	handler, ok := tm.ChainEventHandlers()[evt.Topic]
+	if !ok {
+		return nil
+	}
+	event := pendingEvent(evt.Event)
+	if nil == event {
+		return nil
+	}
+	return handler(event)
+}
+
+// RemoveUnconfirmedEvent 未确认的块被替换后删除其中交易的pending记录,并通知相关用户
+func (tm *TransactionManager) RemoveUnconfirmedEvent(input eventemitter.EventData) error {
+	evt := input.(*types.ChainEvent)
+	txhash := evt.TxHash.Hex()
+
+	var removed []dao.TransactionView
+	err := tm.db.Transaction(func(rds dao.RdsService) error {
+		views, err := rds.GetPendingTxViewByHash(txhash)
+		if err != nil {
+			return err
+		}
+		if err := rds.DelPendingTxEntity(txhash); err != nil {
+			return err
+		}
+		if err := rds.DelPendingTxView(txhash); err != nil {
+			return err
+		}
+		removed = views
+		return nil
+	})
+	if err != nil {
+		log.Errorf("transaction manager,remove unconfirmed tx:%s error:%s", txhash, err.Error())
+		return err
+	}
+
+	for _, model := range removed {
+		var view txtyp.TransactionView
+		model.ConvertUp(&view)
+		// 被移除的交易通常会回到交易池再次打包,不能标记为failed
+		view.Status = types.TX_STATUS_UNKNOWN
+		emitTransactionEvent(&view)
+	}
+	return nil
+}
+
+// pendingEvent 复制事件并将状态设置为pending,原事件确认后还会被其他模块处理
+func pendingEvent(event interface{}) interface{} {
+	switch evt := event.(type) {
+	case *types.ApprovalEvent:
+		e := *evt
+		e.Status = types.TX_STATUS_PENDING
+		return &e
+	case *types.OrderCancelledEvent:
+		e := *evt
+		e.Status = types.TX_STATUS_PENDING
+		return &e
+	case *types.CutoffEvent:
+		e := *evt
+		e.Status = types.TX_STATUS_PENDING
+		return &e
+	case *types.CutoffPairEvent:
+		e := *evt
+		e.Status = types.TX_STATUS_PENDING
+		return &e
+	case *types.WethDepositEvent:
+		e := *evt
+		e.Status = types.TX_STATUS_PENDING
+		return &e
+	case *types.WethWithdrawalEvent:
+		e := *evt
+		e.Status = types.TX_STATUS_PENDING
+		return &e
+	case *types.TransferEvent:
+		e := *evt
+		e.Status = types.TX_STATUS_PENDING
+		return &e
+	case *types.OrderFilledEvent:
+		e := *evt
+		e.Status = types.TX_STATUS_PENDING
+		return &e
+	}
+	return nil
+}
+
 func (tm *TransactionManager) SaveApproveEvent(input eventemitter.EventData) error {
 	event := input.(*types.ApprovalEvent)
 
@@ -137,7 +268,7 @@ func (tm *TransactionManager) SaveApproveEvent(input eventemitter.EventData) err
 	}
 	list = append(list, view)
 
-	return tm.saveTransaction(&entity, list)
+	return tm.saveTransaction(eventemitter.Approve, &entity, list)
 }
 
 func (tm *TransactionManager) SaveOrderCancelledEvent(input eventemitter.EventData) error {
@@ -152,7 +283,7 @@ func (tm *TransactionManager) SaveOrderCancelledEvent(input eventemitter.EventDa
 	view := txtyp.CancelView(event)
 	list = append(list, view)
 
-	return tm.saveTransaction(&entity, list)
+	return tm.saveTransaction(eventemitter.CancelOrder, &entity, list)
 }
 
 func (tm *TransactionManager) SaveCutoffAllEvent(input eventemitter.EventData) error {
@@ -166,7 +297,7 @@ func (tm *TransactionManager) SaveCutoffAllEvent(input eventemitter.EventData) e
 	view := txtyp.CutoffView(event)
 	list = append(list, view)
 
-	return tm.saveTransaction(&entity, list)
+	return tm.saveTransaction(eventemitter.CutoffAll, &entity, list)
 }
 
 func (tm *TransactionManager) SaveCutoffPairEvent(input eventemitter.EventData) error {
@@ -181,7 +312,7 @@ func (tm *TransactionManager) SaveCutoffPairEvent(input eventemitter.EventData)
 	view := txtyp.CutoffPairView(event)
 	list = append(list, view)
 
-	return tm.saveTransaction(&entity, list)
+	return tm.saveTransaction(eventemitter.CutoffPair, &entity, list)
 }
 
 func (tm *TransactionManager) SaveWethDepositEvent(input eventemitter.EventData) error {
@@ -191,7 +322,7 @@ func (tm *TransactionManager) SaveWethDepositEvent(input eventemitter.EventData)
 	entity.FromWethDepositEvent(event)
 	list := txtyp.WethDepositView(event)
 
-	return tm.saveTransaction(&entity, list)
+	return tm.saveTransaction(eventemitter.WethDeposit, &entity, list)
 }
 
 func (tm *TransactionManager) SaveWethWithdrawalEvent(input eventemitter.EventData) error {
@@ -201,7 +332,7 @@ func (tm *TransactionManager) SaveWethWithdrawalEvent(input eventemitter.EventDa
 	entity.FromWethWithdrawalEvent(event)
 	list := txtyp.WethWithdrawalView(event)
 
-	return tm.saveTransaction(&entity, list)
+	return tm.saveTransaction(eventemitter.WethWithdrawal, &entity, list)
 }
 
 func (tm *TransactionManager) SaveTransferEvent(input eventemitter.EventData) error {
@@ -225,7 +356,7 @@ func (tm *TransactionManager) SaveTransferEvent(input eventemitter.EventData) er
 		list = filterList
 	}
 
-	return tm.saveTransaction(&entity, list)
+	return tm.saveTransaction(eventemitter.Transfer, &entity, list)
 }
 
 // 普通的transaction
@@ -238,7 +369,7 @@ func (tm *TransactionManager) SaveEthTransferEvent(input eventemitter.EventData)
 	entity.FromEthTransferEvent(event)
 	list := txtyp.EthTransferView(event)
 
-	return tm.saveTransaction(&entity, list)
+	return tm.saveTransaction(eventemitter.EthTransferEvent, &entity, list)
 }
 
 func (tm *TransactionManager) SaveOrderFilledEvent(input eventemitter.EventData) error {
@@ -248,20 +379,51 @@ func (tm *TransactionManager) SaveOrderFilledEvent(input eventemitter.EventData)
 	SetFillOwnerCache(event.TxHash, event.Owner)
 
 	// 一个ringmined可以生成多个fill,他们的tx&logIndex都相等,这里将其放大存储到entity及view
-	event.TxLogIndex = event.TxLogIndex*10 + event.FillIndex.Int64()
+	// 事件同时被ordermanager处理,在副本上修改
+	fill := *event
+	fill.TxLogIndex = event.TxLogIndex*10 + event.FillIndex.Int64()
 
 	var entity txtyp.TransactionEntity
-	entity.FromOrderFilledEvent(event)
-	list := txtyp.OrderFilledView(event)
+	entity.FromOrderFilledEvent(&fill)
+	list := txtyp.OrderFilledView(&fill)
+
+	return tm.saveTransaction(eventemitter.OrderFilled, &entity, list)
+}
 
-	return tm.saveTransaction(&entity, list)
+// SaveContractEvent extractor.contracts中登记合约的事件单独存表,不生成entity及view
+func (tm *TransactionManager) SaveContractEvent(input eventemitter.EventData) error {
+	event := input.(*types.ContractEvent)
+
+	_, err := tm.processOnce(eventemitter.ContractEvent, event.TxHash.Hex(), event.TxLogIndex, event.BlockNumber.Int64(), func(rds dao.RdsService) error {
+		if _, err := rds.FindContractEvent(event.TxHash.Hex(), event.TxLogIndex); err == nil {
+			log.Debugf("transaction manager,contract event tx:%s logIndex:%d already exist", event.TxHash.Hex(), event.TxLogIndex)
+			return nil
+		}
+
+		var model dao.ContractEvent
+		if err := model.ConvertDown(event); err != nil {
+			return err
+		}
+		return rds.Add(&model)
+	})
+
+	return err
 }
 
-func (tm *TransactionManager) saveTransaction(tx *txtyp.TransactionEntity, list []txtyp.TransactionView) error {
+// processOnce 以(txhash,logIndex,txmanager.topic)登记事件,与entity及view在同一个数据库事务中写入
+func (tm *TransactionManager) processOnce(topic, txhash string, logIndex, blockNumber int64, process func(rds dao.RdsService) error) (bool, error) {
+	processed, err := tm.db.ProcessEventOnce(txhash, logIndex, processedEventPrefix+topic, blockNumber, process)
+	if err == nil && !processed {
+		log.Debugf("transaction manager,%s event tx:%s logIndex:%d has already been processed", topic, txhash, logIndex)
+	}
+	return processed, err
+}
+
+func (tm *TransactionManager) saveTransaction(topic string, tx *txtyp.TransactionEntity, list []txtyp.TransactionView) error {
 	if tx.Status == types.TX_STATUS_PENDING {
 		return tm.savePendingTx(tx, list)
 	}
-	return tm.saveMinedTx(tx, list)
+	return tm.saveMinedTx(topic, tx, list)
 }
 
 func (tm *TransactionManager) savePendingTx(tx *txtyp.TransactionEntity, list []txtyp.TransactionView) error {
@@ -273,14 +435,12 @@ func (tm *TransactionManager) savePendingTx(tx *txtyp.TransactionEntity, list []
 		return nil
 	}
 
-	// find pending tx entity, return if tx already exist
-	if _, err := tm.db.FindPendingTxEntity(tx.Hash.Hex()); err == nil {
+	// find pending tx entity, 未确认块中同一个交易的多个事件只保存一次entity,view按logIndex补齐
+	_, err := tm.db.FindPendingTxEntity(tx.Hash.Hex())
+	entityExists := err == nil
+	if entityExists {
 		log.Debugf("transaction manager,tx pending entity:%s already exist", tx.Hash.Hex())
-		return nil
-	}
-
-	// save entity
-	if err := tm.addEntity(tx); err != nil {
+	} else if err := addEntity(tm.db, tx); err != nil {
 		log.Errorf("transaction manager,add tx pending entity:%s error:%s", tx.Hash.Hex(), err.Error())
 		return err
 	}
@@ -290,51 +450,83 @@ func (tm *TransactionManager) savePendingTx(tx *txtyp.TransactionEntity, list []
 		if !ump.invalidView(view.Owner) {
 			continue
 		}
-		if err := tm.addView(&view); err != nil {
+		if entityExists && tm.pendingViewExists(&view) {
+			continue
+		}
+		if err := addView(tm.db, &view); err != nil {
 			log.Errorf("transaction manager,add tx pending view:%s owner:%s error:%s", tx.Hash.Hex(), err.Error())
+			continue
 		}
+		emitTransactionEvent(&view)
 	}
 
 	return nil
 }
 
-func (tm *TransactionManager) saveMinedTx(tx *txtyp.TransactionEntity, list []txtyp.TransactionView) error {
+func (tm *TransactionManager) pendingViewExists(view *txtyp.TransactionView) bool {
+	models, err := tm.db.GetTxViewByOwnerAndHashs(view.Owner.Hex(), []string{view.TxHash.Hex()})
+	if err != nil {
+		return false
+	}
+	for _, model := range models {
+		if model.LogIndex == view.LogIndex && model.Type == uint8(view.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+func (tm *TransactionManager) saveMinedTx(topic string, tx *txtyp.TransactionEntity, list []txtyp.TransactionView) error {
 	// get users unlocked map
 	ump := tm.getUnlockedMap(list)
 	if !ump.invalidEntity() {
 		return nil
 	}
 
-	// process pending txs
-	tm.processPendingTxWhileMined(tx)
+	var added []txtyp.TransactionView
+	_, err := tm.processOnce(topic, tx.Hash.Hex(), tx.LogIndex, tx.BlockNumber, func(rds dao.RdsService) error {
+		// process pending txs
+		processPendingTxWhileMined(rds, tx)
+
+		// save entity
+		if _, err := rds.FindTxEntity(tx.Hash.Hex(), tx.LogIndex); err == nil {
+			log.Debugf("transaction manager,tx mined entity:%s logIndex:%d already exist", tx.Hash.Hex(), tx.LogIndex)
+			return nil
+		}
+		if err := addEntity(rds, tx); err != nil {
+			log.Errorf("transaction manager,tx mined entity:%s error:%s", tx.Hash.Hex(), err.Error())
+			return err
+		}
 
-	// save entity
-	if _, err := tm.db.FindTxEntity(tx.Hash.Hex(), tx.LogIndex); err == nil {
-		log.Debugf("transaction manager,tx mined entity:%s logIndex:%d already exist", tx.Hash.Hex(), tx.LogIndex)
+		for _, view := range list {
+			if !ump.invalidView(view.Owner) {
+				continue
+			}
+			if err := addView(rds, &view); err != nil {
+				log.Errorf("transaction manager,add tx mined view:%s error:%s", tx.Hash.Hex(), err.Error())
+				continue
+			}
+			added = append(added, view)
+			log.Debugf("transaction manager,tx mined view:%s type:%s owner:%s logIndex:%d status:%s", view.TxHash.Hex(), txtyp.TypeStr(view.Type), view.Owner.Hex(), view.LogIndex, types.StatusStr(view.Status))
+		}
 		return nil
-	}
-	if err := tm.addEntity(tx); err != nil {
-		log.Errorf("transaction manager,tx mined entity:%s error:%s", tx.Hash.Hex(), err.Error())
+	})
+	if err != nil {
 		return err
 	}
 
-	for _, view := range list {
-		if !ump.invalidView(view.Owner) {
-			continue
-		}
-		if err := tm.addView(&view); err != nil {
-			log.Errorf("transaction manager,add tx mined view:%s error:%s", tx.Hash.Hex(), err.Error())
-		}
-		log.Debugf("transaction manager,tx mined view:%s type:%s owner:%s logIndex:%d status:%s", view.TxHash.Hex(), txtyp.TypeStr(view.Type), view.Owner.Hex(), view.LogIndex, types.StatusStr(view.Status))
+	// 事务提交后再通知
+	for i := range added {
+		emitTransactionEvent(&added[i])
 	}
 
 	return nil
 }
 
 // todo(fuk): redo it as cron task
-func (tm *TransactionManager) processPendingTxWhileMined(tx *txtyp.TransactionEntity) {
+func processPendingTxWhileMined(rds dao.RdsService, tx *txtyp.TransactionEntity) {
 	// find the same nonce pending txs and delete
-	txs, _ := tm.db.GetPendingTxEntity(tx.From.Hex(), tx.Nonce.Int64())
+	txs, _ := rds.GetPendingTxEntity(tx.From.Hex(), tx.Nonce.Int64())
 	if len(txs) == 0 {
 		return
 	}
@@ -354,41 +546,40 @@ func (tm *TransactionManager) processPendingTxWhileMined(tx *txtyp.TransactionEn
 
 	// 将相同nonce的其他hash更新为failed
 	if len(preHashList) > 0 {
-		if err := tm.db.SetPendingTxEntityFailed(preHashList); err != nil {
+		if err := rds.SetPendingTxEntityFailed(preHashList); err != nil {
 			log.Errorf("transaction manager,set pending tx entities:%s err:", err.Error())
 		}
-		if err := tm.db.SetPendingTxViewFailed(preHashList); err != nil {
+		if err := rds.SetPendingTxViewFailed(preHashList); err != nil {
 			log.Errorf("transaction manager,set pending tx view:%s err:", err.Error())
 		}
 	}
 
 	// 删除当前pending tx
 	if currentHashIsPending {
-		if err := tm.db.DelPendingTxEntity(tx.Hash.Hex()); err != nil {
+		if err := rds.DelPendingTxEntity(tx.Hash.Hex()); err != nil {
 			log.Errorf("transaction manager,delete pending tx entity:%s err:", tx.Hash.Hex(), err.Error())
 		}
-		if err := tm.db.DelPendingTxView(tx.Hash.Hex()); err != nil {
+		if err := rds.DelPendingTxView(tx.Hash.Hex()); err != nil {
 			log.Errorf("transaction manager,delete pending tx view:%s err:", tx.Hash.Hex(), err.Error())
 		}
 	}
 }
 
-func (tm *TransactionManager) addEntity(tx *txtyp.TransactionEntity) error {
+func addEntity(rds dao.RdsService, tx *txtyp.TransactionEntity) error {
 	var item dao.TransactionEntity
 	item.ConvertDown(tx)
-	return tm.db.Add(&item)
+	return rds.Add(&item)
 }
 
-func (tm *TransactionManager) addView(tx *txtyp.TransactionView) error {
+func addView(rds dao.RdsService, tx *txtyp.TransactionView) error {
 	var item dao.TransactionView
 
 	item.ConvertDown(tx)
-	if err := tm.db.Add(&item); err != nil {
-		return err
-	}
+	return rds.Add(&item)
+}
 
-	eventemitter.Emit(eventemitter.TransactionEvent, &tx)
-	return nil
+func emitTransactionEvent(tx *txtyp.TransactionView) {
+	eventemitter.Emit(eventemitter.TransactionEvent, tx)
 }
 
 type unlockedMap map[common.Address]bool
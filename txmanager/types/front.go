@@ -46,6 +46,35 @@ type TransactionJsonResult struct {
 	Nonce       string             `json:"nonce"`
 }
 
+// ContractEventJsonResult extractor.contracts中登记合约的事件,Params为按abi解析的参数
+type ContractEventJsonResult struct {
+	Contract    string                 `json:"contract"`
+	Protocol    common.Address         `json:"protocol"`
+	Owner       common.Address         `json:"owner"`
+	TxHash      common.Hash            `json:"txHash"`
+	LogIndex    int64                  `json:"logIndex"`
+	BlockNumber int64                  `json:"blockNumber"`
+	Event       string                 `json:"event"`
+	Params      map[string]interface{} `json:"params"`
+	CreateTime  int64                  `json:"createTime"`
+}
+
+func NewContractEventResult(event *types.ContractEvent) ContractEventJsonResult {
+	var res ContractEventJsonResult
+
+	res.Contract = event.ContractName
+	res.Protocol = event.Protocol
+	res.Owner = event.Owner
+	res.TxHash = event.TxHash
+	res.LogIndex = event.TxLogIndex
+	res.BlockNumber = event.BlockNumber.Int64()
+	res.Event = event.EventName
+	res.Params = event.Params
+	res.CreateTime = event.BlockTime
+
+	return res
+}
+
 // todo(后续版本更改)
 type TransactionContent struct {
 	Market    string `json:"market"`
@@ -41,12 +41,20 @@ func GetAllTransactionCount(ownerStr, symbol, status, typ string) (int, error) {
 func GetAllTransactions(owner, symbol, status, typ string, limit, offset int) ([]txtyp.TransactionJsonResult, error) {
 	return impl.GetAllTransactions(owner, symbol, status, typ, limit, offset)
 }
+func GetContractEventCount(owner, contract string) (int, error) {
+	return impl.GetContractEventCount(owner, contract)
+}
+func GetContractEvents(owner, contract string, limit, offset int) ([]txtyp.ContractEventJsonResult, error) {
+	return impl.GetContractEvents(owner, contract, limit, offset)
+}
 
 type TransactionViewer interface {
 	GetPendingTransactions(owner string) ([]txtyp.TransactionJsonResult, error)
 	GetAllTransactionCount(owner, symbol, status, typ string) (int, error)
 	GetAllTransactions(owner, symbol, status, typ string, limit, offset int) ([]txtyp.TransactionJsonResult, error)
 	GetTransactionsByHash(owner string, hashList []string) ([]txtyp.TransactionJsonResult, error)
+	GetContractEventCount(owner, contract string) (int, error)
+	GetContractEvents(owner, contract string, limit, offset int) ([]txtyp.ContractEventJsonResult, error)
 }
 
 var impl TransactionViewer
@@ -150,6 +158,37 @@ func (impl *TransactionViewerImpl) GetAllTransactions(ownerStr, symbolStr, statu
 	return list, nil
 }
 
+func (impl *TransactionViewerImpl) GetContractEventCount(ownerStr, contract string) (int, error) {
+	if !validateOwner(ownerStr) {
+		return 0, ErrOwnerAddressInvalid
+	}
+
+	return impl.db.GetContractEventCount(safeOwner(ownerStr), contract)
+}
+
+func (impl *TransactionViewerImpl) GetContractEvents(ownerStr, contract string, limit, offset int) ([]txtyp.ContractEventJsonResult, error) {
+	list := make([]txtyp.ContractEventJsonResult, 0)
+
+	if !validateOwner(ownerStr) {
+		return list, ErrOwnerAddressInvalid
+	}
+
+	models, err := impl.db.GetContractEvents(safeOwner(ownerStr), contract, limit, offset)
+	if err != nil {
+		return list, err
+	}
+
+	for _, v := range models {
+		var event types.ContractEvent
+		if err := v.ConvertUp(&event); err != nil {
+			continue
+		}
+		list = append(list, txtyp.NewContractEventResult(&event))
+	}
+
+	return list, nil
+}
+
 // 如果transaction包含多条记录,则将protocol不同的记录放到content里
 func (impl *TransactionViewerImpl) assemble(daoviews []dao.TransactionView) []txtyp.TransactionJsonResult {
 	list := make([]txtyp.TransactionJsonResult, 0)
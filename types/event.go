@@ -64,6 +64,32 @@ func StrToTxStatus(txType string) TxStatus {
 	return ret
 }
 
+// ChainState 事件所在块的确认状态,未开启确认深度事件时为CHAIN_STATE_UNKNOWN
+type ChainState uint8
+
+const (
+	CHAIN_STATE_UNKNOWN     ChainState = 0
+	CHAIN_STATE_UNCONFIRMED ChainState = 1
+	CHAIN_STATE_CONFIRMED   ChainState = 2
+	CHAIN_STATE_REMOVED     ChainState = 3
+)
+
+func ChainStateStr(state ChainState) string {
+	var ret string
+	switch state {
+	case CHAIN_STATE_UNCONFIRMED:
+		ret = "unconfirmed"
+	case CHAIN_STATE_CONFIRMED:
+		ret = "confirmed"
+	case CHAIN_STATE_REMOVED:
+		ret = "removed"
+	default:
+		ret = "unknown"
+	}
+
+	return ret
+}
+
 type TxInfo struct {
 	Protocol        common.Address `json:"from"`
 	DelegateAddress common.Address `json:"to"`
@@ -82,6 +108,7 @@ type TxInfo struct {
 	GasPrice        *big.Int       `json:"gas_price"`
 	Nonce           *big.Int       `json:"nonce"`
 	Identify        string         `json:"identify"`
+	ChainState      ChainState     `json:"chain_state"`
 }
 
 type TokenRegisterEvent struct {
@@ -108,6 +135,22 @@ type AddressDeAuthorizedEvent struct {
 	Number   int
 }
 
+type RinghashSubmittedEvent struct {
+	TxInfo
+	RingMiner common.Address
+	Ringhash  common.Hash
+}
+
+// ContractEvent 配置中登记的合约事件,按abi通用解析,Params为参数名到json友好值的映射
+type ContractEvent struct {
+	TxInfo
+	Owner        common.Address
+	ContractName string
+	EventName    string
+	EventId      common.Hash
+	Params       map[string]interface{}
+}
+
 type TransferEvent struct {
 	TxInfo
 	Sender   common.Address
@@ -236,3 +279,37 @@ type BalanceUpdateEvent struct {
 	DelegateAddress string
 	Owner           string
 }
+
+type FundsChange struct {
+	Owner common.Address
+	Token common.Address
+}
+
+// AccountFundsSyncedEvent 区块结束时balance或allowance发生过变化的owner/token
+type AccountFundsSyncedEvent struct {
+	BlockNumber *big.Int
+	Changes     []FundsChange
+}
+
+// ChainEvent 按确认深度发出的事件,Topic及Event为确认后发出的原事件
+type ChainEvent struct {
+	State       ChainState
+	Topic       string
+	Event       interface{}
+	BlockNumber *big.Int
+	BlockHash   common.Hash
+	TxHash      common.Hash
+	TxLogIndex  int64
+}
+
+func NewChainEvent(topic string, event interface{}, info TxInfo) *ChainEvent {
+	return &ChainEvent{
+		State:       info.ChainState,
+		Topic:       topic,
+		Event:       event,
+		BlockNumber: info.BlockNumber,
+		BlockHash:   info.BlockHash,
+		TxHash:      info.TxHash,
+		TxLogIndex:  info.TxLogIndex,
+	}
+}
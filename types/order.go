@@ -38,6 +38,7 @@ const (
 	ORDER_EXPIRE          OrderStatus = 6
 	ORDER_PENDING         OrderStatus = 7
 	ORDER_PENDING_FOR_P2P OrderStatus = 17
+	ORDER_PENDING_TRIGGER OrderStatus = 18 // 条件单,触发前由relay保管,不进入深度以及撮合
 	//ORDER_BALANCE_INSUFFICIENT   OrderStatus = 7
 	//ORDER_ALLOWANCE_INSUFFICIENT OrderStatus = 8
 
@@ -269,6 +270,7 @@ type OrderState struct {
 	CancelledAmountB *big.Int    `json:"cancelledAmountB"`
 	Status           OrderStatus `json:"status"`
 	BroadcastTime    int         `json:"broadcastTime"`
+	FundableAmountS  *big.Int    `json:"fundableAmountS"` // 按owner余额及授权分配给该订单的可成交tokenS数量,nil表示尚未计算
 }
 
 type OrderDelayList struct {
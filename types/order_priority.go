@@ -0,0 +1,110 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package types
+
+import (
+	"fmt"
+	"math/big"
+)
+
+const (
+	ORDER_PRIORITY_PRICE_TIME   = "price_time"   // 价格优先,价格相同时先到先得
+	ORDER_PRIORITY_FEE_WEIGHTED = "fee_weighted" // 单位卖出量支付的lrcFee越高越优先
+	ORDER_PRIORITY_AGE_BOOST    = "age_boost"    // 价格随等待时间逐步提高,避免低价订单一直得不到撮合
+)
+
+// OrderPriority 同一交易方向上订单的优先级,Score越大越先被选取,分数相同时保持价格时间顺序
+// price为订单簿中的价格(amountS/amountB)
+type OrderPriority interface {
+	Name() string
+	Score(state *OrderState, price *big.Rat, now int64) *big.Rat
+}
+
+// NewOrderPriority policy为空时使用price_time,age boost的参数小于等于0时使用默认值
+func NewOrderPriority(policy string, ageBoostPercentage float64, ageBoostInterval int64, maxAgeBoostPercentage float64) (OrderPriority, error) {
+	switch policy {
+	case "", ORDER_PRIORITY_PRICE_TIME:
+		return &priceTimePriority{}, nil
+	case ORDER_PRIORITY_FEE_WEIGHTED:
+		return &feeWeightedPriority{}, nil
+	case ORDER_PRIORITY_AGE_BOOST:
+		p := &ageBoostPriority{percentage: 1, interval: 60, maxPercentage: 20}
+		if ageBoostPercentage > 0 {
+			p.percentage = ageBoostPercentage
+		}
+		if ageBoostInterval > 0 {
+			p.interval = ageBoostInterval
+		}
+		if maxAgeBoostPercentage > 0 {
+			p.maxPercentage = maxAgeBoostPercentage
+		}
+		return p, nil
+	default:
+		return nil, fmt.Errorf("unsupported order priority:%s", policy)
+	}
+}
+
+type priceTimePriority struct{}
+
+func (p *priceTimePriority) Name() string {
+	return ORDER_PRIORITY_PRICE_TIME
+}
+
+func (p *priceTimePriority) Score(state *OrderState, price *big.Rat, now int64) *big.Rat {
+	return new(big.Rat).Set(price)
+}
+
+// feeWeightedPriority 同一方向的订单tokenS相同,lrcFee/amountS可以直接比较
+type feeWeightedPriority struct{}
+
+func (p *feeWeightedPriority) Name() string {
+	return ORDER_PRIORITY_FEE_WEIGHTED
+}
+
+func (p *feeWeightedPriority) Score(state *OrderState, price *big.Rat, now int64) *big.Rat {
+	order := state.RawOrder
+	if nil == order.LrcFee || nil == order.AmountS || order.AmountS.Sign() <= 0 {
+		return new(big.Rat)
+	}
+	return new(big.Rat).SetFrac(order.LrcFee, order.AmountS)
+}
+
+// ageBoostPriority 订单每等待interval秒价格提高percentage%,最多提高maxPercentage%
+type ageBoostPriority struct {
+	percentage    float64
+	interval      int64
+	maxPercentage float64
+}
+
+func (p *ageBoostPriority) Name() string {
+	return ORDER_PRIORITY_AGE_BOOST
+}
+
+func (p *ageBoostPriority) Score(state *OrderState, price *big.Rat, now int64) *big.Rat {
+	age := now - state.RawOrder.CreateTime
+	if age <= 0 {
+		return new(big.Rat).Set(price)
+	}
+	boost := float64(age/p.interval) * p.percentage
+	if boost > p.maxPercentage {
+		boost = p.maxPercentage
+	}
+	rate := new(big.Rat).SetFloat64(1 + boost/100)
+	return rate.Mul(rate, price)
+}
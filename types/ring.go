@@ -56,6 +56,7 @@ type Ring struct {
 	LegalCost *big.Rat
 	Gas       *big.Int
 	GasPrice  *big.Int
+	Cvs       *big.Int // 各订单兑换率折扣的变异系数平方
 }
 
 func (ring *Ring) FeeSelections() *big.Int {
@@ -82,10 +83,15 @@ func (ring *Ring) GenerateUniqueId() common.Hash {
 }
 
 func (ring *Ring) GenerateHash(feeReceipt common.Address) common.Hash {
+	return GenerateRinghash(ring.GenerateUniqueId(), feeReceipt, ring.FeeSelections())
+}
+
+// GenerateRinghash 与合约中ringhash的计算方式一致,feeRecipient与feeSelections不同时同一组订单的ringhash也不同
+func GenerateRinghash(uniqueId common.Hash, feeReceipt common.Address, feeSelections *big.Int) common.Hash {
 	hashBytes := crypto.GenerateHash(
-		ring.GenerateUniqueId().Bytes(),
+		uniqueId.Bytes(),
 		feeReceipt.Bytes(),
-		common.LeftPadBytes(ring.FeeSelections().Bytes(), 2),
+		common.LeftPadBytes(feeSelections.Bytes(), 2),
 	)
 	return common.BytesToHash(hashBytes)
 }
@@ -166,6 +172,18 @@ func (ring *Ring) ValidSinceTime() int64 {
 //	return ringSubmitArgs, nil
 //}
 
+// RingSubmitStatus 环路在提交outbox中的状态,queued -> (registering) -> sent -> mined/failed
+type RingSubmitStatus uint8
+
+const (
+	RING_SUBMIT_UNKNOWN     RingSubmitStatus = 0
+	RING_SUBMIT_QUEUED      RingSubmitStatus = 1
+	RING_SUBMIT_SENT        RingSubmitStatus = 2
+	RING_SUBMIT_MINED       RingSubmitStatus = 3
+	RING_SUBMIT_FAILED      RingSubmitStatus = 4
+	RING_SUBMIT_REGISTERING RingSubmitStatus = 5 // commit-reveal时ringhash已发送登记,等待确认之后再提交环路
+)
+
 type RingSubmitInfo struct {
 	RawRing *Ring
 
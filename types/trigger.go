@@ -0,0 +1,77 @@
+/*
+
+  Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+  Licensed under the Apache License, Version 2.0 (the "License");
+  you may not use this file except in compliance with the License.
+  You may obtain a copy of the License at
+
+  http://www.apache.org/licenses/LICENSE-2.0
+
+  Unless required by applicable law or agreed to in writing, software
+  distributed under the License is distributed on an "AS IS" BASIS,
+  WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+  See the License for the specific language governing permissions and
+  limitations under the License.
+
+*/
+
+package types
+
+import (
+	"github.com/ethereum/go-ethereum/common"
+)
+
+type TriggerStatus uint8
+
+const (
+	TRIGGER_STATUS_PENDING   TriggerStatus = 0
+	TRIGGER_STATUS_TRIGGERED TriggerStatus = 1
+	TRIGGER_STATUS_CANCELLED TriggerStatus = 2
+	TRIGGER_STATUS_EXPIRED   TriggerStatus = 3
+
+	// 触发价格来源,loopring为本relay最近成交价,exchange为外部交易所ticker均价
+	TRIGGER_SOURCE_LOOPRING = "loopring"
+	TRIGGER_SOURCE_EXCHANGE = "exchange"
+
+	// 价格上穿(gte)或下穿(lte)触发价时释放订单
+	TRIGGER_CONDITION_GTE = "gte"
+	TRIGGER_CONDITION_LTE = "lte"
+)
+
+// OrderTrigger 止损/止盈条件单的触发条件,价格为market中tokenB计价的tokenS价格,与ticker一致
+type OrderTrigger struct {
+	OrderHash    common.Hash    `json:"orderHash"`
+	Owner        common.Address `json:"owner"`
+	Market       string         `json:"market"`
+	PriceSource  string         `json:"priceSource"`
+	Condition    string         `json:"condition"`
+	TriggerPrice float64        `json:"triggerPrice"`
+	Status       TriggerStatus  `json:"status"`
+	CreateTime   int64          `json:"createTime"`
+	TriggerTime  int64          `json:"triggerTime"`
+}
+
+func (t *OrderTrigger) IsValid() bool {
+	if t.PriceSource != TRIGGER_SOURCE_LOOPRING && t.PriceSource != TRIGGER_SOURCE_EXCHANGE {
+		return false
+	}
+	if t.Condition != TRIGGER_CONDITION_GTE && t.Condition != TRIGGER_CONDITION_LTE {
+		return false
+	}
+	return t.TriggerPrice > 0
+}
+
+func (t *OrderTrigger) Triggered(price float64) bool {
+	if price <= 0 {
+		return false
+	}
+
+	switch t.Condition {
+	case TRIGGER_CONDITION_GTE:
+		return price >= t.TriggerPrice
+	case TRIGGER_CONDITION_LTE:
+		return price <= t.TriggerPrice
+	}
+	return false
+}
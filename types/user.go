@@ -27,3 +27,11 @@ type WhiteListUser struct {
 	Owner      common.Address `json:"owner"`
 	CreateTime int64          `json:"create_time"`
 }
+
+// 做市商等用户的挂单限制,覆盖gateway配置的默认值,0表示不限制
+type OrderLimit struct {
+	Owner            common.Address `json:"owner"`
+	MaxOpenOrders    int            `json:"maxOpenOrders"`
+	MaxOpenUsdAmount float64        `json:"maxOpenUsdAmount"`
+	CreateTime       int64          `json:"create_time"`
+}
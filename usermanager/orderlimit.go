@@ -0,0 +1,134 @@
+/*
+
+ Copyright 2017 Loopring Project Ltd (Loopring Foundation).
+
+ Licensed under the Apache License, Version 2.0 (the "License");
+ you may not use this file except in compliance with the License.
+ You may obtain a copy of the License at
+
+ http://www.apache.org/licenses/LICENSE-2.0
+
+ Unless required by applicable law or agreed to in writing, software
+ distributed under the License is distributed on an "AS IS" BASIS,
+ WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ See the License for the specific language governing permissions and
+ limitations under the License.
+
+*/
+
+package usermanager
+
+import (
+	"github.com/Loopring/relay/dao"
+	"github.com/Loopring/relay/log"
+	"github.com/Loopring/relay/types"
+	"github.com/ethereum/go-ethereum/common"
+	"sync"
+	"time"
+)
+
+// OrderLimitCache 缓存单个用户的挂单限制,定时从数据库同步,多个relay实例之间最终一致
+type OrderLimitCache struct {
+	mtx    sync.RWMutex
+	limits map[common.Address]*types.OrderLimit
+	rds    dao.RdsService
+}
+
+func newOrderLimitCache(rds dao.RdsService) *OrderLimitCache {
+	c := &OrderLimitCache{}
+	c.rds = rds
+	c.limits = make(map[common.Address]*types.OrderLimit)
+
+	c.refreshOrderLimits()
+
+	return c
+}
+
+func (c *OrderLimitCache) syncOrderLimits() {
+	list, err := c.rds.GetOrderLimits()
+	if err != nil {
+		log.Errorf("sync order limits error:%s", err.Error())
+		return
+	}
+
+	limits := make(map[common.Address]*types.OrderLimit)
+	for _, v := range list {
+		limit := &types.OrderLimit{}
+		if err := v.ConvertUp(limit); err != nil {
+			log.Errorf("sync order limits error:%s", err.Error())
+			continue
+		}
+		limits[limit.Owner] = limit
+	}
+
+	c.mtx.Lock()
+	c.limits = limits
+	c.mtx.Unlock()
+}
+
+func (c *OrderLimitCache) refreshOrderLimits() {
+	c.syncOrderLimits()
+	go func() {
+		for {
+			select {
+			case <-time.After(time.Second * 60):
+				c.syncOrderLimits()
+			}
+		}
+	}()
+}
+
+func (c *OrderLimitCache) GetOrderLimit(owner common.Address) (*types.OrderLimit, bool) {
+	c.mtx.RLock()
+	defer c.mtx.RUnlock()
+
+	limit, ok := c.limits[owner]
+	if !ok {
+		return nil, false
+	}
+	cp := *limit
+	return &cp, true
+}
+
+func (c *OrderLimitCache) SetOrderLimit(limit types.OrderLimit) error {
+	if limit.CreateTime == 0 {
+		limit.CreateTime = time.Now().Unix()
+	}
+
+	model, err := c.rds.FindOrderLimitByAddress(limit.Owner)
+	if err != nil {
+		model = &dao.OrderLimit{}
+	}
+	if err := model.ConvertDown(&limit); err != nil {
+		return err
+	}
+	if model.ID > 0 {
+		err = c.rds.Save(model)
+	} else {
+		err = c.rds.Add(model)
+	}
+	if err != nil {
+		return err
+	}
+
+	c.mtx.Lock()
+	c.limits[limit.Owner] = &limit
+	c.mtx.Unlock()
+
+	return nil
+}
+
+func (c *OrderLimitCache) DelOrderLimit(owner common.Address) error {
+	c.mtx.Lock()
+	delete(c.limits, owner)
+	c.mtx.Unlock()
+
+	model, err := c.rds.FindOrderLimitByAddress(owner)
+	if err != nil {
+		log.Debugf("order limit of %s not exist", owner.Hex())
+		return nil
+	}
+	model.IsDeleted = true
+
+	return c.rds.Save(model)
+}
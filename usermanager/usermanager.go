@@ -31,12 +31,16 @@ type UserManager interface {
 	DelWhiteListUser(user types.WhiteListUser) error
 	InWhiteList(owner common.Address) bool
 	IsWhiteListOpen() bool
+	GetOrderLimit(owner common.Address) (*types.OrderLimit, bool)
+	SetOrderLimit(limit types.OrderLimit) error
+	DelOrderLimit(owner common.Address) error
 }
 
 type UserManagerImpl struct {
 	rds       dao.RdsService
 	options   *config.UserManagerOptions
 	whiteList *WhiteListCache
+	limits    *OrderLimitCache
 }
 
 func NewUserManager(options *config.UserManagerOptions, rds dao.RdsService) *UserManagerImpl {
@@ -47,6 +51,7 @@ func NewUserManager(options *config.UserManagerOptions, rds dao.RdsService) *Use
 	if options.WhiteListOpen {
 		impl.whiteList = newWhiteListCache(impl.options, impl.rds)
 	}
+	impl.limits = newOrderLimitCache(impl.rds)
 
 	return impl
 }
@@ -74,3 +79,16 @@ func (m *UserManagerImpl) DelWhiteListUser(user types.WhiteListUser) error {
 func (m *UserManagerImpl) IsWhiteListOpen() bool {
 	return m.options.WhiteListOpen
 }
+
+// GetOrderLimit 返回单独配置的挂单限制,不存在时使用gateway的默认配置
+func (m *UserManagerImpl) GetOrderLimit(owner common.Address) (*types.OrderLimit, bool) {
+	return m.limits.GetOrderLimit(owner)
+}
+
+func (m *UserManagerImpl) SetOrderLimit(limit types.OrderLimit) error {
+	return m.limits.SetOrderLimit(limit)
+}
+
+func (m *UserManagerImpl) DelOrderLimit(owner common.Address) error {
+	return m.limits.DelOrderLimit(owner)
+}